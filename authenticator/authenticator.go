@@ -0,0 +1,46 @@
+package authenticator
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/vprix/vncproxy/rfb"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// ErrInvalidCredentials 用户名或密码错误
+var ErrInvalidCredentials = errors.New("用户名或密码错误")
+
+// userEntry 配置及http回调接口中描述的用户
+type userEntry struct {
+	Username       string            `json:"username"`       // 用户名，http回调接口可以返回规范化后的用户名
+	Password       string            `json:"password"`       // 密码，支持明文及bcrypt
+	Token          string            `json:"token"`          // 该用户连接的目标令牌
+	TargetUsername string            `json:"targetUsername"` // 连接vnc服务端使用的用户名
+	TargetPassword string            `json:"targetPassword"` // 连接vnc服务端使用的密码
+	ViewOnly       bool              `json:"viewOnly"`       // 是否只读
+	Attributes     map[string]string `json:"attributes"`     // 其他属性
+}
+
+// toIdentity 转换成rfb的身份
+func (that *userEntry) toIdentity(username string) *rfb.Identity {
+	if len(that.Username) > 0 {
+		username = that.Username
+	}
+	return &rfb.Identity{
+		Username:       username,
+		Token:          that.Token,
+		TargetUsername: []byte(that.TargetUsername),
+		TargetPassword: []byte(that.TargetPassword),
+		ViewOnly:       that.ViewOnly,
+		Attributes:     that.Attributes,
+	}
+}
+
+// checkPassword 校验密码，hash以$2开头的使用bcrypt校验，否则当做明文比较
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+}
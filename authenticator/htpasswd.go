@@ -0,0 +1,101 @@
+package authenticator
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/util/grand"
+	"github.com/vprix/vncproxy/rfb"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HtpasswdAuthenticator 使用htpasswd文件认证，只支持bcrypt格式(htpasswd -B)，文件修改后会自动重新加载。
+//
+//	alice:$2y$05$....
+type HtpasswdAuthenticator struct {
+	path   string
+	mu     sync.RWMutex
+	mtime  time.Time
+	hashes map[string]string
+	dummy  string // 用户不存在时用来比较的hash，与文件中的hash计算量相同，防止通过响应时间判断用户是否存在
+}
+
+var _ rfb.Authenticator = new(HtpasswdAuthenticator)
+
+// NewHtpasswdAuthenticator 创建htpasswd文件认证器
+func NewHtpasswdAuthenticator(path string) (*HtpasswdAuthenticator, error) {
+	that := &HtpasswdAuthenticator{path: path}
+	if err := that.reload(); err != nil {
+		return nil, err
+	}
+	return that, nil
+}
+
+// Authenticate 校验用户名和密码
+func (that *HtpasswdAuthenticator) Authenticate(_ context.Context, username, password string) (*rfb.Identity, error) {
+	if err := that.reload(); err != nil {
+		return nil, err
+	}
+	that.mu.RLock()
+	hash, ok := that.hashes[username]
+	dummy := that.dummy
+	that.mu.RUnlock()
+	if !ok {
+		checkPassword(dummy, password)
+		return nil, ErrInvalidCredentials
+	}
+	if !checkPassword(hash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &rfb.Identity{Username: username}, nil
+}
+
+// reload 文件有修改的时候重新加载
+func (that *HtpasswdAuthenticator) reload() error {
+	mtime := gfile.MTime(that.path)
+	if mtime.IsZero() {
+		return fmt.Errorf("htpasswd文件 %s 不存在", that.path)
+	}
+	that.mu.RLock()
+	unchanged := mtime.Equal(that.mtime)
+	that.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	hashes := make(map[string]string)
+	cost := 0
+	for i, line := range strings.Split(gfile.GetContents(that.path), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || len(username) == 0 {
+			return fmt.Errorf("htpasswd文件 %s 第%d行格式不正确", that.path, i+1)
+		}
+		// 只支持bcrypt，其他格式(md5,sha1,crypt)不够安全
+		if !strings.HasPrefix(hash, "$2") {
+			return fmt.Errorf("htpasswd文件 %s 中用户 %s 的密码不是bcrypt格式", that.path, username)
+		}
+		hashes[username] = hash
+		if c, err := bcrypt.Cost([]byte(hash)); err == nil && c > cost {
+			cost = c
+		}
+	}
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	dummy, err := bcrypt.GenerateFromPassword([]byte(grand.S(16)), cost)
+	if err != nil {
+		return err
+	}
+	that.mu.Lock()
+	that.hashes = hashes
+	that.dummy = string(dummy)
+	that.mtime = mtime
+	that.mu.Unlock()
+	return nil
+}
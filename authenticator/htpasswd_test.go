@@ -0,0 +1,107 @@
+package authenticator
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// bcryptHash 使用最小的计算量生成bcrypt的hash，加快测试
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestHtpasswdAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	if err := os.WriteFile(path, []byte("# 注释\nalice:"+bcryptHash(t, "secret")+"\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewHtpasswdAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	identity, err := auth.Authenticate(ctx, "alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "alice" {
+		t.Fatalf("用户名为%s", identity.Username)
+	}
+	cases := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"密码错误", "alice", "wrong"},
+		{"用户不存在", "bob", "secret"},
+		{"空用户名", "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := auth.Authenticate(ctx, c.username, c.password); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("认证结果为%v", err)
+			}
+		})
+	}
+	// 用户不存在时与计算量相同的hash比较，响应时间与密码错误相同
+	if cost, err := bcrypt.Cost([]byte(auth.dummy)); err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("用户不存在时比较的hash计算量为%d %v", cost, err)
+	}
+
+	// 文件修改后重新加载
+	if err = os.WriteFile(path, []byte("bob:"+bcryptHash(t, "hunter2")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err = os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.Authenticate(ctx, "bob", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.Authenticate(ctx, "alice", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("重新加载后删除的用户认证结果为%v", err)
+	}
+	// 文件被删除
+	if err = os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.Authenticate(ctx, "bob", "hunter2"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("文件不存在时认证结果为%v", err)
+	}
+}
+
+func TestHtpasswdAuthenticatorMalformed(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{"文件不存在", ""},
+		{"缺少分隔符", "alice\n"},
+		{"缺少用户名", ":$2y$04$abcdefghijklmnopqrstuv\n"},
+		{"不是bcrypt格式", "alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.htpasswd")
+			if len(c.content) > 0 {
+				if err := os.WriteFile(path, []byte(c.content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := NewHtpasswdAuthenticator(path); err == nil {
+				t.Fatal("没有返回错误")
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package authenticator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/vprix/vncproxy/rfb"
+	"net/http"
+	"time"
+)
+
+// HttpAuthenticator 通过http回调接口认证，一般是本机的认证服务，
+// 请求方式为 POST url ，请求体为 {"username":"alice","password":"xxx"} ，
+// 认证成功返回200及json格式的用户信息，例如 {"token":"vm1","targetPassword":"vprix"} ，
+// 用户名或密码错误返回401或者403状态码。
+type HttpAuthenticator struct {
+	url    string
+	client *gclient.Client
+}
+
+var _ rfb.Authenticator = new(HttpAuthenticator)
+
+// NewHttpAuthenticator 创建通过http回调接口认证的认证器
+func NewHttpAuthenticator(url string, timeout time.Duration) *HttpAuthenticator {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &HttpAuthenticator{
+		url:    url,
+		client: g.Client().Timeout(timeout).ContentJson(),
+	}
+}
+
+// Authenticate 校验用户名和密码
+func (that *HttpAuthenticator) Authenticate(ctx context.Context, username, password string) (*rfb.Identity, error) {
+	resp, err := that.client.Post(ctx, that.url, g.Map{"username": username, "password": password})
+	if err != nil {
+		return nil, fmt.Errorf("请求认证接口失败:%v", err)
+	}
+	defer func() {
+		_ = resp.Close()
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrInvalidCredentials
+	default:
+		return nil, fmt.Errorf("认证接口返回错误的状态码:%d", resp.StatusCode)
+	}
+	var entry userEntry
+	if body := resp.ReadAll(); len(body) > 0 {
+		if err = json.Unmarshal(body, &entry); err != nil {
+			return nil, fmt.Errorf("认证接口返回的数据格式不正确:%v", err)
+		}
+	}
+	return entry.toIdentity(username), nil
+}
@@ -0,0 +1,84 @@
+package authenticator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHttpAuthenticator(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case req.Username == "alice" && req.Password == "secret":
+			_, _ = w.Write([]byte(`{"token":"vm1","targetUsername":"admin","targetPassword":"vprix","viewOnly":true,"attributes":{"department":"ops"}}`))
+		case req.Username == "bob" && req.Password == "secret":
+			// 没有返回用户信息
+		case req.Username == "CAROL" && req.Password == "secret":
+			_, _ = w.Write([]byte(`{"username":"carol"}`))
+		case req.Username == "broken":
+			_, _ = w.Write([]byte(`{"token":`))
+		case req.Username == "error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case req.Username == "forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	auth := NewHttpAuthenticator(srv.URL, time.Second)
+	ctx := context.Background()
+
+	identity, err := auth.Authenticate(ctx, "alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "alice" || identity.Token != "vm1" || string(identity.TargetUsername) != "admin" ||
+		string(identity.TargetPassword) != "vprix" || !identity.ViewOnly || identity.Attributes["department"] != "ops" {
+		t.Fatalf("身份为%+v", identity)
+	}
+	if identity, err = auth.Authenticate(ctx, "bob", "secret"); err != nil || identity.Username != "bob" {
+		t.Fatalf("没有用户信息时身份为%+v %v", identity, err)
+	}
+	// 认证接口可以返回规范化后的用户名
+	if identity, err = auth.Authenticate(ctx, "CAROL", "secret"); err != nil || identity.Username != "carol" {
+		t.Fatalf("规范化用户名后身份为%+v %v", identity, err)
+	}
+
+	cases := []struct {
+		name     string
+		username string
+		invalid  bool
+	}{
+		{"密码错误", "alice", true},
+		{"禁止访问", "forbidden", true},
+		{"格式不正确", "broken", false},
+		{"接口错误", "error", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := auth.Authenticate(ctx, c.username, "wrong")
+			if err == nil || errors.Is(err, ErrInvalidCredentials) != c.invalid {
+				t.Fatalf("认证结果为%v", err)
+			}
+		})
+	}
+
+	// 接口无法访问
+	srv.Close()
+	if _, err = auth.Authenticate(ctx, "alice", "secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("接口无法访问时认证结果为%v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package authenticator
+
+import (
+	"context"
+	"github.com/vprix/vncproxy/rfb"
+)
+
+// User 静态认证器中的用户
+type User struct {
+	Password string        // 密码，支持明文及bcrypt
+	Identity *rfb.Identity // 认证成功后的身份，为nil则只包含用户名
+}
+
+// StaticAuthenticator 使用内存中固定的用户表认证
+type StaticAuthenticator struct {
+	users map[string]*User
+}
+
+var _ rfb.Authenticator = new(StaticAuthenticator)
+
+// NewStaticAuthenticator 创建静态认证器
+func NewStaticAuthenticator(users map[string]*User) *StaticAuthenticator {
+	return &StaticAuthenticator{users: users}
+}
+
+// NewPasswordAuthenticator 使用用户名到密码的映射创建静态认证器
+func NewPasswordAuthenticator(passwords map[string]string) *StaticAuthenticator {
+	users := make(map[string]*User, len(passwords))
+	for username, password := range passwords {
+		users[username] = &User{Password: password}
+	}
+	return NewStaticAuthenticator(users)
+}
+
+// Authenticate 校验用户名和密码
+func (that *StaticAuthenticator) Authenticate(_ context.Context, username, password string) (*rfb.Identity, error) {
+	user, ok := that.users[username]
+	if !ok || !checkPassword(user.Password, password) {
+		return nil, ErrInvalidCredentials
+	}
+	if user.Identity == nil {
+		return &rfb.Identity{Username: username}, nil
+	}
+	identity := *user.Identity
+	if len(identity.Username) == 0 {
+		identity.Username = username
+	}
+	return &identity, nil
+}
@@ -0,0 +1,62 @@
+package authenticator
+
+import (
+	"context"
+	"errors"
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+func TestStaticAuthenticator(t *testing.T) {
+	auth := NewStaticAuthenticator(map[string]*User{
+		"alice": {Password: "secret"},
+		"bob":   {Password: bcryptHash(t, "hunter2"), Identity: &rfb.Identity{Token: "vm1", ViewOnly: true}},
+		"carol": {Password: "pw", Identity: &rfb.Identity{Username: "Carol"}},
+	})
+	ctx := context.Background()
+	cases := []struct {
+		name     string
+		username string
+		password string
+		ok       bool
+		want     rfb.Identity
+	}{
+		{"明文密码", "alice", "secret", true, rfb.Identity{Username: "alice"}},
+		{"bcrypt密码", "bob", "hunter2", true, rfb.Identity{Username: "bob", Token: "vm1", ViewOnly: true}},
+		{"身份中的用户名优先", "carol", "pw", true, rfb.Identity{Username: "Carol"}},
+		{"明文密码错误", "alice", "secre", false, rfb.Identity{}},
+		{"bcrypt密码错误", "bob", "hunter3", false, rfb.Identity{}},
+		{"用户不存在", "dave", "secret", false, rfb.Identity{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			identity, err := auth.Authenticate(ctx, c.username, c.password)
+			if !c.ok {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("认证结果为%v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Username != c.want.Username || identity.Token != c.want.Token || identity.ViewOnly != c.want.ViewOnly {
+				t.Fatalf("身份为%+v，应该为%+v", identity, c.want)
+			}
+		})
+	}
+	// 返回的是副本，修改不影响配置的身份
+	identity, _ := auth.Authenticate(ctx, "bob", "hunter2")
+	identity.Token = "changed"
+	if identity, _ = auth.Authenticate(ctx, "bob", "hunter2"); identity.Token != "vm1" {
+		t.Fatalf("配置的身份被修改为%+v", identity)
+	}
+
+	auth = NewPasswordAuthenticator(map[string]string{"alice": "secret"})
+	if _, err := auth.Authenticate(ctx, "alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Authenticate(ctx, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("密码错误认证结果为%v", err)
+	}
+}
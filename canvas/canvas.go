@@ -2,7 +2,6 @@ package canvas
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"github.com/vprix/vncproxy/rfb"
 	"image"
@@ -26,6 +25,7 @@ type VncCanvas struct {
 	CursorLocation *image.Point
 	DrawCursor     bool
 	Changed        map[string]bool
+	ColorMap       rfb.ColorMap // 像素格式不是真彩色时使用的颜色地图
 }
 
 func NewVncCanvas(width, height int) *VncCanvas {
@@ -126,6 +126,10 @@ func (that *VncCanvas) PaintCursor() image.Image {
 			if mask {
 
 				that.CursorBackup.Set(x, y, backup)
+				// 半透明的鼠标指针需要与背景混合
+				if c, ok := col.(color.NRGBA); ok && c.A != 0xff {
+					col = blendColor(c, backup)
+				}
 				//logger.Info("Drawing Cursor for real: ", x, y, col)
 				img.Set(x+loc.X-that.CursorOffset.X, y+loc.Y-that.CursorOffset.Y, col)
 			}
@@ -136,6 +140,42 @@ func (that *VncCanvas) PaintCursor() image.Image {
 	return img
 }
 
+// SetCursor 设置鼠标指针的形状，mask标记指针中需要绘制的像素，hotspot是指针的热点，img为nil时隐藏鼠标指针
+func (that *VncCanvas) SetCursor(img draw.Image, mask [][]bool, hotspot image.Point) {
+	if img == nil || img.Bounds().Empty() {
+		that.Cursor = nil
+		that.CursorMask = nil
+		that.CursorBackup = nil
+		return
+	}
+	that.CursorOffset = &hotspot
+	that.Cursor = img
+	that.CursorBackup = image.NewRGBA(img.Bounds())
+	that.CursorMask = mask
+}
+
+// Resize 修改画布的大小，保留画布左上角原有的画面，新增的区域为黑色
+func (that *VncCanvas) Resize(width, height int) {
+	if that.Bounds() == image.Rect(0, 0, width, height) {
+		return
+	}
+	img := NewRGBImage(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Rect, that.Image, image.Point{}, draw.Src)
+	that.Image = img
+}
+
+// blendColor 把非预乘透明度的颜色c混合到背景色bg上
+func blendColor(c color.NRGBA, bg color.Color) color.RGBA {
+	r, g, b, _ := bg.RGBA()
+	a := uint32(c.A)
+	return color.RGBA{
+		R: uint8((uint32(c.R)*a + (r>>8)*(255-a)) / 255),
+		G: uint8((uint32(c.G)*a + (g>>8)*(255-a)) / 255),
+		B: uint8((uint32(c.B)*a + (b>>8)*(255-a)) / 255),
+		A: 0xff,
+	}
+}
+
 // FillRect 为指定的矩形区域填充颜色
 func (that *VncCanvas) FillRect(rect *image.Rectangle, c color.Color) {
 	for x := rect.Min.X; x < rect.Max.X; x++ {
@@ -145,11 +185,8 @@ func (that *VncCanvas) FillRect(rect *image.Rectangle, c color.Color) {
 	}
 }
 
-// ReadColor Read unmarshal color from conn
+// ReadColor 读取一个像素并转换为颜色
 func (that *VncCanvas) ReadColor(c io.Reader, pf *rfb.PixelFormat) (*color.RGBA, error) {
-	if pf.TrueColor == 0 {
-		return nil, errors.New("support for non true color formats was not implemented")
-	}
 	order := pf.Order()
 	var pixel uint32
 
@@ -172,15 +209,43 @@ func (that *VncCanvas) ReadColor(c io.Reader, pf *rfb.PixelFormat) (*color.RGBA,
 			return nil, err
 		}
 		pixel = uint32(px)
+	default:
+		return nil, fmt.Errorf("不支持的像素位数:%d", pf.BPP)
+	}
+	return that.PixelColor(pixel, pf), nil
+}
+
+// PixelColor 把像素值转换为颜色，真彩色的颜色分量按照像素格式中的最大值缩放到8位，否则像素值是颜色地图中的索引
+func (that *VncCanvas) PixelColor(pixel uint32, pf *rfb.PixelFormat) *color.RGBA {
+	if pf.TrueColor == 0 {
+		if pixel >= uint32(len(that.ColorMap)) {
+			return &color.RGBA{A: 1}
+		}
+		c := that.ColorMap[pixel]
+		return &color.RGBA{R: uint8(c.R >> 8), G: uint8(c.G >> 8), B: uint8(c.B >> 8), A: 1}
 	}
-	rgb := color.RGBA{
-		R: uint8((pixel >> pf.RedShift) & uint32(pf.RedMax)),
-		G: uint8((pixel >> pf.GreenShift) & uint32(pf.GreenMax)),
-		B: uint8((pixel >> pf.BlueShift) & uint32(pf.BlueMax)),
+	return &color.RGBA{
+		R: scaleUp(pixel>>pf.RedShift&uint32(pf.RedMax), pf.RedMax),
+		G: scaleUp(pixel>>pf.GreenShift&uint32(pf.GreenMax), pf.GreenMax),
+		B: scaleUp(pixel>>pf.BlueShift&uint32(pf.BlueMax), pf.BlueMax),
 		A: 1,
 	}
+}
+
+// scaleUp 把最大值为max的颜色分量缩放到8位
+func scaleUp(v uint32, max uint16) uint8 {
+	switch max {
+	case 0:
+		return 0
+	case 255:
+		return uint8(v)
+	}
+	return uint8((v*255 + uint32(max)/2) / uint32(max))
+}
 
-	return &rgb, nil
+// scaleDown 把8位的颜色分量缩放到最大值为max
+func scaleDown(v uint32, max uint16) uint32 {
+	return (v*uint32(max) + 127) / 255
 }
 
 func (that *VncCanvas) DecodeRaw(reader io.Reader, pf *rfb.PixelFormat, rect *rfb.Rectangle) error {
@@ -196,6 +261,11 @@ func (that *VncCanvas) DecodeRaw(reader io.Reader, pf *rfb.PixelFormat, rect *rf
 	return nil
 }
 
+// CopyRect 把画布上以(sx,sy)为左上角的区域复制到指定的矩形区域，源和目标区域可以重叠
+func (that *VncCanvas) CopyRect(sx, sy int, rect *rfb.Rectangle) {
+	draw.Draw(that.Image, MakeRectFromVncRect(rect), that.Image, image.Pt(sx, sy), draw.Src)
+}
+
 func MakeRect(x, y, width, height int) image.Rectangle {
 	return image.Rectangle{Min: image.Point{X: x, Y: y}, Max: image.Point{X: x + width, Y: y + height}}
 }
@@ -203,3 +273,69 @@ func MakeRect(x, y, width, height int) image.Rectangle {
 func MakeRectFromVncRect(rect *rfb.Rectangle) image.Rectangle {
 	return MakeRect(int(rect.X), int(rect.Y), int(rect.Width), int(rect.Height))
 }
+
+// EncodeRaw 把画布指定区域的像素按照像素格式编码成Raw编码的数据，不是真彩色时使用颜色地图中最接近的颜色
+func (that *VncCanvas) EncodeRaw(writer io.Writer, pf *rfb.PixelFormat, rect image.Rectangle) error {
+	order := pf.Order()
+	bytesPixel := int(pf.BPP / 8)
+	if bytesPixel != 1 && bytesPixel != 2 && bytesPixel != 4 {
+		return fmt.Errorf("不支持的像素位数:%d", pf.BPP)
+	}
+	// 同一个颜色在颜色地图中的索引只查找一次
+	var indexes map[uint32]uint32
+	if pf.TrueColor == 0 {
+		indexes = make(map[uint32]uint32)
+	}
+	buf := make([]byte, rect.Dx()*bytesPixel)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, b, _ := that.At(x, y).RGBA()
+			// 画布中的颜色分量都是8位，需要按照像素格式中每种颜色的最大值进行缩放
+			r, g, b = r&0xff, g&0xff, b&0xff
+			var pixel uint32
+			if pf.TrueColor != 0 {
+				pixel = scaleDown(r, pf.RedMax)<<pf.RedShift |
+					scaleDown(g, pf.GreenMax)<<pf.GreenShift |
+					scaleDown(b, pf.BlueMax)<<pf.BlueShift
+			} else {
+				key := r<<16 | g<<8 | b
+				index, ok := indexes[key]
+				if !ok {
+					index = that.nearestColor(r, g, b)
+					indexes[key] = index
+				}
+				pixel = index
+			}
+			i := (x - rect.Min.X) * bytesPixel
+			switch pf.BPP {
+			case 8:
+				buf[i] = uint8(pixel)
+			case 16:
+				order.PutUint16(buf[i:], uint16(pixel))
+			case 32:
+				order.PutUint32(buf[i:], pixel)
+			}
+		}
+		if _, err := writer.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nearestColor 在颜色地图中查找与8位颜色分量最接近的颜色的索引
+func (that *VncCanvas) nearestColor(r, g, b uint32) uint32 {
+	var index uint32
+	best := -1
+	for i, c := range that.ColorMap {
+		dr, dg, db := int(c.R>>8)-int(r), int(c.G>>8)-int(g), int(c.B>>8)-int(b)
+		dist := dr*dr + dg*dg + db*db
+		if best < 0 || dist < best {
+			index, best = uint32(i), dist
+			if dist == 0 {
+				break
+			}
+		}
+	}
+	return index
+}
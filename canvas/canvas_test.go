@@ -0,0 +1,163 @@
+package canvas
+
+import (
+	"bytes"
+	"github.com/vprix/vncproxy/rfb"
+	"image"
+	"image/color"
+	"testing"
+)
+
+var (
+	testBGR233  = rfb.PixelFormat{BPP: 8, Depth: 8, TrueColor: 1, RedMax: 7, GreenMax: 7, BlueMax: 3, GreenShift: 3, BlueShift: 6}
+	testRGB565  = rfb.PixelFormat{BPP: 16, Depth: 16, TrueColor: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}
+	testRGB555  = rfb.PixelFormat{BPP: 16, Depth: 15, TrueColor: 1, RedMax: 31, GreenMax: 31, BlueMax: 31, RedShift: 10, GreenShift: 5}
+	testIndexed = rfb.PixelFormat{BPP: 8, Depth: 8}
+)
+
+// newTestColorMap 颜色地图的前4个颜色为黑、白、红及灰色，其余为黑色
+func newTestColorMap() rfb.ColorMap {
+	var cm rfb.ColorMap
+	cm[1].R, cm[1].G, cm[1].B = 0xFFFF, 0xFFFF, 0xFFFF
+	cm[2].R = 0xFFFF
+	cm[3].R, cm[3].G, cm[3].B = 0x8000, 0x8000, 0x8000
+	return cm
+}
+
+// TestReadColor 低色深的颜色分量按照像素格式中的最大值四舍五入缩放到8位，非真彩色时使用颜色地图
+func TestReadColor(t *testing.T) {
+	rgb565BE := testRGB565
+	rgb565BE.BigEndian = 1
+	cases := []struct {
+		name string
+		pf   rfb.PixelFormat
+		data []byte
+		want color.RGBA
+	}{
+		{"bgr233 白色", testBGR233, []byte{0xFF}, color.RGBA{255, 255, 255, 1}},
+		{"bgr233", testBGR233, []byte{0x53}, color.RGBA{109, 73, 85, 1}},
+		{"rgb565 红色", testRGB565, []byte{0x00, 0xF8}, color.RGBA{255, 0, 0, 1}},
+		{"rgb565 绿色", testRGB565, []byte{0xE0, 0x07}, color.RGBA{0, 255, 0, 1}},
+		{"rgb565", testRGB565, []byte{0x10, 0x84}, color.RGBA{132, 130, 132, 1}},
+		{"rgb565 大端序", rgb565BE, []byte{0x84, 0x10}, color.RGBA{132, 130, 132, 1}},
+		{"rgb555 白色", testRGB555, []byte{0xFF, 0x7F}, color.RGBA{255, 255, 255, 1}},
+		{"rgb555", testRGB555, []byte{0x10, 0x42}, color.RGBA{132, 132, 132, 1}},
+		{"rgb555 蓝色最小值", testRGB555, []byte{0x01, 0x00}, color.RGBA{0, 0, 8, 1}},
+		{"rgb888", rfb.PixelFormat32bit, []byte{0x30, 0x20, 0x10, 0x00}, color.RGBA{0x10, 0x20, 0x30, 1}},
+		{"颜色地图", testIndexed, []byte{3}, color.RGBA{128, 128, 128, 1}},
+		{"颜色地图 红色", testIndexed, []byte{2}, color.RGBA{255, 0, 0, 1}},
+	}
+	cv := NewVncCanvas(1, 1)
+	cv.ColorMap = newTestColorMap()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := cv.ReadColor(bytes.NewReader(c.data), &c.pf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != c.want {
+				t.Fatalf("颜色为%v，应该为%v", *got, c.want)
+			}
+		})
+	}
+
+	// 16位的颜色地图索引超出范围时使用黑色
+	indexed16 := rfb.PixelFormat{BPP: 16, Depth: 16}
+	if got := cv.PixelColor(300, &indexed16); *got != (color.RGBA{A: 1}) {
+		t.Fatalf("超出颜色地图的颜色为%v", *got)
+	}
+}
+
+// TestEncodeRawRoundTrip 低色深的每个像素值解码到画布后再编码，得到原来的像素值
+func TestEncodeRawRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		pf   rfb.PixelFormat
+	}{
+		{"bgr233", testBGR233},
+		{"rgb565", testRGB565},
+		{"rgb555", testRGB555},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			count := 1 << c.pf.Depth
+			bytesPixel := int(c.pf.BPP / 8)
+			data := make([]byte, count*bytesPixel)
+			for i := 0; i < count; i++ {
+				pixel := uint32(i)
+				// 没有使用的位不参与编码
+				pixel &= uint32(c.pf.RedMax)<<c.pf.RedShift | uint32(c.pf.GreenMax)<<c.pf.GreenShift | uint32(c.pf.BlueMax)<<c.pf.BlueShift
+				if bytesPixel == 1 {
+					data[i] = uint8(pixel)
+				} else {
+					c.pf.Order().PutUint16(data[i*2:], uint16(pixel))
+				}
+			}
+			// 每行256个像素，矩形宽度不能超过65535
+			cv := NewVncCanvas(256, count/256)
+			rect := &rfb.Rectangle{Width: 256, Height: uint16(count / 256)}
+			if err := cv.DecodeRaw(bytes.NewReader(data), &c.pf, rect); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := cv.EncodeRaw(&buf, &c.pf, cv.Bounds()); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				for i := 0; i < len(data); i += bytesPixel {
+					if !bytes.Equal(buf.Bytes()[i:i+bytesPixel], data[i:i+bytesPixel]) {
+						t.Fatalf("像素%v编码为%v", data[i:i+bytesPixel], buf.Bytes()[i:i+bytesPixel])
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestEncodeRaw 8位的颜色分量按照像素格式中的最大值四舍五入缩放，非真彩色时使用颜色地图中最接近的颜色
+func TestEncodeRaw(t *testing.T) {
+	cases := []struct {
+		name string
+		pf   rfb.PixelFormat
+		col  color.RGBA
+		want []byte
+	}{
+		{"bgr233", testBGR233, color.RGBA{200, 100, 50, 255}, []byte{5 | 3<<3 | 1<<6}},
+		{"rgb565", testRGB565, color.RGBA{200, 100, 50, 255}, []byte{0x26, 0xC3}},
+		{"rgb555", testRGB555, color.RGBA{200, 100, 50, 255}, []byte{0x86, 0x61}},
+		{"rgb888", rfb.PixelFormat32bit, color.RGBA{200, 100, 50, 255}, []byte{50, 100, 200, 0}},
+		{"颜色地图 相同颜色", testIndexed, color.RGBA{255, 255, 255, 255}, []byte{1}},
+		{"颜色地图 接近红色", testIndexed, color.RGBA{250, 10, 5, 255}, []byte{2}},
+		{"颜色地图 接近灰色", testIndexed, color.RGBA{120, 130, 125, 255}, []byte{3}},
+		{"颜色地图 接近黑色", testIndexed, color.RGBA{60, 60, 60, 255}, []byte{0}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cv := NewVncCanvas(2, 2)
+			cv.ColorMap = newTestColorMap()
+			cv.Set(1, 1, c.col)
+			var buf bytes.Buffer
+			if err := cv.EncodeRaw(&buf, &c.pf, image.Rect(1, 1, 2, 2)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.want) {
+				t.Fatalf("编码为%v，应该为%v", buf.Bytes(), c.want)
+			}
+		})
+	}
+
+	// 颜色地图中的颜色解码后再编码，得到原来的索引
+	cv := NewVncCanvas(4, 1)
+	cv.ColorMap = newTestColorMap()
+	data := []byte{0, 1, 2, 3}
+	if err := cv.DecodeRaw(bytes.NewReader(data), &testIndexed, &rfb.Rectangle{Width: 4, Height: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := cv.EncodeRaw(&buf, &testIndexed, cv.Bounds()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("颜色地图的索引%v编码为%v", data, buf.Bytes())
+	}
+}
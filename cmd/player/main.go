@@ -7,6 +7,8 @@ import (
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/osgochina/dmicro/easyservice"
 	"github.com/osgochina/dmicro/logger"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/vnc"
 	"golang.org/x/net/context"
 	"os"
 )
@@ -16,13 +18,22 @@ var (
 USAGE
 	./server [start|stop|quit] [tcpServer|wsServer] [OPTION]
 OPTION
-	--rbsFile       使用的rbs文件地址  必传
+	--rbsFile       使用的rbs文件地址，也支持FBS及vncrec格式的录屏  必传
 	--tcpHost       本地监听的tcp协议地址 默认0.0.0.0
 	--tcpPort       本地监听的tcp协议端口 默认8989
 	--proxyPassword 连接到proxy的密码   不传入密码则使用auth none
 	--wsHost        启动websocket服务的本地地址  默认 0.0.0.0
 	--wsPort        启动websocket服务的本地端口 默认8988
 	--wsPath        启动websocket服务的url path 默认'/'
+	--speed         播放速度 0.5到16倍 默认1
+	--idleLimit     跳过超过该时长的空闲时间 例如3s 默认不跳过
+	--loop          是否循环播放 默认loop=false
+	--seekStep      快捷键前进及后退的时长 默认10s
+	--overlay       是否在画面左下角显示播放信息 默认overlay=true
+	--hotkeys       播放控制的快捷键 例如space=pause,left=backward,right=forward 配置为none则不响应快捷键
+	--keyFile       解密录屏的密钥文件，播放加密的录屏时必传
+	--keyEnv        从该环境变量读取解密录屏的密钥，格式同keyFile
+	--verify        只校验录屏文件，输出每个分段第一个损坏的位置后退出，校验失败时退出码为1
 	--debug         是否开启debug 默认debug=false
 	-d,--daemon     使用守护进程模式启动
 	--pid           设置pid文件的地址，默认是/tmp/[server].pid
@@ -44,6 +55,7 @@ EXAMPLES
                                     --tcpPort=8989
                                     --proxyPassword=12345612
                                     --debug
+	/path/to/server --rbsFile=/path/to/foo.rbs.zst --keyFile=/path/to/rbs.key --verify
 	/path/to/server stop
 	/path/to/server quit
 	/path/to/server reload
@@ -52,18 +64,87 @@ EXAMPLES
 `)
 )
 
+// newPlayerOptions 根据配置生成播放器的配置
+func newPlayerOptions(cfg *gcfg.Config) ([]vnc.PlayerOption, error) {
+	opts := []vnc.PlayerOption{
+		vnc.OptPlaybackSpeed(cfg.MustGet(context.TODO(), "speed", 1).Float64()),
+		vnc.OptPlaybackIdleLimit(cfg.MustGet(context.TODO(), "idleLimit").Duration()),
+		vnc.OptPlaybackLoop(cfg.MustGet(context.TODO(), "loop").Bool()),
+		vnc.OptPlaybackSeekStep(cfg.MustGet(context.TODO(), "seekStep", "10s").Duration()),
+		vnc.OptPlaybackOverlay(cfg.MustGet(context.TODO(), "overlay", true).Bool()),
+	}
+	key, err := loadKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		opts = append(opts, vnc.OptPlaybackKey(key))
+	}
+	// 不配置则使用默认快捷键，配置为none则不响应快捷键
+	hotkeys := cfg.MustGet(context.TODO(), "hotkeys").String()
+	switch hotkeys {
+	case "":
+	case "none":
+		opts = append(opts, vnc.OptPlaybackHotkeys(nil))
+	default:
+		keys, err := vnc.ParsePlaybackHotkeys(hotkeys)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, vnc.OptPlaybackHotkeys(keys))
+	}
+	return opts, nil
+}
+
+// loadKey 读取解密录屏的密钥，未配置返回nil
+func loadKey(cfg *gcfg.Config) ([]byte, error) {
+	return rbs.LoadKey(
+		cfg.MustGet(context.TODO(), "keyFile").String(),
+		cfg.MustGet(context.TODO(), "keyEnv").String(),
+	)
+}
+
+// verify 校验录屏文件的所有分段并输出结果，全部通过返回true
+func verify(cfg *gcfg.Config) bool {
+	key, err := loadKey(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	results, err := rbs.Verify(cfg.MustGet(context.TODO(), "rbsFile").String(), rbs.OptKey(key))
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	ok := true
+	for _, r := range results {
+		fmt.Println(r)
+		ok = ok && r.OK()
+	}
+	return ok
+}
+
 func main() {
 	easyservice.Authors = "ClownFish"
 	easyservice.SetHelpContent(helpContent)
 	easyservice.SetOptions(
 		map[string]bool{
-			"tcpHost":       true, //本地监听的tcp协议地址 默认0.0.0.0
-			"tcpPort":       true, //本地监听的tcp协议端口 默认8989
-			"proxyPassword": true, //连接到proxy的密码   不传入密码则使用auth none
-			"wsHost":        true, //启动websocket服务的本地地址  默认 0.0.0.0
-			"wsPort":        true, //启动websocket服务的本地端口 默认8988
-			"wsPath":        true, //启动websocket服务的url path 默认'/'
-			"rbsFile":       true, // 使用的rbs文件地址  必传
+			"tcpHost":       true,  //本地监听的tcp协议地址 默认0.0.0.0
+			"tcpPort":       true,  //本地监听的tcp协议端口 默认8989
+			"proxyPassword": true,  //连接到proxy的密码   不传入密码则使用auth none
+			"wsHost":        true,  //启动websocket服务的本地地址  默认 0.0.0.0
+			"wsPort":        true,  //启动websocket服务的本地端口 默认8988
+			"wsPath":        true,  //启动websocket服务的url path 默认'/'
+			"rbsFile":       true,  // 使用的rbs文件地址  必传
+			"speed":         true,  // 播放速度 0.5到16倍 默认1
+			"idleLimit":     true,  // 跳过超过该时长的空闲时间 默认不跳过
+			"loop":          true,  // 是否循环播放
+			"seekStep":      true,  // 快捷键前进及后退的时长 默认10s
+			"overlay":       true,  // 是否显示播放信息 默认true
+			"hotkeys":       true,  // 播放控制的快捷键
+			"keyFile":       true,  // 解密录屏的密钥文件
+			"keyEnv":        true,  // 解密录屏的密钥环境变量
+			"verify":        false, // 只校验录屏文件
 		})
 
 	easyservice.Setup(func(svr *easyservice.EasyService) {
@@ -88,6 +169,22 @@ func main() {
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("wsHost", svr.CmdParser().GetOpt("wsHost", "0.0.0.0").String())
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("wsPort", svr.CmdParser().GetOpt("wsPort", 8988).Int())
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("wsPath", svr.CmdParser().GetOpt("wsPath", "/").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("speed", svr.CmdParser().GetOpt("speed", 1).Float64())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("idleLimit", svr.CmdParser().GetOpt("idleLimit", "0").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("loop", svr.CmdParser().GetOpt("loop", false).Bool())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("seekStep", svr.CmdParser().GetOpt("seekStep", "10s").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("overlay", svr.CmdParser().GetOpt("overlay", true).Bool())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("hotkeys", svr.CmdParser().GetOpt("hotkeys", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("keyFile", svr.CmdParser().GetOpt("keyFile", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("keyEnv", svr.CmdParser().GetOpt("keyEnv", "").String())
+
+		// 校验模式不启动服务，输出结果后退出
+		if svr.CmdParser().GetOpt("verify") != nil {
+			if !verify(cfg) {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
 
 		if svr.SandboxNames().ContainsI("tcpserver") {
 			svr.AddSandBox(NewTcpSandBox(cfg))
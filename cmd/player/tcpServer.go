@@ -48,16 +48,20 @@ func (that *TcpSandBox) Name() string {
 
 func (that *TcpSandBox) Setup() error {
 	var err error
-	addr := fmt.Sprintf("%s:%d", that.cfg.MustGet(context.TODO(), "tcpHost"), that.cfg.MustGet(context.TODO(), "tcpPort"))
+	addr := fmt.Sprintf("%s:%d", that.cfg.MustGet(context.TODO(), "tcpHost"), that.cfg.MustGet(context.TODO(), "tcpPort").Int())
 	that.lis, err = net.Listen("tcp", addr)
 	if err != nil {
 		glog.Fatalf(context.TODO(), "Error listen. %v", err)
 	}
-	fmt.Printf("Tcp proxy started! listening %s . vnc server %s:%d\n", that.lis.Addr().String(), that.cfg.MustGet(context.TODO(), "vncHost"), that.cfg.MustGet(context.TODO(), "vncPort"))
+	fmt.Printf("Tcp proxy started! listening %s . vnc server %s:%d\n", that.lis.Addr().String(), that.cfg.MustGet(context.TODO(), "vncHost"), that.cfg.MustGet(context.TODO(), "vncPort").Int())
 	securityHandlers := []rfb.ISecurityHandler{&security.ServerAuthNone{}}
 	if len(that.cfg.MustGet(context.TODO(), "proxyPassword").Bytes()) > 0 {
 		securityHandlers = append(securityHandlers, &security.ServerAuthVNC{Password: that.cfg.MustGet(context.TODO(), "proxyPassword").Bytes()})
 	}
+	playerOpts, err := newPlayerOptions(that.cfg)
+	if err != nil {
+		return err
+	}
 	for {
 		conn, err := that.lis.Accept()
 		if err != nil {
@@ -81,7 +85,7 @@ func (that *TcpSandBox) Setup() error {
 					return c, nil
 				}),
 			)
-			play := vnc.NewPlayer(that.cfg.MustGet(context.TODO(), "rbsFile").String(), svrSession)
+			play := vnc.NewPlayer(that.cfg.MustGet(context.TODO(), "rbsFile").String(), svrSession, playerOpts...)
 			err = play.Start()
 			if err != nil {
 				glog.Warning(context.TODO(), err)
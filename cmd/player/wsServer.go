@@ -47,6 +47,10 @@ func (that *WSSandBox) Name() string {
 func (that *WSSandBox) Setup() error {
 
 	that.svr = g.Server()
+	playerOpts, err := newPlayerOptions(that.cfg)
+	if err != nil {
+		return err
+	}
 	that.svr.BindHandler(that.cfg.MustGet(context.TODO(), "wsPath", "/").String(), func(r *ghttp.Request) {
 		h := websocket.Handler(func(conn *websocket.Conn) {
 			conn.PayloadType = websocket.BinaryFrame
@@ -61,7 +65,7 @@ func (that *WSSandBox) Setup() error {
 					return conn, nil
 				}),
 			)
-			play := vnc.NewPlayer(that.cfg.MustGet(context.TODO(), "rfbFile").String(), svrSession)
+			play := vnc.NewPlayer(that.cfg.MustGet(context.TODO(), "rbsFile").String(), svrSession, playerOpts...)
 			err := play.Start()
 			if err != nil {
 				glog.Warning(context.TODO(), err)
@@ -6,24 +6,70 @@ import (
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/osgochina/dmicro/easyservice"
 	"github.com/osgochina/dmicro/logger"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/vnc"
 	"golang.org/x/net/context"
 	"os"
 )
 
+// sharedHub tcp服务和websocket服务共用的共享会话中心
+var sharedHub = vnc.NewHub()
+
+// authLimiter tcp服务和websocket服务共用的认证限流器
+var authLimiter rfb.AuthLimiter
+
 var (
 	helpContent = gstr.TrimLeft(`
 USAGE
 	./proxy [start|stop|quit] [tcpServer|wsServer] [OPTION]
 OPTION
-	--vncHost       要连接的vnc服务端地址  必传
-	--vncPort       要连接的vnc服务端端口 必传
+	--vncHost       要连接的vnc服务端地址  未设置令牌解析时必传
+	--vncPort       要连接的vnc服务端端口 未设置令牌解析时必传
 	--vncPassword   要连接的vnc服务端密码 不传则使用auth none
+	--vncUsername   要连接的vnc服务端VeNCrypt Plain认证的用户名
+	--vncTls        使用VeNCrypt加密连接vnc服务端 默认false
+	--vncTlsCA      校验vnc服务端证书的ca文件 默认使用系统ca
+	--vncTlsCert    连接vnc服务端使用的客户端证书文件
+	--vncTlsKey     连接vnc服务端使用的客户端私钥文件
+	--vncRa2Key     固定vnc服务端RA2认证的rsa公钥指纹(sha256十六进制)，不一致则拒绝连接
+	--vncRa2KnownHosts 记录vnc服务端RA2认证rsa公钥的文件，首次连接时记录，之后公钥改变则拒绝连接 默认~/.config/vncproxy/ra2_known_hosts
 	--tcpHost       本地监听的tcp协议地址 默认0.0.0.0
 	--tcpPort       本地监听的tcp协议端口 默认8989
 	--proxyPassword 连接到proxy的密码   不传入密码则使用auth none
+	--viewOnlyPassword 连接到proxy的只读密码，使用该密码连接的vnc客户端只能查看不能操作
+	--viewOnly      所有vnc客户端都只能查看不能操作 默认false
+	--tlsCert       tcp服务使用VeNCrypt加密连接的证书文件，设置后tcp服务只支持VeNCrypt认证
+	--tlsKey        tcp服务使用VeNCrypt加密连接的私钥文件
+	--tlsClientCA   校验vnc客户端证书的ca文件，设置后X509*认证子类型要求vnc客户端提供证书
+	--htpasswd      使用htpasswd文件(bcrypt)认证vnc客户端的用户名密码，使用VeNCrypt Plain认证
+	--authUrl       使用http回调接口认证vnc客户端的用户名密码，接口可以返回该用户的令牌及vnc服务端凭证
+	--insecurePlainAuth 未设置tlsCert时允许VeNCrypt Plain认证明文传输用户名密码，只在已经使用https/wss加密时设置 默认false
+	--authMaxAttempts 时间窗口内允许认证失败的次数，超过后锁定该地址或者用户名 默认5，0表示不限制
+	--authWindow    统计认证失败次数的时间窗口 默认10m
+	--authLockout   首次锁定的时长，连续锁定时翻倍 默认1m
+	--statusPath    websocket服务提供状态接口的url path，返回共享会话数量及被锁定的vnc客户端，默认不提供
+	--statusToken   访问状态接口需要的令牌，通过 Authorization: Bearer {statusToken} 传入
 	--wsHost        启动websocket服务的本地地址  默认 0.0.0.0
 	--wsPort        启动websocket服务的本地端口 默认8988
 	--wsPath        启动websocket服务的url path 默认'/'
+	--tokenFile     令牌文件地址(toml/json)，根据令牌动态选择要连接的vnc服务端
+	--tokenUrl      令牌解析的http回调地址，根据令牌动态选择要连接的vnc服务端
+	                websocket连接通过url参数token或者路径 wsPath/{token} 传入令牌，
+	                tcp连接使用vnc密码作为令牌(仅支持tokenFile)
+	--shared        连接同一个vnc服务端的多个vnc客户端共享同一个连接 默认false
+	--recordDir     录屏保存的目录，设置后录制每个vnc客户端看到的画面及操作，文件名为 用户名_vnc服务端地址_开始时间.rbs
+	--recordRedactKeys 录屏时清空按键内容，防止泄露密码 默认false
+	--recordRedactPointer 录屏时清空鼠标位置，防止通过屏幕键盘泄露密码 默认false
+	--recordRedactClipboard 录屏时清空剪贴板内容 默认false
+	--recordStateless 录屏时只向vnc服务端协商无状态的编码，不需要解码但是转发的带宽更大 默认false
+	--recordCompress 录屏文件的压缩算法 none|gzip|zstd 默认none
+	--recordSegmentSize 录屏分段的最大大小，例如100M，默认不分段
+	--recordSegmentDuration 录屏分段的最长时长，例如1h，默认不分段
+	--recordMaxAge  录屏文件的最长保留时间，例如720h，默认不限制
+	--recordMaxSize 录屏目录的最大总大小，例如100G，默认不限制
+	--recordMaxFiles 录屏目录的最大文件数量，默认不限制
+	--recordKeyFile 加密录屏的密钥文件，密钥为16、24或32字节，可以是十六进制或base64，默认不加密
+	--recordKeyEnv  从该环境变量读取加密录屏的密钥，格式同recordKeyFile
 	--debug         是否开启debug 默认debug=false
 	-d,--daemon     使用守护进程模式启动
 	--pid           设置pid文件的地址，默认是/tmp/[server].pid
@@ -50,6 +96,9 @@ EXAMPLES
                                     --tcpPort=8989
                                     --proxyPassword=12345612
                                     --debug
+	/path/to/proxy start wsServer,tcpServer --tokenFile=tokens.toml
+                                    --wsPort=8988
+                                    --tcpPort=8989
 	/path/to/proxy stop
 	/path/to/proxy quit
 	/path/to/proxy reload
@@ -63,15 +112,51 @@ func main() {
 	easyservice.SetHelpContent(helpContent)
 	easyservice.SetOptions(
 		map[string]bool{
-			"tcpHost":       true, //本地监听的tcp协议地址 默认0.0.0.0
-			"tcpPort":       true, //本地监听的tcp协议端口 默认8989
-			"proxyPassword": true, //连接到proxy的密码   不传入密码则使用auth none
-			"wsHost":        true, //启动websocket服务的本地地址  默认 0.0.0.0
-			"wsPort":        true, //启动websocket服务的本地端口 默认8988
-			"wsPath":        true, //启动websocket服务的url path 默认'/'
-			"vncHost":       true, // 要连接的vnc服务端地址  必传
-			"vncPort":       true, // 要连接的vnc服务端端口 必传
-			"vncPassword":   true, // 要连接的vnc服务端密码 不传则使用auth none
+			"tcpHost":               true,  //本地监听的tcp协议地址 默认0.0.0.0
+			"tcpPort":               true,  //本地监听的tcp协议端口 默认8989
+			"proxyPassword":         true,  //连接到proxy的密码   不传入密码则使用auth none
+			"wsHost":                true,  //启动websocket服务的本地地址  默认 0.0.0.0
+			"wsPort":                true,  //启动websocket服务的本地端口 默认8988
+			"wsPath":                true,  //启动websocket服务的url path 默认'/'
+			"vncHost":               true,  // 要连接的vnc服务端地址  必传
+			"vncPort":               true,  // 要连接的vnc服务端端口 必传
+			"vncPassword":           true,  // 要连接的vnc服务端密码 不传则使用auth none
+			"vncUsername":           true,  // 要连接的vnc服务端VeNCrypt Plain认证的用户名
+			"vncTls":                false, // 使用VeNCrypt加密连接vnc服务端
+			"vncTlsCA":              true,  // 校验vnc服务端证书的ca文件
+			"vncTlsCert":            true,  // 客户端证书文件
+			"vncTlsKey":             true,  // 客户端私钥文件
+			"vncRa2Key":             true,  // 固定vnc服务端RA2认证的公钥指纹
+			"vncRa2KnownHosts":      true,  // 记录vnc服务端RA2认证公钥的文件
+			"tokenFile":             true,  // 令牌文件地址
+			"tokenUrl":              true,  // 令牌解析的http回调地址
+			"shared":                false, // 多个vnc客户端共享同一个vnc服务端连接
+			"viewOnlyPassword":      true,  // 连接到proxy的只读密码
+			"viewOnly":              false, // 所有vnc客户端只读
+			"tlsCert":               true,  // VeNCrypt证书文件
+			"tlsKey":                true,  // VeNCrypt私钥文件
+			"tlsClientCA":           true,  // 校验vnc客户端证书的ca文件
+			"htpasswd":              true,  // htpasswd文件
+			"authUrl":               true,  // 用户认证的http回调地址
+			"insecurePlainAuth":     false, // 未设置证书时允许明文的Plain认证
+			"authMaxAttempts":       true,  // 允许认证失败的次数
+			"authWindow":            true,  // 统计认证失败次数的时间窗口
+			"authLockout":           true,  // 首次锁定的时长
+			"statusPath":            true,  // 状态接口的url path
+			"statusToken":           true,  // 访问状态接口需要的令牌
+			"recordDir":             true,  // 录屏保存的目录
+			"recordRedactKeys":      false, // 录屏时清空按键内容
+			"recordRedactPointer":   false, // 录屏时清空鼠标位置
+			"recordRedactClipboard": false, // 录屏时清空剪贴板内容
+			"recordStateless":       false, // 录屏时只协商无状态的编码
+			"recordCompress":        true,  // 录屏文件的压缩算法
+			"recordSegmentSize":     true,  // 录屏分段的最大大小
+			"recordSegmentDuration": true,  // 录屏分段的最长时长
+			"recordMaxAge":          true,  // 录屏文件的最长保留时间
+			"recordMaxSize":         true,  // 录屏目录的最大总大小
+			"recordMaxFiles":        true,  // 录屏目录的最大文件数量
+			"recordKeyFile":         true,  // 加密录屏的密钥文件
+			"recordKeyEnv":          true,  // 加密录屏的密钥环境变量
 		})
 
 	easyservice.Setup(func(svr *easyservice.EasyService) {
@@ -81,19 +166,32 @@ func main() {
 			return true
 		})
 		cfg := svr.Config()
+		tokenFile := svr.CmdParser().GetOpt("tokenFile", "")
+		tokenUrl := svr.CmdParser().GetOpt("tokenUrl", "")
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("tokenFile", tokenFile.String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("tokenUrl", tokenUrl.String())
+		// 设置了令牌解析，则不需要固定的vnc服务端地址
+		useToken := len(tokenFile.String()) > 0 || len(tokenUrl.String()) > 0
 		vncHost := svr.CmdParser().GetOpt("vncHost", "")
-		if len(vncHost.String()) <= 0 {
+		if len(vncHost.String()) <= 0 && !useToken {
 			svr.Help()
 			os.Exit(0)
 		}
 		vncPort := svr.CmdParser().GetOpt("vncPort", 0)
-		if vncPort.Int() <= 0 {
+		if vncPort.Int() <= 0 && !useToken {
 			svr.Help()
 			os.Exit(0)
 		}
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncHost", vncHost.String())
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncPort", vncPort.Int())
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncPassword", svr.CmdParser().GetOpt("vncPassword", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncUsername", svr.CmdParser().GetOpt("vncUsername", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncTls", svr.CmdParser().GetOpt("vncTls") != nil)
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncTlsCA", svr.CmdParser().GetOpt("vncTlsCA", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncTlsCert", svr.CmdParser().GetOpt("vncTlsCert", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncTlsKey", svr.CmdParser().GetOpt("vncTlsKey", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncRa2Key", svr.CmdParser().GetOpt("vncRa2Key", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncRa2KnownHosts", svr.CmdParser().GetOpt("vncRa2KnownHosts", "").String())
 
 		logger.SetDebug(cfg.MustGet(context.TODO(), "Debug").Bool())
 
@@ -103,6 +201,34 @@ func main() {
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("wsHost", svr.CmdParser().GetOpt("wsHost", "0.0.0.0").String())
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("wsPort", svr.CmdParser().GetOpt("wsPort", 8988).Int())
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("wsPath", svr.CmdParser().GetOpt("wsPath", "/").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("shared", svr.CmdParser().GetOpt("shared") != nil)
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("viewOnlyPassword", svr.CmdParser().GetOpt("viewOnlyPassword", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("viewOnly", svr.CmdParser().GetOpt("viewOnly") != nil)
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("tlsCert", svr.CmdParser().GetOpt("tlsCert", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("tlsKey", svr.CmdParser().GetOpt("tlsKey", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("tlsClientCA", svr.CmdParser().GetOpt("tlsClientCA", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("htpasswd", svr.CmdParser().GetOpt("htpasswd", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("authUrl", svr.CmdParser().GetOpt("authUrl", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("insecurePlainAuth", svr.CmdParser().GetOpt("insecurePlainAuth") != nil)
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("authMaxAttempts", svr.CmdParser().GetOpt("authMaxAttempts", 5).Int())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("authWindow", svr.CmdParser().GetOpt("authWindow", "10m").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("authLockout", svr.CmdParser().GetOpt("authLockout", "1m").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("statusPath", svr.CmdParser().GetOpt("statusPath", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("statusToken", svr.CmdParser().GetOpt("statusToken", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordDir", svr.CmdParser().GetOpt("recordDir", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordRedactKeys", svr.CmdParser().GetOpt("recordRedactKeys") != nil)
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordRedactPointer", svr.CmdParser().GetOpt("recordRedactPointer") != nil)
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordRedactClipboard", svr.CmdParser().GetOpt("recordRedactClipboard") != nil)
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordStateless", svr.CmdParser().GetOpt("recordStateless") != nil)
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordCompress", svr.CmdParser().GetOpt("recordCompress", "none").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordSegmentSize", svr.CmdParser().GetOpt("recordSegmentSize", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordSegmentDuration", svr.CmdParser().GetOpt("recordSegmentDuration", "0").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordMaxAge", svr.CmdParser().GetOpt("recordMaxAge", "0").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordMaxSize", svr.CmdParser().GetOpt("recordMaxSize", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordMaxFiles", svr.CmdParser().GetOpt("recordMaxFiles", 0).Int())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordKeyFile", svr.CmdParser().GetOpt("recordKeyFile", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("recordKeyEnv", svr.CmdParser().GetOpt("recordKeyEnv", "").String())
+		authLimiter = newAuthLimiter(cfg)
 
 		if svr.SandboxNames().ContainsI("tcpserver") {
 			svr.AddSandBox(NewTcpSandBox(cfg))
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/session"
+	"github.com/vprix/vncproxy/vnc"
+	"golang.org/x/net/context"
+)
+
+// newRecorderFactory 根据配置生成录屏会话工厂，未配置录屏目录则返回nil
+func newRecorderFactory(cfg *gcfg.Config) (vnc.RecorderFactory, error) {
+	dir := cfg.MustGet(context.TODO(), "recordDir").String()
+	if len(dir) == 0 {
+		return nil, nil
+	}
+	compression, err := rbs.ParseCompression(cfg.MustGet(context.TODO(), "recordCompress").String())
+	if err != nil {
+		return nil, err
+	}
+	key, err := rbs.LoadKey(
+		cfg.MustGet(context.TODO(), "recordKeyFile").String(),
+		cfg.MustGet(context.TODO(), "recordKeyEnv").String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	factory := vnc.FileRecorder(dir,
+		vnc.OptRecordCompression(compression),
+		vnc.OptRecordRotation(
+			gfile.StrToSize(cfg.MustGet(context.TODO(), "recordSegmentSize").String()),
+			cfg.MustGet(context.TODO(), "recordSegmentDuration").Duration(),
+		),
+		vnc.OptRecordRetention(rbs.Retention{
+			MaxAge:   cfg.MustGet(context.TODO(), "recordMaxAge").Duration(),
+			MaxSize:  gfile.StrToSize(cfg.MustGet(context.TODO(), "recordMaxSize").String()),
+			MaxFiles: cfg.MustGet(context.TODO(), "recordMaxFiles").Int(),
+		}),
+		vnc.OptRecordEncryptKey(key),
+	)
+	redactKeys := cfg.MustGet(context.TODO(), "recordRedactKeys").Bool()
+	redactPointer := cfg.MustGet(context.TODO(), "recordRedactPointer").Bool()
+	redactClipboard := cfg.MustGet(context.TODO(), "recordRedactClipboard").Bool()
+	return func(proxy *vnc.Proxy) (*session.RecorderSession, error) {
+		recorder, err := factory(proxy)
+		if err != nil || recorder == nil {
+			return recorder, err
+		}
+		recorder.SetRedactKeys(redactKeys)
+		recorder.SetRedactPointer(redactPointer)
+		recorder.SetRedactClipboard(redactClipboard)
+		return recorder, nil
+	}, nil
+}
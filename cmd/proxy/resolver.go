@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/vprix/vncproxy/resolver"
+	"github.com/vprix/vncproxy/rfb"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// newTargetConfig 根据配置生成固定的vnc服务端配置
+func newTargetConfig(cfg *gcfg.Config) rfb.TargetConfig {
+	return rfb.TargetConfig{
+		Host:        cfg.MustGet(context.TODO(), "vncHost").String(),
+		Port:        cfg.MustGet(context.TODO(), "vncPort").Int(),
+		Password:    cfg.MustGet(context.TODO(), "vncPassword").Bytes(),
+		Username:    cfg.MustGet(context.TODO(), "vncUsername").Bytes(),
+		TLS:         cfg.MustGet(context.TODO(), "vncTls").Bool(),
+		TLSCAFile:   cfg.MustGet(context.TODO(), "vncTlsCA").String(),
+		TLSCertFile: cfg.MustGet(context.TODO(), "vncTlsCert").String(),
+		TLSKeyFile:  cfg.MustGet(context.TODO(), "vncTlsKey").String(),
+
+		RA2ServerKey:  cfg.MustGet(context.TODO(), "vncRa2Key").String(),
+		RA2KnownHosts: cfg.MustGet(context.TODO(), "vncRa2KnownHosts").String(),
+	}
+}
+
+// newTargetResolver 根据配置生成目标解析器，未配置令牌解析则返回nil
+func newTargetResolver(cfg *gcfg.Config) (rfb.TargetResolver, error) {
+	if tokenFile := cfg.MustGet(context.TODO(), "tokenFile").String(); len(tokenFile) > 0 {
+		return resolver.NewFileResolver(tokenFile)
+	}
+	if tokenUrl := cfg.MustGet(context.TODO(), "tokenUrl").String(); len(tokenUrl) > 0 {
+		return resolver.NewHttpResolver(tokenUrl, 5*time.Second), nil
+	}
+	return nil, nil
+}
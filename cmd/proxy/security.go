@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/vprix/vncproxy/authenticator"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/security"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// newSecurityHandlers 根据配置生成vnc客户端连接proxy的认证方式
+// 设置了密码或者只读密码后只使用vnc auth认证，否则vnc客户端可以选择auth none绕过密码
+func newSecurityHandlers(cfg *gcfg.Config) []rfb.ISecurityHandler {
+	password := cfg.MustGet(context.TODO(), "proxyPassword").Bytes()
+	viewOnlyPassword := cfg.MustGet(context.TODO(), "viewOnlyPassword").Bytes()
+	if len(password) == 0 && len(viewOnlyPassword) == 0 {
+		return withTight(&security.ServerAuthNone{})
+	}
+	return withTight(&security.ServerAuthVNC{Password: password, ViewOnlyPassword: viewOnlyPassword})
+}
+
+// withTight 同时提供tight认证，tight认证的内层使用同样的认证方式，方便tightvnc客户端连接
+func withTight(handlers ...rfb.ISecurityHandler) []rfb.ISecurityHandler {
+	return append(handlers, &security.ServerAuthTight{Handlers: handlers})
+}
+
+// newAuthenticator 根据配置生成认证vnc客户端用户名密码的认证器，未配置则返回nil
+func newAuthenticator(cfg *gcfg.Config) (rfb.Authenticator, error) {
+	if htpasswd := cfg.MustGet(context.TODO(), "htpasswd").String(); len(htpasswd) > 0 {
+		return authenticator.NewHtpasswdAuthenticator(htpasswd)
+	}
+	if authUrl := cfg.MustGet(context.TODO(), "authUrl").String(); len(authUrl) > 0 {
+		return authenticator.NewHttpAuthenticator(authUrl, 5*time.Second), nil
+	}
+	return nil, nil
+}
+
+// newVeNCryptSecurityHandler 配置了证书或者认证器则生成VeNCrypt认证，未配置返回nil。
+// 配置了证书时使用tls加密的子类型；未配置证书时Plain认证会明文传输用户名和密码，
+// 只有设置了insecurePlainAuth(例如websocket服务由反向代理提供https)才允许，否则返回错误。
+func newVeNCryptSecurityHandler(cfg *gcfg.Config, auth rfb.Authenticator) (rfb.ISecurityHandler, error) {
+	certFile := cfg.MustGet(context.TODO(), "tlsCert").String()
+	if len(certFile) == 0 {
+		if auth == nil {
+			return nil, nil
+		}
+		if !cfg.MustGet(context.TODO(), "insecurePlainAuth").Bool() {
+			return nil, fmt.Errorf("设置了认证器时需要设置tlsCert加密用户名和密码，已经使用https/wss加密时可以设置insecurePlainAuth")
+		}
+		return &security.ServerAuthVeNCrypt{Authenticator: auth}, nil
+	}
+	vencrypt, err := security.NewServerAuthVeNCrypt(
+		certFile,
+		cfg.MustGet(context.TODO(), "tlsKey").String(),
+		cfg.MustGet(context.TODO(), "tlsClientCA").String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	vencrypt.Authenticator = auth
+	vencrypt.VNCPassword = cfg.MustGet(context.TODO(), "proxyPassword").Bytes()
+	vencrypt.ViewOnlyPassword = cfg.MustGet(context.TODO(), "viewOnlyPassword").Bytes()
+	return vencrypt, nil
+}
+
+// newAuthLimiter 根据配置生成认证限流器，authMaxAttempts小于等于0则不限制认证失败次数
+func newAuthLimiter(cfg *gcfg.Config) rfb.AuthLimiter {
+	maxAttempts := cfg.MustGet(context.TODO(), "authMaxAttempts").Int()
+	if maxAttempts <= 0 {
+		return nil
+	}
+	limiter := security.NewAuthLimiter(
+		maxAttempts,
+		cfg.MustGet(context.TODO(), "authWindow").Duration(),
+		cfg.MustGet(context.TODO(), "authLockout").Duration(),
+	)
+	limiter.OnBlocked = func(client security.BlockedClient) {
+		if len(client.Username) > 0 {
+			glog.Warningf(context.TODO(), "用户 %s 认证失败次数过多，锁定到 %s", client.Username, client.Until.Format(time.DateTime))
+			return
+		}
+		glog.Warningf(context.TODO(), "vnc客户端 %s 认证失败次数过多，锁定到 %s", client.RemoteAddr, client.Until.Format(time.DateTime))
+	}
+	return limiter
+}
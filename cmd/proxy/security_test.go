@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/vprix/vncproxy/authenticator"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/security"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestConfig 使用data生成proxy的配置
+func newTestConfig(t *testing.T, data map[string]any) *gcfg.Config {
+	t.Helper()
+	content, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter, err := gcfg.NewAdapterContent(string(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gcfg.NewWithAdapter(adapter)
+}
+
+// writeTestCert 生成自签名的证书及私钥文件
+func writeTestCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vncproxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"vncproxy"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// TestNewVeNCryptSecurityHandler 设置了认证器时Plain认证必须使用tls加密，除非明确允许明文传输
+func TestNewVeNCryptSecurityHandler(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	auth := authenticator.NewPasswordAuthenticator(map[string]string{"alice": "secret"})
+	cases := []struct {
+		name string
+		data map[string]any
+		auth rfb.Authenticator
+		ok   bool
+		none bool
+		tls  bool
+	}{
+		{"没有配置", map[string]any{}, nil, true, true, false},
+		{"认证器没有证书", map[string]any{}, auth, false, true, false},
+		{"认证器允许明文", map[string]any{"insecurePlainAuth": true}, auth, true, false, false},
+		{"认证器使用证书", map[string]any{"tlsCert": certFile, "tlsKey": keyFile}, auth, true, false, true},
+		{"只配置证书", map[string]any{"tlsCert": certFile, "tlsKey": keyFile}, nil, true, false, true},
+		{"证书文件不存在", map[string]any{"tlsCert": certFile + ".missing", "tlsKey": keyFile}, auth, false, true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h, err := newVeNCryptSecurityHandler(newTestConfig(t, c.data), c.auth)
+			if (err == nil) != c.ok {
+				t.Fatalf("返回的错误为%v", err)
+			}
+			if (h == nil) != c.none {
+				t.Fatalf("返回的认证方式为%v", h)
+			}
+			if h == nil {
+				return
+			}
+			vencrypt := h.(*security.ServerAuthVeNCrypt)
+			if (vencrypt.TLSConfig != nil) != c.tls {
+				t.Fatalf("是否使用tls为%v", vencrypt.TLSConfig != nil)
+			}
+			if vencrypt.Authenticator != c.auth {
+				t.Fatal("没有使用配置的认证器")
+			}
+		})
+	}
+}
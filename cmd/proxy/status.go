@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/security"
+	"net/http"
+	"time"
+)
+
+// blockedStatus 状态接口中被锁定的vnc客户端
+type blockedStatus struct {
+	RemoteAddr string    `json:"remoteAddr,omitempty"` // 被锁定的地址
+	Username   string    `json:"username,omitempty"`   // 被锁定的用户名
+	Lockouts   int       `json:"lockouts"`             // 连续被锁定的次数
+	Until      time.Time `json:"until"`                // 锁定的截止时间
+}
+
+// proxyStatus 状态接口返回的内容
+type proxyStatus struct {
+	SharedSessions int             `json:"sharedSessions"` // 当前共享会话的数量
+	Blocked        []blockedStatus `json:"blocked"`        // 当前被锁定的vnc客户端
+}
+
+// newProxyStatus 获取proxy当前的状态，认证限流器支持查询时列出被锁定的vnc客户端
+func newProxyStatus(limiter rfb.AuthLimiter) proxyStatus {
+	status := proxyStatus{
+		SharedSessions: sharedHub.Len(),
+		Blocked:        []blockedStatus{},
+	}
+	if l, ok := limiter.(*security.AuthLimiter); ok {
+		for _, client := range l.Blocked() {
+			status.Blocked = append(status.Blocked, blockedStatus{
+				RemoteAddr: client.RemoteAddr,
+				Username:   client.Username,
+				Lockouts:   client.Lockouts,
+				Until:      client.Until,
+			})
+		}
+	}
+	return status
+}
+
+// newStatusHandler 生成状态接口，配置了statusToken时需要通过 Authorization: Bearer {statusToken} 访问
+func newStatusHandler(cfg *gcfg.Config) ghttp.HandlerFunc {
+	token := cfg.MustGet(context.TODO(), "statusToken").String()
+	return func(r *ghttp.Request) {
+		if len(token) > 0 && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			r.Response.WriteStatus(http.StatusUnauthorized)
+			return
+		}
+		r.Response.WriteJson(newProxyStatus(authLimiter))
+	}
+}
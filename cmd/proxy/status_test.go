@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/vprix/vncproxy/security"
+	"testing"
+	"time"
+)
+
+func TestNewProxyStatus(t *testing.T) {
+	if status := newProxyStatus(nil); status.Blocked == nil || len(status.Blocked) != 0 {
+		t.Fatalf("未设置认证限流器时被锁定的vnc客户端为%v", status.Blocked)
+	}
+	limiter := security.NewAuthLimiter(2, time.Minute, time.Minute)
+	limiter.Backoff = 0
+	limiter.Fail("10.0.0.1", "alice")
+	limiter.Fail("10.0.0.1", "alice")
+	limiter.Fail("10.0.0.2", "")
+	status := newProxyStatus(limiter)
+	if len(status.Blocked) != 2 {
+		t.Fatalf("被锁定的vnc客户端为%v，期望地址及用户名各一条", status.Blocked)
+	}
+	var addr, user bool
+	for _, b := range status.Blocked {
+		addr = addr || b.RemoteAddr == "10.0.0.1"
+		user = user || b.Username == "alice"
+		if b.Lockouts != 1 || time.Until(b.Until) <= 0 {
+			t.Fatalf("锁定信息不正确:%+v", b)
+		}
+	}
+	if !addr || !user {
+		t.Fatalf("被锁定的vnc客户端为%v", status.Blocked)
+	}
+}
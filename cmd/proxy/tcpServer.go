@@ -15,7 +15,6 @@ import (
 	"golang.org/x/net/context"
 	"io"
 	"net"
-	"time"
 )
 
 // TcpSandBox  Tcp的服务
@@ -57,17 +56,46 @@ func (that *TcpSandBox) Setup() error {
 	if err != nil {
 		glog.Fatalf(context.TODO(), "Error listen. %v", err)
 	}
-	fmt.Printf("Tcp proxy started! listening %s . vnc server %s:%d\n", that.lis.Addr().String(), that.cfg.MustGet(context.TODO(), "vncHost").String(), that.cfg.MustGet(context.TODO(), "vncPort"))
-	securityHandlers := []rfb.ISecurityHandler{
-		&security.ServerAuthNone{},
+	targetResolver, err := newTargetResolver(that.cfg)
+	if err != nil {
+		return err
+	}
+	auth, err := newAuthenticator(that.cfg)
+	if err != nil {
+		return err
+	}
+	securityHandlers := newSecurityHandlers(that.cfg)
+	// 配置了证书或者认证器则只允许使用VeNCrypt认证
+	vencrypt, err := newVeNCryptSecurityHandler(that.cfg, auth)
+	if err != nil {
+		return err
 	}
-	if len(that.cfg.MustGet(context.TODO(), "proxyPassword").Bytes()) > 0 {
-		securityHandlers = append(securityHandlers, &security.ServerAuthVNC{Password: that.cfg.MustGet(context.TODO(), "proxyPassword").Bytes()})
+	if vencrypt != nil {
+		securityHandlers = []rfb.ISecurityHandler{vencrypt}
 	}
-	targetCfg := rfb.TargetConfig{
-		Host:     that.cfg.MustGet(context.TODO(), "vncHost").String(),
-		Port:     that.cfg.MustGet(context.TODO(), "vncPort").Int(),
-		Password: that.cfg.MustGet(context.TODO(), "vncPassword").Bytes(),
+	targetCfg := newTargetConfig(that.cfg)
+	recorderFactory, err := newRecorderFactory(that.cfg)
+	if err != nil {
+		return err
+	}
+	// tcp连接无法携带url参数，使用vnc密码作为令牌，所以只支持能够列出全部令牌的解析器
+	// 配置了认证器时使用认证器给出的令牌
+	useToken := false
+	if lister, ok := targetResolver.(rfb.TokenLister); ok && auth == nil {
+		if vencrypt != nil {
+			return fmt.Errorf("tcp代理使用vnc密码作为令牌时不支持VeNCrypt")
+		}
+		useToken = true
+		securityHandlers = withTight(&security.ServerAuthVNCToken{Lister: lister})
+		fmt.Printf("Tcp proxy started! listening %s . vnc server resolved by token\n", that.lis.Addr().String())
+	} else if auth != nil && targetResolver != nil {
+		useToken = true
+		fmt.Printf("Tcp proxy started! listening %s . vnc server resolved by user token\n", that.lis.Addr().String())
+	} else {
+		if len(targetCfg.Host) == 0 {
+			return fmt.Errorf("tcp代理使用vnc密码作为令牌，只支持tokenFile的令牌解析方式")
+		}
+		fmt.Printf("Tcp proxy started! listening %s . vnc server %s\n", that.lis.Addr().String(), targetCfg.Addr())
 	}
 	for {
 		conn, err := that.lis.Accept()
@@ -91,19 +119,29 @@ func (that *TcpSandBox) Setup() error {
 				rfb.OptHeight(768),
 				rfb.OptWidth(1024),
 				rfb.OptSecurityHandlers(securityHandlers...),
+				rfb.OptAuthLimiter(authLimiter),
 				rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
 					return c, nil
 				}),
 			)
-			timeout := 10 * time.Second
-			network := "tcp"
-			cliSess := session.NewClient(
-				rfb.OptSecurityHandlers([]rfb.ISecurityHandler{&security.ClientAuthVNC{Password: targetCfg.Password}}...),
-				rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
-					return net.DialTimeout(network, targetCfg.Addr(), timeout)
-				}),
-			)
-			p := vnc.NewVncProxy(cliSess, svrSess)
+			var opts []vnc.ProxyOption
+			if that.cfg.MustGet(context.TODO(), "shared").Bool() {
+				opts = append(opts, vnc.OptSharedHub(sharedHub))
+			}
+			if that.cfg.MustGet(context.TODO(), "viewOnly").Bool() {
+				opts = append(opts, vnc.OptViewOnly(true))
+			}
+			if recorderFactory != nil {
+				opts = append(opts, vnc.OptRecorder(recorderFactory),
+					vnc.OptRecordStatelessEncodings(that.cfg.MustGet(context.TODO(), "recordStateless").Bool()))
+			}
+			if useToken {
+				opts = append(opts, vnc.OptTargetResolver(targetResolver))
+			}
+			if len(targetCfg.Host) > 0 {
+				opts = append(opts, vnc.OptTarget(targetCfg))
+			}
+			p := vnc.NewVncProxy(nil, svrSess, opts...)
 			remoteKey := c.RemoteAddr().String()
 			that.proxyHub.Set(remoteKey, p)
 			err = p.Start()
@@ -111,6 +149,10 @@ func (that *TcpSandBox) Setup() error {
 				glog.Warning(context.TODO(), err)
 				return
 			}
+			if identity := p.Identity(); identity != nil {
+				glog.Infof(context.TODO(), "proxy session closed, user:%s", identity.Username)
+				return
+			}
 			glog.Info(context.TODO(), "proxy session closed")
 		}(conn)
 
@@ -10,12 +10,12 @@ import (
 	"github.com/gogf/gf/v2/os/glog"
 	"github.com/osgochina/dmicro/easyservice"
 	"github.com/vprix/vncproxy/rfb"
-	"github.com/vprix/vncproxy/security"
 	"github.com/vprix/vncproxy/session"
 	"github.com/vprix/vncproxy/vnc"
 	"golang.org/x/net/websocket"
 	"io"
-	"net"
+	"net/http"
+	"strings"
 )
 
 // WSSandBox  Tcp的服务
@@ -49,39 +49,74 @@ func (that *WSSandBox) Name() string {
 }
 
 func (that *WSSandBox) Setup() error {
-
 	that.svr = g.Server()
-	that.svr.BindHandler(that.cfg.MustGet(context.TODO(), "wsPath", "/").String(), func(r *ghttp.Request) {
+
+	targetResolver, err := newTargetResolver(that.cfg)
+	if err != nil {
+		return err
+	}
+	auth, err := newAuthenticator(that.cfg)
+	if err != nil {
+		return err
+	}
+	// 配置了认证器则使用VeNCrypt的Plain认证，配置了证书时在websocket连接内建立tls连接，
+	// 否则需要设置insecurePlainAuth，由https/wss负责加密
+	var vencrypt rfb.ISecurityHandler
+	if auth != nil {
+		if vencrypt, err = newVeNCryptSecurityHandler(that.cfg, auth); err != nil {
+			return err
+		}
+	}
+	recorderFactory, err := newRecorderFactory(that.cfg)
+	if err != nil {
+		return err
+	}
+	wsPath := that.cfg.MustGet(context.TODO(), "wsPath", "/").String()
+	wsHandler := func(r *ghttp.Request) {
+		// 令牌可以通过url参数token或者路径 wsPath/{token} 传入，配置了认证器时也可以由认证器给出
+		token := r.Get("token").String()
+		if targetResolver != nil && len(token) == 0 && auth == nil {
+			r.Response.WriteStatus(http.StatusBadRequest, "token required")
+			return
+		}
 		h := websocket.Handler(func(conn *websocket.Conn) {
 			conn.PayloadType = websocket.BinaryFrame
-			securityHandlers := []rfb.ISecurityHandler{
-				&security.ServerAuthNone{},
-			}
-			if len(that.cfg.MustGet(context.TODO(), "proxyPassword").Bytes()) > 0 {
-				securityHandlers = append(securityHandlers, &security.ServerAuthVNC{Password: that.cfg.MustGet(context.TODO(), "proxyPassword").Bytes()})
-			}
-			targetCfg := rfb.TargetConfig{
-				Host:     that.cfg.MustGet(context.TODO(), "vncHost").String(),
-				Port:     that.cfg.MustGet(context.TODO(), "vncPort").Int(),
-				Password: that.cfg.MustGet(context.TODO(), "vncPassword").Bytes(),
+			securityHandlers := newSecurityHandlers(that.cfg)
+			if vencrypt != nil {
+				securityHandlers = []rfb.ISecurityHandler{vencrypt}
 			}
+			targetCfg := newTargetConfig(that.cfg)
 			var err error
 			svrSess := session.NewServerSession(
 				rfb.OptDesktopName([]byte("Vprix VNC Proxy")),
 				rfb.OptHeight(768),
 				rfb.OptWidth(1024),
 				rfb.OptSecurityHandlers(securityHandlers...),
+				rfb.OptAuthLimiter(authLimiter),
 				rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
 					return conn, nil
 				}),
 			)
-			cliSess := session.NewClient(
-				rfb.OptSecurityHandlers([]rfb.ISecurityHandler{&security.ClientAuthVNC{Password: targetCfg.Password}}...),
-				rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
-					return net.DialTimeout(targetCfg.GetNetwork(), targetCfg.Addr(), targetCfg.GetTimeout())
-				}),
-			)
-			p := vnc.NewVncProxy(cliSess, svrSess)
+			// websocket连接的对端地址是Origin，需要使用http请求的地址
+			svrSess.Swap().Set(rfb.SwapKeyRemoteAddr, r.GetRemoteIp())
+			var opts []vnc.ProxyOption
+			if that.cfg.MustGet(context.TODO(), "shared").Bool() {
+				opts = append(opts, vnc.OptSharedHub(sharedHub))
+			}
+			if that.cfg.MustGet(context.TODO(), "viewOnly").Bool() {
+				opts = append(opts, vnc.OptViewOnly(true))
+			}
+			if recorderFactory != nil {
+				opts = append(opts, vnc.OptRecorder(recorderFactory),
+					vnc.OptRecordStatelessEncodings(that.cfg.MustGet(context.TODO(), "recordStateless").Bool()))
+			}
+			if targetResolver != nil {
+				opts = append(opts, vnc.OptTargetResolver(targetResolver), vnc.OptTargetToken(token))
+			}
+			if len(targetCfg.Host) > 0 {
+				opts = append(opts, vnc.OptTarget(targetCfg))
+			}
+			p := vnc.NewVncProxy(nil, svrSess, opts...)
 			remoteKey := conn.RemoteAddr().String()
 			that.proxyHub.Set(remoteKey, p)
 			err = p.Start()
@@ -89,10 +124,22 @@ func (that *WSSandBox) Setup() error {
 				glog.Warning(context.TODO(), err)
 				return
 			}
+			if identity := p.Identity(); identity != nil {
+				glog.Infof(context.TODO(), "proxy session end, user:%s", identity.Username)
+				return
+			}
 			glog.Info(context.TODO(), "proxy session end")
 		})
 		h.ServeHTTP(r.Response.Writer, r.Request)
-	})
+	}
+	that.svr.BindHandler(wsPath, wsHandler)
+	if targetResolver != nil {
+		that.svr.BindHandler(strings.TrimRight(wsPath, "/")+"/{token}", wsHandler)
+	}
+	// 状态接口，查看共享会话数量及被认证限流器锁定的vnc客户端
+	if statusPath := that.cfg.MustGet(context.TODO(), "statusPath").String(); len(statusPath) > 0 {
+		that.svr.BindHandler(statusPath, newStatusHandler(that.cfg))
+	}
 	that.svr.SetAddr(fmt.Sprintf("%s:%d", that.cfg.MustGet(context.TODO(), "wsHost").String(), that.cfg.MustGet(context.TODO(), "wsPort").Int()))
 	return that.svr.Start()
 }
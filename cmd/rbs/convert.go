@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"io"
+	"os"
+	"strings"
+)
+
+// fbsExt FBS格式录屏文件的扩展名
+const fbsExt = ".fbs"
+
+// convertCommand 转换录屏的格式
+func convertCommand() *gcmd.Command {
+	return &gcmd.Command{
+		Name:      "convert",
+		Usage:     "rbs convert -o OUTPUT [OPTION] FILE",
+		Brief:     "转换录屏的格式，可以读取rbs、FBS及vncrec格式的录屏，输出的文件扩展名为.fbs时转换成FBS格式，否则转换成rbs格式",
+		Arguments: outputArguments,
+		Func: func(ctx context.Context, parser *gcmd.Parser) error {
+			path, err := inputFile(parser)
+			if err != nil {
+				return err
+			}
+			key, err := loadKey(parser)
+			if err != nil {
+				return err
+			}
+			src, err := openRecording(path, key)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = src.Close()
+			}()
+			if strings.HasSuffix(parser.GetOpt("output").String(), fbsExt) {
+				return exportFBS(parser, src, path)
+			}
+			dst, err := createRecording(parser, src.Header(), startTime(src.Header()), path)
+			if err != nil {
+				return err
+			}
+			return finish(parser, dst, convert(src, dst))
+		},
+	}
+}
+
+// convert 把src的记录按原来的时间写入dst
+func convert(src *session.PlayerSession, dst *session.RecorderSession) error {
+	for {
+		entry, err := src.ReadEntry()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = dst.WriteEntry(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// exportFBS 把src转换成FBS格式，FBS格式只有vnc服务端发送的消息，不会加密及压缩，出错时删除输出的文件
+func exportFBS(parser *gcmd.Parser, src *session.PlayerSession, inputs ...string) error {
+	path, err := outputPath(parser, inputs...)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	err = writeFBS(src, bw)
+	if e := bw.Flush(); err == nil {
+		err = e
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// writeFBS 把src中vnc服务端发送的消息按原来的时间写入FBS格式的录屏，每个消息一个数据块
+func writeFBS(src *session.PlayerSession, w io.Writer) error {
+	fw, err := rbs.NewFBSWriter(w, src.Header())
+	if err != nil {
+		return err
+	}
+	buf := &messageBuffer{ISession: src}
+	for {
+		entry, err := src.ReadEntry()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.Type == rbs.ChunkClientMessage {
+			continue
+		}
+		buf.buff.Reset()
+		if err = entry.Message.Write(buf); err != nil {
+			return err
+		}
+		if err = fw.WriteBlock(entry.Timestamp, buf.buff.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+// messageBuffer 把消息编码到内存中，编码需要的配置信息从读取录屏的会话中获取
+type messageBuffer struct {
+	rfb.ISession
+	buff bytes.Buffer
+}
+
+func (that *messageBuffer) Write(p []byte) (int, error) {
+	return that.buff.Write(p)
+}
+
+func (that *messageBuffer) Flush() error {
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+)
+
+// dumpCommand 把录屏中的记录输出为JSON，每行一条记录
+func dumpCommand() *gcmd.Command {
+	return &gcmd.Command{
+		Name:  "dump",
+		Usage: "rbs dump [OPTION] FILE",
+		Brief: "把录屏中的记录按顺序输出为JSON，每行一条记录，帧缓冲更新只输出矩形的位置、大小及编码",
+		Arguments: append([]gcmd.Argument{
+			{Name: "from", Default: "0", Brief: "从该时间之前最近的关键帧开始输出 默认从头开始"},
+		}, inputArguments...),
+		Func: func(ctx context.Context, parser *gcmd.Parser) error {
+			path, err := inputFile(parser)
+			if err != nil {
+				return err
+			}
+			key, err := loadKey(parser)
+			if err != nil {
+				return err
+			}
+			src, err := openRecording(path, key)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = src.Close()
+			}()
+			if from := parser.GetOpt("from", "0").Duration(); from > 0 {
+				if _, err = src.Seek(from); err != nil {
+					return err
+				}
+			}
+			w := bufio.NewWriter(stdout)
+			defer func() {
+				_ = w.Flush()
+			}()
+			enc := json.NewEncoder(w)
+			for {
+				entry, err := src.ReadEntry()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				line := &dumpLine{
+					Timestamp: entry.Timestamp.Nanoseconds(),
+					Time:      entry.Timestamp.String(),
+					Chunk:     entry.Type.String(),
+					Message:   messageName(entry.Message),
+					Redacted:  entry.Redacted,
+					Data:      dumpMessage(entry.Message),
+				}
+				if err = enc.Encode(line); err != nil {
+					return err
+				}
+			}
+		},
+	}
+}
+
+// dumpLine 输出的一条记录
+type dumpLine struct {
+	Timestamp int64  `json:"ts"`   // 相对录屏开始的时间，纳秒
+	Time      string `json:"time"` // 相对录屏开始的时间，例如1m2.5s
+	Chunk     string `json:"chunk"`
+	Message   string `json:"message"`
+	Redacted  bool   `json:"redacted,omitempty"`
+	Data      any    `json:"data,omitempty"`
+}
+
+// dumpRect 帧缓冲更新中的矩形
+type dumpRect struct {
+	X        uint16 `json:"x"`
+	Y        uint16 `json:"y"`
+	Width    uint16 `json:"width"`
+	Height   uint16 `json:"height"`
+	Encoding string `json:"encoding"`
+}
+
+// dumpMessage 消息的内容，帧缓冲更新不输出像素数据
+func dumpMessage(msg rfb.Message) any {
+	switch m := msg.(type) {
+	case *messages.FramebufferUpdate:
+		rects := make([]dumpRect, len(m.Rects))
+		for i, rect := range m.Rects {
+			rects[i] = dumpRect{X: rect.X, Y: rect.Y, Width: rect.Width, Height: rect.Height, Encoding: encodingName(rect.EncType)}
+		}
+		return map[string]any{"rects": rects}
+	case *messages.KeyEvent:
+		return map[string]any{"down": m.Down, "key": uint32(m.Key), "keyName": m.Key.String()}
+	case *messages.QEMUExtKeyEvent:
+		return map[string]any{"down": m.DownFlag, "key": uint32(m.KeySym), "keyName": m.KeySym.String(), "keyCode": m.KeyCode}
+	case *messages.PointerEvent:
+		return map[string]any{"mask": m.Mask, "x": m.X, "y": m.Y}
+	case *messages.ClientCutText:
+		return map[string]any{"text": string(m.Text)}
+	case *messages.ServerCutText:
+		return map[string]any{"text": string(m.Text)}
+	case *messages.SetColorMapEntries:
+		return map[string]any{"firstColor": m.FirstColor, "colors": m.ColorsNum}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"io"
+	"os"
+	"time"
+)
+
+// cutCommand 截取录屏的一段
+func cutCommand() *gcmd.Command {
+	return &gcmd.Command{
+		Name:  "cut",
+		Usage: "rbs cut --from=1m --to=2m -o OUTPUT [OPTION] FILE",
+		Brief: "截取录屏的一段保存为新的录屏，开头使用截取位置的画面生成关键帧，时间从0开始",
+		Arguments: append([]gcmd.Argument{
+			{Name: "from", Default: "0", Brief: "截取的开始时间，例如1m30s 默认从头开始"},
+			{Name: "to", Default: "0", Brief: "截取的结束时间 默认到录屏结尾"},
+		}, outputArguments...),
+		Func: func(ctx context.Context, parser *gcmd.Parser) error {
+			path, err := inputFile(parser)
+			if err != nil {
+				return err
+			}
+			from := parser.GetOpt("from", "0").Duration()
+			to := parser.GetOpt("to", "0").Duration()
+			if from < 0 || (to > 0 && to <= from) {
+				return fmt.Errorf("截取的时间范围不正确:%s-%s", from, to)
+			}
+			key, err := loadKey(parser)
+			if err != nil {
+				return err
+			}
+			src, err := openRecording(path, key)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = src.Close()
+			}()
+			dst, err := createRecording(parser, src.Header(), startTime(src.Header()).Add(from), path)
+			if err != nil {
+				return err
+			}
+			return finish(parser, dst, cut(src, dst, from, to))
+		},
+	}
+}
+
+// finish 写入完成后关闭输出的录屏，出错时删除输出的录屏文件
+func finish(parser *gcmd.Parser, dst *session.RecorderSession, err error) error {
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		segments, _ := rbs.Segments(parser.GetOpt("output").String())
+		for _, p := range segments {
+			_ = os.Remove(p)
+		}
+	}
+	return err
+}
+
+// cut 把src中from到to的记录写入dst，to为0表示到录屏结尾。
+// 先跳转到from之前最近的关键帧，把之后的画面绘制到画布上，再把画布作为dst的第一个关键帧。
+func cut(src *session.PlayerSession, dst *session.RecorderSession, from, to time.Duration) error {
+	if _, err := src.Seek(from); err != nil {
+		return err
+	}
+	canvasSession := session.NewCanvasSession(
+		rfb.OptPixelFormat(src.Options().PixelFormat),
+		rfb.OptWidth(int(src.Options().Width)),
+		rfb.OptHeight(int(src.Options().Height)),
+	)
+	canvasSession.Start()
+	defer func() {
+		_ = canvasSession.Close()
+	}()
+	var cursor *rfb.Rectangle
+	started := false
+	for {
+		entry, err := src.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if to > 0 && entry.Timestamp > to {
+			break
+		}
+		// 关键帧只在跳转之后出现，与from之前的帧缓冲更新一起绘制到画布上
+		if entry.Type == rbs.ChunkKeyframe || entry.Timestamp < from {
+			if fbu, ok := entry.Message.(*messages.FramebufferUpdate); ok {
+				if err = fbu.Write(canvasSession); err != nil {
+					return err
+				}
+				for _, rect := range fbu.Rects {
+					if rect.EncType == rfb.EncCursorPseudo {
+						cursor = rect
+					}
+				}
+			}
+			continue
+		}
+		if !started {
+			keyframe, err := canvasSession.RawUpdate(src.Options().PixelFormat)
+			if err != nil {
+				return err
+			}
+			if cursor != nil {
+				keyframe.Rects = append(keyframe.Rects, cursor)
+				keyframe.NumRect = uint16(len(keyframe.Rects))
+			}
+			if err = dst.WriteEntry(&session.RecordEntry{Type: rbs.ChunkKeyframe, Message: keyframe}); err != nil {
+				return err
+			}
+			started = true
+		}
+		entry.Timestamp -= from
+		if err = dst.WriteEntry(entry); err != nil {
+			return err
+		}
+	}
+	if !started {
+		return fmt.Errorf("截取的时间范围内没有记录")
+	}
+	return nil
+}
+
+// concatCommand 按顺序合并多个录屏
+func concatCommand() *gcmd.Command {
+	return &gcmd.Command{
+		Name:      "concat",
+		Usage:     "rbs concat -o OUTPUT [OPTION] FILE1 FILE2...",
+		Brief:     "按顺序合并多个桌面大小相同的录屏，后一个录屏紧接着前一个录屏的结尾，像素格式不同时转码成第一个录屏的像素格式",
+		Arguments: outputArguments,
+		Func: func(ctx context.Context, parser *gcmd.Parser) error {
+			paths, err := inputFiles(parser)
+			if err != nil {
+				return err
+			}
+			key, err := loadKey(parser)
+			if err != nil {
+				return err
+			}
+			var dst *session.RecorderSession
+			var offset time.Duration
+			for _, path := range paths {
+				src, err := openRecording(path, key)
+				if err != nil {
+					return err
+				}
+				if dst == nil {
+					if dst, err = createRecording(parser, src.Header(), startTime(src.Header()), paths...); err != nil {
+						_ = src.Close()
+						return err
+					}
+				}
+				offset, err = concat(src, dst, offset)
+				_ = src.Close()
+				if err != nil {
+					return finish(parser, dst, fmt.Errorf("合并录屏文件[%s]失败:%v", path, err))
+				}
+			}
+			return finish(parser, dst, nil)
+		},
+	}
+}
+
+// concat 把src的记录写入dst，时间加上offset，返回下一个录屏的offset
+func concat(src *session.PlayerSession, dst *session.RecorderSession, offset time.Duration) (time.Duration, error) {
+	if src.Options().Width != dst.Options().Width || src.Options().Height != dst.Options().Height {
+		return 0, fmt.Errorf("桌面大小%dx%d与第一个录屏不一致", src.Options().Width, src.Options().Height)
+	}
+	// 录屏器把之后的帧缓冲更新从src的像素格式转码成文件头中的像素格式
+	if err := dst.WriteEntry(&session.RecordEntry{Timestamp: offset, Message: &messages.SetPixelFormat{PF: src.Options().PixelFormat}}); err != nil {
+		return 0, err
+	}
+	end := offset + src.Duration()
+	for {
+		entry, err := src.ReadEntry()
+		if err == io.EOF {
+			return end, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		entry.Timestamp += offset
+		if entry.Timestamp > end {
+			end = entry.Timestamp
+		}
+		if err = dst.WriteEntry(entry); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// speedupCommand 压缩录屏中的空闲时间
+func speedupCommand() *gcmd.Command {
+	return &gcmd.Command{
+		Name:  "speedup",
+		Usage: "rbs speedup --idleMax=3s -o OUTPUT [OPTION] FILE",
+		Brief: "把录屏中超过idleMax没有任何记录(包括键盘鼠标操作)的时间缩短为idleMax，保存为新的录屏",
+		Arguments: append([]gcmd.Argument{
+			{Name: "idleMax", Default: "3s", Brief: "保留的最长空闲时间 默认3s"},
+		}, outputArguments...),
+		Func: func(ctx context.Context, parser *gcmd.Parser) error {
+			path, err := inputFile(parser)
+			if err != nil {
+				return err
+			}
+			idleMax := parser.GetOpt("idleMax", "3s").Duration()
+			if idleMax <= 0 {
+				return fmt.Errorf("idleMax需要大于0")
+			}
+			key, err := loadKey(parser)
+			if err != nil {
+				return err
+			}
+			src, err := openRecording(path, key)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = src.Close()
+			}()
+			dst, err := createRecording(parser, src.Header(), startTime(src.Header()), path)
+			if err != nil {
+				return err
+			}
+			return finish(parser, dst, speedup(src, dst, idleMax))
+		},
+	}
+}
+
+// speedup 把src的记录写入dst，相邻记录的间隔超过idleMax时缩短为idleMax
+func speedup(src *session.PlayerSession, dst *session.RecorderSession, idleMax time.Duration) error {
+	var skipped, last time.Duration
+	for {
+		entry, err := src.ReadEntry()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if idle := entry.Timestamp - last; idle > idleMax {
+			skipped += idle - idleMax
+		}
+		last = entry.Timestamp
+		entry.Timestamp -= skipped
+		if err = dst.WriteEntry(entry); err != nil {
+			return err
+		}
+	}
+}
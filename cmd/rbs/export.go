@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/vprix/vncproxy/media"
+	"github.com/vprix/vncproxy/vnc"
+	"image"
+	"os"
+	"strings"
+)
+
+// exportCommand 把录屏导出为动画或者图片
+func exportCommand() *gcmd.Command {
+	return &gcmd.Command{
+		Name:  "export",
+		Usage: "rbs export --from=1m --to=1m20s -o OUTPUT [OPTION] FILE",
+		Brief: "把录屏的一段按固定的帧率导出，输出的文件扩展名为.gif时导出GIF动画，为.apng时导出APNG动画，" +
+			"为.png、.jpg或者.jpeg时导出按序号命名的图片，文件名中没有%05d这样的序号格式时在扩展名之前加上序号",
+		Arguments: append([]gcmd.Argument{
+			{Name: "from", Default: "0", Brief: "导出的开始时间，例如1m30s 默认从头开始"},
+			{Name: "to", Default: "0", Brief: "导出的结束时间 默认到录屏结尾"},
+			{Name: "fps", Default: "10", Brief: "每秒的帧数，GIF动画不能超过50 默认10"},
+			{Name: "scale", Default: "1", Brief: "画面的缩放比例，例如0.5把画面缩小一半 默认1"},
+			{Name: "crop", Brief: "只导出画面中的区域，格式为x,y,宽,高 默认整个画面"},
+			{Name: "cursor", Default: "true", Brief: "是否绘制鼠标指针 默认true"},
+			{Name: "quality", Default: "75", Brief: "JPEG图片的压缩质量 1-100 默认75"},
+			{Name: "output", Short: "o", Brief: "输出的文件  必传"},
+		}, inputArguments...),
+		Func: func(ctx context.Context, parser *gcmd.Parser) error {
+			path, err := inputFile(parser)
+			if err != nil {
+				return err
+			}
+			from := parser.GetOpt("from", "0").Duration()
+			to := parser.GetOpt("to", "0").Duration()
+			if from < 0 || (to > 0 && to <= from) {
+				return fmt.Errorf("导出的时间范围不正确:%s-%s", from, to)
+			}
+			fps := parser.GetOpt("fps", 10).Int()
+			if fps <= 0 {
+				return fmt.Errorf("fps需要大于0")
+			}
+			scale := parser.GetOpt("scale", 1).Float64()
+			if scale <= 0 {
+				return fmt.Errorf("scale需要大于0")
+			}
+			key, err := loadKey(parser)
+			if err != nil {
+				return err
+			}
+			opts := []vnc.VideoOption{
+				vnc.OptVideoRange(from, to),
+				vnc.OptVideoFPS(fps),
+				vnc.OptVideoScale(scale),
+				vnc.OptVideoDrawCursor(parser.GetOpt("cursor", true).Bool()),
+				vnc.OptVideoKey(key),
+			}
+			if crop := parser.GetOpt("crop").String(); len(crop) > 0 {
+				rect, err := parseCrop(crop)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, vnc.OptVideoCrop(rect))
+			}
+			output, err := outputPath(parser, path)
+			if err != nil {
+				return err
+			}
+			return export(path, output, parser.GetOpt("quality", media.DefaultJPEGQuality).Int(), opts...)
+		},
+	}
+}
+
+// export 根据输出文件的扩展名把录屏导出为GIF动画、APNG动画或者图片序列，导出动画出错时删除输出的文件
+func export(input, output string, quality int, opts ...vnc.VideoOption) error {
+	ext := strings.ToLower(gfile.Ext(output))
+	switch ext {
+	case ".png", ".jpg", ".jpeg":
+		return vnc.NewFileVideo(input, media.SequenceOutput(media.SequencePattern(output), quality), opts...).Start()
+	case ".gif", ".apng":
+	default:
+		return fmt.Errorf("不支持导出的文件格式:%s", output)
+	}
+	f, err := os.OpenFile(output, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	out := media.APNGOutput(f)
+	if ext == ".gif" {
+		out = media.GIFOutput(f)
+	}
+	err = vnc.NewFileVideo(input, out, opts...).Start()
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(output)
+	}
+	return err
+}
+
+// parseCrop 解析x,y,宽,高格式的区域
+func parseCrop(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("裁剪的区域格式不正确，需要是x,y,宽,高:%s", s)
+	}
+	v := make([]int, len(parts))
+	for i, p := range parts {
+		v[i] = gconv.Int(strings.TrimSpace(p))
+	}
+	if v[0] < 0 || v[1] < 0 || v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("裁剪的区域格式不正确，需要是x,y,宽,高:%s", s)
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
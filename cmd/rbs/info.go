@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// infoCommand 输出录屏的基本信息及统计
+func infoCommand() *gcmd.Command {
+	return &gcmd.Command{
+		Name:      "info",
+		Usage:     "rbs info [OPTION] FILE",
+		Brief:     "输出录屏的文件头、时长、关键帧数量，以及各类消息和帧缓冲更新中各种编码的数量",
+		Arguments: inputArguments,
+		Func: func(ctx context.Context, parser *gcmd.Parser) error {
+			path, err := inputFile(parser)
+			if err != nil {
+				return err
+			}
+			key, err := loadKey(parser)
+			if err != nil {
+				return err
+			}
+			segments, err := rbs.Segments(path)
+			if err != nil {
+				return err
+			}
+			src, err := openRecording(path, key)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = src.Close()
+			}()
+			s, err := stat(src)
+			if err != nil {
+				return err
+			}
+			header := src.Header()
+			fmt.Fprintf(stdout, "file:        %s\n", path)
+			fmt.Fprintf(stdout, "segments:    %d\n", len(segments))
+			fmt.Fprintf(stdout, "version:     %s\n", strings.TrimSpace(header.Version))
+			fmt.Fprintf(stdout, "protocol:    %s\n", strings.TrimSpace(header.ProtocolVersion))
+			fmt.Fprintf(stdout, "desktop:     %s\n", header.DesktopName)
+			fmt.Fprintf(stdout, "size:        %dx%d\n", header.Width, header.Height)
+			fmt.Fprintf(stdout, "pixelFormat: %s\n", header.PixelFormat)
+			if !header.StartTime.IsZero() {
+				fmt.Fprintf(stdout, "startTime:   %s\n", header.StartTime.Format(time.RFC3339))
+			}
+			fmt.Fprintf(stdout, "duration:    %s\n", s.duration)
+			fmt.Fprintf(stdout, "keyframes:   %d\n", len(src.Index()))
+			fmt.Fprintf(stdout, "frames:      %d\n", s.frames)
+			fmt.Fprintf(stdout, "rects:       %d\n", s.rects)
+			printHistogram("encodings:", s.encodings)
+			printHistogram("messages:", s.messages)
+			if s.redacted > 0 {
+				fmt.Fprintf(stdout, "redacted:    %d\n", s.redacted)
+			}
+			return nil
+		},
+	}
+}
+
+// recordingStat 录屏的统计信息
+type recordingStat struct {
+	duration  time.Duration  // 最后一条记录的时间
+	frames    int            // 帧缓冲更新的数量
+	rects     int            // 帧缓冲更新中矩形的数量
+	redacted  int            // 已经脱敏的记录数量
+	encodings map[string]int // 各种编码的矩形数量
+	messages  map[string]int // 各类消息的数量
+}
+
+// stat 顺序读取所有记录并统计
+func stat(src *session.PlayerSession) (*recordingStat, error) {
+	s := &recordingStat{
+		duration:  src.Duration(),
+		encodings: map[string]int{},
+		messages:  map[string]int{},
+	}
+	for {
+		entry, err := src.ReadEntry()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return s, err
+		}
+		if entry.Type == rbs.ChunkKeyframe {
+			continue
+		}
+		if entry.Timestamp > s.duration {
+			s.duration = entry.Timestamp
+		}
+		if entry.Redacted {
+			s.redacted++
+		}
+		s.messages[messageName(entry.Message)]++
+		if fbu, ok := entry.Message.(*messages.FramebufferUpdate); ok {
+			s.frames++
+			s.rects += len(fbu.Rects)
+			for _, rect := range fbu.Rects {
+				s.encodings[encodingName(rect.EncType)]++
+			}
+		}
+	}
+}
+
+// printHistogram 按数量从多到少输出
+func printHistogram(title string, histogram map[string]int) {
+	names := make([]string, 0, len(histogram))
+	for name := range histogram {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if histogram[names[i]] != histogram[names[j]] {
+			return histogram[names[i]] > histogram[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	fmt.Fprintln(stdout, title)
+	for _, name := range names {
+		fmt.Fprintf(stdout, "  %-24s %d\n", name, histogram[name])
+	}
+}
+
+// messageName 消息的名称，例如FramebufferUpdate、KeyEvent
+func messageName(msg rfb.Message) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", msg), "*messages.")
+}
+
+// encodingName 编码的名称，去掉Enc前缀，例如Raw、CursorPseudo
+func encodingName(typ rfb.EncodingType) string {
+	return strings.TrimPrefix(typ.String(), "Enc")
+}
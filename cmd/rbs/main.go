@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/osgochina/dmicro/logger"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// inputArguments 读取录屏文件的参数，编辑加密的录屏时输出的文件使用同一个密钥加密
+var inputArguments = []gcmd.Argument{
+	{Name: "FILE", IsArg: true, Brief: "录屏文件，分段录屏传入任意一个分段的文件名"},
+	{Name: "keyFile", Brief: "加密录屏的密钥文件，读取加密的录屏时必传，设置后输出的文件也会加密"},
+	{Name: "keyEnv", Brief: "从该环境变量读取加密录屏的密钥，格式同keyFile"},
+}
+
+// outputArguments 输出录屏文件的配置
+var outputArguments = append([]gcmd.Argument{
+	{Name: "output", Short: "o", Brief: "输出的录屏文件，扩展名为.rbs.gz或者.rbs.zst时压缩  必传"},
+	{Name: "keyframeInterval", Default: "30s", Brief: "输出的录屏写入关键帧的间隔 默认30s"},
+}, inputArguments...)
+
+// stdout 命令输出的位置，测试时替换
+var stdout io.Writer = os.Stdout
+
+func main() {
+	// 消息解析的调试日志太多，命令行工具不输出
+	logger.SetDebug(false)
+	root, err := rootCommand()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err = root.RunWithError(context.Background()); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// rootCommand 包含所有子命令的rbs命令
+func rootCommand() (*gcmd.Command, error) {
+	root := &gcmd.Command{
+		Name:  "rbs",
+		Usage: "rbs COMMAND [OPTION] FILE...",
+		Brief: "查看及编辑rbs录屏文件，支持压缩、分段及加密的录屏，分段录屏传入任意一个分段的文件名，也可以读取FBS及vncrec格式的录屏",
+		Examples: gstr.Trim(`
+rbs info /path/to/foo.rbs
+rbs cut --from=1m --to=2m30s -o /path/to/cut.rbs /path/to/foo.rbs.zst
+rbs concat -o /path/to/all.rbs.zst /path/to/foo.rbs /path/to/bar.rbs
+rbs speedup --idleMax=3s -o /path/to/fast.rbs /path/to/foo.rbs
+rbs convert -o /path/to/foo.fbs /path/to/foo.rbs
+rbs export --from=1m --to=1m20s --scale=0.5 -o /path/to/clip.gif /path/to/foo.rbs
+rbs verify --keyFile=/path/to/rbs.key /path/to/foo.rbs.zst
+rbs dump /path/to/foo.rbs > foo.jsonl
+`),
+	}
+	err := root.AddCommand(
+		infoCommand(),
+		cutCommand(),
+		concatCommand(),
+		speedupCommand(),
+		convertCommand(),
+		exportCommand(),
+		verifyCommand(),
+		dumpCommand(),
+	)
+	return root, err
+}
+
+// verifyCommand 校验录屏文件
+func verifyCommand() *gcmd.Command {
+	return &gcmd.Command{
+		Name:      "verify",
+		Usage:     "rbs verify [OPTION] FILE",
+		Brief:     "校验录屏文件的所有分段，输出第一个损坏的位置，校验失败时退出码为1",
+		Arguments: inputArguments,
+		Func: func(ctx context.Context, parser *gcmd.Parser) error {
+			path, err := inputFile(parser)
+			if err != nil {
+				return err
+			}
+			key, err := loadKey(parser)
+			if err != nil {
+				return err
+			}
+			results, err := rbs.Verify(path, rbs.OptKey(key))
+			if err != nil {
+				return err
+			}
+			ok := true
+			for _, r := range results {
+				fmt.Fprintln(stdout, r)
+				ok = ok && r.OK()
+			}
+			if !ok {
+				return fmt.Errorf("录屏文件[%s]校验失败", path)
+			}
+			return nil
+		},
+	}
+}
+
+// inputFiles 命令行中传入的录屏文件
+func inputFiles(parser *gcmd.Parser) ([]string, error) {
+	// 参数的第一个是程序名，第二个是子命令
+	args := parser.GetArgAll()
+	if len(args) <= 2 {
+		return nil, fmt.Errorf("需要传入录屏文件")
+	}
+	for _, path := range args[2:] {
+		if _, err := rbs.Segments(path); err != nil {
+			return nil, err
+		}
+	}
+	return args[2:], nil
+}
+
+// inputFile 命令行中传入的一个录屏文件
+func inputFile(parser *gcmd.Parser) (string, error) {
+	paths, err := inputFiles(parser)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) > 1 {
+		return "", fmt.Errorf("只能传入一个录屏文件")
+	}
+	return paths[0], nil
+}
+
+// loadKey 读取加密录屏的密钥，未配置返回nil
+func loadKey(parser *gcmd.Parser) ([]byte, error) {
+	return rbs.LoadKey(parser.GetOpt("keyFile").String(), parser.GetOpt("keyEnv").String())
+}
+
+// openRecording 打开录屏文件
+func openRecording(path string, key []byte) (*session.PlayerSession, error) {
+	playerSession := session.NewPlayerSession(
+		rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
+			return rbs.OpenFile(path, rbs.OptKey(key))
+		}),
+	)
+	playerSession.Start()
+	select {
+	case err := <-playerSession.Options().ErrorCh:
+		return nil, fmt.Errorf("打开录屏文件[%s]失败:%v", path, err)
+	default:
+	}
+	return playerSession, nil
+}
+
+// outputPath 输出的录屏文件，不能与输入的录屏文件相同
+func outputPath(parser *gcmd.Parser, inputs ...string) (string, error) {
+	path := parser.GetOpt("output").String()
+	if len(path) == 0 {
+		return "", fmt.Errorf("需要通过--output设置输出的录屏文件")
+	}
+	// 输出的文件会被截断，不能覆盖正在读取的录屏
+	for _, input := range inputs {
+		segments, _ := rbs.Segments(input)
+		for _, p := range segments {
+			if gfile.RealPath(p) == gfile.RealPath(path) {
+				return "", fmt.Errorf("输出的录屏文件不能与输入的录屏文件相同")
+			}
+		}
+	}
+	return path, gfile.Mkdir(gfile.Dir(path))
+}
+
+// createRecording 根据输出参数创建录屏文件，文件头使用header，开始时间为start
+func createRecording(parser *gcmd.Parser, header *rbs.Header, start time.Time, inputs ...string) (*session.RecorderSession, error) {
+	path, err := outputPath(parser, inputs...)
+	if err != nil {
+		return nil, err
+	}
+	// 删除输出文件原来的分段，防止与新的录屏混在一起
+	if segments, err := rbs.Segments(path); err == nil {
+		for _, p := range segments {
+			if err = os.Remove(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	key, err := loadKey(parser)
+	if err != nil {
+		return nil, err
+	}
+	recorderSession := session.NewRecorder(
+		rfb.OptWidth(int(header.Width)),
+		rfb.OptHeight(int(header.Height)),
+		rfb.OptPixelFormat(header.PixelFormat),
+		rfb.OptDesktopName(header.DesktopName),
+		rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
+			segment := sess.(*session.RecorderSession).Segment()
+			return os.OpenFile(rbs.SegmentPath(path, segment), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		}),
+	)
+	recorderSession.SetProtocolVersion(header.ProtocolVersion)
+	recorderSession.SetStartTime(start)
+	recorderSession.SetCompression(compressionOf(path))
+	recorderSession.SetEncryptKey(key)
+	recorderSession.SetKeyframeInterval(parser.GetOpt("keyframeInterval", "30s").Duration())
+	recorderSession.Start()
+	select {
+	case err = <-recorderSession.Options().ErrorCh:
+		return nil, fmt.Errorf("创建录屏文件[%s]失败:%v", path, err)
+	default:
+	}
+	return recorderSession, nil
+}
+
+// compressionOf 根据文件扩展名判断压缩算法
+func compressionOf(path string) rbs.Compression {
+	for _, c := range []rbs.Compression{rbs.CompressionGzip, rbs.CompressionZstd} {
+		if strings.HasSuffix(path, c.Ext()) {
+			return c
+		}
+	}
+	return rbs.CompressionNone
+}
+
+// startTime 录屏开始的时间，v1格式没有开始时间，使用当前时间
+func startTime(header *rbs.Header) time.Time {
+	if header.StartTime.IsZero() {
+		return time.Now()
+	}
+	return header.StartTime
+}
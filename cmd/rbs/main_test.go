@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/vprix/vncproxy/rbs"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRecording 测试使用的录屏，4x2的画面，开始时间为2024-01-02T03:04:05Z:
+// 0s 整个画面为红色的Raw编码，500ms 按下a键，1s 左上角2x1为绿色的Raw编码，1.5s 响铃，2s 剪贴板内容为hello
+const testRecording = "testdata/sample.rbs"
+
+// testEntries 测试录屏中除关键帧以外的记录
+var testEntries = []string{
+	"0s FramebufferUpdate",
+	"500ms KeyEvent",
+	"1s FramebufferUpdate",
+	"1.5s Bell",
+	"2s ServerCutText",
+}
+
+// runCommand 使用命令行参数执行rbs命令，返回输出的内容
+func runCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	old := stdout
+	stdout = buf
+	defer func() { stdout = old }()
+	root, err := rootCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.RunWithSpecificArgs(context.Background(), append([]string{"rbs"}, args...))
+	return buf.String(), err
+}
+
+// readEntries 读取录屏中除关键帧以外的记录，格式为 时间 消息名称
+func readEntries(t *testing.T, path string, key []byte) []string {
+	t.Helper()
+	src, err := openRecording(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = src.Close() }()
+	var entries []string
+	for {
+		entry, err := src.ReadEntry()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Type != rbs.ChunkKeyframe {
+			entries = append(entries, fmt.Sprintf("%s %s", entry.Timestamp, messageName(entry.Message)))
+		}
+	}
+}
+
+// writeKeyFile 把十六进制格式的密钥写入临时文件，返回文件路径
+func writeKeyFile(t *testing.T, key []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rbs.key")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInfo(t *testing.T) {
+	out, err := runCommand(t, "info", testRecording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"segments:    1\n",
+		"version:     RBS 002.000\n",
+		"protocol:    RFB 003.008\n",
+		"desktop:     sample\n",
+		"size:        4x2\n",
+		"startTime:   2024-01-02T03:04:05Z\n",
+		"duration:    2s\n",
+		"keyframes:   1\n",
+		"frames:      2\n",
+		"rects:       2\n",
+		"encodings:\n  Raw                      2\n",
+		"messages:\n  FramebufferUpdate        2\n  Bell                     1\n  KeyEvent                 1\n  ServerCutText            1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("输出中没有%q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "redacted:") {
+		t.Fatalf("没有脱敏的记录时输出了脱敏数量:\n%s", out)
+	}
+
+	if _, err = runCommand(t, "info"); err == nil {
+		t.Fatal("没有传入录屏文件时没有返回错误")
+	}
+	if _, err = runCommand(t, "info", filepath.Join(t.TempDir(), "missing.rbs")); err == nil {
+		t.Fatal("录屏文件不存在时没有返回错误")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{0x42}, 16)
+	keyFile := writeKeyFile(t, key)
+	tests := []struct {
+		name        string
+		output      string
+		args        []string
+		compression rbs.Compression
+		encrypted   bool
+		entries     []string
+	}{
+		{"rbs", "out.rbs", nil, rbs.CompressionNone, false, testEntries},
+		{"gzip压缩", "out.rbs.gz", nil, rbs.CompressionGzip, false, testEntries},
+		{"zstd压缩", "out.rbs.zst", nil, rbs.CompressionZstd, false, testEntries},
+		{"加密", "secret.rbs", []string{"--keyFile=" + keyFile}, rbs.CompressionNone, true, testEntries},
+		// FBS格式只有vnc服务端发送的消息
+		{"FBS", "out.fbs", nil, rbs.CompressionNone, false, []string{"0s FramebufferUpdate", "1s FramebufferUpdate", "1.5s Bell", "2s ServerCutText"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(dir, tt.output)
+			args := append([]string{"convert", "-o", output}, tt.args...)
+			if _, err := runCommand(t, append(args, testRecording)...); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rbs.IsEncrypted(data) != tt.encrypted || rbs.DetectCompression(data) != tt.compression {
+				t.Fatalf("输出的文件加密:%v 压缩算法:%v", rbs.IsEncrypted(data), rbs.DetectCompression(data))
+			}
+			if tt.encrypted && bytes.Contains(data, []byte("hello")) {
+				t.Fatal("加密的录屏中包含明文")
+			}
+			var readKey []byte
+			if tt.encrypted {
+				readKey = key
+			}
+			got := readEntries(t, output, readKey)
+			if strings.Join(got, ",") != strings.Join(tt.entries, ",") {
+				t.Fatalf("转换后的记录为%v，应该为%v", got, tt.entries)
+			}
+		})
+	}
+
+	if _, err := runCommand(t, "convert", testRecording); err == nil {
+		t.Fatal("没有设置输出文件时没有返回错误")
+	}
+	if _, err := runCommand(t, "convert", "-o", testRecording, testRecording); err == nil {
+		t.Fatal("输出文件与输入文件相同时没有返回错误")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{0x42}, 16)
+	keyFile := writeKeyFile(t, key)
+	encrypted := filepath.Join(dir, "secret.rbs")
+	if _, err := runCommand(t, "convert", "-o", encrypted, "--keyFile="+keyFile, testRecording); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(testRecording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.rbs")
+	if err = os.WriteFile(truncated, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+		want    string // 输出中包含的内容
+	}{
+		{"校验通过", []string{testRecording}, false, "chunks=6 keyframes=1 duration=2s complete=true"},
+		{"文件尾损坏", []string{truncated}, true, "corrupted at offset"},
+		{"加密", []string{"--keyFile=" + keyFile, encrypted}, false, "encrypted=true compression=none chunks=6 keyframes=1 duration=2s complete=true"},
+		{"缺少密钥", []string{encrypted}, true, "corrupted at offset 0"},
+		{"错误的密钥", []string{"--keyFile=" + writeKeyFile(t, bytes.Repeat([]byte{0x43}, 16)), encrypted}, true, "corrupted at offset 40"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runCommand(t, append([]string{"verify"}, tt.args...)...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("校验返回%v，期望返回错误:%v\n%s", err, tt.wantErr, out)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Fatalf("输出中没有%q:\n%s", tt.want, out)
+			}
+		})
+	}
+}
@@ -19,6 +19,15 @@ OPTION
 	--vncHost       要连接的vnc服务端地址  必传
 	--vncPort       要连接的vnc服务端端口 必传
 	--vncPassword   要连接的vnc服务端密码 不传则使用auth none
+	--keyframeInterval 写入关键帧的间隔 默认30s
+	--compress      录屏文件的压缩算法 none|gzip|zstd，压缩的文件名会加上.gz或者.zst 默认none
+	--segmentSize   录屏分段的最大大小，例如100M，默认不分段
+	--segmentDuration 录屏分段的最长时长，例如1h，默认不分段
+	--maxAge        录屏目录中文件的最长保留时间，例如720h，默认不限制
+	--maxSize       录屏目录的最大总大小，例如100G，默认不限制
+	--maxFiles      录屏目录的最大文件数量，默认不限制
+	--keyFile       加密录屏的密钥文件，密钥为16、24或32字节，可以是十六进制或base64，默认不加密
+	--keyEnv        从该环境变量读取加密录屏的密钥，格式同keyFile
 	--debug         是否开启debug 默认debug=false
 	-d,--daemon     使用守护进程模式启动
 	--pid           设置pid文件的地址，默认是/tmp/[server].pid
@@ -48,10 +57,19 @@ func main() {
 	easyservice.SetHelpContent(helpContent)
 	easyservice.SetOptions(
 		map[string]bool{
-			"rbsFile":     true, // 使用的rbs文件地址  必传
-			"vncHost":     true, // 要连接的vnc服务端地址  必传
-			"vncPort":     true, // 要连接的vnc服务端端口 必传
-			"vncPassword": true, // 要连接的vnc服务端密码 不传则使用auth none
+			"rbsFile":          true, // 使用的rbs文件地址  必传
+			"vncHost":          true, // 要连接的vnc服务端地址  必传
+			"vncPort":          true, // 要连接的vnc服务端端口 必传
+			"vncPassword":      true, // 要连接的vnc服务端密码 不传则使用auth none
+			"keyframeInterval": true, // 写入关键帧的间隔 默认30s
+			"compress":         true, // 录屏文件的压缩算法
+			"segmentSize":      true, // 录屏分段的最大大小
+			"segmentDuration":  true, // 录屏分段的最长时长
+			"maxAge":           true, // 录屏目录中文件的最长保留时间
+			"maxSize":          true, // 录屏目录的最大总大小
+			"maxFiles":         true, // 录屏目录的最大文件数量
+			"keyFile":          true, // 加密录屏的密钥文件
+			"keyEnv":           true, // 加密录屏的密钥环境变量
 		})
 	easyservice.Setup(func(svr *easyservice.EasyService) {
 		//注册服务停止时要执行法方法
@@ -79,6 +97,15 @@ func main() {
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncHost", vncHost.String())
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncPort", vncPort.Int())
 		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncPassword", svr.CmdParser().GetOpt("vncPassword", ""))
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("keyframeInterval", svr.CmdParser().GetOpt("keyframeInterval", "30s").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("compress", svr.CmdParser().GetOpt("compress", "none").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("segmentSize", svr.CmdParser().GetOpt("segmentSize", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("segmentDuration", svr.CmdParser().GetOpt("segmentDuration", "0").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("maxAge", svr.CmdParser().GetOpt("maxAge", "0").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("maxSize", svr.CmdParser().GetOpt("maxSize", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("maxFiles", svr.CmdParser().GetOpt("maxFiles", 0).Int())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("keyFile", svr.CmdParser().GetOpt("keyFile", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("keyEnv", svr.CmdParser().GetOpt("keyEnv", "").String())
 
 		logger.SetDebug(cfg.MustGet(context.TODO(), "Debug").Bool())
 
@@ -9,6 +9,7 @@ import (
 	"github.com/osgochina/dmicro/logger"
 	"github.com/vprix/vncproxy/encodings"
 	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
 	"github.com/vprix/vncproxy/rfb"
 	"github.com/vprix/vncproxy/security"
 	"github.com/vprix/vncproxy/session"
@@ -17,6 +18,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -52,6 +54,26 @@ func (that *RecorderSandBox) Name() string {
 
 func (that *RecorderSandBox) Setup() error {
 	saveFilePath := that.cfg.MustGet(context.TODO(), "rbsFile").String()
+	compression, err := rbs.ParseCompression(that.cfg.MustGet(context.TODO(), "compress").String())
+	if err != nil {
+		return err
+	}
+	// 压缩的录屏文件加上压缩算法的扩展名
+	if !strings.HasSuffix(saveFilePath, compression.Ext()) {
+		saveFilePath += compression.Ext()
+	}
+	key, err := rbs.LoadKey(
+		that.cfg.MustGet(context.TODO(), "keyFile").String(),
+		that.cfg.MustGet(context.TODO(), "keyEnv").String(),
+	)
+	if err != nil {
+		return err
+	}
+	retention := rbs.Retention{
+		MaxAge:   that.cfg.MustGet(context.TODO(), "maxAge").Duration(),
+		MaxSize:  gfile.StrToSize(that.cfg.MustGet(context.TODO(), "maxSize").String()),
+		MaxFiles: that.cfg.MustGet(context.TODO(), "maxFiles").Int(),
+	}
 	targetCfg := rfb.TargetConfig{
 		Network:  "tcp",
 		Host:     that.cfg.MustGet(context.TODO(), "vncHost").String(),
@@ -73,18 +95,41 @@ func (that *RecorderSandBox) Setup() error {
 		rfb.OptMessages(messages.DefaultServerMessages...),
 		rfb.OptPixelFormat(rfb.PixelFormat32bit),
 		rfb.OptGetConn(func(iSession rfb.ISession) (io.ReadWriteCloser, error) {
+			if retention.Enabled() {
+				removed, err := retention.Apply(gfile.Dir(saveFilePath))
+				if err != nil {
+					logger.Warningf(context.TODO(), "清理录屏文件失败:%v", err)
+				}
+				for _, p := range removed {
+					logger.Infof(context.TODO(), "删除超出保留策略的录屏文件:%s", p)
+				}
+			}
+			if segment := iSession.(*session.RecorderSession).Segment(); segment > 0 {
+				return gfile.OpenFile(rbs.SegmentPath(saveFilePath, segment), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+			}
 			if gfile.Exists(saveFilePath) {
-				saveFilePath = fmt.Sprintf("%s%s%s_%d%s",
+				basename := strings.TrimSuffix(gfile.Basename(saveFilePath), compression.Ext())
+				saveFilePath = fmt.Sprintf("%s%s%s_%d%s%s",
 					gfile.Dir(saveFilePath),
 					gfile.Separator,
-					gfile.Name(gfile.Basename(saveFilePath)),
+					gfile.Name(basename),
 					gtime.Now().Unix(),
-					gfile.Ext(gfile.Basename(saveFilePath)),
+					gfile.Ext(basename),
+					compression.Ext(),
 				)
 			}
 			return gfile.OpenFile(saveFilePath, os.O_RDWR|os.O_CREATE, 0644)
 		}),
 	)
+	if interval := that.cfg.MustGet(context.TODO(), "keyframeInterval").Duration(); interval > 0 {
+		recorderSess.SetKeyframeInterval(interval)
+	}
+	recorderSess.SetCompression(compression)
+	recorderSess.SetRotation(
+		gfile.StrToSize(that.cfg.MustGet(context.TODO(), "segmentSize").String()),
+		that.cfg.MustGet(context.TODO(), "segmentDuration").Duration(),
+	)
+	recorderSess.SetEncryptKey(key)
 	cliSession := session.NewClient(
 		rfb.OptEncodings(encodings.DefaultEncodings...),
 		rfb.OptMessages(messages.DefaultServerMessages...),
@@ -95,7 +140,7 @@ func (that *RecorderSandBox) Setup() error {
 		rfb.OptSecurityHandlers(securityHandlers...),
 	)
 	that.recorder = vnc.NewRecorder(recorderSess, cliSession)
-	err := that.recorder.Start()
+	err = that.recorder.Start()
 	if err != nil {
 		logger.Fatal(context.TODO(), err)
 	}
@@ -1,25 +1,127 @@
 package main
 
+import (
+	"fmt"
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/osgochina/dmicro/easyservice"
+	"github.com/osgochina/dmicro/logger"
+	"github.com/vprix/vncproxy/media"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/vnc"
+	"golang.org/x/net/context"
+	"os"
+)
+
+var (
+	helpContent = gstr.TrimLeft(`
+USAGE
+	./video [start|stop|quit] [OPTION]
+OPTION
+	--videoFile     要生成的视频地址，MJPEG编码的avi格式  必传
+	--rbsFile       要转换的录屏文件，支持rbs、FBS及vncrec格式，转换完成后退出 不传则连接vnc服务端
+	--vncHost       要连接的vnc服务端地址  不传rbsFile时必传
+	--vncPort       要连接的vnc服务端端口 不传rbsFile时必传
+	--vncPassword   要连接的vnc服务端密码 不传则使用auth none
+	--fps           视频每秒的帧数 默认10
+	--quality       每一帧JPEG图片的压缩质量 1-100 默认75
+	--drawCursor    是否在视频中绘制鼠标指针 默认drawCursor=true
+	--keyFile       解密录屏的密钥文件，转换加密的录屏时必传
+	--keyEnv        从该环境变量读取解密录屏的密钥，格式同keyFile
+	--debug         是否开启debug 默认debug=false
+	-d,--daemon     使用守护进程模式启动
+	--pid           设置pid文件的地址，默认是/tmp/[server].pid
+	-h,--help       获取帮助信息
+	-v,--version    获取编译版本信息
+
+EXAMPLES
+	/path/to/video
+	/path/to/video --videoFile=/path/to/foo.avi --rbsFile=/path/to/foo.rbs --fps=15
+	/path/to/video start --env=dev --debug=true --pid=/tmp/server.pid
+	/path/to/video start --videoFile=/path/to/foo.avi
+						--vncHost=192.168.1.2
+						--vncPort=5901
+						--vncPassword=vprix
+						--debug
+	/path/to/server stop
+	/path/to/server quit
+	/path/to/server version
+	/path/to/server help
+`)
+)
+
+// newVideoOptions 根据配置生成视频的配置
+func newVideoOptions(cfg *gcfg.Config) ([]vnc.VideoOption, error) {
+	key, err := rbs.LoadKey(
+		cfg.MustGet(context.TODO(), "keyFile").String(),
+		cfg.MustGet(context.TODO(), "keyEnv").String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return []vnc.VideoOption{
+		vnc.OptVideoFPS(cfg.MustGet(context.TODO(), "fps", vnc.DefaultVideoFPS).Int()),
+		vnc.OptVideoDrawCursor(cfg.MustGet(context.TODO(), "drawCursor", true).Bool()),
+		vnc.OptVideoKey(key),
+	}, nil
+}
+
 func main() {
-	// 暂未实现
-	//v := vnc.NewVideo(nil,
-	//	rfb.TargetConfig{
-	//		Network:  "tcp",
-	//		Host:     "127.0.0.1",
-	//		Port:     5901,
-	//		Password: []byte("@abc1234"),
-	//		Timeout:  10 * time.Second,
-	//	},
-	//)
-	//go func() {
-	//	err := v.Start()
-	//	if err != nil {
-	//		logger.Fatal(err)
-	//	}
-	//}()
-	//for {
-	//	err := <-v.Error()
-	//	logger.Error(err)
-	//}
+	easyservice.Authors = "ClownFish"
+	easyservice.SetHelpContent(helpContent)
+	easyservice.SetOptions(
+		map[string]bool{
+			"videoFile":   true, // 要生成的视频地址  必传
+			"rbsFile":     true, // 要转换的录屏文件
+			"vncHost":     true, // 要连接的vnc服务端地址
+			"vncPort":     true, // 要连接的vnc服务端端口
+			"vncPassword": true, // 要连接的vnc服务端密码 不传则使用auth none
+			"fps":         true, // 视频每秒的帧数
+			"quality":     true, // JPEG图片的压缩质量
+			"drawCursor":  true, // 是否绘制鼠标指针
+			"keyFile":     true, // 解密录屏的密钥文件
+			"keyEnv":      true, // 解密录屏的密钥环境变量
+		})
+	easyservice.Setup(func(svr *easyservice.EasyService) {
+		//注册服务停止时要执行法方法
+		svr.BeforeStop(func(service *easyservice.EasyService) bool {
+			fmt.Println("Vnc video server stop")
+			return true
+		})
+		cfg := svr.Config()
+		videoFile := svr.CmdParser().GetOpt("videoFile", "")
+		if len(videoFile.String()) <= 0 {
+			svr.Help()
+			os.Exit(0)
+		}
+		rbsFile := svr.CmdParser().GetOpt("rbsFile", "")
+		vncHost := svr.CmdParser().GetOpt("vncHost", "")
+		vncPort := svr.CmdParser().GetOpt("vncPort", 0)
+		if len(rbsFile.String()) <= 0 && (len(vncHost.String()) <= 0 || vncPort.Int() <= 0) {
+			svr.Help()
+			os.Exit(0)
+		}
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("videoFile", videoFile.String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("rbsFile", rbsFile.String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncHost", vncHost.String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncPort", vncPort.Int())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("vncPassword", svr.CmdParser().GetOpt("vncPassword", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("fps", svr.CmdParser().GetOpt("fps", vnc.DefaultVideoFPS).Int())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("quality", svr.CmdParser().GetOpt("quality", media.DefaultJPEGQuality).Int())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("drawCursor", svr.CmdParser().GetOpt("drawCursor", true).Bool())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("keyFile", svr.CmdParser().GetOpt("keyFile", "").String())
+		_ = cfg.GetAdapter().(*gcfg.AdapterFile).Set("keyEnv", svr.CmdParser().GetOpt("keyEnv", "").String())
+
+		logger.SetDebug(cfg.MustGet(context.TODO(), "Debug").Bool())
 
+		// 录屏文件转换完成后退出
+		if len(rbsFile.String()) > 0 {
+			if err := convert(cfg); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		svr.AddSandBox(NewVideoSandBox(cfg))
+	})
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/osgochina/dmicro/easyservice"
+	"github.com/osgochina/dmicro/logger"
+	"github.com/vprix/vncproxy/media"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/vnc"
+	"golang.org/x/net/context"
+	"os"
+	"time"
+)
+
+// VideoSandBox  连接vnc服务端生成视频的服务
+type VideoSandBox struct {
+	id      int
+	name    string
+	cfg     *gcfg.Config
+	service *easyservice.EasyService
+	video   *vnc.Video
+}
+
+// NewVideoSandBox 创建一个默认的服务沙盒
+func NewVideoSandBox(cfg *gcfg.Config) *VideoSandBox {
+	id := easyservice.GetNextSandBoxId()
+	sBox := &VideoSandBox{
+		id:   id,
+		name: fmt.Sprintf("video_%d", id),
+		cfg:  cfg,
+	}
+	return sBox
+}
+
+func (that *VideoSandBox) ID() int {
+	return that.id
+}
+
+func (that *VideoSandBox) Name() string {
+	return that.name
+}
+
+func (that *VideoSandBox) Setup() error {
+	opts, err := newVideoOptions(that.cfg)
+	if err != nil {
+		return err
+	}
+	f, err := createVideoFile(that.cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	that.video = vnc.NewVideo(rfb.TargetConfig{
+		Network:  "tcp",
+		Host:     that.cfg.MustGet(context.TODO(), "vncHost").String(),
+		Port:     that.cfg.MustGet(context.TODO(), "vncPort").Int(),
+		Password: that.cfg.MustGet(context.TODO(), "vncPassword").Bytes(),
+		Timeout:  10 * time.Second,
+	}, aviOutput(that.cfg, f), opts...)
+	err = that.video.Start()
+	if err != nil {
+		logger.Fatal(context.TODO(), err)
+	}
+	return err
+}
+
+func (that *VideoSandBox) Shutdown() error {
+	// 等待视频的索引写入完成，否则生成的视频无法播放
+	if that.video != nil {
+		that.video.Close()
+	}
+	return nil
+}
+
+func (that *VideoSandBox) Service() *easyservice.EasyService {
+	return that.service
+}
+
+// convert 把录屏文件转换成视频，出错时删除生成的视频文件
+func convert(cfg *gcfg.Config) error {
+	opts, err := newVideoOptions(cfg)
+	if err != nil {
+		return err
+	}
+	f, err := createVideoFile(cfg)
+	if err != nil {
+		return err
+	}
+	video := vnc.NewFileVideo(cfg.MustGet(context.TODO(), "rbsFile").String(), aviOutput(cfg, f), opts...)
+	err = video.Start()
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// aviOutput 输出MJPEG编码的AVI视频
+func aviOutput(cfg *gcfg.Config, f *os.File) media.Output {
+	return media.AVIOutput(f, cfg.MustGet(context.TODO(), "quality", media.DefaultJPEGQuality).Int())
+}
+
+// createVideoFile 创建视频文件，已经存在则覆盖
+func createVideoFile(cfg *gcfg.Config) (*os.File, error) {
+	path := cfg.MustGet(context.TODO(), "videoFile").String()
+	if err := gfile.Mkdir(gfile.Dir(path)); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
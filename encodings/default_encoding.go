@@ -5,8 +5,11 @@ import "github.com/vprix/vncproxy/rfb"
 var (
 	DefaultEncodings = []rfb.IEncoding{
 		&ZRLEEncoding{},
+		&TRLEEncoding{},
 		&TightEncoding{},
 		&HexTileEncoding{},
+		&ZlibHexEncoding{},
+		&JPEGEncoding{},
 		&TightPngEncoding{},
 		&RREEncoding{},
 		&ZLibEncoding{},
@@ -49,7 +49,8 @@ func ReadBytes(count int, r io.Reader) ([]byte, error) {
 		return nil, err
 	}
 
-	return buff.Bytes(), nil
+	// 缓冲释放后会被其他协程复用，返回数据的副本
+	return append([]byte(nil), buff.Bytes()...), nil
 }
 
 func ReadPixel(c io.Reader, pf *rfb.PixelFormat) ([]byte, error) {
@@ -2,6 +2,8 @@ package encodings
 
 import (
 	"encoding/binary"
+	"errors"
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
 )
 
@@ -47,6 +49,14 @@ func (that *CopyRectEncoding) Read(session rfb.ISession, rect *rfb.Rectangle) er
 }
 
 func (that *CopyRectEncoding) Write(session rfb.ISession, rect *rfb.Rectangle) error {
+	if session.Type() == rfb.CanvasSessionType {
+		cv, ok := session.Conn().(*canvas.VncCanvas)
+		if !ok {
+			return errors.New("canvas error")
+		}
+		cv.CopyRect(int(that.SX), int(that.SY), rect)
+		return nil
+	}
 	if err := binary.Write(session, binary.BigEndian, that.SX); err != nil {
 		return err
 	}
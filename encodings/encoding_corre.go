@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
 )
 
@@ -69,6 +70,11 @@ func (that *CoRREEncoding) Write(session rfb.ISession, rect *rfb.Rectangle) (err
 	if that.buff == nil {
 		return errors.New("ByteBuffer is nil")
 	}
+	if session.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		pf := session.Options().PixelFormat
+		return drawRRE(session.Conn().(*canvas.VncCanvas), that.buff, &pf, rect, 1)
+	}
 	_, err = that.buff.WriteTo(session)
 	that.buff.Reset()
 	return err
@@ -0,0 +1,11 @@
+package encodings
+
+import (
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// TestCoRREDecode 子矩形的位置及大小只有1个字节，重叠的子矩形按顺序覆盖，子矩形在矩形的边缘
+func TestCoRREDecode(t *testing.T) {
+	decodeFixture(t, "corre", rfb.PixelFormat32bit, 0)
+}
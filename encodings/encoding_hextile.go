@@ -9,6 +9,7 @@ import (
 	"github.com/vprix/vncproxy/rfb"
 	"image"
 	"image/color"
+	"io"
 )
 
 const (
@@ -169,8 +170,6 @@ func (that *HexTileEncoding) draw(cv *canvas.VncCanvas, pf rfb.PixelFormat, rect
 	var fgCol *color.RGBA
 	var err error
 	var subEncoding byte
-	var dimensions byte
-	var nSubRects uint8
 
 	// 从上到下
 	for ty := rect.Y; ty < rect.Y+rect.Height; ty += 16 {
@@ -198,68 +197,75 @@ func (that *HexTileEncoding) draw(cv *canvas.VncCanvas, pf rfb.PixelFormat, rect
 				}
 				continue
 			}
-			// 读取单个背景颜色
-			if (subEncoding & HexTileBackgroundSpecified) != 0 {
-				bgCol, err = cv.ReadColor(that.buff, &pf)
-				if err != nil {
-					return fmt.Errorf("HexTileEncoding.Read: error in hexTile bg color reader: %v", err)
-				}
-			}
-			// 绘制一个矩形
-			rBounds := image.Rectangle{
-				Min: image.Point{X: int(tx), Y: int(ty)},
-				Max: image.Point{X: int(tx) + tw, Y: int(ty) + th},
-			}
-			// 填充背景色
-			cv.FillRect(&rBounds, bgCol)
-
-			// 读取前景色
-			if (subEncoding & HexTileForegroundSpecified) != 0 {
-				fgCol, err = cv.ReadColor(that.buff, &pf)
-				if err != nil {
-					return fmt.Errorf("HexTileEncoding.Read: error in hexTile fg color reader: %v", err)
-				}
-			}
-			if (subEncoding & HexTileAnySubRects) == 0 {
-				continue
-			}
-			// 读取子块的个数
-			nSubRects, err = ReadUint8(that.buff)
+			bgCol, fgCol, err = drawHexTile(cv, that.buff, &pf, subEncoding, canvas.MakeRect(int(tx), int(ty), tw, th), bgCol, fgCol)
 			if err != nil {
 				return err
 			}
-			// 是否指定子块的填充颜色，如果未指定，则使用前景色
-			colorSpecified := (subEncoding & HexTileSubRectsColoured) != 0
-			for i := 0; i < int(nSubRects); i++ {
-				var co *color.RGBA
-				if colorSpecified {
-					co, err = cv.ReadColor(that.buff, &pf)
-					if err != nil {
-						return fmt.Errorf("HexTileEncoding.Read: problem reading color from connection: %v", err)
-					}
-				} else {
-					co = fgCol
-				}
-				fgCol = co
-				dimensions, err = ReadUint8(that.buff) // bits 7-4 for x, bits 3-0 for y
-				if err != nil {
-					return fmt.Errorf("HexTileEncoding.Read: problem reading dimensions from connection: %v", err)
-				}
-				subTileX := dimensions >> 4 & 0x0f
-				subTileY := dimensions & 0x0f
-				dimensions, err = ReadUint8(that.buff) // bits 7-4 for x, bits 3-0 for y
-				if err != nil {
-					return fmt.Errorf("HexTileEncoding.Read: problem reading dimensions from connection: %v", err)
-				}
-				subTileWidth := 1 + (dimensions >> 4 & 0x0f)
-				subTileHeight := 1 + (dimensions & 0x0f)
-				subRectBounds := image.Rectangle{
-					Min: image.Point{X: int(tx) + int(subTileX), Y: int(ty) + int(subTileY)},
-					Max: image.Point{X: int(tx) + int(subTileX) + int(subTileWidth), Y: int(ty) + int(subTileY) + int(subTileHeight)},
-				}
-				cv.FillRect(&subRectBounds, fgCol)
-			}
 		}
 	}
 	return nil
 }
+
+// drawHexTile 绘制一个不是原始编码的块，bgCol和fgCol是上一个块的背景色和前景色，返回该块的背景色和前景色
+func drawHexTile(cv *canvas.VncCanvas, r io.Reader, pf *rfb.PixelFormat, subEncoding uint8, tile image.Rectangle, bgCol, fgCol *color.RGBA) (*color.RGBA, *color.RGBA, error) {
+	var err error
+	var dimensions byte
+	var nSubRects uint8
+	// 读取单个背景颜色
+	if (subEncoding & HexTileBackgroundSpecified) != 0 {
+		bgCol, err = cv.ReadColor(r, pf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("HexTileEncoding.Read: error in hexTile bg color reader: %v", err)
+		}
+	}
+	// 填充背景色
+	cv.FillRect(&tile, bgCol)
+
+	// 读取前景色
+	if (subEncoding & HexTileForegroundSpecified) != 0 {
+		fgCol, err = cv.ReadColor(r, pf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("HexTileEncoding.Read: error in hexTile fg color reader: %v", err)
+		}
+	}
+	if (subEncoding & HexTileAnySubRects) == 0 {
+		return bgCol, fgCol, nil
+	}
+	// 读取子块的个数
+	nSubRects, err = ReadUint8(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 是否指定子块的填充颜色，如果未指定，则使用前景色
+	colorSpecified := (subEncoding & HexTileSubRectsColoured) != 0
+	for i := 0; i < int(nSubRects); i++ {
+		var co *color.RGBA
+		if colorSpecified {
+			co, err = cv.ReadColor(r, pf)
+			if err != nil {
+				return nil, nil, fmt.Errorf("HexTileEncoding.Read: problem reading color from connection: %v", err)
+			}
+		} else {
+			co = fgCol
+		}
+		fgCol = co
+		dimensions, err = ReadUint8(r) // bits 7-4 for x, bits 3-0 for y
+		if err != nil {
+			return nil, nil, fmt.Errorf("HexTileEncoding.Read: problem reading dimensions from connection: %v", err)
+		}
+		subTileX := dimensions >> 4 & 0x0f
+		subTileY := dimensions & 0x0f
+		dimensions, err = ReadUint8(r) // bits 7-4 for x, bits 3-0 for y
+		if err != nil {
+			return nil, nil, fmt.Errorf("HexTileEncoding.Read: problem reading dimensions from connection: %v", err)
+		}
+		subTileWidth := 1 + (dimensions >> 4 & 0x0f)
+		subTileHeight := 1 + (dimensions & 0x0f)
+		subRectBounds := image.Rectangle{
+			Min: image.Point{X: tile.Min.X + int(subTileX), Y: tile.Min.Y + int(subTileY)},
+			Max: image.Point{X: tile.Min.X + int(subTileX) + int(subTileWidth), Y: tile.Min.Y + int(subTileY) + int(subTileHeight)},
+		}
+		cv.FillRect(&subRectBounds, fgCol)
+	}
+	return bgCol, fgCol, nil
+}
@@ -1 +1,212 @@
 package encodings
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/rfb"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+)
+
+// JPEG图片中使用的标记
+const (
+	jpegSOF0 = 0xC0 // 基线DCT的帧开始
+	jpegSOF2 = 0xC2 // 渐进式DCT的帧开始
+	jpegDHT  = 0xC4 // 哈夫曼表
+	jpegEOI  = 0xD9 // 图片结束
+	jpegSOS  = 0xDA // 扫描开始
+	jpegDQT  = 0xDB // 量化表
+)
+
+// JPEGEncoding RealVNC使用的JPEG编码，每个矩形的数据是一张完整的JPEG图片，
+// 量化表和哈夫曼表没有变化时vnc服务端会省略，vnc客户端需要使用之前的矩形中的表。
+type JPEGEncoding struct {
+	buff *bytes.Buffer
+}
+
+var _ rfb.IEncoding = new(JPEGEncoding)
+
+// jpegTables 会话中缓存的量化表及哈夫曼表
+type jpegTables struct {
+	quant   [][]byte
+	huffman [][]byte
+}
+
+func (that *JPEGEncoding) Supported(rfb.ISession) bool {
+	return true
+}
+
+func (that *JPEGEncoding) Type() rfb.EncodingType {
+	return rfb.EncJPEG
+}
+
+func (that *JPEGEncoding) Clone(data ...bool) rfb.IEncoding {
+	obj := &JPEGEncoding{}
+	if len(data) > 0 && data[0] {
+		if that.buff != nil {
+			obj.buff = &bytes.Buffer{}
+			_, _ = obj.buff.Write(that.buff.Bytes())
+		}
+	}
+	return obj
+}
+
+// Read JPEG图片没有长度，需要解析所有的段直到图片结束
+func (that *JPEGEncoding) Read(sess rfb.ISession, rect *rfb.Rectangle) error {
+	if that.buff == nil {
+		that.buff = &bytes.Buffer{}
+	}
+	_, err := readJPEGSegments(io.TeeReader(sess, that.buff))
+	return err
+}
+
+func (that *JPEGEncoding) Write(sess rfb.ISession, rect *rfb.Rectangle) error {
+	if that.buff == nil {
+		return errors.New("ByteBuffer is nil")
+	}
+	if sess.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		return that.draw(sess, sess.Conn().(*canvas.VncCanvas), rect)
+	}
+	_, err := that.buff.WriteTo(sess)
+	that.buff.Reset()
+	return err
+}
+
+// draw 解码JPEG图片绘制到画布上
+func (that *JPEGEncoding) draw(sess rfb.ISession, cv *canvas.VncCanvas, rect *rfb.Rectangle) error {
+	segments, err := readJPEGSegments(that.buff)
+	if err != nil {
+		return err
+	}
+	img, err := sessionJPEGTables(sess).decode(segments)
+	if err != nil {
+		return err
+	}
+	draw.Draw(cv, canvas.MakeRectFromVncRect(rect), img, img.Bounds().Min, draw.Src)
+	return nil
+}
+
+// sessionJPEGTables 会话中缓存的量化表及哈夫曼表，JPEG及JRLE编码共用
+func sessionJPEGTables(sess rfb.ISession) *jpegTables {
+	return sess.Swap().GetOrSetFuncLock(rfb.SwapKeyJPEGTables, func() interface{} {
+		return &jpegTables{}
+	}).(*jpegTables)
+}
+
+// decode 解码JPEG图片，图片中没有的量化表及哈夫曼表使用缓存的表，图片中有的表更新到缓存中
+func (that *jpegTables) decode(segments [][]byte) (image.Image, error) {
+	var quant, huffman [][]byte
+	sof := -1
+	for i, segment := range segments {
+		switch segment[1] {
+		case jpegDQT:
+			quant = append(quant, segment)
+		case jpegDHT:
+			huffman = append(huffman, segment)
+		case jpegSOF0, jpegSOF0 + 1, jpegSOF2:
+			if sof < 0 {
+				sof = i
+			}
+		}
+	}
+	if sof < 0 {
+		return nil, fmt.Errorf("JPEG编码的图片没有帧开始标记")
+	}
+	if len(quant) > 0 {
+		that.quant = quant
+	}
+	if len(huffman) > 0 {
+		that.huffman = huffman
+	}
+	data := &bytes.Buffer{}
+	for i, segment := range segments {
+		// 缺少的表插入到帧开始之前
+		if i == sof {
+			if len(quant) == 0 {
+				_, _ = data.Write(bytes.Join(that.quant, nil))
+			}
+			if len(huffman) == 0 {
+				_, _ = data.Write(bytes.Join(that.huffman, nil))
+			}
+		}
+		_, _ = data.Write(segment)
+	}
+	img, err := jpeg.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("JPEG编码的图片解码失败:%v", err)
+	}
+	return img, nil
+}
+
+// readJPEGSegments 读取一张JPEG图片的所有段，每个段都包含开头的标记，
+// 扫描开始的段包含之后的压缩数据，压缩数据一直到不是0xFF00及RSTn的标记为止
+func readJPEGSegments(r io.Reader) ([][]byte, error) {
+	var segments [][]byte
+	var b [1]byte
+	readByte := func() (byte, error) {
+		_, err := io.ReadFull(r, b[:])
+		return b[0], err
+	}
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil {
+		return nil, err
+	}
+	for {
+		if marker[0] != 0xFF {
+			return nil, fmt.Errorf("JPEG编码的标记不正确:%#x", marker[0])
+		}
+		typ := marker[1]
+		// SOI、EOI、RSTn及TEM标记之后没有长度
+		if (typ >= 0xD0 && typ <= jpegEOI) || typ == 0x01 {
+			segments = append(segments, []byte{0xFF, typ})
+			if typ == jpegEOI {
+				return segments, nil
+			}
+			if _, err := io.ReadFull(r, marker[:]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		length, err := ReadUint16(r)
+		if err != nil {
+			return nil, err
+		}
+		if length < 2 {
+			return nil, fmt.Errorf("JPEG编码的段长度不正确:%d", length)
+		}
+		segment := make([]byte, 2+int(length))
+		segment[0], segment[1], segment[2], segment[3] = 0xFF, typ, byte(length>>8), byte(length)
+		if _, err = io.ReadFull(r, segment[4:]); err != nil {
+			return nil, err
+		}
+		if typ == jpegSOS {
+			// 读取压缩数据，直到遇到下一个标记
+			var prev byte
+			for {
+				c, err := readByte()
+				if err != nil {
+					return nil, err
+				}
+				if prev == 0xFF && c != 0x00 && !(c >= 0xD0 && c <= 0xD7) {
+					// 连续的0xFF是填充
+					if c == 0xFF {
+						continue
+					}
+					segment = segment[:len(segment)-1]
+					marker = [2]byte{0xFF, c}
+					break
+				}
+				segment = append(segment, c)
+				prev = c
+			}
+		} else if _, err = io.ReadFull(r, marker[:]); err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+}
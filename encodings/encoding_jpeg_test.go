@@ -0,0 +1,23 @@
+package encodings
+
+import (
+	"encoding/binary"
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// TestJPEGDecode 第二个矩形的图片去掉了量化表及霍夫曼表，需要使用之前矩形的表解码，JPEG有损压缩允许一定的误差
+func TestJPEGDecode(t *testing.T) {
+	decodeFixture(t, "jpeg", rfb.PixelFormat32bit, 12)
+}
+
+// TestJPEGDecodeError 数据不是JPEG图片
+func TestJPEGDecodeError(t *testing.T) {
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint16(header[4:], 1)
+	binary.BigEndian.PutUint16(header[6:], 1)
+	binary.BigEndian.PutUint32(header[8:], uint32(rfb.EncJPEG))
+	if err := newTestSession(rfb.PixelFormat32bit, 1, 1).decode(append(header, 0x00, 0xD8)); err == nil {
+		t.Fatal("没有返回错误")
+	}
+}
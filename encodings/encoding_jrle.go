@@ -1 +1,64 @@
 package encodings
+
+import (
+	"bytes"
+	"errors"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+)
+
+// JRLEJPEG JRLE编码的二级编码: 块的数据是一张JPEG图片
+const JRLEJPEG = 17
+
+// JRLEEncoding JRLE(JPEG Run-Length Encoding)是RealVNC使用的编码，没有公开的协议文档。
+// 这里按照TRLE的格式实现: 矩形被分割成16x16的块，数据没有压缩也没有长度，二级编码与TRLE相同，
+// 另外增加了二级编码 JRLEJPEG ，块的数据是一张JPEG图片，与JPEG编码一样可以省略量化表及哈夫曼表。
+// 无法保证与RealVNC的vnc服务端兼容，所以没有加入DefaultEncodings，需要时通过rfb.OptEncodings添加。
+type JRLEEncoding struct {
+	buff *bytes.Buffer
+}
+
+var _ rfb.IEncoding = new(JRLEEncoding)
+
+func (that *JRLEEncoding) Type() rfb.EncodingType {
+	return rfb.EncJRLE
+}
+
+func (that *JRLEEncoding) Supported(rfb.ISession) bool {
+	return true
+}
+
+func (that *JRLEEncoding) Clone(data ...bool) rfb.IEncoding {
+	obj := &JRLEEncoding{}
+	if len(data) > 0 && data[0] {
+		if that.buff != nil {
+			obj.buff = &bytes.Buffer{}
+			_, _ = obj.buff.Write(that.buff.Bytes())
+		}
+	}
+	return obj
+}
+
+func (that *JRLEEncoding) Read(sess rfb.ISession, rect *rfb.Rectangle) error {
+	if that.buff == nil {
+		that.buff = &bytes.Buffer{}
+	}
+	pf := sess.Options().PixelFormat
+	// 解析的同时把读取的数据保存下来
+	return readRLETiles(io.TeeReader(sess, that.buff), &pf, rect, 16, true)
+}
+
+func (that *JRLEEncoding) Write(sess rfb.ISession, rect *rfb.Rectangle) error {
+	if that.buff == nil {
+		return errors.New("ByteBuffer is nil")
+	}
+	if sess.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		pf := sess.Options().PixelFormat
+		return drawRLETiles(sess.Conn().(*canvas.VncCanvas), that.buff, &pf, rect, 16, sessionJPEGTables(sess))
+	}
+	_, err := that.buff.WriteTo(sess)
+	that.buff.Reset()
+	return err
+}
@@ -0,0 +1,84 @@
+package encodings
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/vprix/vncproxy/rfb"
+	"os"
+	"testing"
+)
+
+// passSession 转发编码数据的会话，Write写入缓冲区
+type passSession struct {
+	*testSession
+	out bytes.Buffer
+}
+
+func (that *passSession) Write(buf []byte) (int, error) { return that.out.Write(buf) }
+func (that *passSession) Type() rfb.SessionType         { return rfb.ClientSessionType }
+
+// TestJRLEDecode JPEG图片的块(第二个块及第二个矩形省略了量化表及哈夫曼表)、JPEG图片之后重用调色板、纯色及原始像素的块，
+// JPEG有损压缩允许一定的误差
+func TestJRLEDecode(t *testing.T) {
+	decodeFixture(t, "jrle", rfb.PixelFormat32bit, 12)
+}
+
+// TestJRLEPassthrough 转发时原样写出读取的数据，读取后的数据正好在矩形结束的位置
+func TestJRLEPassthrough(t *testing.T) {
+	data, err := os.ReadFile("testdata/jrle.rfb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := &passSession{testSession: newTestSession(rfb.PixelFormat32bit, 64, 48)}
+	r := bytes.NewReader(data)
+	sess.r = r
+	for r.Len() > 0 {
+		start := len(data) - r.Len()
+		var header struct {
+			X, Y, Width, Height uint16
+			EncType             int32
+		}
+		if err = binary.Read(sess, binary.BigEndian, &header); err != nil {
+			t.Fatal(err)
+		}
+		rect := &rfb.Rectangle{X: header.X, Y: header.Y, Width: header.Width, Height: header.Height, EncType: rfb.EncodingType(header.EncType)}
+		enc := sess.NewEncoding(rect.EncType)
+		if err = enc.Read(sess, rect); err != nil {
+			t.Fatal(err)
+		}
+		sess.out.Reset()
+		if err = enc.Clone(true).Write(sess, rect); err != nil {
+			t.Fatal(err)
+		}
+		if want := data[start+12 : len(data)-r.Len()]; !bytes.Equal(sess.out.Bytes(), want) {
+			t.Fatalf("转发了%d字节，应该为%d字节", sess.out.Len(), len(want))
+		}
+	}
+}
+
+// TestJRLEDecodeError 二级编码不正确、没有可以重用的调色板以及JPEG图片不完整
+func TestJRLEDecodeError(t *testing.T) {
+	rect := func(data ...byte) []byte {
+		header := make([]byte, 12)
+		binary.BigEndian.PutUint16(header[4:], 4)
+		binary.BigEndian.PutUint16(header[6:], 4)
+		binary.BigEndian.PutUint32(header[8:], uint32(rfb.EncJRLE))
+		return append(header, data...)
+	}
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"二级编码不正确", rect(18)},
+		{"重用压缩调色板", rect(127)},
+		{"JPEG图片不完整", rect(JRLEJPEG, 0xFF, 0xD8, 0xFF)},
+		{"不是JPEG图片", rect(JRLEJPEG, 0x00, 0xD8)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := newTestSession(rfb.PixelFormat32bit, 4, 4).decode(c.data); err == nil {
+				t.Fatal("没有返回错误")
+			}
+		})
+	}
+}
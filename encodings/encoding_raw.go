@@ -17,6 +17,11 @@ type RawEncoding struct {
 
 var _ rfb.IEncoding = new(RawEncoding)
 
+// NewRawEncoding 使用已经编码好的像素数据生成Raw编码对象
+func NewRawEncoding(data []byte) *RawEncoding {
+	return &RawEncoding{buff: bytes.NewBuffer(data)}
+}
+
 func (that *RawEncoding) Supported(rfb.ISession) bool {
 	return true
 }
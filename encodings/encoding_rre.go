@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
+	"io"
 )
 
 // RREEncoding RRE表示提升和运行长度，正如它名字暗示的那样，它实质上表示二维向量的运行长度编码。
@@ -66,7 +68,52 @@ func (that *RREEncoding) Write(session rfb.ISession, rect *rfb.Rectangle) error
 	if that.buff == nil {
 		return errors.New("ByteBuffer is nil")
 	}
+	if session.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		pf := session.Options().PixelFormat
+		return drawRRE(session.Conn().(*canvas.VncCanvas), that.buff, &pf, rect, 2)
+	}
 	_, err := that.buff.WriteTo(session)
 	that.buff.Reset()
 	return err
 }
+
+// drawRRE 使用背景色填充矩形后再填充每个子矩形，coordSize是子矩形位置及大小的字节数，RRE为2，CoRRE为1
+func drawRRE(cv *canvas.VncCanvas, r io.Reader, pf *rfb.PixelFormat, rect *rfb.Rectangle, coordSize int) error {
+	numOfSubRectangles, err := ReadUint32(r)
+	if err != nil {
+		return err
+	}
+	bgCol, err := cv.ReadColor(r, pf)
+	if err != nil {
+		return err
+	}
+	bounds := canvas.MakeRectFromVncRect(rect)
+	cv.FillRect(&bounds, bgCol)
+	coords := make([]int, 4)
+	for i := uint32(0); i < numOfSubRectangles; i++ {
+		col, err := cv.ReadColor(r, pf)
+		if err != nil {
+			return err
+		}
+		// x,y,w,h
+		for j := range coords {
+			if coordSize == 1 {
+				var v uint8
+				v, err = ReadUint8(r)
+				coords[j] = int(v)
+			} else {
+				var v uint16
+				v, err = ReadUint16(r)
+				coords[j] = int(v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		// 子矩形的位置是相对于矩形的，超出矩形的部分不绘制
+		subRect := canvas.MakeRect(int(rect.X)+coords[0], int(rect.Y)+coords[1], coords[2], coords[3]).Intersect(bounds)
+		cv.FillRect(&subRect, col)
+	}
+	return nil
+}
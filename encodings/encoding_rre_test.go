@@ -0,0 +1,13 @@
+package encodings
+
+import (
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// TestRREDecode 16位像素格式，重叠的子矩形按顺序覆盖，子矩形在矩形的边缘，没有子矩形的矩形只填充背景色
+func TestRREDecode(t *testing.T) {
+	decodeFixture(t, "rre", rfb.PixelFormat{
+		BPP: 16, Depth: 16, TrueColor: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5,
+	}, 0)
+}
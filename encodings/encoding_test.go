@@ -0,0 +1,154 @@
+package encodings
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/rfb"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+)
+
+// testSession 把录制的矩形数据解码到画布上的会话
+type testSession struct {
+	rfb.ISession
+	r    io.Reader
+	opts rfb.Options
+	swap *gmap.Map
+	cv   *canvas.VncCanvas
+}
+
+func newTestSession(pf rfb.PixelFormat, width, height int) *testSession {
+	return &testSession{
+		opts: rfb.Options{PixelFormat: pf, Width: uint16(width), Height: uint16(height)},
+		swap: gmap.New(true),
+		cv:   canvas.NewVncCanvas(width, height),
+	}
+}
+
+func (that *testSession) Read(buf []byte) (int, error) { return that.r.Read(buf) }
+func (that *testSession) Options() rfb.Options         { return that.opts }
+func (that *testSession) Swap() *gmap.Map              { return that.swap }
+func (that *testSession) Conn() io.ReadWriteCloser     { return that.cv }
+func (that *testSession) Type() rfb.SessionType        { return rfb.CanvasSessionType }
+func (that *testSession) SetWidth(width uint16)        { that.opts.Width = width }
+func (that *testSession) SetHeight(height uint16)      { that.opts.Height = height }
+
+// testEncodings 测试会话支持的编码，包括没有加入DefaultEncodings的 JRLEEncoding
+var testEncodings = append([]rfb.IEncoding{&JRLEEncoding{}}, DefaultEncodings...)
+
+func (that *testSession) NewEncoding(typ rfb.EncodingType) rfb.IEncoding {
+	for _, enc := range testEncodings {
+		if enc.Type() == typ {
+			return enc.Clone()
+		}
+	}
+	return nil
+}
+
+// rectHeader 生成帧缓冲更新消息中的矩形头
+func rectHeader(x, y, width, height uint16, enc rfb.EncodingType) []byte {
+	header := binary.BigEndian.AppendUint16(nil, x)
+	header = binary.BigEndian.AppendUint16(header, y)
+	header = binary.BigEndian.AppendUint16(header, width)
+	header = binary.BigEndian.AppendUint16(header, height)
+	return binary.BigEndian.AppendUint32(header, uint32(enc))
+}
+
+// decode 按顺序读取data中的矩形(矩形头及编码后的数据，与帧缓冲更新消息中的格式相同)，解码后绘制到画布上
+func (that *testSession) decode(data []byte) error {
+	that.r = bytes.NewReader(data)
+	for {
+		var header struct {
+			X, Y, Width, Height uint16
+			EncType             int32
+		}
+		if err := binary.Read(that, binary.BigEndian, &header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		rect := &rfb.Rectangle{
+			X:       header.X,
+			Y:       header.Y,
+			Width:   header.Width,
+			Height:  header.Height,
+			EncType: rfb.EncodingType(header.EncType),
+		}
+		rect.Enc = that.NewEncoding(rect.EncType)
+		if err := rect.Enc.Read(that, rect); err != nil {
+			return err
+		}
+		if err := rect.Enc.Write(that, rect); err != nil {
+			return err
+		}
+	}
+}
+
+// loadFixture 读取testdata/{name}.rfb中的矩形数据及testdata/{name}.png中解码后应该得到的画面。
+// 矩形数据按照rfb协议文档编码生成，png是编码前的原始画面，与本包的解码实现无关。
+func loadFixture(t *testing.T, name string) ([]byte, image.Image) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name + ".rfb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("testdata/" + name + ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, want
+}
+
+// expectCanvas 与want比较画面的大小，并逐个像素比较，颜色分量的误差不能超过tolerance
+func expectCanvas(t *testing.T, name string, got, want image.Image, tolerance int) {
+	t.Helper()
+	bounds := want.Bounds()
+	if got.Bounds() != bounds {
+		t.Fatalf("%s的画面大小为%v，应该为%v", name, got.Bounds(), bounds)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := got.At(x, y).RGBA()
+			r2, g2, b2, _ := want.At(x, y).RGBA()
+			for _, d := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+				if d > tolerance || d < -tolerance {
+					t.Fatalf("%s的像素(%d,%d)为(%d,%d,%d)，应该为(%d,%d,%d)", name, x, y, r1>>8, g1>>8, b1>>8, r2>>8, g2>>8, b2>>8)
+				}
+			}
+		}
+	}
+}
+
+// decodeFixture 解码testdata/{name}.rfb中的矩形，与testdata/{name}.png逐个像素比较，颜色分量的误差不能超过tolerance
+func decodeFixture(t *testing.T, name string, pf rfb.PixelFormat, tolerance int) {
+	t.Helper()
+	data, want := loadFixture(t, name)
+	sess := newTestSession(pf, want.Bounds().Dx(), want.Bounds().Dy())
+	if err := sess.decode(data); err != nil {
+		t.Fatalf("解码%s失败:%v", name, err)
+	}
+	expectCanvas(t, name, sess.cv, want, tolerance)
+}
+
+// decodeCursorFixture 解码testdata/{name}.rfb中的矩形，在鼠标指针的位置绘制鼠标指针后与testdata/{name}.png比较
+func decodeCursorFixture(t *testing.T, name string, tolerance int) {
+	t.Helper()
+	data, want := loadFixture(t, name)
+	sess := newTestSession(rfb.PixelFormat32bit, want.Bounds().Dx(), want.Bounds().Dy())
+	if err := sess.decode(data); err != nil {
+		t.Fatalf("解码%s失败:%v", name, err)
+	}
+	sess.cv.DrawCursor = true
+	expectCanvas(t, name, sess.cv.PaintCursor(), want, tolerance)
+}
@@ -5,7 +5,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
 )
 
 var TightMinToCompress int = 12
@@ -23,10 +29,19 @@ const (
 	TightFilterGradient = 2
 )
 
+const (
+	tightExplicitFilter = 0x40 // 压缩控制字节中表示后面跟着过滤器id的标识
+	tightMaxStreams     = 4    // Tight编码使用的zlib流的个数
+)
+
+// TightEncoding tightvnc项目设置的编码，每个矩形可以使用纯色填充、JPEG图片或者经过过滤器处理后用zlib压缩的像素数据，
+// 压缩控制字节的低4位表示需要重置的zlib流，基础压缩方式使用4个持续的zlib流中的一个。
 type TightEncoding struct {
 	buff *bytes.Buffer
 }
 
+var _ rfb.IEncoding = new(TightEncoding)
+
 func (that *TightEncoding) Supported(session rfb.ISession) bool {
 	return true
 }
@@ -46,21 +61,26 @@ func (that *TightEncoding) Type() rfb.EncodingType {
 }
 
 func calcTightBytePerPixel(pf *rfb.PixelFormat) int {
-	bytesPerPixel := int(pf.BPP / 8)
-
-	var bytesPerPixelTight int
-	if 24 == pf.Depth && 32 == pf.BPP {
-		bytesPerPixelTight = 3
-	} else {
-		bytesPerPixelTight = bytesPerPixel
+	if isTightPixel24(pf) {
+		return 3
 	}
-	return bytesPerPixelTight
+	return int(pf.BPP / 8)
+}
+
+// isTightPixel24 32位深度24，每种颜色最大值为255的真彩色像素格式，Tight编码使用按R,G,B顺序排列的3字节TPIXEL表示像素
+func isTightPixel24(pf *rfb.PixelFormat) bool {
+	return pf.TrueColor != 0 && pf.BPP == 32 && pf.Depth == 24 &&
+		pf.RedMax == 255 && pf.GreenMax == 255 && pf.BlueMax == 255
 }
 
 func (that *TightEncoding) Write(session rfb.ISession, rect *rfb.Rectangle) error {
 	if that.buff == nil {
 		return errors.New("ByteBuffer is nil")
 	}
+	if session.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		return that.draw(session, session.Conn().(*canvas.VncCanvas), session.Options().PixelFormat, rect)
+	}
 	_, err := that.buff.WriteTo(session)
 	that.buff.Reset()
 	return err
@@ -73,7 +93,7 @@ func (that *TightEncoding) Read(session rfb.ISession, rect *rfb.Rectangle) error
 	bytesPixel := calcTightBytePerPixel(&pf)
 	compressionControl, err := ReadUint8(session)
 	if err != nil {
-		return nil
+		return err
 	}
 	_ = binary.Write(that.buff, binary.BigEndian, compressionControl)
 
@@ -94,6 +114,9 @@ func (that *TightEncoding) Read(session rfb.ISession, rect *rfb.Rectangle) error
 		}
 		// 获取jpeg流的长度
 		size, err := that.ReadCompactLen(session)
+		if err != nil {
+			return err
+		}
 		//读取jpeg流
 		jpegBytes, err := ReadBytes(size, session)
 		if err != nil {
@@ -227,3 +250,270 @@ func (that *TightEncoding) ReadTightData(dataSize int, session rfb.ISession) err
 	_, _ = that.buff.Write(zippedBytes)
 	return nil
 }
+
+// draw 解码Tight编码的矩形，绘制到画布上
+func (that *TightEncoding) draw(sess rfb.ISession, cv *canvas.VncCanvas, pf rfb.PixelFormat, rect *rfb.Rectangle) error {
+	compCtl, err := ReadUint8(that.buff)
+	if err != nil {
+		return err
+	}
+	resetTightStreams(sess, compCtl)
+	switch compCtl >> 4 {
+	case tightCompressionFill:
+		return fillTightRect(cv, that.buff, &pf, rect)
+	case tightCompressionJPEG:
+		return drawTightImage(cv, that.buff, rect, jpeg.Decode)
+	default:
+		return that.drawBasic(sess, cv, &pf, rect, compCtl)
+	}
+}
+
+// drawBasic 解码基础压缩方式的像素数据，像素数据经过过滤器处理后使用compCtl指定的zlib流压缩
+func (that *TightEncoding) drawBasic(sess rfb.ISession, cv *canvas.VncCanvas, pf *rfb.PixelFormat, rect *rfb.Rectangle, compCtl uint8) error {
+	var filterId uint8 = TightFilterCopy
+	var err error
+	if compCtl&tightExplicitFilter != 0 {
+		filterId, err = ReadUint8(that.buff)
+		if err != nil {
+			return err
+		}
+	}
+	stream := sessionZlibStream(sess, rfb.EncTight, int(compCtl>>4&0x03))
+	width, height := int(rect.Width), int(rect.Height)
+	switch filterId {
+	case TightFilterCopy:
+		data, err := readTightData(that.buff, stream, width*height*calcTightBytePerPixel(pf))
+		if err != nil {
+			return err
+		}
+		return drawTightPixels(cv, pf, rect, data)
+	case TightFilterPalette:
+		return that.drawPalette(cv, pf, rect, stream)
+	case TightFilterGradient:
+		data, err := readTightData(that.buff, stream, width*height*calcTightBytePerPixel(pf))
+		if err != nil {
+			return err
+		}
+		return drawTightPixels(cv, pf, rect, tightGradient(pf, data, width, height))
+	default:
+		return fmt.Errorf("Tight编码的过滤器id不正确:%d", filterId)
+	}
+}
+
+// drawPalette 解码调色板过滤器处理的像素数据，两种颜色时每个像素使用1位表示并且每行按字节对齐，否则每个像素使用1字节表示
+func (that *TightEncoding) drawPalette(cv *canvas.VncCanvas, pf *rfb.PixelFormat, rect *rfb.Rectangle, stream *zlibStream) error {
+	colorCount, err := ReadUint8(that.buff)
+	if err != nil {
+		return err
+	}
+	palette := make([]*color.RGBA, int(colorCount)+1)
+	for i := range palette {
+		palette[i], err = readTightColor(cv, that.buff, pf)
+		if err != nil {
+			return err
+		}
+	}
+	width, height := int(rect.Width), int(rect.Height)
+	rowBytes := width
+	if len(palette) == 2 {
+		rowBytes = (width + 7) / 8
+	}
+	data, err := readTightData(that.buff, stream, rowBytes*height)
+	if err != nil {
+		return err
+	}
+	for y := 0; y < height; y++ {
+		row := data[y*rowBytes:]
+		for x := 0; x < width; x++ {
+			var index int
+			if len(palette) == 2 {
+				index = int(row[x/8]>>(7-x%8)) & 0x01
+			} else {
+				index = int(row[x])
+			}
+			if index >= len(palette) {
+				return fmt.Errorf("Tight编码的调色板索引超出范围:%d", index)
+			}
+			cv.Set(int(rect.X)+x, int(rect.Y)+y, palette[index])
+		}
+	}
+	return nil
+}
+
+// resetTightStreams 根据压缩控制字节的低4位重置对应的zlib流
+func resetTightStreams(sess rfb.ISession, compCtl uint8) {
+	for i := 0; i < tightMaxStreams; i++ {
+		if compCtl&(1<<i) != 0 {
+			sessionZlibStream(sess, rfb.EncTight, i).Reset()
+		}
+	}
+}
+
+// readCompactLen 读取1到3字节表示的长度，前两个字节的最高位表示后面还有字节
+func readCompactLen(r io.Reader) (int, error) {
+	size := 0
+	for i := 0; i < 3; i++ {
+		b, err := ReadUint8(r)
+		if err != nil {
+			return 0, err
+		}
+		if i == 2 {
+			size |= int(b) << 14
+			break
+		}
+		size |= int(b&0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return size, nil
+}
+
+// readTightData 读取size字节的像素数据，不足TightMinToCompress字节时没有压缩，否则从zlib流中解压
+func readTightData(r io.Reader, stream *zlibStream, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if size < TightMinToCompress {
+		_, err := io.ReadFull(r, data)
+		return data, err
+	}
+	zippedLen, err := readCompactLen(r)
+	if err != nil {
+		return nil, err
+	}
+	zipped, err := ReadBytes(zippedLen, r)
+	if err != nil {
+		return nil, err
+	}
+	unZipper, err := stream.Reader(zipped)
+	if err != nil {
+		return nil, fmt.Errorf("Tight编码的数据解压失败:%v", err)
+	}
+	if _, err = io.ReadFull(unZipper, data); err != nil {
+		return nil, fmt.Errorf("Tight编码的数据解压失败:%v", err)
+	}
+	return data, nil
+}
+
+// readTightColor 读取一个TPIXEL格式的颜色
+func readTightColor(cv *canvas.VncCanvas, r io.Reader, pf *rfb.PixelFormat) (*color.RGBA, error) {
+	if !isTightPixel24(pf) {
+		return cv.ReadColor(r, pf)
+	}
+	var px [3]byte
+	if _, err := io.ReadFull(r, px[:]); err != nil {
+		return nil, err
+	}
+	return &color.RGBA{R: px[0], G: px[1], B: px[2], A: 1}, nil
+}
+
+// fillTightRect 读取一个颜色填充整个矩形
+func fillTightRect(cv *canvas.VncCanvas, r io.Reader, pf *rfb.PixelFormat, rect *rfb.Rectangle) error {
+	col, err := readTightColor(cv, r, pf)
+	if err != nil {
+		return err
+	}
+	bounds := canvas.MakeRectFromVncRect(rect)
+	cv.FillRect(&bounds, col)
+	return nil
+}
+
+// drawTightPixels 把TPIXEL格式的像素数据绘制到画布上
+func drawTightPixels(cv *canvas.VncCanvas, pf *rfb.PixelFormat, rect *rfb.Rectangle, data []byte) error {
+	if !isTightPixel24(pf) {
+		return cv.DecodeRaw(bytes.NewReader(data), pf, rect)
+	}
+	width := int(rect.Width)
+	for y := 0; y < int(rect.Height); y++ {
+		for x := 0; x < width; x++ {
+			i := (y*width + x) * 3
+			cv.Set(int(rect.X)+x, int(rect.Y)+y, color.RGBA{R: data[i], G: data[i+1], B: data[i+2], A: 1})
+		}
+	}
+	return nil
+}
+
+// drawTightImage 读取JPEG或者PNG图片，解码后绘制到画布上
+func drawTightImage(cv *canvas.VncCanvas, r io.Reader, rect *rfb.Rectangle, decode func(io.Reader) (image.Image, error)) error {
+	size, err := readCompactLen(r)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return err
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("Tight编码的图片解码失败:%v", err)
+	}
+	draw.Draw(cv, canvas.MakeRectFromVncRect(rect), img, img.Bounds().Min, draw.Src)
+	return nil
+}
+
+// tightGradient 还原渐变过滤器处理的像素数据，每个像素的颜色分量使用左边、上边及左上角像素的分量预测，
+// 数据中是与预测值的差，返回TPIXEL格式的像素数据
+func tightGradient(pf *rfb.PixelFormat, data []byte, width, height int) []byte {
+	bytesPixel := calcTightBytePerPixel(pf)
+	pixel24 := isTightPixel24(pf)
+	order := pf.Order()
+	shifts := [3]uint8{pf.RedShift, pf.GreenShift, pf.BlueShift}
+	maxes := [3]int{int(pf.RedMax), int(pf.GreenMax), int(pf.BlueMax)}
+	// 拆分像素的颜色分量
+	split := func(b []byte) (c [3]int) {
+		if pixel24 {
+			return [3]int{int(b[0]), int(b[1]), int(b[2])}
+		}
+		var pixel uint32
+		switch bytesPixel {
+		case 1:
+			pixel = uint32(b[0])
+		case 2:
+			pixel = uint32(order.Uint16(b))
+		case 4:
+			pixel = order.Uint32(b)
+		}
+		for i := range c {
+			c[i] = int(pixel>>shifts[i]) & maxes[i]
+		}
+		return c
+	}
+	// 合并颜色分量为像素
+	join := func(b []byte, c [3]int) {
+		if pixel24 {
+			b[0], b[1], b[2] = uint8(c[0]), uint8(c[1]), uint8(c[2])
+			return
+		}
+		var pixel uint32
+		for i := range c {
+			pixel |= uint32(c[i]) << shifts[i]
+		}
+		switch bytesPixel {
+		case 1:
+			b[0] = uint8(pixel)
+		case 2:
+			order.PutUint16(b, uint16(pixel))
+		case 4:
+			order.PutUint32(b, pixel)
+		}
+	}
+	out := make([]byte, len(data))
+	prevRow := make([][3]int, width)
+	thisRow := make([][3]int, width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := (y*width + x) * bytesPixel
+			diff := split(data[i : i+bytesPixel])
+			for c := 0; c < 3; c++ {
+				est := prevRow[x][c]
+				if x > 0 {
+					est += thisRow[x-1][c] - prevRow[x-1][c]
+				}
+				est = min(max(est, 0), maxes[c])
+				thisRow[x][c] = (est + diff[c]) & maxes[c]
+			}
+			join(out[i:i+bytesPixel], thisRow[x])
+		}
+		prevRow, thisRow = thisRow, prevRow
+	}
+	return out
+}
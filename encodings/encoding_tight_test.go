@@ -0,0 +1,89 @@
+package encodings
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/vprix/vncproxy/rfb"
+	"os"
+	"testing"
+)
+
+var (
+	testPixelFormatRGB565 = rfb.PixelFormat{BPP: 16, Depth: 16, TrueColor: 1, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}
+	testPixelFormatBGR233 = rfb.PixelFormat{BPP: 8, Depth: 8, TrueColor: 1, RedMax: 7, GreenMax: 7, BlueMax: 3, GreenShift: 3, BlueShift: 6}
+)
+
+// TestTightDecode 基础压缩方式的各个过滤器：填充、复制(持续的zlib流及不足12字节不压缩的数据)、
+// 两种颜色及多种颜色的调色板、渐变、重置zlib流，以及JPEG图片
+func TestTightDecode(t *testing.T) {
+	depth32 := rfb.PixelFormat32bit
+	depth32.Depth = 32
+	depth32.BigEndian = 1
+	cases := []struct {
+		name      string
+		pf        rfb.PixelFormat
+		tolerance int
+	}{
+		{"tight_basic", rfb.PixelFormat32bit, 0},
+		{"tight_basic_depth32", depth32, 0},
+		{"tight_basic_rgb565", testPixelFormatRGB565, 0},
+		{"tight_basic_bgr233", testPixelFormatBGR233, 0},
+		{"tight_jpeg", rfb.PixelFormat32bit, 12},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			decodeFixture(t, c.name, c.pf, c.tolerance)
+		})
+	}
+}
+
+// TestTightPngDecode TightPng编码的填充、PNG图片及JPEG图片，JPEG有损压缩允许一定的误差
+func TestTightPngDecode(t *testing.T) {
+	decodeFixture(t, "tightpng", rfb.PixelFormat32bit, 12)
+}
+
+// TestTightDecodeError 数据不正确时返回错误
+func TestTightDecodeError(t *testing.T) {
+	rect := func(data []byte, width, height int) []byte {
+		header := make([]byte, 12)
+		binary.BigEndian.PutUint16(header[4:], uint16(width))
+		binary.BigEndian.PutUint16(header[6:], uint16(height))
+		binary.BigEndian.PutUint32(header[8:], uint32(rfb.EncTight))
+		return append(header, data...)
+	}
+	// tight_basic中第一个矩形是填充，第二、三个矩形是使用zlib流0的复制过滤器，
+	// 第三个矩形的压缩控制字节中设置重置zlib流0后，数据不是新的zlib流，无法解压
+	data, err := os.ReadFile("testdata/tight_basic.rfb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := 12 + 1 + 3 + 12 + 1
+	size, err := readCompactLen(bytes.NewReader(data[offset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset++; data[offset-1]&0x80 != 0; offset++ {
+	}
+	reset := append([]byte{}, data...)
+	reset[offset+size+12] |= 0x01
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"过滤器id不正确", rect([]byte{tightExplicitFilter, 3}, 1, 1)},
+		{"调色板索引超出范围", rect([]byte{tightExplicitFilter, TightFilterPalette, 2, 0, 0, 0, 1, 1, 1, 2, 2, 2, 3}, 1, 1)},
+		{"压缩类型不正确", rect([]byte{0xB0}, 1, 1)},
+		{"重置zlib流", reset},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := newTestSession(rfb.PixelFormat32bit, 32, 24).decode(c.data); err == nil {
+				t.Fatal("没有返回错误")
+			}
+		})
+	}
+	if err = newTestSession(rfb.PixelFormat32bit, 32, 24).decode(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,13 +5,19 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
+	"image/jpeg"
+	"image/png"
 )
 
+// TightPngEncoding noVNC使用的Tight编码的变种，没有基础压缩方式，像素数据使用PNG图片传输
 type TightPngEncoding struct {
 	buff *bytes.Buffer
 }
 
+var _ rfb.IEncoding = new(TightPngEncoding)
+
 func (that *TightPngEncoding) Supported(session rfb.ISession) bool {
 	return true
 }
@@ -35,6 +41,10 @@ func (that *TightPngEncoding) Write(session rfb.ISession, rect *rfb.Rectangle) e
 	if that.buff == nil {
 		return errors.New("ByteBuffer is nil")
 	}
+	if session.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		return that.draw(session.Conn().(*canvas.VncCanvas), session.Options().PixelFormat, rect)
+	}
 	_, err := that.buff.WriteTo(session)
 	that.buff.Reset()
 	return err
@@ -47,14 +57,14 @@ func (that *TightPngEncoding) Read(session rfb.ISession, rect *rfb.Rectangle) er
 	bytesPixel := calcTightBytePerPixel(&pf)
 	compressionControl, err := ReadUint8(session)
 	if err != nil {
-		return nil
+		return err
 	}
 	_ = binary.Write(that.buff, binary.BigEndian, compressionControl)
 
 	compType := compressionControl >> 4 & 0x0F
 
 	switch compType {
-	case tightCompressionPNG:
+	case tightCompressionPNG, tightCompressionJPEG:
 		size, err := that.ReadCompactLen(session)
 		if err != nil {
 			return err
@@ -104,3 +114,21 @@ func (that *TightPngEncoding) ReadCompactLen(session rfb.ISession) (int, error)
 
 	return int(size), err
 }
+
+// draw 解码TightPng编码的矩形，绘制到画布上，TightPng编码不使用zlib流，压缩控制字节中重置zlib流的标识可以忽略
+func (that *TightPngEncoding) draw(cv *canvas.VncCanvas, pf rfb.PixelFormat, rect *rfb.Rectangle) error {
+	compCtl, err := ReadUint8(that.buff)
+	if err != nil {
+		return err
+	}
+	switch compCtl >> 4 {
+	case tightCompressionFill:
+		return fillTightRect(cv, that.buff, &pf, rect)
+	case tightCompressionJPEG:
+		return drawTightImage(cv, that.buff, rect, jpeg.Decode)
+	case tightCompressionPNG:
+		return drawTightImage(cv, that.buff, rect, png.Decode)
+	default:
+		return fmt.Errorf("unknown tight compression %d", compCtl>>4)
+	}
+}
@@ -1 +1,146 @@
 package encodings
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+)
+
+// TRLEEncoding TRLE(Tiled Run-Length Encoding)，矩形被分割成16x16的块，每个块的二级编码与ZRLE相同，
+// 但是数据没有使用zlib压缩，也没有数据的长度，只能解析完所有的块才知道矩形的数据在哪里结束。
+// 与ZRLE不同，TRLE的块可以使用二级编码127和129重用上一个块的调色板。
+type TRLEEncoding struct {
+	buff *bytes.Buffer
+}
+
+var _ rfb.IEncoding = new(TRLEEncoding)
+
+func (that *TRLEEncoding) Type() rfb.EncodingType {
+	return rfb.EncTRLE
+}
+
+func (that *TRLEEncoding) Supported(rfb.ISession) bool {
+	return true
+}
+
+func (that *TRLEEncoding) Clone(data ...bool) rfb.IEncoding {
+	obj := &TRLEEncoding{}
+	if len(data) > 0 && data[0] {
+		if that.buff != nil {
+			obj.buff = &bytes.Buffer{}
+			_, _ = obj.buff.Write(that.buff.Bytes())
+		}
+	}
+	return obj
+}
+
+func (that *TRLEEncoding) Read(sess rfb.ISession, rect *rfb.Rectangle) error {
+	if that.buff == nil {
+		that.buff = &bytes.Buffer{}
+	}
+	pf := sess.Options().PixelFormat
+	// 解析的同时把读取的数据保存下来
+	return readRLETiles(io.TeeReader(sess, that.buff), &pf, rect, 16, false)
+}
+
+func (that *TRLEEncoding) Write(sess rfb.ISession, rect *rfb.Rectangle) error {
+	if that.buff == nil {
+		return errors.New("ByteBuffer is nil")
+	}
+	if sess.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		pf := sess.Options().PixelFormat
+		return drawRLETiles(sess.Conn().(*canvas.VncCanvas), that.buff, &pf, rect, 16, nil)
+	}
+	_, err := that.buff.WriteTo(sess)
+	that.buff.Reset()
+	return err
+}
+
+// readRLETiles 读取TRLE及JRLE编码的所有块，只解析数据的长度，不解码像素，jrle为true时允许JPEG图片的块
+func readRLETiles(r io.Reader, pf *rfb.PixelFormat, rect *rfb.Rectangle, tileSize int, jrle bool) error {
+	cPixelSize := int(pf.BPP / 8)
+	if IsCPixelSpecific(pf) {
+		cPixelSize = 3
+	}
+	skip := func(n int) error {
+		_, err := io.CopyN(io.Discard, r, int64(n))
+		return err
+	}
+	paletteSize := 0
+	for tileOffsetY := 0; tileOffsetY < int(rect.Height); tileOffsetY += tileSize {
+		tileHeight := min(tileSize, int(rect.Height)-tileOffsetY)
+		for tileOffsetX := 0; tileOffsetX < int(rect.Width); tileOffsetX += tileSize {
+			tileWidth := min(tileSize, int(rect.Width)-tileOffsetX)
+			pixels := tileWidth * tileHeight
+			subEnc, err := ReadUint8(r)
+			if err != nil {
+				return err
+			}
+			switch {
+			case subEnc == ZRLERawPixelData:
+				err = skip(pixels * cPixelSize)
+			case subEnc == ZRLESingleColour:
+				err = skip(cPixelSize)
+			case subEnc == JRLEJPEG && jrle:
+				_, err = readJPEGSegments(r)
+			case subEnc >= 2 && subEnc <= 16, subEnc == TRLEReusePalette:
+				if subEnc != TRLEReusePalette {
+					paletteSize = int(subEnc)
+					if err = skip(paletteSize * cPixelSize); err != nil {
+						return err
+					}
+				} else if paletteSize == 0 {
+					return fmt.Errorf("TRLE编码的块没有可以重用的调色板")
+				}
+				// 每行按字节对齐
+				bits := 4
+				if paletteSize == 2 {
+					bits = 1
+				} else if paletteSize <= 4 {
+					bits = 2
+				}
+				err = skip((tileWidth*bits + 7) / 8 * tileHeight)
+			case subEnc == 128:
+				for n := 0; n < pixels && err == nil; {
+					if err = skip(cPixelSize); err != nil {
+						break
+					}
+					var runLen int
+					runLen, err = readRunLength(r)
+					n += runLen
+				}
+			case subEnc >= TRLEReusePaletteRLE:
+				if subEnc != TRLEReusePaletteRLE {
+					paletteSize = int(subEnc) - 128
+					if err = skip(paletteSize * cPixelSize); err != nil {
+						return err
+					}
+				} else if paletteSize == 0 {
+					return fmt.Errorf("TRLE编码的块没有可以重用的调色板")
+				}
+				for n := 0; n < pixels && err == nil; {
+					var index uint8
+					if index, err = ReadUint8(r); err != nil {
+						break
+					}
+					runLen := 1
+					// 最高位为1表示后面跟着游程长度
+					if index&0x80 != 0 {
+						runLen, err = readRunLength(r)
+					}
+					n += runLen
+				}
+			default:
+				return fmt.Errorf("TRLE编码的二级编码不正确:%d", subEnc)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
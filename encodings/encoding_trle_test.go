@@ -0,0 +1,38 @@
+package encodings
+
+import (
+	"encoding/binary"
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// TestTRLEDecode 不完整的边缘块，原始像素、纯色、2色及多色的压缩调色板、重用调色板、普通游程及调色板游程(长度超过255)
+func TestTRLEDecode(t *testing.T) {
+	decodeFixture(t, "trle", rfb.PixelFormat32bit, 0)
+}
+
+// TestTRLEDecodeError 二级编码不正确以及没有可以重用的调色板
+func TestTRLEDecodeError(t *testing.T) {
+	rect := func(data ...byte) []byte {
+		header := make([]byte, 12)
+		binary.BigEndian.PutUint16(header[4:], 4)
+		binary.BigEndian.PutUint16(header[6:], 4)
+		binary.BigEndian.PutUint32(header[8:], uint32(rfb.EncTRLE))
+		return append(header, data...)
+	}
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"二级编码不正确", rect(17)},
+		{"重用压缩调色板", rect(127)},
+		{"重用游程调色板", rect(129)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := newTestSession(rfb.PixelFormat32bit, 4, 4).decode(c.data); err == nil {
+				t.Fatal("没有返回错误")
+			}
+		})
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
 )
 
+// ZLibEncoding 使用zlib压缩的Raw编码，数据是4字节的长度和压缩的像素数据，
+// 整个连接上的矩形使用同一个持续的zlib流压缩。
 type ZLibEncoding struct {
 	buff *bytes.Buffer
 }
@@ -56,7 +59,28 @@ func (that *ZLibEncoding) Write(sess rfb.ISession, rect *rfb.Rectangle) error {
 	if that.buff == nil {
 		return errors.New("ByteBuffer is nil")
 	}
+	if sess.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		return that.draw(sess, sess.Conn().(*canvas.VncCanvas), sess.Options().PixelFormat, rect)
+	}
 	_, err := that.buff.WriteTo(sess)
 	that.buff.Reset()
 	return err
 }
+
+// draw 使用会话中持续的zlib流解压数据，按照Raw编码绘制到画布上
+func (that *ZLibEncoding) draw(sess rfb.ISession, cv *canvas.VncCanvas, pf rfb.PixelFormat, rect *rfb.Rectangle) error {
+	size, err := ReadUint32(that.buff)
+	if err != nil {
+		return err
+	}
+	data, err := ReadBytes(int(size), that.buff)
+	if err != nil {
+		return err
+	}
+	unZipper, err := sessionZlibStream(sess, rfb.EncZlib, 0).Reader(data)
+	if err != nil {
+		return err
+	}
+	return cv.DecodeRaw(unZipper, &pf, rect)
+}
@@ -0,0 +1,21 @@
+package encodings
+
+import (
+	"encoding/binary"
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// TestZlibDecode 所有矩形使用同一个zlib流，第二个矩形与第一个矩形的内容相同，压缩数据引用了第一个矩形的数据
+func TestZlibDecode(t *testing.T) {
+	decodeFixture(t, "zlib", rfb.PixelFormat32bit, 0)
+}
+
+// TestZlibDecodeError 没有解压之前的矩形时，之后的矩形无法单独解压
+func TestZlibDecodeError(t *testing.T) {
+	data, _ := loadFixture(t, "zlib")
+	first := 12 + 4 + int(binary.BigEndian.Uint32(data[12:]))
+	if err := newTestSession(rfb.PixelFormat32bit, 64, 48).decode(data[first:]); err == nil {
+		t.Fatal("没有返回错误")
+	}
+}
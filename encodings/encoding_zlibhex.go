@@ -0,0 +1,167 @@
+package encodings
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/rfb"
+	"image/color"
+	"io"
+)
+
+const (
+	HexTileZlibRaw = 1 << 5 // 原始数据使用zlib压缩：标志位之后是2字节的长度和压缩的原始数据
+	HexTileZlibHex = 1 << 6 // 块数据使用zlib压缩：标志位之后是2字节的长度和压缩的背景色、前景色及子块数据
+)
+
+// ZlibHexEncoding UltraVNC使用的HexTile编码的变种，每个块可以使用zlib压缩，
+// 压缩的原始数据和压缩的块数据分别使用两个持续的zlib流。
+type ZlibHexEncoding struct {
+	buff *bytes.Buffer
+}
+
+var _ rfb.IEncoding = new(ZlibHexEncoding)
+
+func (that *ZlibHexEncoding) Supported(rfb.ISession) bool {
+	return true
+}
+
+func (that *ZlibHexEncoding) Type() rfb.EncodingType {
+	return rfb.EncZlibHex
+}
+
+func (that *ZlibHexEncoding) Clone(data ...bool) rfb.IEncoding {
+	obj := &ZlibHexEncoding{}
+	if len(data) > 0 && data[0] {
+		if that.buff != nil {
+			obj.buff = &bytes.Buffer{}
+			_, _ = obj.buff.Write(that.buff.Bytes())
+		}
+	}
+	return obj
+}
+
+func (that *ZlibHexEncoding) Read(sess rfb.ISession, rect *rfb.Rectangle) error {
+	if that.buff == nil {
+		that.buff = &bytes.Buffer{}
+	}
+	bytesPerPixel := int(sess.Options().PixelFormat.BPP) / 8
+	// 解析的同时把读取的数据保存下来
+	r := io.TeeReader(sess, that.buff)
+	skip := func(n int) error {
+		_, err := io.CopyN(io.Discard, r, int64(n))
+		return err
+	}
+	for ty := 0; ty < int(rect.Height); ty += 16 {
+		th := min(16, int(rect.Height)-ty)
+		for tx := 0; tx < int(rect.Width); tx += 16 {
+			tw := min(16, int(rect.Width)-tx)
+			subEncoding, err := ReadUint8(r)
+			if err != nil {
+				return fmt.Errorf("ZlibHexEncoding.Read: error in zlibhex reader: %v", err)
+			}
+			switch {
+			case subEncoding&(HexTileZlibRaw|HexTileZlibHex) != 0:
+				var size uint16
+				if size, err = ReadUint16(r); err == nil {
+					err = skip(int(size))
+				}
+			case subEncoding&HexTileRaw != 0:
+				err = skip(tw * th * bytesPerPixel)
+			default:
+				size := 0
+				if subEncoding&HexTileBackgroundSpecified != 0 {
+					size += bytesPerPixel
+				}
+				if subEncoding&HexTileForegroundSpecified != 0 {
+					size += bytesPerPixel
+				}
+				if err = skip(size); err != nil {
+					break
+				}
+				if subEncoding&HexTileAnySubRects != 0 {
+					var nSubRects uint8
+					if nSubRects, err = ReadUint8(r); err != nil {
+						break
+					}
+					// 每个子块有2字节的位置及大小，指定颜色时还有颜色
+					size = 2
+					if subEncoding&HexTileSubRectsColoured != 0 {
+						size += bytesPerPixel
+					}
+					err = skip(int(nSubRects) * size)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("ZlibHexEncoding.Read: error in zlibhex reader: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (that *ZlibHexEncoding) Write(sess rfb.ISession, rect *rfb.Rectangle) error {
+	if that.buff == nil {
+		return errors.New("ByteBuffer is nil")
+	}
+	if sess.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		return that.draw(sess, sess.Conn().(*canvas.VncCanvas), sess.Options().PixelFormat, rect)
+	}
+	_, err := that.buff.WriteTo(sess)
+	that.buff.Reset()
+	return err
+}
+
+// draw 绘制画布，压缩的数据需要使用会话中持续的zlib流解压
+func (that *ZlibHexEncoding) draw(sess rfb.ISession, cv *canvas.VncCanvas, pf rfb.PixelFormat, rect *rfb.Rectangle) error {
+	rawStream := sessionZlibStream(sess, rfb.EncZlibHex, 0)
+	hexStream := sessionZlibStream(sess, rfb.EncZlibHex, 1)
+	var bgCol, fgCol *color.RGBA
+	for ty := rect.Y; ty < rect.Y+rect.Height; ty += 16 {
+		th := min(16, int(rect.Y+rect.Height-ty))
+		for tx := rect.X; tx < rect.X+rect.Width; tx += 16 {
+			tw := min(16, int(rect.X+rect.Width-tx))
+			subEncoding, err := ReadUint8(that.buff)
+			if err != nil {
+				return fmt.Errorf("ZlibHexEncoding.draw: error in zlibhex reader: %v", err)
+			}
+			tile := &rfb.Rectangle{X: tx, Y: ty, Width: uint16(tw), Height: uint16(th), EncType: rfb.EncRaw}
+			switch {
+			case subEncoding&HexTileZlibRaw != 0:
+				var unZipper io.Reader
+				if unZipper, err = readZlibHexData(that.buff, rawStream); err == nil {
+					err = cv.DecodeRaw(unZipper, &pf, tile)
+				}
+			case subEncoding&HexTileRaw != 0:
+				err = cv.DecodeRaw(that.buff, &pf, tile)
+			case subEncoding&HexTileZlibHex != 0:
+				var unZipper io.Reader
+				if unZipper, err = readZlibHexData(that.buff, hexStream); err != nil {
+					break
+				}
+				bgCol, fgCol, err = drawHexTile(cv, unZipper, &pf, subEncoding, canvas.MakeRectFromVncRect(tile), bgCol, fgCol)
+			default:
+				bgCol, fgCol, err = drawHexTile(cv, that.buff, &pf, subEncoding, canvas.MakeRectFromVncRect(tile), bgCol, fgCol)
+			}
+			if err != nil {
+				return fmt.Errorf("ZlibHexEncoding.draw: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// readZlibHexData 读取2字节长度的压缩数据，追加到zlib流中，返回读取解压数据的reader
+func readZlibHexData(r io.Reader, stream *zlibStream) (io.Reader, error) {
+	size, err := ReadUint16(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ReadBytes(int(size), r)
+	if err != nil {
+		return nil, err
+	}
+	return stream.Reader(data)
+}
@@ -0,0 +1,22 @@
+package encodings
+
+import (
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// TestZlibHexDecode 原始像素及hextile子编码的块，分别使用未压缩的数据以及两个持续的zlib流
+func TestZlibHexDecode(t *testing.T) {
+	cases := []struct {
+		name string
+		pf   rfb.PixelFormat
+	}{
+		{"zlibhex_32bpp", rfb.PixelFormat32bit},
+		{"zlibhex_16bpp", testPixelFormatRGB565},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			decodeFixture(t, c.name, c.pf, 0)
+		})
+	}
+}
@@ -2,19 +2,21 @@ package encodings
 
 import (
 	"bytes"
-	"compress/zlib"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
 	"image/color"
+	"image/draw"
 	"io"
 )
 
 const (
-	ZRLERawPixelData = 0
-	ZRLESingleColour = 1
+	ZRLERawPixelData    = 0
+	ZRLESingleColour    = 1
+	TRLEReusePalette    = 127 // TRLE编码使用上一个块调色板的调色板编码
+	TRLEReusePaletteRLE = 129 // TRLE编码使用上一个块调色板的调色板rle编码
 )
 
 // ZRLEEncoding ZRLE(Zlib Run - Length Encoding),它结合了zlib 压缩，片技术、调色板和运行长度编码。
@@ -70,15 +72,15 @@ func (that *ZRLEEncoding) Write(sess rfb.ISession, rect *rfb.Rectangle) error {
 		return errors.New("ByteBuffer is nil")
 	}
 	if sess.Type() == rfb.CanvasSessionType {
-		return that.draw(sess.Conn().(*canvas.VncCanvas), sess.Options().PixelFormat, rect)
+		return that.draw(sess, sess.Conn().(*canvas.VncCanvas), sess.Options().PixelFormat, rect)
 	}
 	_, err := that.buff.WriteTo(sess)
 	that.buff.Reset()
 	return err
 }
 
-// 绘制画布
-func (that *ZRLEEncoding) draw(cv *canvas.VncCanvas, pf rfb.PixelFormat, rect *rfb.Rectangle) error {
+// 绘制画布，所有矩形的数据属于同一个zlib流，需要使用会话中持续的zlib流解压
+func (that *ZRLEEncoding) draw(sess rfb.ISession, cv *canvas.VncCanvas, pf rfb.PixelFormat, rect *rfb.Rectangle) error {
 	var size uint32
 	err := binary.Read(that.buff, binary.BigEndian, &size)
 	if err != nil {
@@ -88,50 +90,90 @@ func (that *ZRLEEncoding) draw(cv *canvas.VncCanvas, pf rfb.PixelFormat, rect *r
 	if err != nil {
 		return err
 	}
-	bytesBuff := bytes.NewBuffer(b)
-	unZipper, err := zlib.NewReader(bytesBuff)
+	unZipper, err := sessionZlibStream(sess, rfb.EncZRLE, 0).Reader(b)
 	if err != nil {
 		return err
 	}
+	return drawRLETiles(cv, unZipper, &pf, rect, 64, nil)
+}
 
-	for tileOffsetY := 0; tileOffsetY < int(rect.Height); tileOffsetY += 64 {
+// drawRLETiles 解码ZRLE、TRLE及JRLE编码的数据，矩形被分割成tileSize大小的块，每个块使用单独的二级编码，
+// TRLE及JRLE编码的块可以重用上一个块的调色板，tables不为nil时允许JPEG图片的块，使用tables中缓存的表解码
+func drawRLETiles(cv *canvas.VncCanvas, r io.Reader, pf *rfb.PixelFormat, rect *rfb.Rectangle, tileSize int, tables *jpegTables) error {
+	var palette []*color.RGBA
+	for tileOffsetY := 0; tileOffsetY < int(rect.Height); tileOffsetY += tileSize {
 
-		tileHeight := min(64, int(rect.Height)-tileOffsetY)
+		tileHeight := min(tileSize, int(rect.Height)-tileOffsetY)
 
-		for tileOffsetX := 0; tileOffsetX < int(rect.Width); tileOffsetX += 64 {
+		for tileOffsetX := 0; tileOffsetX < int(rect.Width); tileOffsetX += tileSize {
 
-			tileWidth := min(64, int(rect.Width)-tileOffsetX)
+			tileWidth := min(tileSize, int(rect.Width)-tileOffsetX)
 			// 获取二级编码格式
-			subEnc, err := ReadUint8(unZipper)
+			subEnc, err := ReadUint8(r)
 			if err != nil {
 				return fmt.Errorf("renderZRLE: error while reading subencoding: %v", err)
 			}
+			tx, ty := int(rect.X)+tileOffsetX, int(rect.Y)+tileOffsetY
 
 			switch {
 			case subEnc == ZRLERawPixelData: // 原始编码格式
-				err = that.readZRLERaw(cv, unZipper, &pf, int(rect.X)+tileOffsetX, int(rect.Y)+tileOffsetY, tileWidth, tileHeight)
+				err = readZRLERaw(cv, r, pf, tx, ty, tileWidth, tileHeight)
 				if err != nil {
 					return fmt.Errorf("renderZRLE: error while reading Raw tile: %v", err)
 				}
 			case subEnc == ZRLESingleColour: // 获取一个颜色，填充指定区域
-				co, err := readCPixel(cv, unZipper, &pf)
+				co, err := readCPixel(cv, r, pf)
 				if err != nil {
 					return fmt.Errorf("renderZRLE: error while reading CPixel for bgColor tile: %v", err)
 				}
-				myRect := canvas.MakeRect(int(rect.X)+tileOffsetX, int(rect.Y)+tileOffsetY, tileWidth, tileHeight)
+				myRect := canvas.MakeRect(tx, ty, tileWidth, tileHeight)
 				cv.FillRect(&myRect, co)
+			case subEnc == JRLEJPEG && tables != nil: // JPEG图片
+				segments, err := readJPEGSegments(r)
+				if err != nil {
+					return err
+				}
+				img, err := tables.decode(segments)
+				if err != nil {
+					return err
+				}
+				draw.Draw(cv, canvas.MakeRect(tx, ty, tileWidth, tileHeight), img, img.Bounds().Min, draw.Src)
 			case subEnc >= 2 && subEnc <= 16: // 调色版编码
-				err = that.handlePaletteTile(cv, unZipper, tileOffsetX, tileOffsetY, tileWidth, tileHeight, subEnc, &pf, rect)
+				palette, err = readRLEPalette(cv, r, pf, int(subEnc))
+				if err != nil {
+					return err
+				}
+				err = handlePaletteTile(cv, r, tx, ty, tileWidth, tileHeight, palette)
+				if err != nil {
+					return err
+				}
+			case subEnc == TRLEReusePalette: // 使用上一个块的调色板
+				if len(palette) == 0 {
+					return fmt.Errorf("renderZRLE: no palette to reuse")
+				}
+				err = handlePaletteTile(cv, r, tx, ty, tileWidth, tileHeight, palette)
+				if err != nil {
+					return err
+				}
+			case subEnc == 128: // 普通rle编码
+				err = handlePlainRLETile(cv, r, tx, ty, tileWidth, tileHeight, pf)
 				if err != nil {
 					return err
 				}
-			case subEnc == 128: //
-				err = that.handlePlainRLETile(cv, unZipper, tileOffsetX, tileOffsetY, tileWidth, tileHeight, &pf, rect)
+			case subEnc == TRLEReusePaletteRLE: // 使用上一个块调色板的rle编码
+				if len(palette) == 0 {
+					return fmt.Errorf("renderZRLE: no palette to reuse")
+				}
+				err = handlePaletteRLETile(cv, r, tx, ty, tileWidth, tileHeight, palette)
 				if err != nil {
 					return err
 				}
 			case subEnc >= 130:
-				err = that.handlePaletteRLETile(cv, unZipper, tileOffsetX, tileOffsetY, tileWidth, tileHeight, subEnc, &pf, rect)
+				palette, err = readRLEPalette(cv, r, pf, int(subEnc)-128)
+				if err != nil {
+					return err
+				}
+				err = handlePaletteRLETile(cv, r, tx, ty, tileWidth, tileHeight, palette)
 				if err != nil {
 					return err
 				}
@@ -150,7 +192,7 @@ func min(a, b int) int {
 	return b
 }
 
-func (that *ZRLEEncoding) readZRLERaw(cv *canvas.VncCanvas, reader io.Reader, pf *rfb.PixelFormat, tx, ty, tw, th int) error {
+func readZRLERaw(cv *canvas.VncCanvas, reader io.Reader, pf *rfb.PixelFormat, tx, ty, tw, th int) error {
 	for y := 0; y < th; y++ {
 		for x := 0; x < tw; x++ {
 			col, err := readCPixel(cv, reader, pf)
@@ -164,66 +206,64 @@ func (that *ZRLEEncoding) readZRLERaw(cv *canvas.VncCanvas, reader io.Reader, pf
 	return nil
 }
 
-// 获取像素格式
+// readCPixel 读取一个CPIXEL格式的像素，32位真彩色并且颜色分量都在低3字节或者高3字节时只传输这3个字节
 func readCPixel(cv *canvas.VncCanvas, c io.Reader, pf *rfb.PixelFormat) (*color.RGBA, error) {
-	if pf.TrueColor == 0 {
-		return nil, errors.New("support for non true color formats was not implemented")
-	}
-
-	isZRLEFormat := IsCPixelSpecific(pf)
-	var col *color.RGBA
-	if isZRLEFormat {
-		tBytes, err := ReadBytes(3, c)
+	if !IsCPixelSpecific(pf) {
+		col, err := cv.ReadColor(c, pf)
 		if err != nil {
-			return nil, err
-		}
-		if pf.BigEndian != 1 {
-			col = &color.RGBA{
-				B: tBytes[0],
-				G: tBytes[1],
-				R: tBytes[2],
-				A: uint8(1),
-			}
-		} else {
-			col = &color.RGBA{
-				R: tBytes[0],
-				G: tBytes[1],
-				B: tBytes[2],
-				A: uint8(1),
-			}
+			return nil, fmt.Errorf("readCPixel: Error while reading zrle: %v", err)
 		}
 		return col, nil
 	}
-
-	col, err := cv.ReadColor(c, pf)
+	tBytes, err := ReadBytes(3, c)
 	if err != nil {
-		return nil, fmt.Errorf("readCPixel: Error while reading zrle: %v", err)
+		return nil, err
 	}
-
-	return col, nil
+	// 3个字节按照像素的字节序排列
+	var pixel uint32
+	if pf.BigEndian != 0 {
+		pixel = uint32(tBytes[0])<<16 | uint32(tBytes[1])<<8 | uint32(tBytes[2])
+	} else {
+		pixel = uint32(tBytes[0]) | uint32(tBytes[1])<<8 | uint32(tBytes[2])<<16
+	}
+	if cPixelSignificant(pf)&0xff000000 != 0 {
+		pixel <<= 8
+	}
+	return cv.PixelColor(pixel, pf), nil
 }
 
+// IsCPixelSpecific CPIXEL是否只有3个字节
 func IsCPixelSpecific(pf *rfb.PixelFormat) bool {
-	significant := int(pf.RedMax<<pf.RedShift | pf.GreenMax<<pf.GreenShift | pf.BlueMax<<pf.BlueShift)
+	significant := cPixelSignificant(pf)
 
-	if pf.Depth <= 24 && 32 == pf.BPP && ((significant&0x00ff000000) == 0 || (significant&0x000000ff) == 0) {
+	if pf.TrueColor != 0 && pf.Depth <= 24 && 32 == pf.BPP && ((significant&0xff000000) == 0 || (significant&0x000000ff) == 0) {
 		return true
 	}
 	return false
 }
 
-// 调色板编码
-func (that *ZRLEEncoding) handlePaletteTile(cv *canvas.VncCanvas, unZipper io.Reader, tileOffsetX, tileOffsetY, tileWidth, tileHeight int, subEnc uint8, pf *rfb.PixelFormat, rect *rfb.Rectangle) error {
-	paletteSize := subEnc
+// cPixelSignificant 像素中颜色分量使用的位
+func cPixelSignificant(pf *rfb.PixelFormat) uint32 {
+	return uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+}
+
+// 读取调色板
+func readRLEPalette(cv *canvas.VncCanvas, r io.Reader, pf *rfb.PixelFormat, paletteSize int) ([]*color.RGBA, error) {
 	palette := make([]*color.RGBA, paletteSize)
 	var err error
-	// Read palette
-	for j := 0; j < int(paletteSize); j++ {
-		palette[j], err = readCPixel(cv, unZipper, pf)
+	for j := 0; j < paletteSize; j++ {
+		palette[j], err = readCPixel(cv, r, pf)
 		if err != nil {
-			return fmt.Errorf("renderZRLE: error while reading CPixel for palette tile: %v", err)
+			return nil, fmt.Errorf("renderZRLE: error while reading CPixel for palette: %v", err)
 		}
 	}
+	return palette, nil
+}
+
+// 调色板编码
+func handlePaletteTile(cv *canvas.VncCanvas, unZipper io.Reader, tx, ty, tileWidth, tileHeight int, palette []*color.RGBA) error {
+	paletteSize := len(palette)
+	var err error
 	// Calculate index size
 	var indexBits, mask uint32
 	if paletteSize == 2 {
@@ -259,15 +299,18 @@ func (that *ZRLEEncoding) handlePaletteTile(cv *canvas.VncCanvas, unZipper io.Re
 			buffer <<= indexBits
 			bitsAvailable -= indexBits
 
+			if int(index) >= paletteSize {
+				return fmt.Errorf("renderZRLE: palette index out of range: %d", index)
+			}
 			// Write pixel to image
-			cv.Set(tileOffsetX+int(rect.X)+x, tileOffsetY+int(rect.Y)+y, palette[index])
+			cv.Set(tx+x, ty+y, palette[index])
 		}
 	}
 	return err
 }
 
 // 普通rle编码
-func (that *ZRLEEncoding) handlePlainRLETile(cv *canvas.VncCanvas, unZipper io.Reader, tileOffsetX int, tileOffsetY int, tileWidth int, tileHeight int, pf *rfb.PixelFormat, rect *rfb.Rectangle) error {
+func handlePlainRLETile(cv *canvas.VncCanvas, unZipper io.Reader, tx, ty, tileWidth, tileHeight int, pf *rfb.PixelFormat) error {
 	var col *color.RGBA
 	var err error
 	runLen := 0
@@ -286,7 +329,7 @@ func (that *ZRLEEncoding) handlePlainRLETile(cv *canvas.VncCanvas, unZipper io.R
 				}
 			}
 			// Write pixel to image
-			cv.Set(tileOffsetX+int(rect.X)+x, tileOffsetY+int(rect.Y)+y, col)
+			cv.Set(tx+x, ty+y, col)
 			runLen--
 		}
 	}
@@ -313,19 +356,8 @@ func readRunLength(r io.Reader) (int, error) {
 }
 
 // 调色板rle编码
-func (that *ZRLEEncoding) handlePaletteRLETile(cv *canvas.VncCanvas, unZipper io.Reader, tileOffsetX, tileOffsetY, tileWidth, tileHeight int, subEnc uint8, pf *rfb.PixelFormat, rect *rfb.Rectangle) error {
-	// Palette RLE
-	paletteSize := subEnc - 128
-	palette := make([]*color.RGBA, paletteSize)
+func handlePaletteRLETile(cv *canvas.VncCanvas, unZipper io.Reader, tx, ty, tileWidth, tileHeight int, palette []*color.RGBA) error {
 	var err error
-
-	// Read RLE palette
-	for j := 0; j < int(paletteSize); j++ {
-		palette[j], err = readCPixel(cv, unZipper, pf)
-		if err != nil {
-			return fmt.Errorf("renderZRLE: error while reading color in palette RLE subencoding: %v", err)
-		}
-	}
 	var index uint8
 	runLen := 0
 	for y := 0; y < tileHeight; y++ {
@@ -348,8 +380,11 @@ func (that *ZRLEEncoding) handlePaletteRLETile(cv *canvas.VncCanvas, unZipper io
 
 				}
 			}
+			if int(index) >= len(palette) {
+				return fmt.Errorf("renderZRLE: palette index out of range: %d", index)
+			}
 			// Write pixel to image
-			cv.Set(tileOffsetX+int(rect.X)+x, tileOffsetY+int(rect.Y)+y, palette[index])
+			cv.Set(tx+x, ty+y, palette[index])
 			runLen--
 		}
 	}
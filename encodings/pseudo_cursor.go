@@ -57,11 +57,7 @@ func (that *CursorPseudoEncoding) draw(cv *canvas.VncCanvas, pf rfb.PixelFormat,
 		}
 	}
 	// 设置鼠标指针
-	cv.CursorOffset = &image.Point{X: int(rect.X), Y: int(rect.Y)}
-	cv.Cursor = cursorImg
-	cv.CursorBackup = image.NewRGBA(cursorImg.Bounds())
-	cv.CursorMask = cursorMask
-
+	cv.SetCursor(cursorImg, cursorMask, image.Point{X: int(rect.X), Y: int(rect.Y)})
 	return nil
 }
 
@@ -106,6 +102,11 @@ func (that *CursorPseudoEncoding) Read(session rfb.ISession, rect *rfb.Rectangle
 }
 
 func (that *CursorPseudoEncoding) Write(sess rfb.ISession, rect *rfb.Rectangle) error {
+	// 宽或者高为0表示隐藏鼠标指针
+	if sess.Type() == rfb.CanvasSessionType && (rect.Width == 0 || rect.Height == 0) {
+		sess.Conn().(*canvas.VncCanvas).SetCursor(nil, nil, image.Point{})
+		return nil
+	}
 	if that.buff == nil {
 		return nil
 	}
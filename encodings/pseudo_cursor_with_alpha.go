@@ -2,9 +2,16 @@ package encodings
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
+	"image"
+	"image/color"
 )
 
+// CursorWithAlphaPseudoEncoding 带透明度的鼠标指针，伪矩形的x和y表示鼠标的热点，宽和高表示鼠标的宽和高。
+// 数据由4字节的编码类型和使用该编码的像素数据组成，每个像素按照红、绿、蓝、透明度的顺序各占1个字节，颜色已经预乘了透明度。
 type CursorWithAlphaPseudoEncoding struct {
 	buff *bytes.Buffer
 }
@@ -54,8 +61,56 @@ func (that *CursorWithAlphaPseudoEncoding) Write(session rfb.ISession, rect *rfb
 	if that.buff == nil {
 		return nil
 	}
+	if session.Type() == rfb.CanvasSessionType {
+		defer that.buff.Reset()
+		return that.draw(session.Conn().(*canvas.VncCanvas), rect)
+	}
 	var err error
 	_, err = that.buff.WriteTo(session)
 	that.buff.Reset()
 	return err
 }
+
+// draw 设置画布的鼠标指针，只支持Raw编码的像素数据
+func (that *CursorWithAlphaPseudoEncoding) draw(cv *canvas.VncCanvas, rect *rfb.Rectangle) error {
+	encType, err := ReadUint32(that.buff)
+	if err != nil {
+		return err
+	}
+	if rect.Width == 0 || rect.Height == 0 {
+		cv.SetCursor(nil, nil, image.Point{})
+		return nil
+	}
+	if typ := rfb.EncodingType(int32(encType)); typ != rfb.EncRaw {
+		return fmt.Errorf("不支持绘制使用%s编码的鼠标指针", typ)
+	}
+	width, height := int(rect.Width), int(rect.Height)
+	data := that.buff.Next(width * height * 4)
+	if len(data) < width*height*4 {
+		return errors.New("CursorWithAlpha编码的像素数据不完整")
+	}
+	// 画布混合时使用非预乘透明度的颜色
+	cursorImg := image.NewNRGBA(canvas.MakeRect(0, 0, width, height))
+	cursorMask := make([][]bool, width)
+	for x := range cursorMask {
+		cursorMask[x] = make([]bool, height)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px := data[(y*width+x)*4:]
+			a := uint32(px[3])
+			if a == 0 {
+				continue
+			}
+			cursorImg.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(min(int(uint32(px[0])*255/a), 255)),
+				G: uint8(min(int(uint32(px[1])*255/a), 255)),
+				B: uint8(min(int(uint32(px[2])*255/a), 255)),
+				A: px[3],
+			})
+			cursorMask[x][y] = true
+		}
+	}
+	cv.SetCursor(cursorImg, cursorMask, image.Point{X: int(rect.X), Y: int(rect.Y)})
+	return nil
+}
@@ -0,0 +1,21 @@
+package encodings
+
+import (
+	"encoding/binary"
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// TestCursorWithAlphaDecode 预乘透明度的鼠标指针与背景混合，透明度为0的像素不绘制，混合的舍入允许1的误差
+func TestCursorWithAlphaDecode(t *testing.T) {
+	decodeCursorFixture(t, "cursor_with_alpha", 1)
+}
+
+// TestCursorWithAlphaDecodeError 只支持Raw编码的像素数据
+func TestCursorWithAlphaDecodeError(t *testing.T) {
+	data := binary.BigEndian.AppendUint32(rectHeader(0, 0, 1, 1, rfb.EncCursorWithAlphaPseudo), uint32(rfb.EncZRLE))
+	data = append(data, 0, 0, 0, 0)
+	if err := newTestSession(rfb.PixelFormat32bit, 4, 4).decode(data); err == nil {
+		t.Fatal("没有返回错误")
+	}
+}
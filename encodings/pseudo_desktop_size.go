@@ -1,6 +1,7 @@
 package encodings
 
 import (
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
 )
 
@@ -27,5 +28,15 @@ func (that *DesktopSizePseudoEncoding) Read(session rfb.ISession, rect *rfb.Rect
 }
 
 func (that *DesktopSizePseudoEncoding) Write(session rfb.ISession, rect *rfb.Rectangle) error {
+	if session.Type() == rfb.CanvasSessionType {
+		resizeCanvas(session, rect.Width, rect.Height)
+	}
 	return nil
 }
+
+// resizeCanvas 修改画布及会话中桌面的大小
+func resizeCanvas(session rfb.ISession, width, height uint16) {
+	session.Conn().(*canvas.VncCanvas).Resize(int(width), int(height))
+	session.SetWidth(width)
+	session.SetHeight(height)
+}
@@ -0,0 +1,25 @@
+package encodings
+
+import (
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// decodeResizeFixture 在32x24的画布上解码testdata/{name}.rfb，画布及会话中桌面的大小修改为png的大小
+func decodeResizeFixture(t *testing.T, name string) {
+	t.Helper()
+	data, want := loadFixture(t, name)
+	sess := newTestSession(rfb.PixelFormat32bit, 32, 24)
+	if err := sess.decode(data); err != nil {
+		t.Fatalf("解码%s失败:%v", name, err)
+	}
+	expectCanvas(t, name, sess.cv, want, 0)
+	if int(sess.opts.Width) != want.Bounds().Dx() || int(sess.opts.Height) != want.Bounds().Dy() {
+		t.Fatalf("会话中桌面的大小为%dx%d", sess.opts.Width, sess.opts.Height)
+	}
+}
+
+// TestDesktopSizeDecode 缩小画布后再放大，缩小时裁掉的区域为黑色，之后的矩形绘制在新增的区域
+func TestDesktopSizeDecode(t *testing.T) {
+	decodeResizeFixture(t, "desktop_size")
+}
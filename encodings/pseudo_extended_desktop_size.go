@@ -3,10 +3,12 @@ package encodings
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"github.com/vprix/vncproxy/rfb"
 )
 
-// ExtendedDesktopSizePseudo 扩展适应客户端桌面分辨率
+// ExtendedDesktopSizePseudo 扩展适应客户端桌面分辨率，伪矩形的x表示修改桌面大小的原因，y表示修改的结果，宽和高表示帧缓存新的宽和高。
+// 数据是屏幕的数量和每个屏幕的位置及大小，y不为0表示vnc客户端请求修改桌面大小失败，桌面的大小没有变化。
 type ExtendedDesktopSizePseudo struct {
 	buff *bytes.Buffer
 }
@@ -17,7 +19,7 @@ func (that *ExtendedDesktopSizePseudo) Supported(rfb.ISession) bool {
 
 func (that *ExtendedDesktopSizePseudo) Clone(data ...bool) rfb.IEncoding {
 	obj := &ExtendedDesktopSizePseudo{}
-	if len(data) > 0 && data[0] {
+	if len(data) > 0 && data[0] && that.buff != nil {
 		obj.buff = &bytes.Buffer{}
 		_, _ = obj.buff.Write(that.buff.Bytes())
 	}
@@ -29,6 +31,16 @@ func (that *ExtendedDesktopSizePseudo) Type() rfb.EncodingType {
 }
 
 func (that *ExtendedDesktopSizePseudo) Write(session rfb.ISession, rect *rfb.Rectangle) (err error) {
+	if that.buff == nil {
+		return errors.New("ByteBuffer is nil")
+	}
+	if session.Type() == rfb.CanvasSessionType {
+		that.buff.Reset()
+		if rect.Y == 0 {
+			resizeCanvas(session, rect.Width, rect.Height)
+		}
+		return nil
+	}
 	_, err = that.buff.WriteTo(session)
 	that.buff.Reset()
 	return err
@@ -53,6 +65,9 @@ func (that *ExtendedDesktopSizePseudo) Read(session rfb.ISession, rect *rfb.Rect
 		return err
 	}
 	err = binary.Write(that.buff, binary.BigEndian, pad)
+	if err != nil {
+		return err
+	}
 
 	b2, err := ReadBytes(int(screensNumber)*16, session)
 	if err != nil {
@@ -0,0 +1,9 @@
+package encodings
+
+import "testing"
+
+// TestExtendedDesktopSizeDecode vnc服务端修改桌面大小(两个屏幕)后放大画布，之后的矩形绘制在新增的区域，
+// vnc客户端请求修改桌面大小失败时画布的大小不变
+func TestExtendedDesktopSizeDecode(t *testing.T) {
+	decodeResizeFixture(t, "extended_desktop_size")
+}
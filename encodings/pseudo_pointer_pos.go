@@ -39,5 +39,9 @@ func (that *CursorPosPseudoEncoding) Read(session rfb.ISession, rect *rfb.Rectan
 }
 
 func (that *CursorPosPseudoEncoding) Write(session rfb.ISession, rect *rfb.Rectangle) error {
+	// 绘制到画布时记录鼠标指针的位置
+	if session.Type() == rfb.CanvasSessionType {
+		return that.Draw(session.Conn().(*canvas.VncCanvas), rect)
+	}
 	return nil
 }
@@ -0,0 +1,24 @@
+package encodings
+
+import (
+	"github.com/vprix/vncproxy/rfb"
+	"image"
+	"testing"
+)
+
+// TestPointerPosDecode 鼠标指针绘制在最后一个位置，之前位置的画面不受影响
+func TestPointerPosDecode(t *testing.T) {
+	decodeCursorFixture(t, "pointer_pos", 0)
+}
+
+// TestPointerPosLocation 鼠标指针的位置是伪矩形的x和y
+func TestPointerPosLocation(t *testing.T) {
+	data, _ := loadFixture(t, "pointer_pos")
+	sess := newTestSession(rfb.PixelFormat32bit, 64, 48)
+	if err := sess.decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if loc := sess.cv.CursorLocation; loc == nil || *loc != image.Pt(8, 30) {
+		t.Fatalf("鼠标指针的位置为%v", loc)
+	}
+}
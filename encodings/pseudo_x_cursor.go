@@ -2,10 +2,17 @@ package encodings
 
 import (
 	"encoding/binary"
+	"errors"
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/rfb"
+	"image"
+	"image/color"
 	"math"
 )
 
+// XCursorPseudoEncoding X风格的鼠标指针，伪矩形的x和y表示鼠标的热点，宽和高表示鼠标的宽和高。
+// 数据由主颜色、次颜色、位图和位掩码组成，位图中为1的像素使用主颜色，为0的像素使用次颜色，位掩码为1的像素才需要绘制。
+// 宽或者高为0时没有数据，表示隐藏鼠标指针。
 type XCursorPseudoEncoding struct {
 	PrimaryR, PrimaryG, PrimaryB       uint8  // 主颜色
 	SecondaryR, SecondaryG, SecondaryB uint8  // 次颜色
@@ -39,6 +46,9 @@ func (that *XCursorPseudoEncoding) Type() rfb.EncodingType {
 }
 
 func (that *XCursorPseudoEncoding) Read(session rfb.ISession, rect *rfb.Rectangle) error {
+	if rect.Width == 0 || rect.Height == 0 {
+		return nil
+	}
 	if err := binary.Read(session, binary.BigEndian, &that.PrimaryR); err != nil {
 		return err
 	}
@@ -74,6 +84,12 @@ func (that *XCursorPseudoEncoding) Read(session rfb.ISession, rect *rfb.Rectangl
 }
 
 func (that *XCursorPseudoEncoding) Write(session rfb.ISession, rect *rfb.Rectangle) error {
+	if session.Type() == rfb.CanvasSessionType {
+		return that.draw(session.Conn().(*canvas.VncCanvas), rect)
+	}
+	if rect.Width == 0 || rect.Height == 0 {
+		return nil
+	}
 	if err := binary.Write(session, binary.BigEndian, that.PrimaryR); err != nil {
 		return err
 	}
@@ -102,3 +118,40 @@ func (that *XCursorPseudoEncoding) Write(session rfb.ISession, rect *rfb.Rectang
 
 	return nil
 }
+
+// draw 设置画布的鼠标指针
+func (that *XCursorPseudoEncoding) draw(cv *canvas.VncCanvas, rect *rfb.Rectangle) error {
+	if rect.Width == 0 || rect.Height == 0 {
+		cv.SetCursor(nil, nil, image.Point{})
+		return nil
+	}
+	width, height := int(rect.Width), int(rect.Height)
+	scanLine := (width + 7) / 8
+	if len(that.Bitmap) < scanLine*height || len(that.Bitmask) < scanLine*height {
+		return errors.New("XCursor编码的位图数据不完整")
+	}
+	primary := color.RGBA{R: that.PrimaryR, G: that.PrimaryG, B: that.PrimaryB, A: 0xff}
+	secondary := color.RGBA{R: that.SecondaryR, G: that.SecondaryG, B: that.SecondaryB, A: 0xff}
+	cursorImg := image.NewRGBA(canvas.MakeRect(0, 0, width, height))
+	cursorMask := make([][]bool, width)
+	for x := range cursorMask {
+		cursorMask[x] = make([]bool, height)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*scanLine + x/8
+			bit := byte(1 << uint(7-x%8))
+			if that.Bitmask[i]&bit == 0 {
+				continue
+			}
+			if that.Bitmap[i]&bit != 0 {
+				cursorImg.SetRGBA(x, y, primary)
+			} else {
+				cursorImg.SetRGBA(x, y, secondary)
+			}
+			cursorMask[x][y] = true
+		}
+	}
+	cv.SetCursor(cursorImg, cursorMask, image.Point{X: int(rect.X), Y: int(rect.Y)})
+	return nil
+}
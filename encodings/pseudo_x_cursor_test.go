@@ -0,0 +1,30 @@
+package encodings
+
+import (
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// TestXCursorDecode 宽度不是8的倍数的鼠标指针，位掩码为0的像素不绘制，热点对准鼠标指针的位置
+func TestXCursorDecode(t *testing.T) {
+	decodeCursorFixture(t, "x_cursor", 0)
+}
+
+// TestXCursorHide 宽和高为0的伪矩形没有数据，隐藏鼠标指针
+func TestXCursorHide(t *testing.T) {
+	data, want := loadFixture(t, "x_cursor")
+	sess := newTestSession(rfb.PixelFormat32bit, 64, 48)
+	if err := sess.decode(append(data, rectHeader(0, 0, 0, 0, rfb.EncXCursorPseudo)...)); err != nil {
+		t.Fatal(err)
+	}
+	if sess.cv.Cursor != nil {
+		t.Fatal("鼠标指针没有隐藏")
+	}
+	sess.cv.DrawCursor = true
+	// 鼠标指针左上角第二个像素是主颜色，隐藏后是背景
+	r1, g1, b1, _ := sess.cv.PaintCursor().At(19, 9).RGBA()
+	r2, g2, b2, _ := want.At(19, 9).RGBA()
+	if r1 == r2 && g1 == g2 && b1 == b2 {
+		t.Fatal("隐藏的鼠标指针仍然被绘制")
+	}
+}
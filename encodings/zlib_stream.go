@@ -0,0 +1,62 @@
+package encodings
+
+import (
+	"bytes"
+	"compress/zlib"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+)
+
+// zlibStream 持续的zlib流，vnc服务端在整个连接上使用同一个zlib流压缩多个矩形的数据，
+// 每个矩形的数据以Z_SYNC_FLUSH结尾，所以按顺序追加到输入缓冲区后就能解压出该矩形的全部数据。
+type zlibStream struct {
+	input  *bytes.Buffer
+	reader io.ReadCloser
+}
+
+// zlibStreamKey 会话中zlib流的索引，Tight编码最多同时使用4个zlib流
+type zlibStreamKey struct {
+	typ rfb.EncodingType
+	id  int
+}
+
+// sessionZlibStream 获取会话中编码typ使用的第id个zlib流，不存在则创建
+func sessionZlibStream(sess rfb.ISession, typ rfb.EncodingType, id int) *zlibStream {
+	streams := sess.Swap().GetOrSetFuncLock(rfb.SwapKeyZlibStreams, func() interface{} {
+		return make(map[zlibStreamKey]*zlibStream)
+	}).(map[zlibStreamKey]*zlibStream)
+	key := zlibStreamKey{typ: typ, id: id}
+	stream, ok := streams[key]
+	if !ok {
+		stream = &zlibStream{}
+		streams[key] = stream
+	}
+	return stream
+}
+
+// Reader 把一个矩形压缩的数据追加到zlib流中，返回读取解压数据的reader
+func (that *zlibStream) Reader(data []byte) (io.Reader, error) {
+	if that.input == nil {
+		that.input = &bytes.Buffer{}
+	}
+	_, _ = that.input.Write(data)
+	if that.reader == nil {
+		// 第一个矩形的数据包含zlib流的头部
+		r, err := zlib.NewReader(that.input)
+		if err != nil {
+			that.Reset()
+			return nil, err
+		}
+		that.reader = r
+	}
+	return that.reader, nil
+}
+
+// Reset 丢弃zlib流的状态，之后的数据作为一个新的zlib流解压
+func (that *zlibStream) Reset() {
+	if that.reader != nil {
+		_ = that.reader.Close()
+	}
+	that.reader = nil
+	that.input = nil
+}
@@ -2,7 +2,10 @@ module github.com/vprix/vncproxy
 
 require (
 	github.com/gogf/gf/v2 v2.9.5
+	github.com/klauspost/compress v1.18.0
 	github.com/osgochina/dmicro v1.3.1
+	golang.org/x/crypto v0.44.0
+	golang.org/x/image v0.25.0
 	golang.org/x/net v0.47.0
 )
 
@@ -37,7 +40,6 @@ require (
 	go.opentelemetry.io/otel/metric v1.38.0 // indirect
 	go.opentelemetry.io/otel/sdk v1.38.0 // indirect
 	go.opentelemetry.io/otel/trace v1.38.0 // indirect
-	golang.org/x/crypto v0.44.0 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 	golang.org/x/text v0.31.0 // indirect
 	golang.org/x/time v0.14.0 // indirect
@@ -46,4 +48,3 @@ require (
 )
 
 go 1.24.7
-
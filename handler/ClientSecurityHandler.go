@@ -25,24 +25,39 @@ func (*ClientSecurityHandler) Handle(session rfb.ISession) error {
 	if err := binary.Read(session, binary.BigEndian, &numSecurityTypes); err != nil {
 		return err
 	}
+	// 安全认证套件数量为0，说明vnc服务端拒绝连接，读取拒绝的原因
+	if numSecurityTypes == 0 {
+		reason, err := readReason(session)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("vnc服务端拒绝连接:%s", reason)
+	}
 	// 读取vnc服务端支持的安全认证套件类型
 	secTypes := make([]rfb.SecurityType, numSecurityTypes)
 	if err := binary.Read(session, binary.BigEndian, &secTypes); err != nil {
 		return err
 	}
 
-	// 匹配vnc服务端与proxy客户端的安全认证套件
+	// 按proxy客户端配置的顺序，选择第一个vnc服务端也支持的安全认证套件
 	var secType rfb.ISecurityHandler
 	for _, st := range cfg.SecurityHandlers {
 		for _, sc := range secTypes {
 			if st.Type() == sc {
 				secType = st
+				break
 			}
 		}
+		if secType != nil {
+			break
+		}
+	}
+	if secType == nil {
+		return fmt.Errorf("vnc服务端支持的安全认证套件%v都不可用", secTypes)
 	}
 
 	// 发送proxy客户端选中的安全认证套件
-	if err := binary.Write(session, binary.BigEndian, cfg.SecurityHandlers[0].Type()); err != nil {
+	if err := binary.Write(session, binary.BigEndian, secType.Type()); err != nil {
 		return err
 	}
 
@@ -66,16 +81,25 @@ func (*ClientSecurityHandler) Handle(session rfb.ISession) error {
 	}
 	//如果认证失败，则读取失败原因
 	if authCode == 1 {
-		var reasonLength uint32
-		if err = binary.Read(session, binary.BigEndian, &reasonLength); err != nil {
+		reason, err := readReason(session)
+		if err != nil {
 			return err
 		}
-		reasonText := make([]byte, reasonLength)
-		if err = binary.Read(session, binary.BigEndian, &reasonText); err != nil {
-			return err
-		}
-		return fmt.Errorf("%s", reasonText)
+		return fmt.Errorf("%s", reason)
 	}
 	session.SetSecurityHandler(secType)
 	return nil
 }
+
+// readReason 读取vnc服务端发送的失败原因
+func readReason(session rfb.ISession) ([]byte, error) {
+	var reasonLength uint32
+	if err := binary.Read(session, binary.BigEndian, &reasonLength); err != nil {
+		return nil, err
+	}
+	reasonText := make([]byte, reasonLength)
+	if err := binary.Read(session, binary.BigEndian, &reasonText); err != nil {
+		return nil, err
+	}
+	return reasonText, nil
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/osgochina/dmicro/logger"
 	"github.com/vprix/vncproxy/messages"
 	"github.com/vprix/vncproxy/rfb"
+	"io"
 )
 
 // ClientServerInitHandler vnc握手第四步
@@ -36,6 +37,17 @@ func (*ClientServerInitHandler) Handle(session rfb.ISession) error {
 	if err = binary.Read(session, binary.BigEndian, &srvInit.NameText); err != nil {
 		return err
 	}
+	// 使用tight认证的vnc服务端会在ServerInit之后发送交互能力列表，proxy不使用这些扩展，直接跳过
+	if isTight(session) {
+		caps := tightInteractionCaps{}
+		if err = binary.Read(session, binary.BigEndian, &caps); err != nil {
+			return err
+		}
+		num := int64(caps.ServerMessageTypes) + int64(caps.ClientMessageTypes) + int64(caps.EncodingTypes)
+		if _, err = io.CopyN(io.Discard, session, num*tightCapabilityLen); err != nil {
+			return err
+		}
+	}
 	if logger.IsDebug() {
 		logger.Debugf(context.TODO(), "[Proxy客户端->VNC服务端]:  serverInit: %s", srvInit)
 	}
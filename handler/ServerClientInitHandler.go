@@ -16,10 +16,12 @@ func (*ServerClientInitHandler) Handle(session rfb.ISession) error {
 	if logger.IsDebug() {
 		logger.Debugf(context.TODO(), "[VNC客户端->Proxy服务端]: 执行vnc握手第三步:[ClientInit]")
 	}
-	// 读取分享屏幕标识符，proxy会无视该标识，因为通过proxy链接的vnc服务端都是默认支持分享的。
+	// 读取分享屏幕标识符，存入会话的交换区，多个vnc客户端共享同一个vnc服务端连接时，
+	// 以独占模式连接的vnc客户端会断开其他vnc客户端。
 	var shared uint8
 	if err := binary.Read(session, binary.BigEndian, &shared); err != nil {
 		return err
 	}
+	session.Swap().Set(rfb.SwapKeyShared, shared != 0)
 	return nil
 }
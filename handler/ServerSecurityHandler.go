@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/osgochina/dmicro/logger"
 	"github.com/vprix/vncproxy/rfb"
+	"time"
 )
 
 // ServerSecurityHandler vnc握手步骤第二步
@@ -19,6 +21,12 @@ func (*ServerSecurityHandler) Handle(session rfb.ISession) error {
 		logger.Debugf(context.TODO(), "[VNC客户端->Proxy服务端]: 执行vnc握手第二步:[Security]")
 	}
 	cfg := session.Options()
+	// 该地址认证失败次数过多被锁定，直接拒绝连接
+	remoteIP := rfb.RemoteIP(session)
+	if cfg.AuthLimiter != nil && !cfg.AuthLimiter.Allow(remoteIP, "") {
+		_ = writeConnFailed(session, rfb.ErrTooManyAttempts)
+		return fmt.Errorf("vnc客户端 %s 认证失败次数过多，拒绝连接", remoteIP)
+	}
 	var secType rfb.SecurityType
 	if session.ProtocolVersion() == rfb.ProtoVersion37 || session.ProtocolVersion() == rfb.ProtoVersion38 {
 		if err := binary.Write(session, binary.BigEndian, uint8(len(cfg.SecurityHandlers))); err != nil {
@@ -63,6 +71,19 @@ func (*ServerSecurityHandler) Handle(session rfb.ISession) error {
 
 	var authCode uint32
 	authErr := sType.Auth(session)
+	if cfg.AuthLimiter != nil {
+		username := gconv.String(session.Swap().Get(rfb.SwapKeyUsername))
+		switch {
+		case !cfg.AuthLimiter.Allow(remoteIP, username):
+			// 地址或者用户名被锁定期间，即使密码正确也认证失败
+			authErr = rfb.ErrTooManyAttempts
+		case authErr != nil:
+			// 认证失败后延迟发送认证结果，降低暴力破解的速度
+			time.Sleep(cfg.AuthLimiter.Fail(remoteIP, username))
+		default:
+			cfg.AuthLimiter.Reset(remoteIP, username)
+		}
+	}
 	if authErr != nil {
 		authCode = uint32(1)
 	}
@@ -92,3 +113,24 @@ func (*ServerSecurityHandler) Handle(session rfb.ISession) error {
 	}
 	return authErr
 }
+
+// writeConnFailed 在发送安全认证类型的阶段拒绝连接，并发送失败原因
+// 3.3版本发送安全认证类型0，3.7及以上版本发送安全认证类型数量0
+func writeConnFailed(session rfb.ISession, reason error) error {
+	var err error
+	if session.ProtocolVersion() == rfb.ProtoVersion37 || session.ProtocolVersion() == rfb.ProtoVersion38 {
+		err = binary.Write(session, binary.BigEndian, uint8(0))
+	} else {
+		err = binary.Write(session, binary.BigEndian, uint32(0))
+	}
+	if err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, uint32(len(reason.Error()))); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, []byte(reason.Error())); err != nil {
+		return err
+	}
+	return session.Flush()
+}
@@ -36,6 +36,12 @@ func (*ServerServerInitHandler) Handle(session rfb.ISession) error {
 	if err := binary.Write(session, binary.BigEndian, desktopName); err != nil {
 		return err
 	}
+	// 使用tight认证的vnc客户端需要读取交互能力列表，proxy不额外声明能力，发送空的列表
+	if isTight(session) {
+		if err := binary.Write(session, binary.BigEndian, tightInteractionCaps{}); err != nil {
+			return err
+		}
+	}
 	if logger.IsDebug() {
 		logger.Debugf(context.TODO(), "[Proxy服务端->VNC客户端]: ServerInit[Width:%d,Height:%d,PixelFormat:%s,DesktopName:%s]",
 			session.Options().Width, session.Options().Height, session.Options().PixelFormat, desktopName)
@@ -0,0 +1,20 @@
+package handler
+
+import "github.com/vprix/vncproxy/rfb"
+
+// tightCapabilityLen tight协议中每个能力描述的长度，4字节编码，4字节厂商标识，8字节名称
+const tightCapabilityLen = 16
+
+// tightInteractionCaps 使用tight认证时ServerInit之后的交互能力列表头，后面跟着各个能力描述
+type tightInteractionCaps struct {
+	ServerMessageTypes uint16 // vnc服务端支持的扩展消息数量
+	ClientMessageTypes uint16 // vnc客户端可以发送的扩展消息数量
+	EncodingTypes      uint16 // 支持的编码数量
+	_                  uint16 // 填充
+}
+
+// isTight 判断会话是否使用了tight认证
+func isTight(session rfb.ISession) bool {
+	h := session.SecurityHandler()
+	return h != nil && h.Type() == rfb.SecTypeTight
+}
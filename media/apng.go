@@ -0,0 +1,235 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+// APNG在PNG的基础上增加了acTL(动画控制)、fcTL(帧控制)及fdAT(帧数据)数据块:
+//
+//	PNG签名 IHDR acTL fcTL IDAT... fcTL fdAT... ... IEND
+//
+// 第一帧使用IDAT，不支持APNG的播放器只显示第一帧。之后每一帧只编码与上一帧不同的区域，
+// 每一帧都是不透明的，使用image/png编码后取出IDAT的数据，所以所有的帧都是8位RGB格式。
+const (
+	pngSignature  = "\x89PNG\r\n\x1a\n"
+	apngActlStart = int64(len(pngSignature) + 8 + 13 + 4) // acTL在文件中的偏移，在IHDR之后
+	apngMaxDelay  = 0xffff                                // 延时的分子及分母的最大值
+)
+
+// APNGWriter 写入APNG动画，循环播放。
+// 写入一帧时需要知道这一帧持续的时间，所以最近的一帧在下一个不同的画面或者Close时才写入，
+// Close时回到文件开头更新帧数，所以需要io.WriteSeeker。
+type APNGWriter struct {
+	w       io.WriteSeeker
+	width   int
+	height  int
+	fps     int
+	encoder *png.Encoder
+	prev    *image.RGBA     // 上一帧的画面
+	pending [][]byte        // 还没有写入的帧的IDAT数据
+	rect    image.Rectangle // pending的区域
+	count   int             // pending重复的次数
+	frames  int             // 已经写入的fcTL数量
+	seq     uint32          // fcTL及fdAT的序号
+	closed  bool
+}
+
+var _ FrameWriter = new(APNGWriter)
+
+// NewAPNGWriter 创建APNG动画，立即写入文件头
+func NewAPNGWriter(w io.WriteSeeker, width, height, fps int) (*APNGWriter, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("APNG动画大小不正确:%dx%d", width, height)
+	}
+	if fps <= 0 || fps > apngMaxDelay {
+		return nil, fmt.Errorf("APNG动画的帧率不正确:%d", fps)
+	}
+	offset, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if offset != 0 {
+		return nil, fmt.Errorf("APNG动画需要从文件开头写入")
+	}
+	that := &APNGWriter{
+		w:       w,
+		width:   width,
+		height:  height,
+		fps:     fps,
+		encoder: &png.Encoder{CompressionLevel: png.BestSpeed},
+	}
+	// 8位RGB，与image/png编码不透明的image.RGBA使用的格式一致
+	ihdr := be(uint32(width), uint32(height), uint8(8), uint8(2), uint8(0), uint8(0), uint8(0))
+	buf := append([]byte(pngSignature), pngChunk("IHDR", ihdr)...)
+	buf = append(buf, that.actl()...)
+	if _, err = w.Write(buf); err != nil {
+		return nil, err
+	}
+	return that, nil
+}
+
+func (that *APNGWriter) WriteFrame(img *image.RGBA) error {
+	if that.closed {
+		return fmt.Errorf("APNG动画已经关闭")
+	}
+	if img.Bounds() != image.Rect(0, 0, that.width, that.height) {
+		return fmt.Errorf("画面大小%v与APNG动画不一致", img.Bounds())
+	}
+	rect := changedRect(that.prev, img)
+	if that.prev != nil && rect.Empty() {
+		return that.RepeatFrame()
+	}
+	if err := that.flush(); err != nil {
+		return err
+	}
+	// 编码时所有像素都需要是不透明的
+	sub := img.SubImage(rect).(*image.RGBA)
+	if !sub.Opaque() {
+		sub = copyRGBA(nil, sub)
+		for i := 3; i < len(sub.Pix); i += 4 {
+			sub.Pix[i] = 0xff
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := that.encoder.Encode(buf, sub); err != nil {
+		return err
+	}
+	idat, err := pngIDAT(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	that.pending, that.rect, that.count = idat, rect, 1
+	that.prev = copyRGBA(that.prev, img)
+	return nil
+}
+
+func (that *APNGWriter) RepeatFrame() error {
+	if that.pending == nil {
+		return fmt.Errorf("还没有写入动画帧")
+	}
+	that.count++
+	return nil
+}
+
+// Close 写入最后一帧及文件结尾，更新acTL中的帧数，不会关闭底层的io.WriteSeeker
+func (that *APNGWriter) Close() error {
+	if that.closed {
+		return nil
+	}
+	that.closed = true
+	if err := that.flush(); err != nil {
+		return err
+	}
+	if _, err := that.w.Write(pngChunk("IEND", nil)); err != nil {
+		return err
+	}
+	offset, err := that.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = that.w.Seek(apngActlStart, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = that.w.Write(that.actl()); err != nil {
+		return err
+	}
+	_, err = that.w.Seek(offset, io.SeekStart)
+	return err
+}
+
+// flush 写入还没有写入的帧，延时为重复的次数除以帧率
+func (that *APNGWriter) flush() error {
+	if that.pending == nil {
+		return nil
+	}
+	num, den := that.count, that.fps
+	for num > apngMaxDelay && den > 1 {
+		num, den = (num+1)/2, den/2
+	}
+	num = min(num, apngMaxDelay)
+	// 不处理上一帧，直接覆盖区域中的像素
+	fctl := be(that.seq, uint32(that.rect.Dx()), uint32(that.rect.Dy()), uint32(that.rect.Min.X), uint32(that.rect.Min.Y),
+		uint16(num), uint16(den), uint8(0), uint8(0))
+	that.seq++
+	buf := pngChunk("fcTL", fctl)
+	for _, data := range that.pending {
+		if that.frames == 0 {
+			buf = append(buf, pngChunk("IDAT", data)...)
+			continue
+		}
+		buf = append(buf, pngChunk("fdAT", append(be(that.seq), data...))...)
+		that.seq++
+	}
+	that.pending = nil
+	that.frames++
+	_, err := that.w.Write(buf)
+	return err
+}
+
+// actl 动画控制数据块，帧数为已经写入的帧数，循环播放
+func (that *APNGWriter) actl() []byte {
+	return pngChunk("acTL", be(uint32(that.frames), uint32(0)))
+}
+
+// pngChunk 生成PNG数据块: 长度 + 类型 + 数据 + CRC
+func pngChunk(typ string, data []byte) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	buf = append(buf, typ...)
+	buf = append(buf, data...)
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+}
+
+// pngIDAT 取出PNG文件中所有IDAT数据块的数据，同时检查像素格式
+func pngIDAT(data []byte) ([][]byte, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, fmt.Errorf("不是PNG格式的数据")
+	}
+	data = data[len(pngSignature):]
+	var idat [][]byte
+	for len(data) >= 12 {
+		length := int(binary.BigEndian.Uint32(data))
+		if len(data) < 12+length {
+			return nil, fmt.Errorf("PNG数据块不完整")
+		}
+		typ, body := string(data[4:8]), data[8:8+length]
+		switch typ {
+		case "IHDR":
+			if length != 13 || body[8] != 8 || body[9] != 2 {
+				return nil, fmt.Errorf("不支持的PNG像素格式")
+			}
+		case "IDAT":
+			idat = append(idat, body)
+		}
+		data = data[12+length:]
+	}
+	if len(idat) == 0 {
+		return nil, fmt.Errorf("PNG数据中没有IDAT数据块")
+	}
+	return idat, nil
+}
+
+// be 按大端序依次写入fields
+func be(fields ...interface{}) []byte {
+	var buf []byte
+	for _, f := range fields {
+		switch v := f.(type) {
+		case []byte:
+			buf = append(buf, v...)
+		case uint8:
+			buf = append(buf, v)
+		case uint16:
+			buf = binary.BigEndian.AppendUint16(buf, v)
+		case uint32:
+			buf = binary.BigEndian.AppendUint32(buf, v)
+		default:
+			panic(fmt.Sprintf("不支持的字段类型:%T", f))
+		}
+	}
+	return buf
+}
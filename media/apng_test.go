@@ -0,0 +1,236 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// apngFrame APNG动画中的一帧
+type apngFrame struct {
+	rect     image.Rectangle
+	delayNum int
+	delayDen int
+	img      image.Image
+}
+
+// readAPNG 解析APNG动画，检查数据块的CRC及序号，使用image/png解码每一帧，返回acTL中的帧数及所有的帧
+func readAPNG(t *testing.T, data []byte) (int, []apngFrame) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		t.Fatal("不是PNG格式的数据")
+	}
+	var ihdr []byte
+	var frames []apngFrame
+	var pending [][]byte // 当前帧的图像数据
+	numFrames, seq := -1, uint32(0)
+	// decodeFrame 使用当前帧的区域大小及图像数据生成PNG文件解码
+	decodeFrame := func() {
+		if len(frames) == 0 || len(pending) == 0 {
+			return
+		}
+		f := &frames[len(frames)-1]
+		hdr := append(be(uint32(f.rect.Dx()), uint32(f.rect.Dy())), ihdr[8:]...)
+		buf := append([]byte(pngSignature), pngChunk("IHDR", hdr)...)
+		for _, p := range pending {
+			buf = append(buf, pngChunk("IDAT", p)...)
+		}
+		buf = append(buf, pngChunk("IEND", nil)...)
+		img, err := png.Decode(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("第%d帧无法解码:%v", len(frames)-1, err)
+		}
+		f.img, pending = img, nil
+	}
+	for pos := len(pngSignature); ; {
+		if pos+12 > len(data) {
+			t.Fatal("没有IEND数据块")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		typ, body := string(data[pos+4:pos+8]), data[pos+8:pos+8+length]
+		if crc := binary.BigEndian.Uint32(data[pos+8+length:]); crc != crc32.ChecksumIEEE(data[pos+4:pos+8+length]) {
+			t.Fatalf("数据块%s的CRC不正确", typ)
+		}
+		pos += 12 + length
+		// fcTL及fdAT的序号依次递增
+		if typ == "fcTL" || typ == "fdAT" {
+			if got := binary.BigEndian.Uint32(body); got != seq {
+				t.Fatalf("数据块%s的序号为%d，应该为%d", typ, got, seq)
+			}
+			seq++
+		}
+		switch typ {
+		case "IHDR":
+			ihdr = body
+		case "acTL":
+			if len(frames) > 0 || len(pending) > 0 {
+				t.Fatal("acTL不在图像数据之前")
+			}
+			if plays := binary.BigEndian.Uint32(body[4:]); plays != 0 {
+				t.Fatalf("播放次数为%d", plays)
+			}
+			numFrames = int(binary.BigEndian.Uint32(body))
+		case "fcTL":
+			decodeFrame()
+			be32 := func(offset int) int { return int(binary.BigEndian.Uint32(body[offset:])) }
+			if body[24] != 0 || body[25] != 0 {
+				t.Fatalf("帧的处理方式为%d %d", body[24], body[25])
+			}
+			frames = append(frames, apngFrame{
+				rect:     image.Rect(be32(12), be32(16), be32(12)+be32(4), be32(16)+be32(8)),
+				delayNum: int(binary.BigEndian.Uint16(body[20:])),
+				delayDen: int(binary.BigEndian.Uint16(body[22:])),
+			})
+		case "IDAT":
+			if len(frames) != 1 {
+				t.Fatal("IDAT不属于第一帧")
+			}
+			pending = append(pending, body)
+		case "fdAT":
+			if len(frames) < 2 {
+				t.Fatal("fdAT属于第一帧")
+			}
+			pending = append(pending, body[4:])
+		case "IEND":
+			decodeFrame()
+			if pos != len(data) {
+				t.Fatal("IEND之后有多余的数据")
+			}
+			return numFrames, frames
+		}
+	}
+}
+
+// writeAPNG 使用 APNGWriter 写入文件，write中写入动画帧，返回文件的内容
+func writeAPNG(t *testing.T, fps int, write func(FrameWriter)) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	w, err := APNGOutput(f)(8, 4, fps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(w)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestAPNGWriter 相同的画面合并成一帧，延时为重复的次数除以帧率，之后的帧只包含变化的区域，
+// 不支持APNG的解码器显示第一帧
+func TestAPNGWriter(t *testing.T) {
+	red := testFrame(testRed, testRed, image.Rectangle{})
+	changed := image.Rect(2, 1, 5, 3)
+	green := testFrame(testRed, testGreen, changed)
+	blue := testFrame(testRed, testGreen, changed)
+	blue.SetRGBA(7, 3, testBlue)
+	// 半透明的像素编码成不透明的
+	blue.Pix[blue.PixOffset(0, 0)+3] = 0x80
+	last := testFrame(testRed, testBlue, image.Rect(0, 0, 4, 4))
+
+	data := writeAPNG(t, 10, func(w FrameWriter) {
+		if err := w.RepeatFrame(); err == nil {
+			t.Fatal("没有写入动画帧时重复上一帧没有返回错误")
+		}
+		for _, img := range []*image.RGBA{red, red, green, blue} {
+			if err := w.WriteFrame(img); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if err := w.RepeatFrame(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.WriteFrame(last); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteFrame(image.NewRGBA(image.Rect(0, 0, 4, 4))); err == nil {
+			t.Fatal("画面大小不一致没有返回错误")
+		}
+	})
+
+	numFrames, frames := readAPNG(t, data)
+	want := []struct {
+		rect     image.Rectangle
+		num, den int
+		img      *image.RGBA
+	}{
+		{red.Rect, 2, 10, red},
+		{changed, 1, 10, green},
+		{image.Rect(0, 0, 8, 4), 3, 10, blue},
+		{image.Rect(0, 0, 8, 4), 1, 10, last},
+	}
+	if numFrames != len(want) || len(frames) != len(want) {
+		t.Fatalf("acTL中的帧数为%d，动画有%d帧，应该为%d帧", numFrames, len(frames), len(want))
+	}
+	canvas := image.NewRGBA(red.Rect)
+	for i, w := range want {
+		f := frames[i]
+		if f.rect != w.rect || f.delayNum != w.num || f.delayDen != w.den {
+			t.Fatalf("第%d帧的区域为%v，延时为%d/%d", i, f.rect, f.delayNum, f.delayDen)
+		}
+		draw.Draw(canvas, f.rect, f.img, image.Point{}, draw.Src)
+		expectImage(t, "动画帧", canvas, w.img)
+	}
+
+	// 不支持APNG的解码器只显示第一帧
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectImage(t, "默认图像", img, red)
+}
+
+// TestAPNGWriterLongDelay 延时超过最大值时同时减小分子及分母
+func TestAPNGWriterLongDelay(t *testing.T) {
+	data := writeAPNG(t, 2, func(w FrameWriter) {
+		if err := w.WriteFrame(testFrame(testRed, testRed, image.Rectangle{})); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 69999; i++ {
+			if err := w.RepeatFrame(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	numFrames, frames := readAPNG(t, data)
+	if numFrames != 1 || len(frames) != 1 || frames[0].delayNum != 35000 || frames[0].delayDen != 1 {
+		t.Fatalf("acTL中的帧数为%d，动画帧为%v", numFrames, frames)
+	}
+}
+
+func TestNewAPNGWriter(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err = NewAPNGWriter(f, 0, 4, 10); err == nil {
+		t.Fatal("动画大小不正确没有返回错误")
+	}
+	if _, err = NewAPNGWriter(f, 8, 4, 0); err == nil {
+		t.Fatal("帧率不正确没有返回错误")
+	}
+	if _, err = f.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewAPNGWriter(f, 8, 4, 10); err == nil {
+		t.Fatal("不是从文件开头写入没有返回错误")
+	}
+}
@@ -0,0 +1,339 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// AVI文件的结构(OpenDML扩展):
+//
+//	RIFF('AVI ' LIST('hdrl' avih LIST('strl' strh strf indx) LIST('odml' dmlh)) LIST('movi' 00dc... ix00) idx1)
+//	RIFF('AVIX' LIST('movi' 00dc... ix00))
+//	...
+//
+// 每个RIFF不超过1GB，第一个RIFF带有旧格式的idx1索引，兼容不支持OpenDML的播放器，
+// 每个RIFF的movi中带有ix00标准索引，hdrl中的indx超级索引指向所有的ix00。
+const (
+	riffLimit        = 1 << 30 // 单个RIFF的最大长度
+	superIndexLen    = 256     // 超级索引预留的条目数量，限制了文件的最大长度
+	avifHasIndex     = 0x10    // avih的标识: 带有idx1索引
+	aviifKeyframe    = 0x10    // idx1的标识: 关键帧
+	aviIndexOfIndex  = 0x00    // 超级索引
+	aviIndexOfChunks = 0x01    // 标准索引
+	frameChunkID     = "00dc"  // 第一个流的压缩视频帧
+	stdIndexID       = "ix00"  // 第一个流的标准索引
+)
+
+// riff 一个RIFF块
+type riff struct {
+	start     int64 // RIFF块在文件中的偏移
+	moviStart int64 // movi列表类型('movi')在文件中的偏移，索引中的偏移相对于该位置
+	size      uint32
+	moviSize  uint32
+	frames    []indexEntry
+}
+
+// indexEntry 视频帧数据块的位置
+type indexEntry struct {
+	offset int64 // 数据块头在文件中的偏移
+	size   uint32
+}
+
+// superIndexEntry 超级索引的条目，指向一个标准索引
+type superIndexEntry struct {
+	offset   int64
+	size     uint32
+	duration uint32
+}
+
+// AVIWriter 写入MJPEG编码的AVI视频文件，每一帧是一张完整的JPEG图片，不需要依赖ffmpeg。
+// 写入过程中只追加数据，Close时回到文件开头更新文件头，所以需要io.WriteSeeker。
+type AVIWriter struct {
+	w            io.WriteSeeker
+	width        int
+	height       int
+	fps          int
+	offset       int64 // 当前写入的偏移
+	riffs        []*riff
+	superIndex   []superIndexEntry
+	totalFrames  int
+	maxFrameSize uint32
+	lastFrame    []byte // 上一帧的数据，重复写入时使用
+	closed       bool
+}
+
+// NewAVIWriter 创建MJPEG编码的AVI视频，立即写入文件头，fps为每秒的帧数
+func NewAVIWriter(w io.WriteSeeker, width, height, fps int) (*AVIWriter, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("视频大小不正确:%dx%d", width, height)
+	}
+	if fps <= 0 {
+		return nil, fmt.Errorf("视频帧率不正确:%d", fps)
+	}
+	offset, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if offset != 0 {
+		return nil, fmt.Errorf("AVI视频需要从文件开头写入")
+	}
+	that := &AVIWriter{w: w, width: width, height: height, fps: fps}
+	if err = that.write(that.header()); err != nil {
+		return nil, err
+	}
+	// 文件头以movi列表的类型结尾
+	that.riffs = append(that.riffs, &riff{start: 0, moviStart: that.offset - 4})
+	return that, nil
+}
+
+// Frames 已经写入的帧数
+func (that *AVIWriter) Frames() int {
+	return that.totalFrames
+}
+
+// WriteFrame 写入一帧JPEG图片，写入后不能再修改jpeg，重复写入上一帧时会使用
+func (that *AVIWriter) WriteFrame(jpeg []byte) error {
+	if that.closed {
+		return fmt.Errorf("AVI视频已经关闭")
+	}
+	if len(jpeg) == 0 {
+		return fmt.Errorf("视频帧不能为空")
+	}
+	cur := that.riffs[len(that.riffs)-1]
+	// 数据块及之后需要写入的索引超过了RIFF的最大长度，开始新的RIFF
+	need := that.offset - cur.start + int64(8+len(jpeg)+1) + int64(32+8*(len(cur.frames)+1))
+	if len(that.riffs) == 1 {
+		need += int64(8 + 16*(len(cur.frames)+1))
+	}
+	if len(cur.frames) > 0 && need > riffLimit {
+		if err := that.closeRiff(cur); err != nil {
+			return err
+		}
+		if err := that.startRiff(); err != nil {
+			return err
+		}
+		cur = that.riffs[len(that.riffs)-1]
+	}
+	entry := indexEntry{offset: that.offset, size: uint32(len(jpeg))}
+	if err := that.writeChunk(frameChunkID, jpeg); err != nil {
+		return err
+	}
+	cur.frames = append(cur.frames, entry)
+	that.totalFrames++
+	if entry.size > that.maxFrameSize {
+		that.maxFrameSize = entry.size
+	}
+	that.lastFrame = jpeg
+	return nil
+}
+
+// RepeatFrame 重复写入上一帧，画面没有变化时不需要重新编码
+func (that *AVIWriter) RepeatFrame() error {
+	if that.lastFrame == nil {
+		return fmt.Errorf("还没有写入视频帧")
+	}
+	return that.WriteFrame(that.lastFrame)
+}
+
+// Close 写入索引并更新文件头，不会关闭底层的io.WriteSeeker
+func (that *AVIWriter) Close() error {
+	if that.closed {
+		return nil
+	}
+	that.closed = true
+	if err := that.closeRiff(that.riffs[len(that.riffs)-1]); err != nil {
+		return err
+	}
+	if _, err := that.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := that.w.Write(that.header()); err != nil {
+		return err
+	}
+	_, err := that.w.Seek(that.offset, io.SeekStart)
+	return err
+}
+
+// startRiff 开始一个AVIX类型的RIFF块
+func (that *AVIWriter) startRiff() error {
+	if len(that.riffs) >= superIndexLen {
+		return fmt.Errorf("AVI视频太大，最多%d个RIFF块", superIndexLen)
+	}
+	r := &riff{start: that.offset}
+	if err := that.write(le("RIFF", uint32(0), "AVIX", "LIST", uint32(0), "movi")); err != nil {
+		return err
+	}
+	r.moviStart = that.offset - 4
+	that.riffs = append(that.riffs, r)
+	return nil
+}
+
+// closeRiff 在movi列表的末尾写入标准索引，第一个RIFF还需要写入idx1索引，然后更新RIFF及movi的长度
+func (that *AVIWriter) closeRiff(r *riff) error {
+	ixOffset := that.offset
+	entries := make([]interface{}, 0, 2*len(r.frames))
+	for _, f := range r.frames {
+		// 标准索引中的偏移指向数据块的数据
+		entries = append(entries, uint32(f.offset+8-r.moviStart), f.size)
+	}
+	ix := le(uint16(2), uint8(0), uint8(aviIndexOfChunks), uint32(len(r.frames)), frameChunkID, uint64(r.moviStart), uint32(0))
+	ix = append(ix, le(entries...)...)
+	if err := that.writeChunk(stdIndexID, ix); err != nil {
+		return err
+	}
+	that.superIndex = append(that.superIndex, superIndexEntry{
+		offset:   ixOffset,
+		size:     uint32(that.offset - ixOffset),
+		duration: uint32(len(r.frames)),
+	})
+	r.moviSize = uint32(that.offset - r.moviStart)
+	if r == that.riffs[0] {
+		idx1 := make([]interface{}, 0, 4*len(r.frames))
+		for _, f := range r.frames {
+			// idx1中的偏移指向数据块头，相对于movi列表的类型
+			idx1 = append(idx1, frameChunkID, uint32(aviifKeyframe), uint32(f.offset-r.moviStart), f.size)
+		}
+		if err := that.writeChunk("idx1", le(idx1...)); err != nil {
+			return err
+		}
+	}
+	r.size = uint32(that.offset - r.start - 8)
+	if err := that.patch(r.start+4, r.size); err != nil {
+		return err
+	}
+	return that.patch(r.moviStart-4, r.moviSize)
+}
+
+// header 生成第一个RIFF的文件头，到movi列表的类型为止，长度固定
+func (that *AVIWriter) header() []byte {
+	usPerFrame := uint32(1000000 / that.fps)
+	var firstFrames, riffSize, moviSize uint32
+	if len(that.riffs) > 0 {
+		firstFrames = uint32(len(that.riffs[0].frames))
+		riffSize, moviSize = that.riffs[0].size, that.riffs[0].moviSize
+	}
+	bufferSize := that.maxFrameSize + 8
+	avih := le(
+		usPerFrame,
+		bufferSize*uint32(that.fps), // dwMaxBytesPerSec
+		uint32(0),                   // dwPaddingGranularity
+		uint32(avifHasIndex),
+		firstFrames, // dwTotalFrames 只包括第一个RIFF中的帧数
+		uint32(0),   // dwInitialFrames
+		uint32(1),   // dwStreams
+		bufferSize,
+		uint32(that.width),
+		uint32(that.height),
+		make([]byte, 16),
+	)
+	strh := le(
+		"vids",
+		"MJPG",
+		uint32(0), // dwFlags
+		uint16(0), // wPriority
+		uint16(0), // wLanguage
+		uint32(0), // dwInitialFrames
+		uint32(1), // dwScale
+		uint32(that.fps),
+		uint32(0), // dwStart
+		uint32(that.totalFrames),
+		bufferSize,
+		uint32(0xFFFFFFFF), // dwQuality 使用默认值
+		uint32(0),          // dwSampleSize
+		uint16(0), uint16(0), uint16(that.width), uint16(that.height),
+	)
+	strf := le(
+		uint32(40), // biSize
+		int32(that.width),
+		int32(that.height),
+		uint16(1),  // biPlanes
+		uint16(24), // biBitCount
+		"MJPG",
+		uint32(that.width*that.height*3),
+		make([]byte, 16),
+	)
+	superIndex := make([]byte, 16*superIndexLen)
+	for i, e := range that.superIndex {
+		copy(superIndex[16*i:], le(uint64(e.offset), e.size, e.duration))
+	}
+	indx := le(uint16(4), uint8(0), uint8(aviIndexOfIndex), uint32(len(that.superIndex)), frameChunkID, make([]byte, 12), superIndex)
+	dmlh := le(uint32(that.totalFrames), make([]byte, 244))
+
+	strl := list("strl", chunk("strh", strh), chunk("strf", strf), chunk("indx", indx))
+	odml := list("odml", chunk("dmlh", dmlh))
+	hdrl := list("hdrl", chunk("avih", avih), strl, odml)
+	return le("RIFF", riffSize, "AVI ", hdrl, "LIST", moviSize, "movi")
+}
+
+// writeChunk 写入数据块，奇数长度的数据需要补齐
+func (that *AVIWriter) writeChunk(id string, data []byte) error {
+	if err := that.write(le(id, uint32(len(data)))); err != nil {
+		return err
+	}
+	if err := that.write(data); err != nil {
+		return err
+	}
+	if len(data)%2 == 1 {
+		return that.write([]byte{0})
+	}
+	return nil
+}
+
+func (that *AVIWriter) write(p []byte) error {
+	n, err := that.w.Write(p)
+	that.offset += int64(n)
+	return err
+}
+
+// patch 修改已经写入的长度字段，之后回到文件末尾
+func (that *AVIWriter) patch(offset int64, v uint32) error {
+	if _, err := that.w.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := that.w.Write(le(v)); err != nil {
+		return err
+	}
+	_, err := that.w.Seek(that.offset, io.SeekStart)
+	return err
+}
+
+// chunk 生成数据块
+func chunk(id string, data []byte) []byte {
+	return le(id, uint32(len(data)), data)
+}
+
+// list 生成列表
+func list(typ string, items ...[]byte) []byte {
+	data := []byte(typ)
+	for _, item := range items {
+		data = append(data, item...)
+	}
+	return chunk("LIST", data)
+}
+
+// le 按小端字节序拼接字段，字符串按原样写入
+func le(fields ...interface{}) []byte {
+	var buf []byte
+	for _, f := range fields {
+		switch v := f.(type) {
+		case string:
+			buf = append(buf, v...)
+		case []byte:
+			buf = append(buf, v...)
+		case uint8:
+			buf = append(buf, v)
+		case uint16:
+			buf = binary.LittleEndian.AppendUint16(buf, v)
+		case uint32:
+			buf = binary.LittleEndian.AppendUint32(buf, v)
+		case int32:
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(v))
+		case uint64:
+			buf = binary.LittleEndian.AppendUint64(buf, v)
+		default:
+			panic(fmt.Sprintf("不支持的字段类型:%T", f))
+		}
+	}
+	return buf
+}
@@ -0,0 +1,287 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// aviChunk AVI文件中的一个数据块或者列表，列表的data从列表类型之后开始
+type aviChunk struct {
+	id     string // 数据块的标识，列表为列表类型
+	offset int    // 数据块头在文件中的偏移
+	data   []byte
+}
+
+// parseChunks 依次解析data中的数据块，offset为data在文件中的偏移
+func parseChunks(t *testing.T, data []byte, offset int) []aviChunk {
+	t.Helper()
+	var chunks []aviChunk
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			t.Fatalf("偏移%d的数据块头不完整", offset+pos)
+		}
+		id, size := string(data[pos:pos+4]), int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if pos+8+size > len(data) {
+			t.Fatalf("偏移%d的数据块%s长度为%d，超过了文件末尾", offset+pos, id, size)
+		}
+		c := aviChunk{id: id, offset: offset + pos, data: data[pos+8 : pos+8+size]}
+		if id == "LIST" {
+			c.id, c.data = string(c.data[:4]), c.data[4:]
+		}
+		chunks = append(chunks, c)
+		// 奇数长度的数据块需要补齐
+		pos += 8 + size + size%2
+	}
+	return chunks
+}
+
+// findChunk 按标识查找数据块
+func findChunk(t *testing.T, chunks []aviChunk, id string) aviChunk {
+	t.Helper()
+	for _, c := range chunks {
+		if c.id == id {
+			return c
+		}
+	}
+	t.Fatalf("没有找到数据块%s", id)
+	return aviChunk{}
+}
+
+// writeAVI 使用 AVIWriter 写入文件，write中写入视频帧，返回文件的内容
+func writeAVI(t *testing.T, width, height, fps int, write func(*AVIWriter)) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.avi")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	w, err := NewAVIWriter(f, width, height, fps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(w)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 关闭后回到文件末尾，可以继续在后面写入其他数据
+	if offset, _ := f.Seek(0, io.SeekCurrent); offset == 0 {
+		t.Fatal("关闭后没有回到文件末尾")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestAVIWriter 文件头中的帧数、画面大小及帧率正确，movi中的每一帧都能通过idx1及ix00索引找到
+func TestAVIWriter(t *testing.T) {
+	// 奇数长度的帧需要补齐
+	frames := [][]byte{[]byte("frame0"), []byte("frame-1"), []byte("frame-2")}
+	// 最后一帧重复一次
+	want := append(frames, frames[2])
+	data := writeAVI(t, 4, 2, 10, func(w *AVIWriter) {
+		for _, f := range frames {
+			if err := w.WriteFrame(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.RepeatFrame(); err != nil {
+			t.Fatal(err)
+		}
+		if w.Frames() != len(want) {
+			t.Fatalf("写入了%d帧", w.Frames())
+		}
+		if err := w.WriteFrame(nil); err == nil {
+			t.Fatal("空的视频帧没有返回错误")
+		}
+	})
+
+	if string(data[:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+		t.Fatalf("文件头为%q", data[:12])
+	}
+	if size := int(binary.LittleEndian.Uint32(data[4:])); size != len(data)-8 {
+		t.Fatalf("RIFF的长度为%d，文件长度为%d", size, len(data))
+	}
+	top := parseChunks(t, data[12:], 12)
+	if len(top) != 3 || top[0].id != "hdrl" || top[1].id != "movi" || top[2].id != "idx1" {
+		t.Fatalf("RIFF中的数据块为%v", top)
+	}
+
+	hdrl := parseChunks(t, top[0].data, top[0].offset+12)
+	avih := findChunk(t, hdrl, "avih").data
+	if len(avih) != 56 {
+		t.Fatalf("avih的长度为%d", len(avih))
+	}
+	le32 := func(b []byte, offset int) int { return int(binary.LittleEndian.Uint32(b[offset:])) }
+	if le32(avih, 0) != 100000 || le32(avih, 12) != avifHasIndex || le32(avih, 16) != len(want) ||
+		le32(avih, 24) != 1 || le32(avih, 32) != 4 || le32(avih, 36) != 2 {
+		t.Fatalf("avih为%v", avih)
+	}
+	strl := parseChunks(t, findChunk(t, hdrl, "strl").data, 0)
+	strh := findChunk(t, strl, "strh").data
+	if string(strh[:8]) != "vidsMJPG" || le32(strh, 20) != 1 || le32(strh, 24) != 10 || le32(strh, 32) != len(want) {
+		t.Fatalf("strh为%v", strh)
+	}
+	strf := findChunk(t, strl, "strf").data
+	if le32(strf, 4) != 4 || le32(strf, 8) != 2 || string(strf[16:20]) != "MJPG" {
+		t.Fatalf("strf为%v", strf)
+	}
+	odml := parseChunks(t, findChunk(t, hdrl, "odml").data, 0)
+	if dmlh := findChunk(t, odml, "dmlh").data; le32(dmlh, 0) != len(want) {
+		t.Fatalf("dmlh中的总帧数为%d", le32(dmlh, 0))
+	}
+
+	// movi中依次是视频帧及标准索引
+	moviStart := top[1].offset + 8
+	movi := parseChunks(t, top[1].data, moviStart+4)
+	if len(movi) != len(want)+1 || movi[len(want)].id != stdIndexID {
+		t.Fatalf("movi中有%d个数据块", len(movi))
+	}
+	for i, f := range want {
+		if movi[i].id != frameChunkID || !bytes.Equal(movi[i].data, f) {
+			t.Fatalf("第%d帧为%s %q", i, movi[i].id, movi[i].data)
+		}
+	}
+
+	// idx1中的偏移指向数据块头，相对于movi列表的类型
+	idx1 := top[2].data
+	if len(idx1) != 16*len(want) {
+		t.Fatalf("idx1有%d字节", len(idx1))
+	}
+	for i, f := range want {
+		e := idx1[16*i:]
+		if string(e[:4]) != frameChunkID || le32(e, 4) != aviifKeyframe || le32(e, 12) != len(f) {
+			t.Fatalf("idx1的第%d个条目为%v", i, e[:16])
+		}
+		if offset := moviStart + le32(e, 8); offset != movi[i].offset {
+			t.Fatalf("idx1的第%d个条目指向偏移%d，应该为%d", i, offset, movi[i].offset)
+		}
+	}
+
+	// 超级索引指向ix00，ix00中的偏移指向数据块的数据，相对于movi列表的类型
+	indx := findChunk(t, strl, "indx").data
+	if indx[3] != aviIndexOfIndex || le32(indx, 4) != 1 || string(indx[8:12]) != frameChunkID {
+		t.Fatalf("超级索引为%v", indx[:24])
+	}
+	ix := movi[len(want)]
+	if offset := int(binary.LittleEndian.Uint64(indx[24:])); offset != ix.offset {
+		t.Fatalf("超级索引指向偏移%d，ix00的偏移为%d", offset, ix.offset)
+	}
+	if le32(indx, 32) != len(ix.data)+8 || le32(indx, 36) != len(want) {
+		t.Fatalf("超级索引的条目为%v", indx[24:40])
+	}
+	if ix.data[3] != aviIndexOfChunks || le32(ix.data, 4) != len(want) ||
+		int(binary.LittleEndian.Uint64(ix.data[12:])) != moviStart {
+		t.Fatalf("ix00为%v", ix.data[:24])
+	}
+	for i, f := range want {
+		e := ix.data[24+8*i:]
+		if offset := moviStart + le32(e, 0); offset != movi[i].offset+8 || le32(e, 4) != len(f) {
+			t.Fatalf("ix00的第%d个条目指向偏移%d，长度为%d", i, offset, le32(e, 4))
+		}
+	}
+}
+
+func TestAVIWriterErrors(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.avi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err = NewAVIWriter(f, 0, 2, 10); err == nil {
+		t.Fatal("视频大小不正确没有返回错误")
+	}
+	if _, err = NewAVIWriter(f, 4, 2, 0); err == nil {
+		t.Fatal("视频帧率不正确没有返回错误")
+	}
+	w, err := NewAVIWriter(f, 4, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.RepeatFrame(); err == nil {
+		t.Fatal("没有写入视频帧时重复上一帧没有返回错误")
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteFrame([]byte("frame")); err == nil {
+		t.Fatal("关闭后还能写入视频帧")
+	}
+	if _, err = NewAVIWriter(f, 4, 2, 10); err == nil {
+		t.Fatal("不是从文件开头写入没有返回错误")
+	}
+}
+
+// TestMJPEGWriter 每一帧都是能够解码的JPEG图片
+func TestMJPEGWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.avi")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err = NewMJPEGWriter(f, 4, 2, 10, 0); err == nil {
+		t.Fatal("JPEG压缩质量不正确没有返回错误")
+	}
+	w, err := AVIOutput(f, DefaultJPEGQuality)(16, 8, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := []color.RGBA{{R: 0xff, A: 0xff}, {G: 0xff, A: 0xff}}
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for _, c := range colors {
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+		if err = w.WriteFrame(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.RepeatFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := parseChunks(t, data[12:], 12)
+	movi := parseChunks(t, findChunk(t, top, "movi").data, 0)
+	want := append(colors, colors[1])
+	if len(movi) != len(want)+1 {
+		t.Fatalf("movi中有%d个数据块", len(movi))
+	}
+	for i, c := range want {
+		frame, err := jpeg.Decode(bytes.NewReader(movi[i].data))
+		if err != nil {
+			t.Fatalf("第%d帧无法解码:%v", i, err)
+		}
+		if frame.Bounds() != img.Bounds() {
+			t.Fatalf("第%d帧的大小为%v", i, frame.Bounds())
+		}
+		// JPEG是有损压缩，颜色允许有误差
+		r, g, b, _ := frame.At(8, 4).RGBA()
+		if diff(r>>8, c.R) > 8 || diff(g>>8, c.G) > 8 || diff(b>>8, c.B) > 8 {
+			t.Fatalf("第%d帧的颜色为%d,%d,%d，应该为%v", i, r>>8, g>>8, b>>8, c)
+		}
+	}
+}
+
+// diff 两个颜色分量的差
+func diff(a uint32, b uint8) uint32 {
+	if a > uint32(b) {
+		return a - uint32(b)
+	}
+	return uint32(b) - a
+}
@@ -0,0 +1,125 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+)
+
+// DefaultJPEGQuality 默认的JPEG压缩质量
+const DefaultJPEGQuality = 75
+
+// FrameWriter 按固定帧率写入视频或者动画，每一帧都是完整的画面
+type FrameWriter interface {
+	// WriteFrame 写入一帧画面，返回后调用方可以继续修改img
+	WriteFrame(img *image.RGBA) error
+	// RepeatFrame 重复上一帧，画面没有变化时使用，不需要重新编码
+	RepeatFrame() error
+	// Close 写入剩余的数据，不会关闭底层的文件
+	Close() error
+}
+
+// Output 根据画面的大小及帧率创建FrameWriter，画面的大小在开始绘制之后才能确定
+type Output func(width, height, fps int) (FrameWriter, error)
+
+// AVIOutput 输出MJPEG编码的AVI视频，quality为JPEG的压缩质量
+func AVIOutput(w io.WriteSeeker, quality int) Output {
+	return func(width, height, fps int) (FrameWriter, error) {
+		return NewMJPEGWriter(w, width, height, fps, quality)
+	}
+}
+
+// GIFOutput 输出GIF动画
+func GIFOutput(w io.Writer) Output {
+	return func(width, height, fps int) (FrameWriter, error) {
+		return NewGIFWriter(w, width, height, fps)
+	}
+}
+
+// APNGOutput 输出APNG动画
+func APNGOutput(w io.WriteSeeker) Output {
+	return func(width, height, fps int) (FrameWriter, error) {
+		return NewAPNGWriter(w, width, height, fps)
+	}
+}
+
+// SequenceOutput 输出按序号命名的图片，pattern参考 NewSequenceWriter
+func SequenceOutput(pattern string, quality int) Output {
+	return func(width, height, fps int) (FrameWriter, error) {
+		return NewSequenceWriter(pattern, quality)
+	}
+}
+
+// MJPEGWriter 把画面编码成JPEG图片写入AVI视频
+type MJPEGWriter struct {
+	avi     *AVIWriter
+	quality int
+}
+
+var _ FrameWriter = new(MJPEGWriter)
+
+// NewMJPEGWriter 创建MJPEG编码的AVI视频，quality为JPEG的压缩质量，范围1-100
+func NewMJPEGWriter(w io.WriteSeeker, width, height, fps, quality int) (*MJPEGWriter, error) {
+	if quality <= 0 || quality > 100 {
+		return nil, fmt.Errorf("JPEG压缩质量不正确:%d", quality)
+	}
+	avi, err := NewAVIWriter(w, width, height, fps)
+	if err != nil {
+		return nil, err
+	}
+	return &MJPEGWriter{avi: avi, quality: quality}, nil
+}
+
+func (that *MJPEGWriter) WriteFrame(img *image.RGBA) error {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: that.quality}); err != nil {
+		return err
+	}
+	return that.avi.WriteFrame(buf.Bytes())
+}
+
+func (that *MJPEGWriter) RepeatFrame() error {
+	return that.avi.RepeatFrame()
+}
+
+func (that *MJPEGWriter) Close() error {
+	return that.avi.Close()
+}
+
+// changedRect 返回img与prev不同的像素所在的最小矩形，prev为nil时返回整个画面
+func changedRect(prev, img *image.RGBA) image.Rectangle {
+	bounds := img.Bounds()
+	if prev == nil || prev.Bounds() != bounds {
+		return bounds
+	}
+	rect := image.Rectangle{}
+	width := bounds.Dx() * 4
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		a := prev.Pix[prev.PixOffset(bounds.Min.X, y):][:width]
+		b := img.Pix[img.PixOffset(bounds.Min.X, y):][:width]
+		if bytes.Equal(a, b) {
+			continue
+		}
+		left, right := 0, width
+		for a[left] == b[left] {
+			left++
+		}
+		for a[right-1] == b[right-1] {
+			right--
+		}
+		rect = rect.Union(image.Rect(bounds.Min.X+left/4, y, bounds.Min.X+(right+3)/4, y+1))
+	}
+	return rect
+}
+
+// copyRGBA 把img复制到dst，dst为nil或者大小不同时重新创建
+func copyRGBA(dst, img *image.RGBA) *image.RGBA {
+	if dst == nil || dst.Bounds() != img.Bounds() {
+		dst = image.NewRGBA(img.Bounds())
+	}
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,208 @@
+package media
+
+import (
+	"bytes"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	gifMaxFPS   = 50     // GIF的延时单位是10毫秒，播放器会把小于20毫秒的延时当作100毫秒
+	gifMaxDelay = 0xffff // 一帧的最大延时
+)
+
+// GIFWriter 写入GIF动画，循环播放。
+// 每一帧只编码与上一帧不同的区域，区域中未变化的像素使用透明色，每一帧使用单独的调色板，
+// 颜色超过调色板的大小时使用中位切分算法减少颜色。
+// 写入一帧时需要知道这一帧持续的时间，所以最近的一帧在下一个不同的画面或者Close时才写入。
+type GIFWriter struct {
+	w       io.Writer
+	fps     int
+	prev    *image.RGBA // 上一帧的画面
+	pending []byte      // 还没有写入的帧，不包括图形控制扩展
+	trans   int         // pending的透明色序号，没有透明色为-1
+	start   int         // pending开始的帧序号
+	frames  int         // 已经写入的帧数量，包括重复的帧
+	closed  bool
+}
+
+var _ FrameWriter = new(GIFWriter)
+
+// NewGIFWriter 创建GIF动画，立即写入文件头，fps不能超过50
+func NewGIFWriter(w io.Writer, width, height, fps int) (*GIFWriter, error) {
+	if width <= 0 || height <= 0 || width > 0xffff || height > 0xffff {
+		return nil, fmt.Errorf("GIF动画大小不正确:%dx%d", width, height)
+	}
+	if fps <= 0 || fps > gifMaxFPS {
+		return nil, fmt.Errorf("GIF动画的帧率需要在1-%d之间:%d", gifMaxFPS, fps)
+	}
+	buf := []byte("GIF89a")
+	// 逻辑屏幕描述，没有全局调色板
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(width))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(height))
+	buf = append(buf, 0, 0, 0)
+	// NETSCAPE2.0扩展，无限循环
+	buf = append(buf, 0x21, 0xff, 0x0b)
+	buf = append(buf, "NETSCAPE2.0"...)
+	buf = append(buf, 0x03, 0x01, 0x00, 0x00, 0x00)
+	if _, err := w.Write(buf); err != nil {
+		return nil, err
+	}
+	return &GIFWriter{w: w, fps: fps, trans: -1}, nil
+}
+
+func (that *GIFWriter) WriteFrame(img *image.RGBA) error {
+	if that.closed {
+		return fmt.Errorf("GIF动画已经关闭")
+	}
+	rect := changedRect(that.prev, img)
+	if that.prev != nil && rect.Empty() {
+		return that.RepeatFrame()
+	}
+	if err := that.flush(); err != nil {
+		return err
+	}
+	prev := that.prev
+	if prev != nil && prev.Bounds() != img.Bounds() {
+		prev = nil
+	}
+	that.trans = -1
+	n := 256
+	if prev != nil {
+		// 预留一个透明色给未变化的像素
+		n = 255
+	}
+	palette := quantize(img, prev, rect, n)
+	mapper := newPaletteMapper(palette)
+	if prev != nil {
+		that.trans = len(palette)
+		palette = append(palette, color.RGBA{})
+	}
+	paletted := image.NewPaletted(rect, palette)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := rgbKey(img, x, y)
+			if prev != nil && rgbKey(prev, x, y) == c {
+				paletted.SetColorIndex(x, y, uint8(that.trans))
+				continue
+			}
+			paletted.SetColorIndex(x, y, mapper.index(c))
+		}
+	}
+	var err error
+	if that.pending, err = encodeGIFImage(paletted); err != nil {
+		return err
+	}
+	that.start = that.frames
+	that.frames++
+	that.prev = copyRGBA(that.prev, img)
+	return nil
+}
+
+func (that *GIFWriter) RepeatFrame() error {
+	if that.pending == nil {
+		return fmt.Errorf("还没有写入动画帧")
+	}
+	that.frames++
+	return nil
+}
+
+// Close 写入最后一帧及文件结尾，不会关闭底层的io.Writer
+func (that *GIFWriter) Close() error {
+	if that.closed {
+		return nil
+	}
+	that.closed = true
+	if err := that.flush(); err != nil {
+		return err
+	}
+	_, err := that.w.Write([]byte{0x3b})
+	return err
+}
+
+// flush 写入还没有写入的帧，超过最大延时的部分使用透明的空白帧
+func (that *GIFWriter) flush() error {
+	if that.pending == nil {
+		return nil
+	}
+	delay := that.centiseconds(that.frames) - that.centiseconds(that.start)
+	data, trans := that.pending, that.trans
+	that.pending = nil
+	for {
+		d := delay
+		if d > gifMaxDelay {
+			d = gifMaxDelay
+		}
+		// 图形控制扩展，不处理上一帧，透明色
+		flags := byte(1 << 2)
+		if trans >= 0 {
+			flags |= 1
+		}
+		gce := []byte{0x21, 0xf9, 0x04, flags, byte(d), byte(d >> 8), byte(max(trans, 0)), 0x00}
+		if _, err := that.w.Write(append(gce, data...)); err != nil {
+			return err
+		}
+		delay -= d
+		if delay <= 0 {
+			return nil
+		}
+		blank := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.RGBA{}, color.RGBA{}})
+		var err error
+		if data, err = encodeGIFImage(blank); err != nil {
+			return err
+		}
+		trans = 0
+	}
+}
+
+// centiseconds 第n帧开始的时间，单位10毫秒，累计计算避免误差
+func (that *GIFWriter) centiseconds(n int) int {
+	return (n*100 + that.fps/2) / that.fps
+}
+
+// encodeGIFImage 编码图像描述、局部调色板及LZW压缩的图像数据
+func encodeGIFImage(img *image.Paletted) ([]byte, error) {
+	// 调色板的大小需要是2的幂，至少是4
+	bits := 2
+	for 1<<bits < len(img.Palette) {
+		bits++
+	}
+	rect := img.Rect
+	buf := []byte{0x2c}
+	for _, v := range []int{rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy()} {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(v))
+	}
+	buf = append(buf, 0x80|byte(bits-1))
+	for i := 0; i < 1<<bits; i++ {
+		if i < len(img.Palette) {
+			r, g, b, _ := img.Palette[i].RGBA()
+			buf = append(buf, byte(r>>8), byte(g>>8), byte(b>>8))
+		} else {
+			buf = append(buf, 0, 0, 0)
+		}
+	}
+	buf = append(buf, byte(bits))
+	compressed := &bytes.Buffer{}
+	lw := lzw.NewWriter(compressed, lzw.LSB, bits)
+	for y := 0; y < rect.Dy(); y++ {
+		if _, err := lw.Write(img.Pix[y*img.Stride : y*img.Stride+rect.Dx()]); err != nil {
+			return nil, err
+		}
+	}
+	if err := lw.Close(); err != nil {
+		return nil, err
+	}
+	// 数据分成不超过255字节的子块，以长度为0的子块结束
+	data := compressed.Bytes()
+	for len(data) > 0 {
+		n := min(len(data), 255)
+		buf = append(buf, byte(n))
+		buf = append(buf, data[:n]...)
+		data = data[n:]
+	}
+	return append(buf, 0x00), nil
+}
@@ -0,0 +1,194 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+var (
+	testRed   = color.RGBA{R: 0xff, A: 0xff}
+	testGreen = color.RGBA{G: 0xff, A: 0xff}
+	testBlue  = color.RGBA{B: 0xff, A: 0xff}
+)
+
+// testFrame 生成8x4的画面，rect之外为bg，rect之内为fg
+func testFrame(bg, fg color.RGBA, rect image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(img, rect, image.NewUniform(fg), image.Point{}, draw.Src)
+	return img
+}
+
+// expectImage 比较画面中每个像素的颜色，忽略透明度
+func expectImage(t *testing.T, name string, got image.Image, want *image.RGBA) {
+	t.Helper()
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("%s的大小为%v，应该为%v", name, got.Bounds(), want.Bounds())
+	}
+	for y := want.Rect.Min.Y; y < want.Rect.Max.Y; y++ {
+		for x := want.Rect.Min.X; x < want.Rect.Max.X; x++ {
+			r, g, b, _ := got.At(x, y).RGBA()
+			w := want.RGBAAt(x, y)
+			if uint8(r>>8) != w.R || uint8(g>>8) != w.G || uint8(b>>8) != w.B {
+				t.Fatalf("%s在(%d,%d)的颜色为%d,%d,%d，应该为%v", name, x, y, r>>8, g>>8, b>>8, w)
+			}
+		}
+	}
+}
+
+// TestGIFWriter 相同的画面合并成一帧，延时为重复的次数，之后的帧只包含变化的区域，未变化的像素为透明色
+func TestGIFWriter(t *testing.T) {
+	red := testFrame(testRed, testRed, image.Rectangle{})
+	changed := image.Rect(2, 1, 5, 3)
+	green := testFrame(testRed, testGreen, changed)
+	// 变化区域中有未变化的像素
+	mixed := testFrame(testRed, testGreen, changed)
+	mixed.SetRGBA(2, 1, testBlue)
+	mixed.SetRGBA(4, 2, testBlue)
+
+	buf := &bytes.Buffer{}
+	w, err := GIFOutput(buf)(8, 4, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.RepeatFrame(); err == nil {
+		t.Fatal("没有写入动画帧时重复上一帧没有返回错误")
+	}
+	for _, img := range []*image.RGBA{red, red, green, mixed} {
+		if err = w.WriteFrame(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.RepeatFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.RepeatFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteFrame(red); err == nil {
+		t.Fatal("关闭后还能写入动画帧")
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Config.Width != 8 || g.Config.Height != 4 || g.LoopCount != 0 {
+		t.Fatalf("动画大小为%dx%d，循环次数为%d", g.Config.Width, g.Config.Height, g.LoopCount)
+	}
+	wantDelay := []int{20, 10, 30}
+	if len(g.Image) != len(wantDelay) {
+		t.Fatalf("动画有%d帧，应该为%d帧", len(g.Image), len(wantDelay))
+	}
+	for i, d := range wantDelay {
+		if g.Delay[i] != d || g.Disposal[i] != gif.DisposalNone {
+			t.Fatalf("第%d帧的延时为%d，处理方式为%d", i, g.Delay[i], g.Disposal[i])
+		}
+	}
+	if g.Image[0].Rect != red.Rect || g.Image[1].Rect != changed || g.Image[2].Rect != changed {
+		t.Fatalf("每一帧的区域为%v %v %v", g.Image[0].Rect, g.Image[1].Rect, g.Image[2].Rect)
+	}
+	if _, _, _, a := g.Image[2].At(3, 1).RGBA(); a != 0 {
+		t.Fatal("未变化的像素不是透明色")
+	}
+	// 按顺序叠加每一帧，透明的像素保留上一帧的内容
+	canvas := image.NewRGBA(red.Rect)
+	for i, want := range []*image.RGBA{red, green, mixed} {
+		draw.Draw(canvas, g.Image[i].Rect, g.Image[i], g.Image[i].Rect.Min, draw.Over)
+		expectImage(t, "动画帧", canvas, want)
+	}
+}
+
+// TestGIFWriterLongDelay 超过最大延时的帧之后使用透明的空白帧补足延时
+func TestGIFWriterLongDelay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewGIFWriter(buf, 8, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteFrame(testFrame(testRed, testRed, image.Rectangle{})); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 699; i++ {
+		if err = w.RepeatFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 2 || g.Delay[0] != gifMaxDelay || g.Delay[1] != 70000-gifMaxDelay {
+		t.Fatalf("动画有%d帧，延时为%v", len(g.Image), g.Delay)
+	}
+	if _, _, _, a := g.Image[1].At(0, 0).RGBA(); a != 0 {
+		t.Fatal("补足延时的空白帧不是透明的")
+	}
+}
+
+// TestGIFWriterQuantize 颜色超过调色板的大小时减少颜色，误差不会太大
+func TestGIFWriterQuantize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 8), B: uint8((x + y) * 4), A: 0xff})
+		}
+	}
+	buf := &bytes.Buffer{}
+	w, err := NewGIFWriter(buf, 32, 32, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteFrame(img); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 1 || len(g.Image[0].Palette) > 256 {
+		t.Fatalf("动画有%d帧", len(g.Image))
+	}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			r, gr, b, _ := g.Image[0].At(x, y).RGBA()
+			c := img.RGBAAt(x, y)
+			if diff(r>>8, c.R) > 32 || diff(gr>>8, c.G) > 32 || diff(b>>8, c.B) > 32 {
+				t.Fatalf("(%d,%d)的颜色为%d,%d,%d，应该为%v", x, y, r>>8, gr>>8, b>>8, c)
+			}
+		}
+	}
+}
+
+func TestNewGIFWriter(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		fps           int
+	}{
+		{"宽度为0", 0, 4, 10},
+		{"高度超过65535", 8, 0x10000, 10},
+		{"帧率为0", 8, 4, 0},
+		{"帧率超过50", 8, 4, gifMaxFPS + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewGIFWriter(&bytes.Buffer{}, tt.width, tt.height, tt.fps); err == nil {
+				t.Fatal("参数不正确没有返回错误")
+			}
+		})
+	}
+}
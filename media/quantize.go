@@ -0,0 +1,149 @@
+package media
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// colorCount 颜色及其出现的次数
+type colorCount struct {
+	rgb   [3]uint8
+	count int
+}
+
+// quantize 根据img中rect区域的像素生成不超过n种颜色的调色板，跳过与prev相同的像素，prev可以为nil。
+// 颜色数量不超过n时直接使用这些颜色，远程桌面的画面大多是这种情况，否则使用中位切分算法减少颜色。
+func quantize(img, prev *image.RGBA, rect image.Rectangle, n int) color.Palette {
+	hist := make(map[uint32]int)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := rgbKey(img, x, y)
+			if prev != nil && rgbKey(prev, x, y) == c {
+				continue
+			}
+			hist[c]++
+		}
+	}
+	colors := make([]colorCount, 0, len(hist))
+	for c, count := range hist {
+		colors = append(colors, colorCount{rgb: [3]uint8{uint8(c >> 16), uint8(c >> 8), uint8(c)}, count: count})
+	}
+	// map的遍历顺序是随机的，排序后相同的画面生成相同的调色板
+	sort.Slice(colors, func(i, j int) bool {
+		return rgbValue(colors[i].rgb) < rgbValue(colors[j].rgb)
+	})
+	if len(colors) <= n {
+		palette := make(color.Palette, 0, len(colors))
+		for _, c := range colors {
+			palette = append(palette, color.RGBA{R: c.rgb[0], G: c.rgb[1], B: c.rgb[2], A: 0xff})
+		}
+		return palette
+	}
+	boxes := [][]colorCount{colors}
+	for len(boxes) < n {
+		// 切分颜色范围最大的区域
+		index, channel, maxRange := -1, 0, -1
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			ch, r := widestChannel(box)
+			if r > maxRange {
+				index, channel, maxRange = i, ch, r
+			}
+		}
+		if index < 0 {
+			break
+		}
+		box := boxes[index]
+		sort.Slice(box, func(i, j int) bool {
+			return box[i].rgb[channel] < box[j].rgb[channel]
+		})
+		// 按像素数量的中位数切分，两边都至少有一种颜色
+		total := 0
+		for _, c := range box {
+			total += c.count
+		}
+		mid, sum := 1, box[0].count
+		for mid < len(box)-1 && sum < total/2 {
+			sum += box[mid].count
+			mid++
+		}
+		boxes[index] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		var r, g, b, total int
+		for _, c := range box {
+			r += int(c.rgb[0]) * c.count
+			g += int(c.rgb[1]) * c.count
+			b += int(c.rgb[2]) * c.count
+			total += c.count
+		}
+		palette = append(palette, color.RGBA{R: uint8(r / total), G: uint8(g / total), B: uint8(b / total), A: 0xff})
+	}
+	return palette
+}
+
+// widestChannel 返回颜色范围最大的颜色分量及其范围
+func widestChannel(box []colorCount) (int, int) {
+	lo := [3]uint8{255, 255, 255}
+	hi := [3]uint8{}
+	for _, c := range box {
+		for i, v := range c.rgb {
+			if v < lo[i] {
+				lo[i] = v
+			}
+			if v > hi[i] {
+				hi[i] = v
+			}
+		}
+	}
+	channel, maxRange := 0, -1
+	for i := range lo {
+		if r := int(hi[i]) - int(lo[i]); r > maxRange {
+			channel, maxRange = i, r
+		}
+	}
+	return channel, maxRange
+}
+
+// paletteMapper 查找调色板中最接近的颜色，缓存已经查找过的颜色
+type paletteMapper struct {
+	palette color.Palette
+	cache   map[uint32]uint8
+}
+
+func newPaletteMapper(palette color.Palette) *paletteMapper {
+	return &paletteMapper{palette: palette, cache: make(map[uint32]uint8)}
+}
+
+// index 返回颜色c在调色板中最接近的颜色的序号
+func (that *paletteMapper) index(c uint32) uint8 {
+	if i, ok := that.cache[c]; ok {
+		return i
+	}
+	r, g, b := int(c>>16&0xff), int(c>>8&0xff), int(c&0xff)
+	best, bestDist := 0, -1
+	for i, p := range that.palette {
+		pc := p.(color.RGBA)
+		dr, dg, db := r-int(pc.R), g-int(pc.G), b-int(pc.B)
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	that.cache[c] = uint8(best)
+	return uint8(best)
+}
+
+// rgbKey 把像素的颜色转换成整数，忽略透明度
+func rgbKey(img *image.RGBA, x, y int) uint32 {
+	i := img.PixOffset(x, y)
+	return uint32(img.Pix[i])<<16 | uint32(img.Pix[i+1])<<8 | uint32(img.Pix[i+2])
+}
+
+func rgbValue(rgb [3]uint8) uint32 {
+	return uint32(rgb[0])<<16 | uint32(rgb[1])<<8 | uint32(rgb[2])
+}
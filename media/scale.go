@@ -0,0 +1,37 @@
+package media
+
+import (
+	"image"
+)
+
+// Scale 把src缩放到dst的大小，dst的每个像素取src中对应区域像素的平均值，放大时相当于取最近的像素
+func Scale(dst, src *image.RGBA) {
+	sb, db := src.Bounds(), dst.Bounds()
+	sw, sh, dw, dh := sb.Dx(), sb.Dy(), db.Dx(), db.Dy()
+	if sw == 0 || sh == 0 {
+		return
+	}
+	for dy := 0; dy < dh; dy++ {
+		y0 := dy * sh / dh
+		y1 := max((dy+1)*sh/dh, y0+1)
+		for dx := 0; dx < dw; dx++ {
+			x0 := dx * sw / dw
+			x1 := max((dx+1)*sw/dw, x0+1)
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				p := src.Pix[src.PixOffset(sb.Min.X+x0, sb.Min.Y+y):]
+				for x := 0; x < x1-x0; x++ {
+					sum[0] += int(p[x*4+0])
+					sum[1] += int(p[x*4+1])
+					sum[2] += int(p[x*4+2])
+					sum[3] += int(p[x*4+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			d := dst.Pix[dst.PixOffset(db.Min.X+dx, db.Min.Y+dy):]
+			for i := range sum {
+				d[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+}
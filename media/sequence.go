@@ -0,0 +1,93 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gfile"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SequenceWriter 把每一帧保存为一张按序号命名的PNG或者JPEG图片，重复的帧直接复制上一张图片的数据
+type SequenceWriter struct {
+	pattern string
+	jpeg    bool
+	quality int
+	last    []byte // 上一帧编码后的数据
+	frames  int
+}
+
+var _ FrameWriter = new(SequenceWriter)
+
+// NewSequenceWriter 创建图片序列，pattern是带有%d格式的文件路径，例如/path/to/frame_%05d.png，
+// 序号从0开始，扩展名为.jpg或者.jpeg时保存为JPEG图片，quality为JPEG的压缩质量，否则保存为PNG图片
+func NewSequenceWriter(pattern string, quality int) (*SequenceWriter, error) {
+	first := fmt.Sprintf(pattern, 0)
+	if first == fmt.Sprintf(pattern, 1) || strings.Contains(first, "%!") {
+		return nil, fmt.Errorf("图片序列的文件名需要包含一个序号的格式，例如frame_%%05d.png:%s", pattern)
+	}
+	that := &SequenceWriter{pattern: pattern, quality: quality}
+	switch strings.ToLower(filepath.Ext(pattern)) {
+	case ".jpg", ".jpeg":
+		if quality <= 0 || quality > 100 {
+			return nil, fmt.Errorf("JPEG压缩质量不正确:%d", quality)
+		}
+		that.jpeg = true
+	case ".png":
+	default:
+		return nil, fmt.Errorf("图片序列只支持png及jpeg格式:%s", pattern)
+	}
+	if err := gfile.Mkdir(gfile.Dir(first)); err != nil {
+		return nil, err
+	}
+	return that, nil
+}
+
+// SequencePattern 文件名中没有序号的格式时，在扩展名之前加上5位的序号，例如frame.png生成frame_00000.png
+func SequencePattern(path string) string {
+	if strings.Contains(path, "%") {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_%05d" + ext
+}
+
+func (that *SequenceWriter) WriteFrame(img *image.RGBA) error {
+	buf := &bytes.Buffer{}
+	var err error
+	if that.jpeg {
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: that.quality})
+	} else {
+		err = png.Encode(buf, img)
+	}
+	if err != nil {
+		return err
+	}
+	that.last = buf.Bytes()
+	return that.write()
+}
+
+func (that *SequenceWriter) RepeatFrame() error {
+	if that.last == nil {
+		return fmt.Errorf("还没有写入图片")
+	}
+	return that.write()
+}
+
+func (that *SequenceWriter) Close() error {
+	return nil
+}
+
+// write 把上一帧的数据写入下一个序号的图片
+func (that *SequenceWriter) write() error {
+	path := fmt.Sprintf(that.pattern, that.frames)
+	if err := os.WriteFile(path, that.last, 0644); err != nil {
+		return err
+	}
+	that.frames++
+	return nil
+}
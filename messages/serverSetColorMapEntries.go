@@ -20,7 +20,7 @@ func (that *SetColorMapEntries) Clone() rfb.Message {
 	c := &SetColorMapEntries{
 		FirstColor: that.FirstColor,
 		ColorsNum:  that.ColorsNum,
-		Colors:     that.Colors,
+		Colors:     append([]rfb.Color(nil), that.Colors...),
 	}
 	return c
 }
@@ -56,21 +56,36 @@ func (that *SetColorMapEntries) Read(session rfb.ISession) (rfb.Message, error)
 	}
 
 	msg.Colors = make([]rfb.Color, msg.ColorsNum)
-	colorMap := session.Options().ColorMap
-	//读取指定的颜色数据
-	for i := uint16(0); i < msg.ColorsNum; i++ {
+	//读取指定的颜色数据，每个颜色是16位的红、绿、蓝分量
+	for i := range msg.Colors {
 		color := &msg.Colors[i]
-		err := color.Read(session)
-		if err != nil {
-			return nil, err
+		for _, c := range []*uint16{&color.R, &color.G, &color.B} {
+			if err := binary.Read(session, binary.BigEndian, c); err != nil {
+				return nil, err
+			}
 		}
-		colorMap[msg.FirstColor+i] = *color
 	}
-	session.SetColorMap(colorMap)
+	msg.apply(session)
 	return msg, nil
 }
 
+// apply 把颜色更新到会话的颜色地图中，超出颜色地图的颜色被忽略
+func (that *SetColorMapEntries) apply(session rfb.ISession) {
+	colorMap := session.Options().ColorMap
+	for i, color := range that.Colors {
+		if index := int(that.FirstColor) + i; index < len(colorMap) {
+			colorMap[index] = rfb.Color{R: color.R, G: color.G, B: color.B}
+		}
+	}
+	session.SetColorMap(colorMap)
+}
+
 func (that *SetColorMapEntries) Write(session rfb.ISession) error {
+	// 画布只需要更新颜色地图
+	if session.Type() == rfb.CanvasSessionType {
+		that.apply(session)
+		return nil
+	}
 
 	// 写入消息类型
 	if err := binary.Write(session, binary.BigEndian, that.Type()); err != nil {
@@ -95,9 +110,8 @@ func (that *SetColorMapEntries) Write(session rfb.ISession) error {
 	}
 
 	// 颜色数据
-	for i := 0; i < len(that.Colors); i++ {
-		color := that.Colors[i]
-		if err := binary.Write(session, binary.BigEndian, color); err != nil {
+	for _, color := range that.Colors {
+		if err := binary.Write(session, binary.BigEndian, [3]uint16{color.R, color.G, color.B}); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,235 @@
+package rbs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strings"
+)
+
+// Compression 录屏文件整体使用的压缩算法
+// 压缩后的文件无法直接跳转，读取时需要从头解压，配合分段录屏可以减少跳转时需要解压的数据。
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota // 不压缩
+	CompressionGzip                    // gzip压缩，文件扩展名 .gz
+	CompressionZstd                    // zstd压缩，文件扩展名 .zst
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+func (that Compression) String() string {
+	switch that {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Compression(%d)", uint8(that))
+}
+
+// Ext 压缩文件的扩展名，不压缩返回空
+func (that Compression) Ext() string {
+	switch that {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// ParseCompression 解析压缩算法的名称，支持 none、gzip、zstd，空字符串表示不压缩
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return CompressionNone, nil
+	case "gzip", "gz":
+		return CompressionGzip, nil
+	case "zstd", "zst":
+		return CompressionZstd, nil
+	}
+	return CompressionNone, fmt.Errorf("不支持的压缩算法:%s", name)
+}
+
+// NewCompressWriter 使用压缩算法包装w，关闭时写入压缩流的结尾并关闭w(如果实现了io.Closer)
+// 录屏写入的每个消息都会Flush，异常中断时已经Flush的数据仍然可以解压
+func NewCompressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	var cw compressWriter
+	switch c {
+	case CompressionNone:
+		return &compressWriteCloser{w: w}, nil
+	case CompressionGzip:
+		gw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		cw = gw
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		cw = zw
+	default:
+		return nil, fmt.Errorf("不支持的压缩算法:%v", c)
+	}
+	return &compressWriteCloser{w: w, cw: cw}, nil
+}
+
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriteCloser 压缩写入器，cw为nil表示不压缩
+type compressWriteCloser struct {
+	w  io.Writer
+	cw compressWriter
+}
+
+func (that *compressWriteCloser) Write(p []byte) (int, error) {
+	if that.cw == nil {
+		return that.w.Write(p)
+	}
+	return that.cw.Write(p)
+}
+
+// Flush 把压缩器中缓存的数据写入w
+func (that *compressWriteCloser) Flush() error {
+	if that.cw == nil {
+		return nil
+	}
+	return that.cw.Flush()
+}
+
+func (that *compressWriteCloser) Close() error {
+	var err error
+	if that.cw != nil {
+		err = that.cw.Close()
+	}
+	if c, ok := that.w.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// DetectCompression 根据文件开头的魔数判断压缩算法
+func DetectCompression(magic []byte) Compression {
+	if bytes.HasPrefix(magic, gzipMagic) {
+		return CompressionGzip
+	}
+	if bytes.HasPrefix(magic, zstdMagic) {
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// newDecompressReader 使用压缩算法解压r
+func newDecompressReader(r io.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// streamFile 只能顺序读取的录屏文件(压缩或者加密)，支持向前跳转，向后跳转时通过open重新从头读取。
+// 不支持相对文件末尾跳转，rbs.Reader会顺序扫描数据块重建索引。
+type streamFile struct {
+	open   func() (io.ReadCloser, error)
+	r      io.ReadCloser
+	offset int64 // 解压或者解密后的偏移
+}
+
+// reset 重新从头读取
+func (that *streamFile) reset() error {
+	that.closeReader()
+	r, err := that.open()
+	if err != nil {
+		return err
+	}
+	that.r, that.offset = r, 0
+	return nil
+}
+
+func (that *streamFile) Read(p []byte) (int, error) {
+	if that.r == nil {
+		if err := that.reset(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := that.r.Read(p)
+	that.offset += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		// 录屏异常中断，压缩流不完整，已经解压的数据仍然有效
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (that *streamFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += that.offset
+	default:
+		return 0, fmt.Errorf("压缩或者加密的rbs文件不支持从末尾跳转")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("跳转的偏移不正确:%d", offset)
+	}
+	if that.r == nil || offset < that.offset {
+		if err := that.reset(); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := io.CopyN(io.Discard, that, offset-that.offset); err != nil && err != io.EOF {
+		return that.offset, err
+	}
+	return that.offset, nil
+}
+
+func (that *streamFile) closeReader() {
+	if that.r != nil {
+		_ = that.r.Close()
+		that.r = nil
+	}
+}
+
+func (that *streamFile) Close() error {
+	that.closeReader()
+	return nil
+}
+
+// multiCloser 读取数据流，关闭时按顺序关闭所有的io.Closer
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (that *multiCloser) Close() error {
+	var err error
+	for _, c := range that.closers {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package rbs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Compression
+		wantErr bool
+	}{
+		{"", CompressionNone, false},
+		{"none", CompressionNone, false},
+		{"gzip", CompressionGzip, false},
+		{"GZ", CompressionGzip, false},
+		{"zstd", CompressionZstd, false},
+		{"zst", CompressionZstd, false},
+		{"lz4", CompressionNone, true},
+	}
+	for _, tt := range tests {
+		c, err := ParseCompression(tt.name)
+		if c != tt.want || (err != nil) != tt.wantErr {
+			t.Fatalf("解析%q的结果为%v %v", tt.name, c, err)
+		}
+		if parsed, _ := ParseCompression(c.String()); parsed != c {
+			t.Fatalf("%v的名称无法解析回来", c)
+		}
+	}
+}
+
+// writeCompressed 使用压缩算法写入数据块，close为false时只Flush不写入压缩流的结尾，模拟录屏异常中断
+func writeCompressed(t *testing.T, c Compression, chunks []testChunk, close bool) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	cw, err := NewCompressWriter(buf, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWriter(cw, testHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range chunks {
+		if err = w.WriteChunkFlags(chunk.typ, chunk.flags, chunk.ts, chunk.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !close {
+		if err = cw.(*compressWriteCloser).Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestCompressRoundTrip 压缩的录屏文件通过 OpenFile 自动解压，可以顺序读取及跳转
+func TestCompressRoundTrip(t *testing.T) {
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			data := writeCompressed(t, c, testChunks, true)
+			if got := DetectCompression(data); got != c {
+				t.Fatalf("压缩算法识别为%v", got)
+			}
+			if c != CompressionNone && bytes.Contains(data, []byte("key0key0key0")) {
+				t.Fatal("数据没有被压缩")
+			}
+			path := filepath.Join(t.TempDir(), "test.rbs"+c.Ext())
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := OpenFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = f.Close() }()
+			r, err := NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Index()) != 3 || r.Duration() != 2500*time.Millisecond {
+				t.Fatalf("关键帧索引为%v，录屏时长为%v", r.Index(), r.Duration())
+			}
+			checkChunks(t, readAll(t, r), 0, len(testChunks))
+			// 读到末尾之后向前跳转需要重新解压
+			for _, s := range []struct {
+				ts   time.Duration
+				from int
+			}{{2 * time.Second, 6}, {1500 * time.Millisecond, 4}, {0, 0}} {
+				if _, err = r.Seek(s.ts); err != nil {
+					t.Fatal(err)
+				}
+				checkChunks(t, readAll(t, r), s.from, len(testChunks))
+			}
+		})
+	}
+}
+
+// TestCompressTruncated 录屏异常中断时压缩流没有结尾，已经Flush的数据块仍然可以读取
+func TestCompressTruncated(t *testing.T) {
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.rbs"+c.Ext())
+			if err := os.WriteFile(path, writeCompressed(t, c, testChunks[:6], false), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := OpenFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = f.Close() }()
+			r, err := NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Index()) != 2 {
+				t.Fatalf("重建的关键帧索引为%v", r.Index())
+			}
+			checkChunks(t, readAll(t, r), 0, 6)
+		})
+	}
+}
+
+// TestSegments 分段按序号排列，任意一个分段都能找到所有分段，按顺序作为一个连续的录屏读取
+func TestSegments(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "alice.rbs.zst")
+	// 每个分段都是完整的文件，以关键帧开始
+	parts := []struct{ from, to int }{{0, 4}, {4, 6}, {6, 8}}
+	for i, p := range parts {
+		data := writeCompressed(t, CompressionZstd, testChunks[p.from:p.to], true)
+		if err := os.WriteFile(SegmentPath(first, i), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 其他录屏及不是分段的文件
+	for _, name := range []string{"alice.rbs", "alice.bak.rbs.zst", "alice2.001.rbs.zst", "alice.001.rbs.gz"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := SegmentPath(first, 2); got != filepath.Join(dir, "alice.002.rbs.zst") {
+		t.Fatalf("第2个分段的路径为%s", got)
+	}
+	want := []string{first, filepath.Join(dir, "alice.001.rbs.zst"), filepath.Join(dir, "alice.002.rbs.zst")}
+	for _, path := range want {
+		paths, err := Segments(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != len(want) {
+			t.Fatalf("通过%s找到的分段为%v", path, paths)
+		}
+		for i := range want {
+			if paths[i] != want[i] {
+				t.Fatalf("通过%s找到的分段为%v，应该为%v", path, paths, want)
+			}
+		}
+	}
+
+	f, err := OpenFile(want[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	r, err := NewSegmentReader(f.Segments()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Index()) != 3 || r.Duration() != 2500*time.Millisecond {
+		t.Fatalf("关键帧索引为%v，录屏时长为%v", r.Index(), r.Duration())
+	}
+	// readSegments 读取剩余的数据块，直到最后一个分段结束
+	readSegments := func() []*Chunk {
+		var chunks []*Chunk
+		for {
+			chunk, err := r.Next()
+			if err == io.EOF {
+				return chunks
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+	checkChunks(t, readSegments(), 0, len(testChunks))
+
+	// 跳转到其他分段的关键帧，之后顺序读取到最后一个分段
+	if key, err := r.Seek(1700 * time.Millisecond); err != nil || key != time.Second {
+		t.Fatalf("跳转到关键帧%v %v", key, err)
+	}
+	checkChunks(t, readSegments(), 4, len(testChunks))
+	if key, err := r.Seek(0); err != nil || key != 0 {
+		t.Fatalf("跳转到关键帧%v %v", key, err)
+	}
+	checkChunks(t, readSegments(), 0, len(testChunks))
+
+	// 删除第一个分段后剩余的分段仍然可以读取
+	if err = os.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	if paths, err := Segments(want[2]); err != nil || len(paths) != 2 || paths[0] != want[1] {
+		t.Fatalf("删除第一个分段后找到的分段为%v %v", paths, err)
+	}
+}
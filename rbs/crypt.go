@@ -0,0 +1,315 @@
+package rbs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 加密的录屏文件格式:
+// 文件头: 8字节魔数 RBSCRYPT + 32字节随机盐，使用HKDF-SHA256从密钥和盐派生该文件的AES-GCM密钥。
+// 记录: 1字节标识 + 4字节密文长度 + 密文(包含16字节认证标签)，明文是加密前的录屏数据流(可能已经压缩)，
+// 每个记录的附加数据为 序号 + 标识 + 密文长度 + 上一个记录的认证标签，形成链式校验，
+// 任何记录被修改、删除、重排都会导致之后的记录校验失败。最后一个记录带有结束标识，没有结束记录说明文件被截断。
+const (
+	cryptMagic     = "RBSCRYPT"
+	cryptSaltLen   = 32
+	cryptHeaderLen = len(cryptMagic) + cryptSaltLen // 文件头的长度
+	cryptRecordLen = 1 + 4                          // 记录头的长度
+	cryptTagLen    = 16                             // 认证标签的长度
+	cryptMaxPlain  = 64 << 10                       // 单个记录的最大明文长度
+	cryptMaxCipher = cryptMaxPlain + cryptTagLen
+	cryptFlagLast  = uint8(1)    // 最后一个记录
+	cryptKeyInfo   = "rbs-crypt" // HKDF的info
+)
+
+// CorruptionError 录屏文件损坏或者被篡改
+type CorruptionError struct {
+	Offset int64  // 第一个损坏的位置在文件中的偏移
+	Reason string // 损坏的原因
+}
+
+func (that *CorruptionError) Error() string {
+	return fmt.Sprintf("录屏文件在偏移%d处校验失败:%s", that.Offset, that.Reason)
+}
+
+// ParseKey 解析加密密钥，支持16、24、32字节的AES密钥，可以是十六进制、base64或者原始字节，首尾的空白会被忽略
+func ParseKey(data []byte) ([]byte, error) {
+	s := strings.TrimSpace(string(data))
+	candidates := [][]byte{[]byte(s)}
+	if key, err := hex.DecodeString(s); err == nil {
+		candidates = append([][]byte{key}, candidates...)
+	} else if key, err = base64.StdEncoding.DecodeString(s); err == nil {
+		candidates = append([][]byte{key}, candidates...)
+	}
+	for _, key := range candidates {
+		switch len(key) {
+		case 16, 24, 32:
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("加密密钥的长度必须是16、24或者32字节")
+}
+
+// LoadKeyFile 从文件中读取加密密钥，格式参考 ParseKey
+func LoadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(data)
+}
+
+// LoadKeyEnv 从环境变量中读取加密密钥，格式参考 ParseKey
+func LoadKeyEnv(name string) ([]byte, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || len(value) == 0 {
+		return nil, fmt.Errorf("环境变量%s未设置", name)
+	}
+	return ParseKey([]byte(value))
+}
+
+// LoadKey 依次从文件及环境变量中读取加密密钥，都未设置时返回nil，表示不加密
+func LoadKey(file, env string) ([]byte, error) {
+	if len(file) > 0 {
+		return LoadKeyFile(file)
+	}
+	if len(env) > 0 {
+		return LoadKeyEnv(env)
+	}
+	return nil, nil
+}
+
+// newCryptAEAD 从密钥和盐派生该文件的AES-GCM
+func newCryptAEAD(key, salt []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("加密密钥的长度必须是16、24或者32字节")
+	}
+	fileKey, err := hkdf.Key(sha256.New, key, salt, cryptKeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cryptChain 记录的链式校验状态
+type cryptChain struct {
+	aead    cipher.AEAD
+	seq     uint64
+	prevTag []byte
+}
+
+// nonce 使用记录的序号作为nonce，每个文件的密钥都不同，所以不会重复
+func (that *cryptChain) nonce() []byte {
+	nonce := make([]byte, that.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], that.seq)
+	return nonce
+}
+
+func (that *cryptChain) additionalData(header []byte) []byte {
+	ad := make([]byte, 8, 8+len(header)+len(that.prevTag))
+	binary.BigEndian.PutUint64(ad, that.seq)
+	ad = append(ad, header...)
+	return append(ad, that.prevTag...)
+}
+
+// next 记录校验通过，更新链式校验状态
+func (that *cryptChain) next(ciphertext []byte) {
+	that.prevTag = append(that.prevTag[:0], ciphertext[len(ciphertext)-cryptTagLen:]...)
+	that.seq++
+}
+
+// EncryptWriter 把录屏数据流加密写入w，写入的数据缓存到一个记录的长度或者Flush时加密写入
+type EncryptWriter struct {
+	w      io.Writer
+	chain  *cryptChain
+	buf    []byte
+	closed bool
+}
+
+// NewEncryptWriter 创建加密写入器，立即写入文件头
+func NewEncryptWriter(w io.Writer, key []byte) (*EncryptWriter, error) {
+	salt := make([]byte, cryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newCryptAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(append([]byte(cryptMagic), salt...)); err != nil {
+		return nil, err
+	}
+	return &EncryptWriter{
+		w:     w,
+		chain: &cryptChain{aead: aead, prevTag: make([]byte, cryptTagLen)},
+		buf:   make([]byte, 0, cryptMaxPlain),
+	}, nil
+}
+
+func (that *EncryptWriter) Write(p []byte) (int, error) {
+	if that.closed {
+		return 0, fmt.Errorf("加密写入器已经关闭")
+	}
+	n := 0
+	for len(p) > 0 {
+		m := copy(that.buf[len(that.buf):cap(that.buf)], p)
+		that.buf = that.buf[:len(that.buf)+m]
+		p = p[m:]
+		n += m
+		if len(that.buf) == cap(that.buf) {
+			if err := that.writeRecord(0); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush 把缓存的数据作为一个记录加密写入
+func (that *EncryptWriter) Flush() error {
+	if that.closed || len(that.buf) == 0 {
+		return nil
+	}
+	return that.writeRecord(0)
+}
+
+// Close 写入剩余的数据及结束记录，并关闭w(如果实现了io.Closer)
+func (that *EncryptWriter) Close() error {
+	if that.closed {
+		return nil
+	}
+	err := that.writeRecord(cryptFlagLast)
+	that.closed = true
+	if c, ok := that.w.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// writeRecord 加密缓存的数据并写入一个记录
+func (that *EncryptWriter) writeRecord(flags uint8) error {
+	header := make([]byte, cryptRecordLen)
+	header[0] = flags
+	binary.BigEndian.PutUint32(header[1:], uint32(len(that.buf)+cryptTagLen))
+	ciphertext := that.chain.aead.Seal(nil, that.chain.nonce(), that.buf, that.chain.additionalData(header))
+	that.buf = that.buf[:0]
+	if _, err := that.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := that.w.Write(ciphertext); err != nil {
+		return err
+	}
+	that.chain.next(ciphertext)
+	return nil
+}
+
+// DecryptReader 读取并校验加密的录屏数据流，校验失败或者文件被截断时返回 CorruptionError ，不会返回未经校验的数据
+type DecryptReader struct {
+	r      io.Reader
+	chain  *cryptChain
+	offset int64  // 下一个记录在文件中的偏移
+	plain  []byte // 已经解密还未读取的数据
+	last   bool   // 已经读取到结束记录
+	err    error
+}
+
+// IsEncrypted 根据文件开头的魔数判断是否是加密的录屏
+func IsEncrypted(magic []byte) bool {
+	return bytes.HasPrefix(magic, []byte(cryptMagic))
+}
+
+// NewDecryptReader 读取文件头并创建解密读取器
+func NewDecryptReader(r io.Reader, key []byte) (*DecryptReader, error) {
+	header := make([]byte, cryptHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, &CorruptionError{Offset: 0, Reason: "文件头不完整"}
+	}
+	if !IsEncrypted(header) {
+		return nil, fmt.Errorf("不是加密的录屏文件")
+	}
+	aead, err := newCryptAEAD(key, header[len(cryptMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptReader{
+		r:      r,
+		chain:  &cryptChain{aead: aead, prevTag: make([]byte, cryptTagLen)},
+		offset: int64(cryptHeaderLen),
+	}, nil
+}
+
+func (that *DecryptReader) Read(p []byte) (int, error) {
+	for len(that.plain) == 0 {
+		if that.err != nil {
+			return 0, that.err
+		}
+		if that.last {
+			return 0, io.EOF
+		}
+		that.err = that.readRecord()
+	}
+	n := copy(p, that.plain)
+	that.plain = that.plain[n:]
+	return n, nil
+}
+
+// Offset 已经校验通过的数据在文件中的结束偏移
+func (that *DecryptReader) Offset() int64 {
+	return that.offset
+}
+
+// readRecord 读取并校验一个记录
+func (that *DecryptReader) readRecord() error {
+	header := make([]byte, cryptRecordLen)
+	if _, err := io.ReadFull(that.r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return &CorruptionError{Offset: that.offset, Reason: "文件被截断，缺少结束记录"}
+		}
+		return err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length < cryptTagLen || length > cryptMaxCipher || header[0]&^cryptFlagLast != 0 {
+		return &CorruptionError{Offset: that.offset, Reason: "记录头不正确"}
+	}
+	ciphertext := make([]byte, length)
+	if _, err := io.ReadFull(that.r, ciphertext); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return &CorruptionError{Offset: that.offset, Reason: "文件被截断，记录不完整"}
+		}
+		return err
+	}
+	plain, err := that.chain.aead.Open(nil, that.chain.nonce(), ciphertext, that.chain.additionalData(header))
+	if err != nil {
+		return &CorruptionError{Offset: that.offset, Reason: "认证失败，数据被修改或者密钥不正确"}
+	}
+	that.chain.next(ciphertext)
+	that.offset += int64(cryptRecordLen) + int64(length)
+	that.plain = plain
+	if header[0]&cryptFlagLast != 0 {
+		that.last = true
+		// 结束记录之后不应该还有数据
+		if n, _ := that.r.Read(make([]byte, 1)); n > 0 {
+			return &CorruptionError{Offset: that.offset, Reason: "结束记录之后还有多余的数据"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package rbs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testKey 测试使用的加密密钥
+var testKey = bytes.Repeat([]byte{0x42}, 16)
+
+// cryptRecords 加密文件中每个记录的偏移，最后一个是文件末尾
+func cryptRecords(t *testing.T, data []byte) []int64 {
+	t.Helper()
+	offsets := []int64{int64(cryptHeaderLen)}
+	for offset := cryptHeaderLen; offset < len(data); {
+		if offset+cryptRecordLen > len(data) {
+			t.Fatalf("偏移%d的记录头不完整", offset)
+		}
+		offset += cryptRecordLen + int(binary.BigEndian.Uint32(data[offset+1:]))
+		offsets = append(offsets, int64(offset))
+	}
+	return offsets
+}
+
+// writeEncrypted 把每一段数据作为一个记录加密，最后一段写入结束记录
+func writeEncrypted(t *testing.T, key []byte, parts ...[]byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := NewEncryptWriter(buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range parts {
+		if _, err = w.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		if i < len(parts)-1 {
+			if err = w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte{1}); err == nil {
+		t.Fatal("关闭后还能写入数据")
+	}
+	return buf.Bytes()
+}
+
+// moveRecord 把第from个记录移动到第to个记录的位置
+func moveRecord(data []byte, records []int64, from, to int) []byte {
+	var out []byte
+	out = append(out, data[:records[0]]...)
+	order := make([]int, 0, len(records)-1)
+	for i := 0; i < len(records)-1; i++ {
+		if i != from {
+			order = append(order, i)
+		}
+	}
+	order = append(order[:to], append([]int{from}, order[to:]...)...)
+	for _, i := range order {
+		out = append(out, data[records[i]:records[i+1]]...)
+	}
+	return out
+}
+
+// expectCorruption err是 CorruptionError ，并且偏移正确
+func expectCorruption(t *testing.T, err error, offset int64) {
+	t.Helper()
+	var ce *CorruptionError
+	if !errors.As(err, &ce) {
+		t.Fatalf("返回的错误%v不是CorruptionError", err)
+	}
+	if ce.Offset != offset {
+		t.Fatalf("损坏的偏移为%d，应该为%d: %v", ce.Offset, offset, ce)
+	}
+}
+
+// TestDecryptReader 修改、截断、删除、重排记录或者使用错误的密钥都会在第一个损坏的记录处返回 CorruptionError ，
+// 之前校验通过的数据可以读取，损坏的记录中的数据不会返回
+func TestDecryptReader(t *testing.T) {
+	parts := [][]byte{bytes.Repeat([]byte("a"), 100), bytes.Repeat([]byte("b"), 100), bytes.Repeat([]byte("c"), 50)}
+	plain := bytes.Join(parts, nil)
+	data := writeEncrypted(t, testKey, parts...)
+	records := cryptRecords(t, data)
+	if len(records) != 4 || records[3] != int64(len(data)) {
+		t.Fatalf("记录的偏移为%v，文件长度为%d", records, len(data))
+	}
+	if !IsEncrypted(data) || bytes.Contains(data, parts[0][:16]) {
+		t.Fatal("数据没有加密")
+	}
+	// flip 修改偏移处的一个字节
+	flip := func(offset int64) []byte {
+		out := append([]byte{}, data...)
+		out[offset] ^= 0x01
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		key    []byte
+		offset int64 // 损坏的偏移，为-1表示没有损坏
+		plain  int   // 能读取的明文长度
+	}{
+		{"没有损坏", data, testKey, -1, len(plain)},
+		{"修改密文", flip(records[1] + cryptRecordLen + 10), testKey, records[1], 100},
+		{"修改认证标签", flip(records[2] - 1), testKey, records[1], 100},
+		{"修改记录的长度", flip(records[0] + 4), testKey, records[0], 0},
+		{"修改记录的标识", flip(records[0]), testKey, records[0], 0},
+		{"修改盐", flip(int64(len(cryptMagic))), testKey, records[0], 0},
+		{"错误的密钥", data, bytes.Repeat([]byte{0x43}, 16), records[0], 0},
+		{"截断在记录头中", data[:records[1]+2], testKey, records[1], 100},
+		{"截断在密文中", data[:records[2]-1], testKey, records[1], 100},
+		{"删除结束记录", data[:records[2]], testKey, records[2], 200},
+		{"删除中间的记录", append(append([]byte{}, data[:records[1]]...), data[records[2]:]...), testKey, records[1], 100},
+		{"交换记录的顺序", moveRecord(data, records, 2, 1), testKey, records[1], 100},
+		{"重复记录", append(append([]byte{}, data[:records[2]]...), data[records[1]:]...), testKey, records[2], 200},
+		{"结束记录之后有多余的数据", append(append([]byte{}, data...), 0), testKey, records[3], len(plain)},
+		{"文件头不完整", data[:cryptHeaderLen-1], testKey, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewDecryptReader(bytes.NewReader(tt.data), tt.key)
+			var got []byte
+			if err == nil {
+				got, err = io.ReadAll(r)
+			}
+			if tt.offset < 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.Offset() != int64(len(data)) {
+					t.Fatalf("校验通过的偏移为%d", r.Offset())
+				}
+			} else {
+				expectCorruption(t, err, tt.offset)
+			}
+			if !bytes.Equal(got, plain[:tt.plain]) {
+				t.Fatalf("读取了%d字节的明文，应该为%d字节", len(got), tt.plain)
+			}
+		})
+	}
+
+	if _, err := NewDecryptReader(bytes.NewReader(bytes.Repeat([]byte{0}, cryptHeaderLen)), testKey); err == nil {
+		t.Fatal("不是加密的文件没有返回错误")
+	}
+	if _, err := NewEncryptWriter(&bytes.Buffer{}, []byte("short")); err == nil {
+		t.Fatal("密钥长度不正确没有返回错误")
+	}
+}
+
+// TestEncryptWriterRecords 超过单个记录最大长度的数据会分成多个记录，每个文件使用不同的盐
+func TestEncryptWriterRecords(t *testing.T) {
+	plain := bytes.Repeat([]byte("x"), 2*cryptMaxPlain+1)
+	data := writeEncrypted(t, testKey, plain)
+	if records := cryptRecords(t, data); len(records) != 4 {
+		t.Fatalf("%d字节的数据加密成了%d个记录", len(plain), len(records)-1)
+	}
+	r, err := NewDecryptReader(bytes.NewReader(data), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("解密的数据不正确:%v", err)
+	}
+	if other := writeEncrypted(t, testKey, plain); bytes.Equal(other[:cryptHeaderLen], data[:cryptHeaderLen]) {
+		t.Fatal("两个文件使用了相同的盐")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	// 原始字节的密钥不能是合法的十六进制或者base64
+	raw := []byte("!@#$%^&*()_+-=[]{};:,.<>?/|~0123")
+	tests := []struct {
+		name    string
+		input   string
+		want    []byte
+		wantErr bool
+	}{
+		{"十六进制", hex.EncodeToString(raw[:16]), raw[:16], false},
+		{"base64", base64.StdEncoding.EncodeToString(raw[:24]), raw[:24], false},
+		{"原始字节", string(raw), raw, false},
+		{"忽略首尾的空白", " " + hex.EncodeToString(raw) + "\n", raw, false},
+		{"长度不正确", "short", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey([]byte(tt.input))
+			if (err != nil) != tt.wantErr || !bytes.Equal(key, tt.want) {
+				t.Fatalf("解析的密钥为%x %v", key, err)
+			}
+		})
+	}
+}
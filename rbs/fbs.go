@@ -0,0 +1,320 @@
+package rbs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FBS格式(rfbproxy、vnc2flv、TightVNC等使用)录制的是vnc服务端发送的原始数据流，从协议版本握手开始，
+// 文件头之后是数据块: 4字节数据长度 + 数据(补齐到4字节的倍数) + 4字节相对录屏开始的时间(毫秒)。
+// 消息可能跨越多个数据块，需要把数据块拼接起来解析。
+//
+// vncrec格式的魔数之后同样是vnc服务端发送的原始数据流，每个帧缓冲更新消息之后写入8字节的时间(秒和微秒)，
+// 该时间是之后的消息收到的时间。
+const (
+	FBSVersion  = "FBS 001.000\n" // 写入FBS文件使用的版本
+	fbsMagic    = "FBS 001."      // 001.000及001.001等版本的数据块格式相同
+	VncrecMagic = "vncLog0.0"
+)
+
+// IsFBS 根据文件开头的数据判断是否是FBS格式
+func IsFBS(magic []byte) bool {
+	return bytes.HasPrefix(magic, []byte(fbsMagic))
+}
+
+// IsVncrec 根据文件开头的数据判断是否是vncrec格式
+func IsVncrec(magic []byte) bool {
+	return bytes.HasPrefix(magic, []byte(VncrecMagic))
+}
+
+// FBSReader 读取FBS格式的录屏，作为io.Reader使用时读取的是拼接后的vnc服务端数据流
+type FBSReader struct {
+	r         io.Reader
+	header    *Header
+	block     []byte        // 当前数据块中未读取的数据
+	timestamp time.Duration // 当前数据块的时间
+}
+
+// NewFBSReader 读取FBS文件头及握手数据，握手数据中的协议版本、桌面大小、像素格式及桌面名称保存在文件头中
+func NewFBSReader(r io.Reader) (*FBSReader, error) {
+	version := make([]byte, len(FBSVersion))
+	if _, err := io.ReadFull(r, version); err != nil {
+		return nil, err
+	}
+	if !IsFBS(version) || version[len(version)-1] != '\n' {
+		return nil, fmt.Errorf("不是FBS格式的文件:%q", version)
+	}
+	that := &FBSReader{r: r}
+	header, err := ReadServerInit(that)
+	if err != nil {
+		return nil, fmt.Errorf("读取FBS文件的握手数据失败:%v", err)
+	}
+	header.Version = string(version)
+	that.header = header
+	return that, nil
+}
+
+// Header 握手数据中的信息，FBS格式没有开始录屏的时间
+func (that *FBSReader) Header() *Header {
+	return that.header
+}
+
+// Timestamp 最近读取的数据所在数据块的时间。
+// 每次Read只读取一个数据块中的数据，读取完一个消息后返回的就是消息最后一部分数据收到的时间。
+func (that *FBSReader) Timestamp() time.Duration {
+	return that.timestamp
+}
+
+func (that *FBSReader) Read(p []byte) (int, error) {
+	for len(that.block) == 0 {
+		if err := that.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, that.block)
+	that.block = that.block[n:]
+	return n, nil
+}
+
+// readBlock 读取下一个数据块，文件末尾返回io.EOF
+func (that *FBSReader) readBlock() error {
+	var length uint32
+	if err := binary.Read(that.r, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	if length > maxChunkLen {
+		return fmt.Errorf("数据块太大:%d", length)
+	}
+	data := make([]byte, (length+3)&^3)
+	if _, err := io.ReadFull(that.r, data); err != nil {
+		return unexpectedEOF(err)
+	}
+	var ms uint32
+	if err := binary.Read(that.r, binary.BigEndian, &ms); err != nil {
+		return unexpectedEOF(err)
+	}
+	that.block = data[:length]
+	that.timestamp = time.Duration(ms) * time.Millisecond
+	return nil
+}
+
+// FBSWriter 写入FBS格式的录屏
+type FBSWriter struct {
+	w io.Writer
+}
+
+// NewFBSWriter 创建FBS格式的录屏，立即写入文件头及握手数据，安全认证类型为None
+func NewFBSWriter(w io.Writer, header *Header) (*FBSWriter, error) {
+	if _, err := io.WriteString(w, FBSVersion); err != nil {
+		return nil, err
+	}
+	that := &FBSWriter{w: w}
+	buf := &bytes.Buffer{}
+	if err := writeServerInit(buf, header); err != nil {
+		return nil, err
+	}
+	if err := that.WriteBlock(0, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return that, nil
+}
+
+// WriteBlock 写入一个数据块，ts为相对录屏开始的时间，精度为毫秒
+func (that *FBSWriter) WriteBlock(ts time.Duration, data []byte) error {
+	if len(data) > maxChunkLen {
+		return fmt.Errorf("数据块太大:%d", len(data))
+	}
+	buf := make([]byte, 4, 4+len(data)+3+4)
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	buf = append(buf, data...)
+	buf = append(buf, make([]byte, (4-len(data)%4)%4)...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(ts/time.Millisecond))
+	_, err := that.w.Write(buf)
+	return err
+}
+
+// ReadVncrecHeader 读取vncrec格式的魔数及握手数据，vncrec格式的开始时间在第一个帧缓冲更新之后
+func ReadVncrecHeader(r io.Reader) (*Header, error) {
+	magic := make([]byte, len(VncrecMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if !IsVncrec(magic) {
+		return nil, fmt.Errorf("不是vncrec格式的文件:%q", magic)
+	}
+	header, err := ReadServerInit(r)
+	if err != nil {
+		return nil, fmt.Errorf("读取vncrec文件的握手数据失败:%v", err)
+	}
+	header.Version = VncrecMagic
+	return header, nil
+}
+
+// ReadVncrecTime 读取vncrec格式在帧缓冲更新之后写入的时间
+func ReadVncrecTime(r io.Reader) (time.Time, error) {
+	var tv [2]uint32
+	if err := binary.Read(r, binary.BigEndian, &tv); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(tv[0]), int64(tv[1])*int64(time.Microsecond)), nil
+}
+
+// ReadServerInit 从vnc服务端的原始数据流中读取协议版本握手、安全认证及初始化消息。
+// 录制的数据中没有vnc客户端选择的安全认证类型，3.7及以上版本的协议有多个安全认证类型时，
+// 优先按VncAuth解析，其次是None。
+func ReadServerInit(r io.Reader) (*Header, error) {
+	version := make([]byte, len(rfb.ProtoVersion38))
+	if _, err := io.ReadFull(r, version); err != nil {
+		return nil, err
+	}
+	minor, err := protocolMinor(string(version))
+	if err != nil {
+		return nil, err
+	}
+	header := &Header{ProtocolVersion: string(version)}
+	var secType uint32
+	if minor < 7 {
+		// 3.3版本由vnc服务端决定安全认证类型
+		if err = binary.Read(r, binary.BigEndian, &secType); err != nil {
+			return nil, err
+		}
+		if secType == uint32(rfb.SecTypeUnknown) {
+			return nil, readReason(r)
+		}
+	} else {
+		var count uint8
+		if err = binary.Read(r, binary.BigEndian, &count); err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, readReason(r)
+		}
+		types := make([]byte, count)
+		if _, err = io.ReadFull(r, types); err != nil {
+			return nil, err
+		}
+		secType = uint32(types[0])
+		if bytes.IndexByte(types, byte(rfb.SecTypeVNC)) >= 0 {
+			secType = uint32(rfb.SecTypeVNC)
+		} else if bytes.IndexByte(types, byte(rfb.SecTypeNone)) >= 0 {
+			secType = uint32(rfb.SecTypeNone)
+		}
+	}
+	switch rfb.SecurityType(secType) {
+	case rfb.SecTypeNone:
+	case rfb.SecTypeVNC:
+		// 跳过16字节的随机数
+		if _, err = io.ReadFull(r, make([]byte, 16)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的安全认证类型:%d", secType)
+	}
+	// 3.8版本及VncAuth认证有认证结果
+	if secType == uint32(rfb.SecTypeVNC) || minor >= 8 {
+		var result uint32
+		if err = binary.Read(r, binary.BigEndian, &result); err != nil {
+			return nil, err
+		}
+		if result != 0 {
+			if minor >= 8 {
+				return nil, readReason(r)
+			}
+			return nil, fmt.Errorf("安全认证失败")
+		}
+	}
+	if err = binary.Read(r, binary.BigEndian, &header.Width); err != nil {
+		return nil, err
+	}
+	if err = binary.Read(r, binary.BigEndian, &header.Height); err != nil {
+		return nil, err
+	}
+	if err = binary.Read(r, binary.BigEndian, &header.PixelFormat); err != nil {
+		return nil, err
+	}
+	var nameLen uint32
+	if err = binary.Read(r, binary.BigEndian, &nameLen); err != nil {
+		return nil, err
+	}
+	if nameLen > 1<<16 {
+		return nil, fmt.Errorf("桌面名称太长:%d", nameLen)
+	}
+	header.DesktopName = make([]byte, nameLen)
+	if _, err = io.ReadFull(r, header.DesktopName); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// writeServerInit 写入协议版本握手、安全认证类型None及初始化消息
+func writeServerInit(w io.Writer, header *Header) error {
+	protocol := header.ProtocolVersion
+	minor, err := protocolMinor(protocol)
+	if err != nil {
+		protocol, minor = rfb.ProtoVersion38, 8
+	}
+	fields := []interface{}{[]byte(protocol)}
+	if minor < 7 {
+		fields = append(fields, uint32(rfb.SecTypeNone))
+	} else {
+		fields = append(fields, uint8(1), uint8(rfb.SecTypeNone))
+		if minor >= 8 {
+			fields = append(fields, uint32(0))
+		}
+	}
+	fields = append(fields,
+		header.Width,
+		header.Height,
+		header.PixelFormat,
+		uint32(len(header.DesktopName)),
+		header.DesktopName,
+	)
+	for _, f := range fields {
+		if err = binary.Write(w, binary.BigEndian, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protocolMinor 解析 "RFB 003.008\n" 格式的协议版本，返回次版本号
+func protocolMinor(version string) (int, error) {
+	if len(version) != len(rfb.ProtoVersion38) || !strings.HasPrefix(version, "RFB 003.") || version[len(version)-1] != '\n' {
+		return 0, fmt.Errorf("不支持的协议版本:%q", version)
+	}
+	minor, err := strconv.Atoi(version[8:11])
+	if err != nil {
+		return 0, fmt.Errorf("不支持的协议版本:%q", version)
+	}
+	return minor, nil
+}
+
+// readReason 读取vnc服务端拒绝连接的原因
+func readReason(r io.Reader) error {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	if length > 1<<16 {
+		return fmt.Errorf("vnc服务端拒绝了连接")
+	}
+	reason := make([]byte, length)
+	if _, err := io.ReadFull(r, reason); err != nil {
+		return err
+	}
+	return fmt.Errorf("vnc服务端拒绝了连接:%s", reason)
+}
+
+// unexpectedEOF 数据块读取到一半遇到文件末尾时返回io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
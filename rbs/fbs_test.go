@@ -0,0 +1,218 @@
+package rbs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// readTimed 读取n个字节，返回读取到的数据及最后一部分数据所在数据块的时间
+func readTimed(t *testing.T, r *FBSReader, n int) ([]byte, time.Duration) {
+	t.Helper()
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("读取%d字节失败:%v", n, err)
+	}
+	return buf, r.Timestamp()
+}
+
+// TestFBSReaderFixture 读取rfbproxy格式的录屏：3.3协议、VncAuth认证，帧缓冲更新消息跨两个数据块
+func TestFBSReaderFixture(t *testing.T) {
+	data, err := os.ReadFile("testdata/rfbproxy.fbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewFBSReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := r.Header()
+	if header.Version != "FBS 001.001\n" || header.ProtocolVersion != rfb.ProtoVersion33 {
+		t.Fatalf("版本为%q %q", header.Version, header.ProtocolVersion)
+	}
+	if header.Width != 800 || header.Height != 600 || string(header.DesktopName) != "hello" {
+		t.Fatalf("桌面为%dx%d %q", header.Width, header.Height, header.DesktopName)
+	}
+	pf := header.PixelFormat
+	if pf.BPP != 32 || pf.Depth != 24 || pf.TrueColor != 1 || pf.RedMax != 255 || pf.RedShift != 16 || pf.GreenShift != 8 || pf.BlueShift != 0 {
+		t.Fatalf("像素格式为%v", pf)
+	}
+
+	fbu := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 1, 2, 3, 0}
+	msg, ts := readTimed(t, r, len(fbu))
+	if !bytes.Equal(msg, fbu) || ts != 80*time.Millisecond {
+		t.Fatalf("帧缓冲更新消息为%v，时间%v", msg, ts)
+	}
+	msg, ts = readTimed(t, r, 1)
+	if msg[0] != byte(rfb.Bell) || ts != 120*time.Millisecond {
+		t.Fatalf("响铃消息为%v，时间%v", msg, ts)
+	}
+	if _, err = r.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("文件末尾返回%v", err)
+	}
+}
+
+// TestFBSReaderTruncated 数据块读取到一半遇到文件末尾
+func TestFBSReaderTruncated(t *testing.T) {
+	data, err := os.ReadFile("testdata/rfbproxy.fbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 去掉最后一个数据块的时间
+	r, err := NewFBSReader(bytes.NewReader(data[:len(data)-4]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readTimed(t, r, 20)
+	if _, err = r.Read(make([]byte, 1)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("返回%v", err)
+	}
+	if _, err = NewFBSReader(bytes.NewReader([]byte("RBS 001.000\n"))); err == nil {
+		t.Fatal("不是FBS格式的文件没有返回错误")
+	}
+}
+
+// TestFBSWriterRoundTrip 写入的录屏可以读取回来，数据块按4字节补齐
+func TestFBSWriterRoundTrip(t *testing.T) {
+	for _, protocol := range []string{rfb.ProtoVersion33, rfb.ProtoVersion37, rfb.ProtoVersion38} {
+		t.Run(protocol[4:11], func(t *testing.T) {
+			header := &Header{
+				ProtocolVersion: protocol,
+				Width:           1024,
+				Height:          768,
+				PixelFormat:     rfb.PixelFormat32bit,
+				DesktopName:     []byte("desktop"),
+			}
+			var buf bytes.Buffer
+			w, err := NewFBSWriter(&buf, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blocks := [][]byte{{1}, {2, 3}, {4, 5, 6}, {7, 8, 9, 10}, {11, 12, 13, 14, 15}}
+			for i, block := range blocks {
+				before := buf.Len()
+				if err = w.WriteBlock(time.Duration(i+1)*time.Second+time.Microsecond, block); err != nil {
+					t.Fatal(err)
+				}
+				if size := buf.Len() - before; size != 4+(len(block)+3)/4*4+4 {
+					t.Fatalf("%d字节的数据块写入了%d字节", len(block), size)
+				}
+			}
+
+			r, err := NewFBSReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := r.Header()
+			if got.Version != FBSVersion || got.ProtocolVersion != protocol || got.Width != 1024 || got.Height != 768 ||
+				got.PixelFormat != rfb.PixelFormat32bit || string(got.DesktopName) != "desktop" {
+				t.Fatalf("文件头为%+v", got)
+			}
+			for i, block := range blocks {
+				data, ts := readTimed(t, r, len(block))
+				if !bytes.Equal(data, block) || ts != time.Duration(i+1)*time.Second {
+					t.Fatalf("第%d个数据块为%v，时间%v", i, data, ts)
+				}
+			}
+			if _, err = r.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("文件末尾返回%v", err)
+			}
+		})
+	}
+}
+
+// TestReadServerInit 解析各协议版本的握手数据
+func TestReadServerInit(t *testing.T) {
+	serverInit := func(buf *bytes.Buffer) {
+		_ = binary.Write(buf, binary.BigEndian, uint16(64))
+		_ = binary.Write(buf, binary.BigEndian, uint16(48))
+		_ = binary.Write(buf, binary.BigEndian, rfb.PixelFormat32bit)
+		_ = binary.Write(buf, binary.BigEndian, uint32(1))
+		buf.WriteByte('x')
+	}
+	reason := func(buf *bytes.Buffer) {
+		_ = binary.Write(buf, binary.BigEndian, uint32(4))
+		buf.WriteString("busy")
+	}
+	challenge := make([]byte, 16)
+	cases := []struct {
+		name  string
+		build func(buf *bytes.Buffer)
+		err   string
+	}{
+		{"3.3 None", func(buf *bytes.Buffer) {
+			buf.WriteString(rfb.ProtoVersion33)
+			_ = binary.Write(buf, binary.BigEndian, uint32(rfb.SecTypeNone))
+			serverInit(buf)
+		}, ""},
+		{"3.3 VncAuth", func(buf *bytes.Buffer) {
+			buf.WriteString(rfb.ProtoVersion33)
+			_ = binary.Write(buf, binary.BigEndian, uint32(rfb.SecTypeVNC))
+			buf.Write(challenge)
+			_ = binary.Write(buf, binary.BigEndian, uint32(0))
+			serverInit(buf)
+		}, ""},
+		{"3.3 拒绝连接", func(buf *bytes.Buffer) {
+			buf.WriteString(rfb.ProtoVersion33)
+			_ = binary.Write(buf, binary.BigEndian, uint32(rfb.SecTypeUnknown))
+			reason(buf)
+		}, "vnc服务端拒绝了连接:busy"},
+		{"3.7 None", func(buf *bytes.Buffer) {
+			buf.WriteString(rfb.ProtoVersion37)
+			buf.Write([]byte{1, byte(rfb.SecTypeNone)})
+			serverInit(buf)
+		}, ""},
+		{"3.8 优先VncAuth", func(buf *bytes.Buffer) {
+			buf.WriteString(rfb.ProtoVersion38)
+			buf.Write([]byte{2, byte(rfb.SecTypeNone), byte(rfb.SecTypeVNC)})
+			buf.Write(challenge)
+			_ = binary.Write(buf, binary.BigEndian, uint32(0))
+			serverInit(buf)
+		}, ""},
+		{"3.8 认证失败", func(buf *bytes.Buffer) {
+			buf.WriteString(rfb.ProtoVersion38)
+			buf.Write([]byte{1, byte(rfb.SecTypeVNC)})
+			buf.Write(challenge)
+			_ = binary.Write(buf, binary.BigEndian, uint32(1))
+			reason(buf)
+		}, "vnc服务端拒绝了连接:busy"},
+		{"3.8 不支持的认证类型", func(buf *bytes.Buffer) {
+			buf.WriteString(rfb.ProtoVersion38)
+			buf.Write([]byte{1, byte(rfb.SecTypeTight)})
+		}, "不支持的安全认证类型:16"},
+		{"不支持的协议版本", func(buf *bytes.Buffer) {
+			buf.WriteString("RFB 004.001\n")
+		}, `不支持的协议版本:"RFB 004.001\n"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			c.build(&buf)
+			header, err := ReadServerInit(&buf)
+			if len(c.err) > 0 {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("返回%v，应该返回%s", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Width != 64 || header.Height != 48 || string(header.DesktopName) != "x" || buf.Len() != 0 {
+				t.Fatalf("文件头为%+v，剩余%d字节", header, buf.Len())
+			}
+		})
+	}
+
+	// 数据不完整
+	var buf bytes.Buffer
+	cases[1].build(&buf)
+	if _, err := ReadServerInit(bytes.NewReader(buf.Bytes()[:buf.Len()-3])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("数据不完整返回%v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package rbs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"time"
+)
+
+// rbs录屏文件的版本
+// v1格式: 文件头之后是交替出现的帧缓冲更新消息和播放该消息后需要等待的时长(纳秒)，只能从头顺序播放。
+// v2格式: 文件头之后是数据块，每个数据块带有相对录屏开始的时间，定期写入关键帧，文件末尾是关键帧索引，可以快速跳转。
+const (
+	Version1 = "RBS 001.001\n"
+	Version2 = "RBS 002.000\n"
+)
+
+// ChunkType v2格式数据块的类型
+type ChunkType uint8
+
+const (
+	ChunkFramebufferUpdate ChunkType = 1 // vnc服务端发送的帧缓冲更新消息
+	ChunkKeyframe          ChunkType = 2 // 关键帧，使用Raw编码的整个画面，跳转时从关键帧开始绘制
+	ChunkIndex             ChunkType = 3 // 关键帧索引，位于文件末尾
+	ChunkServerMessage     ChunkType = 4 // vnc服务端发送的其他消息，例如响铃、剪贴板、颜色地图
+	ChunkClientMessage     ChunkType = 5 // vnc客户端发送的消息，例如按键、鼠标、剪贴板
+)
+
+func (that ChunkType) String() string {
+	switch that {
+	case ChunkFramebufferUpdate:
+		return "FramebufferUpdate"
+	case ChunkKeyframe:
+		return "Keyframe"
+	case ChunkIndex:
+		return "Index"
+	case ChunkServerMessage:
+		return "ServerMessage"
+	case ChunkClientMessage:
+		return "ClientMessage"
+	}
+	return fmt.Sprintf("ChunkType(%d)", uint8(that))
+}
+
+// 数据块的标识
+const (
+	FlagDeflate  uint8 = 1 << 0 // 数据使用deflate压缩
+	FlagRedacted uint8 = 1 << 1 // 消息内容已经脱敏，例如按键和剪贴板内容被清空，只保留时间
+)
+
+const (
+	chunkHeaderLen = 14         // 数据块头的长度: 1字节类型 + 1字节标识 + 8字节时间 + 4字节长度
+	footerLen      = 16         // 文件尾的长度: 8字节索引块的偏移 + 8字节魔数
+	footerMagic    = "RBSINDEX" // 文件尾的魔数
+	maxChunkLen    = 256 << 20  // 单个数据块的最大长度，防止读取损坏的文件时分配过多内存
+)
+
+// Header 录屏文件头
+type Header struct {
+	Version         string          // rbs格式版本
+	ProtocolVersion string          // rfb协议版本
+	Width           uint16          // 桌面宽度
+	Height          uint16          // 桌面高度
+	PixelFormat     rfb.PixelFormat // 录屏数据使用的像素格式
+	DesktopName     []byte          // 桌面名称
+	StartTime       time.Time       // 开始录屏的时间，v1格式没有该字段
+}
+
+// Chunk v2格式的数据块
+type Chunk struct {
+	Type      ChunkType
+	Flags     uint8
+	Timestamp time.Duration // 相对录屏开始的时间
+	Offset    int64         // 数据块在文件中的偏移
+	Data      []byte        // 解压后的数据
+}
+
+// IndexEntry 关键帧索引
+type IndexEntry struct {
+	Timestamp time.Duration // 关键帧相对录屏开始的时间
+	Offset    int64         // 关键帧数据块在文件中的偏移
+}
+
+// Size 文件头的长度，即第一个消息或者数据块的偏移
+func (that *Header) Size() int64 {
+	n := int64(len(Version2)+len(rfb.ProtoVersion38)) + 2 + 2 + int64(binary.Size(that.PixelFormat)) + 4 + int64(len(that.DesktopName))
+	if that.Version == Version1 {
+		// 安全认证类型
+		return n + 4
+	}
+	// 开始录屏的时间
+	return n + 8
+}
+
+// ReadHeader 读取v1或者v2格式的文件头
+func ReadHeader(r io.Reader) (*Header, error) {
+	header := &Header{}
+	version := make([]byte, len(Version1))
+	if _, err := io.ReadFull(r, version); err != nil {
+		return nil, err
+	}
+	header.Version = string(version)
+	if header.Version != Version1 && header.Version != Version2 {
+		return nil, fmt.Errorf("不支持的rbs文件版本:%q", version)
+	}
+	protocol := make([]byte, len(rfb.ProtoVersion38))
+	if _, err := io.ReadFull(r, protocol); err != nil {
+		return nil, err
+	}
+	header.ProtocolVersion = string(protocol)
+	if header.Version == Version1 {
+		// v1格式在协议版本之后固定写入安全认证类型None
+		var secType int32
+		if err := binary.Read(r, binary.BigEndian, &secType); err != nil {
+			return nil, err
+		}
+	}
+	if err := binary.Read(r, binary.BigEndian, &header.Width); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &header.Height); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &header.PixelFormat); err != nil {
+		return nil, err
+	}
+	var nameLen uint32
+	if err := binary.Read(r, binary.BigEndian, &nameLen); err != nil {
+		return nil, err
+	}
+	if nameLen > 1<<16 {
+		return nil, fmt.Errorf("桌面名称太长:%d", nameLen)
+	}
+	header.DesktopName = make([]byte, nameLen)
+	if _, err := io.ReadFull(r, header.DesktopName); err != nil {
+		return nil, err
+	}
+	if header.Version == Version2 {
+		var start int64
+		if err := binary.Read(r, binary.BigEndian, &start); err != nil {
+			return nil, err
+		}
+		header.StartTime = time.Unix(0, start)
+	}
+	return header, nil
+}
+
+// writeHeader 写入v2格式的文件头，返回写入的长度
+func writeHeader(w io.Writer, header *Header) (int64, error) {
+	protocol := header.ProtocolVersion
+	if len(protocol) != len(rfb.ProtoVersion38) {
+		protocol = rfb.ProtoVersion38
+	}
+	fields := []interface{}{
+		[]byte(Version2),
+		[]byte(protocol),
+		header.Width,
+		header.Height,
+		header.PixelFormat,
+		uint32(len(header.DesktopName)),
+		header.DesktopName,
+		header.StartTime.UnixNano(),
+	}
+	var n int64
+	for _, f := range fields {
+		if err := binary.Write(w, binary.BigEndian, f); err != nil {
+			return n, err
+		}
+		n += int64(binary.Size(f))
+	}
+	return n, nil
+}
@@ -0,0 +1,226 @@
+package rbs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Reader 读取v2格式的录屏文件
+// 优先使用文件末尾的关键帧索引，录屏异常中断没有写入索引时，打开文件会扫描一遍数据块重建索引。
+type Reader struct {
+	r          io.ReadSeeker
+	br         *bufio.Reader
+	header     *Header
+	dataOffset int64         // 第一个数据块的偏移
+	endOffset  int64         // 数据块结束的偏移
+	offset     int64         // 下一个数据块的偏移
+	index      []IndexEntry  // 关键帧索引，按时间排序
+	duration   time.Duration // 录屏时长
+}
+
+// NewReader 打开v2格式的录屏文件
+func NewReader(r io.ReadSeeker) (*Reader, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	header, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if header.Version != Version2 {
+		return nil, fmt.Errorf("不是v2格式的rbs文件:%q", header.Version)
+	}
+	that := &Reader{r: r, br: br, header: header, dataOffset: header.Size()}
+	if err = that.loadIndex(); err != nil {
+		if err = that.rebuildIndex(); err != nil {
+			return nil, err
+		}
+	}
+	if err = that.seekOffset(that.dataOffset); err != nil {
+		return nil, err
+	}
+	return that, nil
+}
+
+// Header 文件头
+func (that *Reader) Header() *Header {
+	return that.header
+}
+
+// Index 关键帧索引
+func (that *Reader) Index() []IndexEntry {
+	return that.index
+}
+
+// Duration 录屏时长
+func (that *Reader) Duration() time.Duration {
+	return that.duration
+}
+
+// Next 读取下一个数据块，读取到文件末尾返回io.EOF
+func (that *Reader) Next() (*Chunk, error) {
+	if that.offset >= that.endOffset {
+		return nil, io.EOF
+	}
+	chunk, err := that.readChunk()
+	if err != nil {
+		return nil, err
+	}
+	if chunk.Flags&FlagDeflate != 0 {
+		if chunk.Data, err = inflate(chunk.Data); err != nil {
+			return nil, fmt.Errorf("解压数据块失败:%v", err)
+		}
+	}
+	return chunk, nil
+}
+
+// Seek 跳转到ts之前最近的关键帧，返回关键帧的时间，之后Next会从该关键帧开始读取
+// ts之前没有关键帧则跳转到第一个数据块，返回0
+func (that *Reader) Seek(ts time.Duration) (time.Duration, error) {
+	i := sort.Search(len(that.index), func(i int) bool {
+		return that.index[i].Timestamp > ts
+	}) - 1
+	if i < 0 {
+		return 0, that.seekOffset(that.dataOffset)
+	}
+	return that.index[i].Timestamp, that.seekOffset(that.index[i].Offset)
+}
+
+// seekOffset 跳转到指定偏移
+func (that *Reader) seekOffset(offset int64) error {
+	if _, err := that.r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	that.br.Reset(that.r)
+	that.offset = offset
+	return nil
+}
+
+// readChunk 读取当前偏移的数据块，不解压
+func (that *Reader) readChunk() (*Chunk, error) {
+	header := make([]byte, chunkHeaderLen)
+	if _, err := io.ReadFull(that.br, header); err != nil {
+		return nil, err
+	}
+	chunk := &Chunk{
+		Type:      ChunkType(header[0]),
+		Flags:     header[1],
+		Timestamp: time.Duration(binary.BigEndian.Uint64(header[2:])),
+		Offset:    that.offset,
+	}
+	length := binary.BigEndian.Uint32(header[10:])
+	if length > maxChunkLen {
+		return nil, fmt.Errorf("数据块太大:%d", length)
+	}
+	chunk.Data = make([]byte, length)
+	if _, err := io.ReadFull(that.br, chunk.Data); err != nil {
+		return nil, err
+	}
+	that.offset += int64(chunkHeaderLen) + int64(length)
+	return chunk, nil
+}
+
+// loadIndex 读取文件尾及关键帧索引
+func (that *Reader) loadIndex() error {
+	size, err := that.r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size < that.dataOffset+chunkHeaderLen+footerLen {
+		return errors.New("rbs文件没有索引")
+	}
+	footer := make([]byte, footerLen)
+	if _, err = that.r.Seek(size-footerLen, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.ReadFull(that.r, footer); err != nil {
+		return err
+	}
+	if string(footer[8:]) != footerMagic {
+		return errors.New("rbs文件没有索引")
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(footer))
+	if indexOffset < that.dataOffset || indexOffset >= size-footerLen {
+		return errors.New("rbs文件的索引偏移不正确")
+	}
+	if err = that.seekOffset(indexOffset); err != nil {
+		return err
+	}
+	chunk, err := that.readChunk()
+	if err != nil {
+		return err
+	}
+	if chunk.Type != ChunkIndex {
+		return errors.New("rbs文件的索引偏移不正确")
+	}
+	r := bytes.NewReader(chunk.Data)
+	var duration int64
+	var count uint32
+	if err = binary.Read(r, binary.BigEndian, &duration); err != nil {
+		return err
+	}
+	if err = binary.Read(r, binary.BigEndian, &count); err != nil {
+		return err
+	}
+	if int(count) > r.Len()/16 {
+		return errors.New("rbs文件的索引不完整")
+	}
+	index := make([]IndexEntry, count)
+	for i := range index {
+		var ts int64
+		_ = binary.Read(r, binary.BigEndian, &ts)
+		_ = binary.Read(r, binary.BigEndian, &index[i].Offset)
+		index[i].Timestamp = time.Duration(ts)
+	}
+	that.index = index
+	that.duration = time.Duration(duration)
+	that.endOffset = indexOffset
+	return nil
+}
+
+// rebuildIndex 扫描所有数据块重建索引，文件末尾不完整的数据块会被忽略
+func (that *Reader) rebuildIndex() error {
+	if err := that.seekOffset(that.dataOffset); err != nil {
+		return err
+	}
+	that.index = nil
+	that.duration = 0
+	for {
+		offset := that.offset
+		chunk, err := that.readChunk()
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				that.endOffset = offset
+				return nil
+			}
+			return err
+		}
+		if chunk.Type == ChunkIndex {
+			that.endOffset = offset
+			return nil
+		}
+		if chunk.Type == ChunkKeyframe {
+			that.index = append(that.index, IndexEntry{Timestamp: chunk.Timestamp, Offset: chunk.Offset})
+		}
+		if chunk.Timestamp > that.duration {
+			that.duration = chunk.Timestamp
+		}
+	}
+}
+
+// inflate 解压数据
+func inflate(data []byte) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(data))
+	defer func() {
+		_ = fr.Close()
+	}()
+	return io.ReadAll(fr)
+}
@@ -0,0 +1,247 @@
+package rbs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"testing"
+	"time"
+)
+
+// testChunk 测试录屏中的数据块
+type testChunk struct {
+	typ   ChunkType
+	flags uint8
+	ts    time.Duration
+	data  []byte
+}
+
+// testChunks 测试录屏的数据块，0、1s、2s三个关键帧，关键帧之间有帧缓冲更新及其他消息
+var testChunks = []testChunk{
+	{ChunkKeyframe, 0, 0, bytes.Repeat([]byte("key0"), 256)},
+	{ChunkFramebufferUpdate, 0, 10 * time.Millisecond, []byte("update1")},
+	{ChunkServerMessage, 0, 20 * time.Millisecond, []byte{2}},
+	{ChunkClientMessage, FlagRedacted, 30 * time.Millisecond, []byte{4, 1, 0, 0, 0, 0, 0, 0}},
+	{ChunkKeyframe, 0, time.Second, bytes.Repeat([]byte("key1"), 256)},
+	{ChunkFramebufferUpdate, 0, 1500 * time.Millisecond, []byte("update2")},
+	{ChunkKeyframe, 0, 2 * time.Second, bytes.Repeat([]byte("key2"), 256)},
+	{ChunkFramebufferUpdate, 0, 2500 * time.Millisecond, []byte("update3")},
+}
+
+// testHeader 测试录屏的文件头
+func testHeader() Header {
+	return Header{
+		ProtocolVersion: rfb.ProtoVersion38,
+		Width:           640,
+		Height:          480,
+		PixelFormat:     rfb.PixelFormat32bit,
+		DesktopName:     []byte("test"),
+		StartTime:       time.Unix(1700000000, 123),
+	}
+}
+
+// writeTestRecording 写入v2格式的测试录屏，返回文件内容及写入时的关键帧索引
+func writeTestRecording(t *testing.T) ([]byte, []IndexEntry) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, testHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range testChunks {
+		// 压缩标识由写入时决定，传入的会被忽略
+		if err = w.WriteChunkFlags(c.typ, c.flags|FlagDeflate, c.ts, c.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("重复关闭返回%v", err)
+	}
+	if err = w.WriteChunk(ChunkFramebufferUpdate, 3*time.Second, nil); err == nil {
+		t.Fatal("关闭后还能写入数据块")
+	}
+	if w.Duration() != 2500*time.Millisecond {
+		t.Fatalf("录屏时长为%v", w.Duration())
+	}
+	return buf.Bytes(), w.Index()
+}
+
+// readAll 读取剩余的数据块，直到io.EOF
+func readAll(t *testing.T, r *Reader) []*Chunk {
+	t.Helper()
+	var chunks []*Chunk
+	for {
+		chunk, err := r.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+// checkChunks 读取的数据块与testChunks[from:to]一致
+func checkChunks(t *testing.T, chunks []*Chunk, from, to int) {
+	t.Helper()
+	want := testChunks[from:to]
+	if len(chunks) != len(want) {
+		t.Fatalf("读取了%d个数据块，应该为%d个", len(chunks), len(want))
+	}
+	for i, c := range want {
+		flags := c.flags
+		if c.typ == ChunkKeyframe {
+			flags |= FlagDeflate
+		}
+		got := chunks[i]
+		if got.Type != c.typ || got.Flags != flags || got.Timestamp != c.ts || !bytes.Equal(got.Data, c.data) {
+			t.Fatalf("第%d个数据块为%v %d %v %q", from+i, got.Type, got.Flags, got.Timestamp, got.Data)
+		}
+	}
+}
+
+// TestWriterReaderRoundTrip 写入的文件头、数据块、关键帧索引及时长都能读回
+func TestWriterReaderRoundTrip(t *testing.T) {
+	data, index := writeTestRecording(t)
+	// 文件尾为索引块的偏移及魔数
+	if string(data[len(data)-8:]) != footerMagic {
+		t.Fatalf("文件尾为%q", data[len(data)-footerLen:])
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(data[len(data)-footerLen:]))
+	if ChunkType(data[indexOffset]) != ChunkIndex {
+		t.Fatalf("索引偏移%d的数据块类型为%v", indexOffset, ChunkType(data[indexOffset]))
+	}
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, want := r.Header(), testHeader()
+	if header.Version != Version2 || header.ProtocolVersion != want.ProtocolVersion || header.Width != want.Width ||
+		header.Height != want.Height || header.PixelFormat != want.PixelFormat ||
+		!bytes.Equal(header.DesktopName, want.DesktopName) || !header.StartTime.Equal(want.StartTime) {
+		t.Fatalf("文件头为%+v", header)
+	}
+	if r.Duration() != 2500*time.Millisecond {
+		t.Fatalf("录屏时长为%v", r.Duration())
+	}
+	if len(r.Index()) != 3 || len(index) != 3 {
+		t.Fatalf("关键帧索引为%v，写入时为%v", r.Index(), index)
+	}
+	for i, entry := range r.Index() {
+		if entry != index[i] || entry.Timestamp != time.Duration(i)*time.Second {
+			t.Fatalf("第%d个关键帧索引为%+v，写入时为%+v", i, entry, index[i])
+		}
+	}
+
+	chunks := readAll(t, r)
+	checkChunks(t, chunks, 0, len(testChunks))
+	if chunks[0].Offset != header.Size() {
+		t.Fatalf("第一个数据块的偏移为%d，文件头长度为%d", chunks[0].Offset, header.Size())
+	}
+	for i, pos := range []int{0, 4, 6} {
+		if chunks[pos].Offset != index[i].Offset {
+			t.Fatalf("第%d个关键帧的偏移为%d，索引中为%d", i, chunks[pos].Offset, index[i].Offset)
+		}
+	}
+}
+
+// TestReaderSeek 跳转到指定时间之前最近的关键帧
+func TestReaderSeek(t *testing.T) {
+	data, _ := writeTestRecording(t)
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		ts   time.Duration
+		key  time.Duration // 跳转到的关键帧时间
+		from int           // 跳转后读取的第一个数据块
+	}{
+		{"开始位置", 0, 0, 0},
+		{"第一个关键帧之前", -time.Second, 0, 0},
+		{"两个关键帧之间", 999 * time.Millisecond, 0, 0},
+		{"正好是关键帧", time.Second, time.Second, 4},
+		{"关键帧之后", 1700 * time.Millisecond, time.Second, 4},
+		{"最后一个关键帧", 2 * time.Second, 2 * time.Second, 6},
+		{"超出录屏时长", time.Hour, 2 * time.Second, 6},
+	}
+	// 按随机顺序跳转，包括读到文件末尾后再向前跳转
+	for _, i := range []int{6, 0, 3, 1, 5, 2, 4} {
+		c := cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			key, err := r.Seek(c.ts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key != c.key {
+				t.Fatalf("跳转到的关键帧时间为%v，应该为%v", key, c.key)
+			}
+			checkChunks(t, readAll(t, r), c.from, len(testChunks))
+		})
+	}
+}
+
+// TestReaderTruncated 录屏异常中断没有写入索引或者文件尾损坏时，扫描数据块重建索引
+func TestReaderTruncated(t *testing.T) {
+	data, index := writeTestRecording(t)
+	indexOffset := int(binary.BigEndian.Uint64(data[len(data)-footerLen:]))
+	header := testHeader()
+	headerLen := int(header.Size())
+	badOffset := append([]byte{}, data...)
+	binary.BigEndian.PutUint64(badOffset[len(badOffset)-footerLen:], uint64(headerLen+1))
+	badMagic := append([]byte{}, data...)
+	badMagic[len(badMagic)-1] ^= 0xFF
+	cases := []struct {
+		name     string
+		data     []byte
+		chunks   int           // 能读取的数据块数量
+		keys     int           // 重建的关键帧数量
+		duration time.Duration // 重建的录屏时长
+	}{
+		{"缺少文件尾", data[:len(data)-footerLen], 8, 3, 2500 * time.Millisecond},
+		{"缺少索引及文件尾", data[:indexOffset], 8, 3, 2500 * time.Millisecond},
+		{"文件尾不完整", data[:len(data)-3], 8, 3, 2500 * time.Millisecond},
+		{"文件尾的魔数不正确", badMagic, 8, 3, 2500 * time.Millisecond},
+		{"索引偏移不正确", badOffset, 8, 3, 2500 * time.Millisecond},
+		{"最后一个数据块不完整", data[:indexOffset-3], 7, 3, 2 * time.Second},
+		{"最后一个数据块头不完整", data[:indexOffset-len("update3")-5], 7, 3, 2 * time.Second},
+		{"只有文件头", data[:headerLen], 0, 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(c.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Index()) != c.keys || r.Duration() != c.duration {
+				t.Fatalf("重建的关键帧索引为%v，录屏时长为%v", r.Index(), r.Duration())
+			}
+			for i, entry := range r.Index() {
+				if entry != index[i] {
+					t.Fatalf("重建的第%d个关键帧索引为%+v，写入时为%+v", i, entry, index[i])
+				}
+			}
+			checkChunks(t, readAll(t, r), 0, c.chunks)
+			if c.keys > 0 {
+				if key, err := r.Seek(1500 * time.Millisecond); err != nil || key != time.Second {
+					t.Fatalf("跳转到关键帧%v %v", key, err)
+				}
+				checkChunks(t, readAll(t, r), 4, c.chunks)
+			}
+		})
+	}
+
+	// 文件头不完整或者不是v2格式
+	for _, bad := range [][]byte{data[:headerLen-1], append([]byte(Version1), data[len(Version2):]...)} {
+		if _, err := NewReader(bytes.NewReader(bad)); err == nil {
+			t.Fatal("打开不正确的文件没有返回错误")
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package rbs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retention 录屏目录的保留策略，从最旧(修改时间最早)的录屏文件开始删除，直到满足所有限制，为0的限制不生效。
+// 每个分段都是单独的文件，删除了前面分段的录屏仍然可以播放剩余的分段。
+type Retention struct {
+	MaxAge   time.Duration // 录屏文件的最长保留时间
+	MaxSize  int64         // 录屏文件的总大小
+	MaxFiles int           // 录屏文件的数量
+}
+
+// Enabled 是否设置了任意一个限制
+func (that Retention) Enabled() bool {
+	return that.MaxAge > 0 || that.MaxSize > 0 || that.MaxFiles > 0
+}
+
+// Apply 删除dir目录中超出保留策略的录屏文件(.rbs、.rbs.gz、.rbs.zst)，返回被删除的文件，
+// exclude中的文件不会被删除，例如正在写入的录屏
+func (that Retention) Apply(dir string, exclude ...string) ([]string, error) {
+	if !that.Enabled() {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type recording struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []recording
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || !IsRecordingFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, recording{path: filepath.Join(dir, entry.Name()), size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	excluded := map[string]bool{}
+	for _, p := range exclude {
+		excluded[filepath.Clean(p)] = true
+	}
+	var removed []string
+	count := len(files)
+	for _, f := range files {
+		expired := that.MaxAge > 0 && time.Since(f.modTime) > that.MaxAge
+		if !expired && (that.MaxSize <= 0 || total <= that.MaxSize) && (that.MaxFiles <= 0 || count <= that.MaxFiles) {
+			break
+		}
+		if excluded[filepath.Clean(f.path)] {
+			continue
+		}
+		if err = os.Remove(f.path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, f.path)
+		total -= f.size
+		count--
+	}
+	return removed, nil
+}
+
+// IsRecordingFile 根据扩展名判断是否是录屏文件，包括压缩的录屏文件
+func IsRecordingFile(name string) bool {
+	_, ext := splitSegmentPath(name)
+	return strings.TrimSuffix(strings.TrimSuffix(ext, CompressionGzip.Ext()), CompressionZstd.Ext()) == ".rbs"
+}
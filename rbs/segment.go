@@ -0,0 +1,293 @@
+package rbs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 分段录屏的文件名: 第一段使用原文件名，之后的分段在扩展名前加上3位以上的序号，
+// 例如 foo.rbs.zst, foo.001.rbs.zst, foo.002.rbs.zst。
+// 每个分段都是完整的v2格式文件，以关键帧开始，时间相对于整个录屏的开始时间，按序号连起来就是完整的录屏。
+
+// SegmentPath 第n个分段的文件路径，path为第一段的文件路径
+func SegmentPath(path string, n int) string {
+	if n <= 0 {
+		return path
+	}
+	stem, ext := splitSegmentPath(path)
+	return fmt.Sprintf("%s.%03d%s", stem, n, ext)
+}
+
+// Segments 按顺序列出录屏的所有分段，path可以是任意一个分段的文件路径。
+// 被保留策略删除的分段会被跳过，剩余的分段仍然可以播放。
+func Segments(path string) ([]string, error) {
+	stem, ext := splitSegmentPath(path)
+	if segmentNumber(filepath.Ext(stem)) > 0 {
+		stem = strings.TrimSuffix(stem, filepath.Ext(stem))
+	}
+	entries, err := os.ReadDir(filepath.Dir(stem))
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(stem)
+	numbers := map[string]int{}
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		middle := strings.TrimSuffix(strings.TrimPrefix(name, base), ext)
+		n := 0
+		if len(middle) > 0 {
+			if n = segmentNumber(middle); n <= 0 {
+				continue
+			}
+		}
+		p := filepath.Join(filepath.Dir(stem), name)
+		numbers[p] = n
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("要读取的文件[%s]不存在", path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return numbers[paths[i]] < numbers[paths[j]]
+	})
+	return paths, nil
+}
+
+// splitSegmentPath 把文件路径拆分成不带扩展名的部分及扩展名(包括压缩文件的扩展名)
+func splitSegmentPath(path string) (string, string) {
+	stem, cext := path, ""
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		if strings.HasSuffix(path, c.Ext()) {
+			stem, cext = strings.TrimSuffix(path, c.Ext()), c.Ext()
+			break
+		}
+	}
+	ext := filepath.Ext(stem)
+	return strings.TrimSuffix(stem, ext), ext + cext
+}
+
+// segmentNumber 解析 .001 格式的分段序号，不是分段序号返回0
+func segmentNumber(s string) int {
+	if len(s) < 4 || s[0] != '.' {
+		return 0
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return 0
+		}
+	}
+	n, _ := strconv.Atoi(s[1:])
+	return n
+}
+
+// File 打开的录屏文件，包括所有分段，压缩的分段会自动解压。
+// 作为io.ReadSeeker使用时读取的是第一个分段，v2格式通过 Segments 获取所有分段。
+type File struct {
+	segments []io.ReadSeekCloser
+}
+
+// Segmented 包含多个分段的录屏
+type Segmented interface {
+	Segments() []io.ReadSeeker
+}
+
+var _ Segmented = new(File)
+
+// OpenOption 打开录屏文件的配置
+type OpenOption func(*openOptions)
+
+type openOptions struct {
+	key []byte
+}
+
+// OptKey 设置解密的密钥，打开加密的录屏文件时需要
+func OptKey(key []byte) OpenOption {
+	return func(o *openOptions) {
+		o.key = key
+	}
+}
+
+// OpenFile 打开录屏文件及其所有分段，压缩的分段自动解压，加密的分段使用 OptKey 设置的密钥解密并校验
+func OpenFile(path string, opts ...OpenOption) (*File, error) {
+	o := &openOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	paths, err := Segments(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &File{}
+	for _, p := range paths {
+		seg, err := openSegment(p, o.key)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		file.segments = append(file.segments, seg)
+	}
+	return file, nil
+}
+
+// openSegment 打开一个分段，根据文件开头的魔数判断是否需要解密及解压，不需要时直接返回文件
+func openSegment(path string, key []byte) (io.ReadSeekCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(cryptMagic))
+	n, _ := io.ReadFull(f, magic)
+	if IsEncrypted(magic[:n]) && len(key) == 0 {
+		_ = f.Close()
+		return nil, fmt.Errorf("录屏文件[%s]已加密，需要设置密钥", path)
+	}
+	if !IsEncrypted(magic[:n]) && DetectCompression(magic[:n]) == CompressionNone {
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+	_ = f.Close()
+	return &streamFile{open: func() (io.ReadCloser, error) {
+		return openStream(path, key)
+	}}, nil
+}
+
+// openStream 从头读取文件，依次解密及解压
+func openStream(path string, key []byte) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(len(cryptMagic)); IsEncrypted(magic) {
+		if r, err = NewDecryptReader(r, key); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		r = bufio.NewReader(r)
+	}
+	magic, _ := r.(*bufio.Reader).Peek(len(zstdMagic))
+	dr, err := newDecompressReader(r, DetectCompression(magic))
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &multiCloser{Reader: dr, closers: []io.Closer{dr, f}}, nil
+}
+
+// Segments 所有分段
+func (that *File) Segments() []io.ReadSeeker {
+	segments := make([]io.ReadSeeker, len(that.segments))
+	for i, seg := range that.segments {
+		segments[i] = seg
+	}
+	return segments
+}
+
+func (that *File) Read(p []byte) (int, error) {
+	return that.segments[0].Read(p)
+}
+
+func (that *File) Seek(offset int64, whence int) (int64, error) {
+	return that.segments[0].Seek(offset, whence)
+}
+
+func (that *File) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("录屏文件只读")
+}
+
+// Close 关闭所有分段
+func (that *File) Close() error {
+	var err error
+	for _, seg := range that.segments {
+		if e := seg.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// SegmentReader 把多个分段作为一个连续的录屏读取，分段内的关键帧索引合并成一个
+type SegmentReader struct {
+	segments []*Reader
+	current  int
+	index    []IndexEntry
+}
+
+// NewSegmentReader 打开按顺序排列的分段
+func NewSegmentReader(segments ...io.ReadSeeker) (*SegmentReader, error) {
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("没有要读取的分段")
+	}
+	that := &SegmentReader{}
+	for i, seg := range segments {
+		r, err := NewReader(seg)
+		if err != nil {
+			return nil, fmt.Errorf("读取第%d个分段失败:%v", i, err)
+		}
+		that.segments = append(that.segments, r)
+		that.index = append(that.index, r.Index()...)
+	}
+	return that, nil
+}
+
+// Header 第一个分段的文件头
+func (that *SegmentReader) Header() *Header {
+	return that.segments[0].Header()
+}
+
+// Index 所有分段的关键帧索引，偏移是关键帧在所在分段中的偏移
+func (that *SegmentReader) Index() []IndexEntry {
+	return that.index
+}
+
+// Duration 录屏时长
+func (that *SegmentReader) Duration() time.Duration {
+	var duration time.Duration
+	for _, r := range that.segments {
+		if r.Duration() > duration {
+			duration = r.Duration()
+		}
+	}
+	return duration
+}
+
+// Next 读取下一个数据块，当前分段读取完成后从下一个分段的开头继续读取，全部读取完成返回io.EOF
+func (that *SegmentReader) Next() (*Chunk, error) {
+	for {
+		chunk, err := that.segments[that.current].Next()
+		if err != io.EOF || that.current >= len(that.segments)-1 {
+			return chunk, err
+		}
+		that.current++
+		r := that.segments[that.current]
+		if err = r.seekOffset(r.dataOffset); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Seek 跳转到ts之前最近的关键帧，返回关键帧的时间，参考 Reader.Seek
+func (that *SegmentReader) Seek(ts time.Duration) (time.Duration, error) {
+	that.current = 0
+	for i := len(that.segments) - 1; i > 0; i-- {
+		if index := that.segments[i].Index(); len(index) > 0 && index[0].Timestamp <= ts {
+			that.current = i
+			break
+		}
+	}
+	return that.segments[that.current].Seek(ts)
+}
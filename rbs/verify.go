@@ -0,0 +1,174 @@
+package rbs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// VerifyResult 一个分段的校验结果
+type VerifyResult struct {
+	Path        string        // 分段的文件路径
+	Encrypted   bool          // 是否加密
+	Compression Compression   // 压缩算法
+	Chunks      int           // 校验通过的数据块数量
+	Keyframes   int           // 校验通过的关键帧数量
+	Duration    time.Duration // 最后一个校验通过的数据块的时间
+	Complete    bool          // 是否正常结束(写入了索引)，异常中断的录屏没有索引
+	// Offset 第一个损坏的位置，加密的文件是在文件中的偏移，
+	// 未加密的文件是数据块在解压后数据流中的偏移(不压缩时即文件中的偏移)，没有损坏为-1
+	Offset int64
+	Err    *CorruptionError // 损坏的位置及原因，没有损坏为nil
+}
+
+// OK 校验是否通过
+func (that *VerifyResult) OK() bool {
+	return that.Err == nil
+}
+
+func (that *VerifyResult) String() string {
+	s := fmt.Sprintf("%s encrypted=%t compression=%s chunks=%d keyframes=%d duration=%s complete=%t",
+		that.Path, that.Encrypted, that.Compression, that.Chunks, that.Keyframes, that.Duration, that.Complete)
+	if that.Err != nil {
+		s += fmt.Sprintf("\n\tcorrupted at offset %d: %s", that.Offset, that.Err.Reason)
+	}
+	return s
+}
+
+// Verify 从头读取并校验录屏文件的所有分段，返回每个分段的校验结果。
+// 加密的分段使用 OptKey 设置的密钥校验每个记录的认证标签及链式校验，可以发现修改、删除及截断，
+// 未加密的分段只能校验数据块的结构。
+func Verify(path string, opts ...OpenOption) ([]*VerifyResult, error) {
+	o := &openOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	paths, err := Segments(path)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*VerifyResult, 0, len(paths))
+	for _, p := range paths {
+		result, err := verifySegment(p, o.key)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// verifySegment 校验一个分段，只有打开文件失败才返回error，文件损坏记录在结果中
+func verifySegment(path string, key []byte) (*VerifyResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	result := &VerifyResult{Path: path, Offset: -1}
+	fail := func(offset int64, err error) *VerifyResult {
+		var ce *CorruptionError
+		if !errors.As(err, &ce) {
+			ce = &CorruptionError{Offset: offset, Reason: err.Error()}
+		}
+		result.Offset, result.Err = ce.Offset, ce
+		return result
+	}
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	var dr *DecryptReader
+	if magic, _ := br.Peek(len(cryptMagic)); IsEncrypted(magic) {
+		result.Encrypted = true
+		if len(key) == 0 {
+			return fail(0, fmt.Errorf("录屏文件已加密，需要设置密钥")), nil
+		}
+		if dr, err = NewDecryptReader(br, key); err != nil {
+			return fail(0, err), nil
+		}
+		r = bufio.NewReader(dr)
+	}
+	// 加密层校验失败时，优先报告加密层的错误，解压器可能会包装该错误
+	failStream := func(offset int64, err error) *VerifyResult {
+		if dr != nil && dr.err != nil {
+			return fail(0, dr.err)
+		}
+		return fail(offset, err)
+	}
+
+	magic, _ := r.(*bufio.Reader).Peek(len(zstdMagic))
+	result.Compression = DetectCompression(magic)
+	dec, err := newDecompressReader(r, result.Compression)
+	if err != nil {
+		return failStream(0, err), nil
+	}
+	defer func() {
+		_ = dec.Close()
+	}()
+
+	rd := &Reader{br: bufio.NewReader(dec)}
+	header, err := ReadHeader(rd.br)
+	if err != nil {
+		return failStream(0, fmt.Errorf("读取文件头失败:%v", err)), nil
+	}
+	if header.Version != Version2 {
+		return failStream(0, fmt.Errorf("不是v2格式的rbs文件:%q", header.Version)), nil
+	}
+	rd.offset = header.Size()
+	for {
+		offset := rd.offset
+		chunk, err := rd.readChunk()
+		if (err == io.EOF || err == io.ErrUnexpectedEOF) && dr == nil {
+			// 未加密的录屏异常中断时没有索引，最后一个数据块可能不完整，之前的数据块仍然有效，无法区分是否被截断
+			return result, nil
+		}
+		if err == io.EOF {
+			return failStream(offset, fmt.Errorf("缺少索引")), nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return failStream(offset, fmt.Errorf("数据块不完整")), nil
+		}
+		if err != nil {
+			return failStream(offset, err), nil
+		}
+		if chunk.Type == ChunkIndex {
+			return verifyFooter(rd, offset, result, failStream), nil
+		}
+		if chunk.Flags&FlagDeflate != 0 {
+			if _, err = inflate(chunk.Data); err != nil {
+				return failStream(offset, fmt.Errorf("解压数据块失败:%v", err)), nil
+			}
+		}
+		result.Chunks++
+		if chunk.Type == ChunkKeyframe {
+			result.Keyframes++
+		}
+		if chunk.Timestamp > result.Duration {
+			result.Duration = chunk.Timestamp
+		}
+	}
+}
+
+// verifyFooter 校验索引块之后的文件尾，文件尾之后不应该还有数据
+func verifyFooter(rd *Reader, indexOffset int64, result *VerifyResult, fail func(int64, error) *VerifyResult) *VerifyResult {
+	footer := make([]byte, footerLen)
+	if _, err := io.ReadFull(rd.br, footer); err != nil {
+		return fail(rd.offset, fmt.Errorf("文件尾不完整"))
+	}
+	if string(footer[8:]) != footerMagic || int64(binary.BigEndian.Uint64(footer)) != indexOffset {
+		return fail(rd.offset, fmt.Errorf("文件尾不正确"))
+	}
+	if n, err := rd.br.Read(make([]byte, 1)); n > 0 {
+		return fail(rd.offset+footerLen, fmt.Errorf("文件尾之后还有多余的数据"))
+	} else if err != nil && err != io.EOF {
+		return fail(rd.offset+footerLen, err)
+	}
+	result.Complete = true
+	return result
+}
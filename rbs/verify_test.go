@@ -0,0 +1,151 @@
+package rbs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeEncryptedRecording 写入加密的v2格式测试录屏，每个数据块加密成一个记录，索引及文件尾在结束记录中
+func writeEncryptedRecording(t *testing.T, c Compression) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	ew, err := NewEncryptWriter(buf, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw, err := NewCompressWriter(ew, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWriter(cw, testHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	flush := func() {
+		if err = cw.(*compressWriteCloser).Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err = ew.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush()
+	for _, chunk := range testChunks {
+		if err = w.WriteChunkFlags(chunk.typ, chunk.flags, chunk.ts, chunk.data); err != nil {
+			t.Fatal(err)
+		}
+		flush()
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 关闭压缩写入器时会关闭加密写入器，写入结束记录
+	if err = cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestVerify 校验通过的录屏返回数据块的统计，损坏的录屏返回 CorruptionError 及第一个损坏的位置
+func TestVerify(t *testing.T) {
+	plain, index := writeTestRecording(t)
+	encrypted := writeEncryptedRecording(t, CompressionNone)
+	records := cryptRecords(t, encrypted)
+	// 文件头、8个数据块各一个记录，最后是索引及文件尾的结束记录
+	if len(records) != 11 {
+		t.Fatalf("加密的录屏有%d个记录", len(records)-1)
+	}
+	// 修改第二个关键帧压缩后的数据
+	badDeflate := append([]byte{}, plain...)
+	badDeflate[index[1].Offset+chunkHeaderLen] ^= 0xFF
+	flip := func(offset int64) []byte {
+		out := append([]byte{}, encrypted...)
+		out[offset] ^= 0x01
+		return out
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		key       []byte
+		encrypted bool
+		offset    int64 // 损坏的偏移，为-1表示没有损坏
+		chunks    int   // 校验通过的数据块数量
+		keyframes int   // 校验通过的关键帧数量
+	}{
+		{"未加密", plain, nil, false, -1, 8, 3},
+		{"加密", encrypted, testKey, true, -1, 8, 3},
+		{"加密并压缩", writeEncryptedRecording(t, CompressionZstd), testKey, true, -1, 8, 3},
+		{"未加密的数据块损坏", badDeflate, nil, false, index[1].Offset, 4, 1},
+		{"未加密的文件尾之后有多余的数据", append(append([]byte{}, plain...), 0), nil, false, int64(len(plain)), 8, 3},
+		{"未加密的异常中断无法区分是否被截断", plain[:index[2].Offset+3], nil, false, -1, 6, 2},
+		{"修改数据块", flip(records[3] + cryptRecordLen + 2), testKey, true, records[3], 2, 1},
+		{"截断", encrypted[:records[5]], testKey, true, records[5], 4, 1},
+		{"删除数据块", append(append([]byte{}, encrypted[:records[2]]...), encrypted[records[3]:]...), testKey, true, records[2], 1, 1},
+		{"交换数据块的顺序", moveRecord(encrypted, records, 4, 3), testKey, true, records[3], 2, 1},
+		{"错误的密钥", encrypted, bytes.Repeat([]byte{0x43}, 16), true, records[0], 0, 0},
+		{"缺少密钥", encrypted, nil, true, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.rbs")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			results, err := Verify(path, OptKey(tt.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("校验了%d个分段", len(results))
+			}
+			r := results[0]
+			if r.Encrypted != tt.encrypted || r.Chunks != tt.chunks || r.Keyframes != tt.keyframes {
+				t.Fatalf("校验结果为%v", r)
+			}
+			if tt.offset < 0 {
+				if !r.OK() || r.Offset != -1 {
+					t.Fatalf("校验失败:%v", r)
+				}
+				if r.Complete != (tt.chunks == len(testChunks)) {
+					t.Fatalf("校验结果为%v", r)
+				}
+				if r.Complete && r.Duration != 2500*time.Millisecond {
+					t.Fatalf("录屏时长为%v", r.Duration)
+				}
+				return
+			}
+			if r.OK() {
+				t.Fatalf("损坏的录屏校验通过:%v", r)
+			}
+			expectCorruption(t, r.Err, tt.offset)
+			if r.Offset != tt.offset {
+				t.Fatalf("校验结果的偏移为%d，应该为%d", r.Offset, tt.offset)
+			}
+		})
+	}
+}
+
+// TestVerifySegments 校验所有分段，每个分段单独返回结果
+func TestVerifySegments(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "test.rbs")
+	data, _ := writeTestRecording(t)
+	if err := os.WriteFile(first, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(SegmentPath(first, 1), data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := Verify(SegmentPath(first, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Path != first || !results[0].OK() || results[1].OK() {
+		t.Fatalf("校验结果为%v", results)
+	}
+	expectCorruption(t, results[1].Err, int64(len(data)-footerLen))
+}
@@ -0,0 +1,131 @@
+package rbs
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Writer 写入v2格式的录屏文件
+type Writer struct {
+	w        io.Writer
+	offset   int64         // 当前写入的偏移
+	index    []IndexEntry  // 已写入的关键帧
+	duration time.Duration // 最后一个数据块的时间
+	closed   bool
+}
+
+// NewWriter 创建v2格式的录屏文件，立即写入文件头
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	if header.StartTime.IsZero() {
+		header.StartTime = time.Now()
+	}
+	n, err := writeHeader(w, &header)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, offset: n}, nil
+}
+
+// WriteChunk 写入一个数据块，ts为相对录屏开始的时间，关键帧会被压缩并加入索引
+func (that *Writer) WriteChunk(typ ChunkType, ts time.Duration, data []byte) error {
+	return that.WriteChunkFlags(typ, 0, ts, data)
+}
+
+// WriteChunkFlags 写入一个带标识的数据块，压缩标识由写入时决定，不需要传入
+func (that *Writer) WriteChunkFlags(typ ChunkType, flags uint8, ts time.Duration, data []byte) error {
+	if that.closed {
+		return fmt.Errorf("rbs文件已经关闭")
+	}
+	flags &^= FlagDeflate
+	if typ == ChunkKeyframe {
+		compressed, err := deflate(data)
+		if err != nil {
+			return err
+		}
+		data = compressed
+		flags |= FlagDeflate
+		that.index = append(that.index, IndexEntry{Timestamp: ts, Offset: that.offset})
+	}
+	if err := that.writeChunk(typ, flags, ts, data); err != nil {
+		return err
+	}
+	if ts > that.duration {
+		that.duration = ts
+	}
+	return nil
+}
+
+// writeChunk 写入数据块头及数据
+func (that *Writer) writeChunk(typ ChunkType, flags uint8, ts time.Duration, data []byte) error {
+	if len(data) > maxChunkLen {
+		return fmt.Errorf("数据块太大:%d", len(data))
+	}
+	header := make([]byte, chunkHeaderLen)
+	header[0] = uint8(typ)
+	header[1] = flags
+	binary.BigEndian.PutUint64(header[2:], uint64(ts))
+	binary.BigEndian.PutUint32(header[10:], uint32(len(data)))
+	if _, err := that.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := that.w.Write(data); err != nil {
+		return err
+	}
+	that.offset += int64(chunkHeaderLen + len(data))
+	return nil
+}
+
+// Index 已写入的关键帧索引
+func (that *Writer) Index() []IndexEntry {
+	return that.index
+}
+
+// Duration 最后一个数据块的时间
+func (that *Writer) Duration() time.Duration {
+	return that.duration
+}
+
+// Close 写入关键帧索引及文件尾，不会关闭底层的io.Writer
+// 索引块的格式为: 8字节录屏时长 + 4字节关键帧数量 + 每个关键帧的8字节时间和8字节偏移
+func (that *Writer) Close() error {
+	if that.closed {
+		return nil
+	}
+	that.closed = true
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.BigEndian, int64(that.duration))
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(that.index)))
+	for _, entry := range that.index {
+		_ = binary.Write(buf, binary.BigEndian, int64(entry.Timestamp))
+		_ = binary.Write(buf, binary.BigEndian, entry.Offset)
+	}
+	indexOffset := that.offset
+	if err := that.writeChunk(ChunkIndex, 0, that.duration, buf.Bytes()); err != nil {
+		return err
+	}
+	footer := make([]byte, footerLen)
+	binary.BigEndian.PutUint64(footer, uint64(indexOffset))
+	copy(footer[8:], footerMagic)
+	_, err := that.w.Write(footer)
+	return err
+}
+
+// deflate 压缩数据
+func deflate(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	fw, err := flate.NewWriter(buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err = fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
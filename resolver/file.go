@@ -0,0 +1,102 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/vprix/vncproxy/rfb"
+	"sync"
+	"time"
+)
+
+// FileResolver 从本地文件中解析目标配置，支持toml，json，yaml等格式，
+// 文件内容是令牌到目标配置的映射，文件修改后会自动重新加载。
+//
+//	[vm1]
+//	host = "192.168.1.2"
+//	port = 5901
+//	password = "vprix"
+type FileResolver struct {
+	path    string
+	mu      sync.RWMutex
+	mtime   time.Time
+	targets map[string]*rfb.TargetConfig
+}
+
+var _ rfb.TargetResolver = new(FileResolver)
+var _ rfb.TokenLister = new(FileResolver)
+
+// NewFileResolver 创建从文件解析目标配置的解析器
+func NewFileResolver(path string) (*FileResolver, error) {
+	that := &FileResolver{path: path}
+	if err := that.reload(); err != nil {
+		return nil, err
+	}
+	return that, nil
+}
+
+// Resolve 根据令牌获取目标配置
+func (that *FileResolver) Resolve(_ context.Context, token string) (*rfb.TargetConfig, error) {
+	if err := that.reload(); err != nil {
+		return nil, err
+	}
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	targetCfg, ok := that.targets[token]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	cfg := *targetCfg
+	return &cfg, nil
+}
+
+// Tokens 获取全部的令牌
+func (that *FileResolver) Tokens(_ context.Context) ([]string, error) {
+	if err := that.reload(); err != nil {
+		return nil, err
+	}
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	tokens := make([]string, 0, len(that.targets))
+	for token := range that.targets {
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// reload 文件有修改的时候重新加载
+func (that *FileResolver) reload() error {
+	mtime := gfile.MTime(that.path)
+	if mtime.IsZero() {
+		return fmt.Errorf("令牌文件 %s 不存在", that.path)
+	}
+	that.mu.RLock()
+	unchanged := mtime.Equal(that.mtime)
+	that.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	j, err := gjson.LoadContent(gfile.GetBytes(that.path), true)
+	if err != nil {
+		return fmt.Errorf("解析令牌文件 %s 失败:%v", that.path, err)
+	}
+	targets := make(map[string]*rfb.TargetConfig)
+	for token, v := range j.Map() {
+		var entry targetEntry
+		if err = gconv.Struct(v, &entry); err != nil {
+			return fmt.Errorf("解析令牌 %s 失败:%v", token, err)
+		}
+		targetCfg, err := entry.toTargetConfig()
+		if err != nil {
+			return fmt.Errorf("解析令牌 %s 失败:%v", token, err)
+		}
+		targets[token] = targetCfg
+	}
+	that.mu.Lock()
+	that.targets = targets
+	that.mtime = mtime
+	that.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,56 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/gclient"
+	"github.com/vprix/vncproxy/rfb"
+	"net/http"
+	"time"
+)
+
+// HttpResolver 通过http回调接口解析目标配置，
+// 请求方式为 GET url?token=xxx ，接口返回json格式的目标配置，
+// 例如 {"host":"192.168.1.2","port":5901,"password":"vprix"} ，令牌不存在时返回404状态码。
+type HttpResolver struct {
+	url    string
+	client *gclient.Client
+}
+
+var _ rfb.TargetResolver = new(HttpResolver)
+
+// NewHttpResolver 创建通过http回调接口解析目标配置的解析器
+func NewHttpResolver(url string, timeout time.Duration) *HttpResolver {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &HttpResolver{
+		url:    url,
+		client: g.Client().Timeout(timeout),
+	}
+}
+
+// Resolve 根据令牌获取目标配置
+func (that *HttpResolver) Resolve(ctx context.Context, token string) (*rfb.TargetConfig, error) {
+	resp, err := that.client.Get(ctx, that.url, g.Map{"token": token})
+	if err != nil {
+		return nil, fmt.Errorf("请求令牌解析接口失败:%v", err)
+	}
+	defer func() {
+		_ = resp.Close()
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrTokenNotFound
+	default:
+		return nil, fmt.Errorf("令牌解析接口返回错误的状态码:%d", resp.StatusCode)
+	}
+	var entry targetEntry
+	if err = json.Unmarshal(resp.ReadAll(), &entry); err != nil {
+		return nil, fmt.Errorf("令牌解析接口返回的数据格式不正确:%v", err)
+	}
+	return entry.toTargetConfig()
+}
@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+	"time"
+)
+
+// ErrTokenNotFound 令牌不存在
+var ErrTokenNotFound = errors.New("令牌不存在")
+
+// targetEntry 配置文件及http回调接口中描述的目标配置
+type targetEntry struct {
+	Network  string `json:"network"`  // 网络协议，默认tcp
+	Host     string `json:"host"`     // vnc服务端地址
+	Port     int    `json:"port"`     // vnc服务端端口
+	Password string `json:"password"` // vnc服务端密码
+	Timeout  string `json:"timeout"`  // 连接超时时间，例如 "10s"
+	ViewOnly bool   `json:"viewOnly"` // 是否只读
+
+	Username      string `json:"username"`      // VeNCrypt Plain认证的用户名
+	TLS           bool   `json:"tls"`           // 是否使用VeNCrypt加密连接
+	TLSCA         string `json:"tlsCA"`         // 校验vnc服务端证书的ca文件
+	TLSCert       string `json:"tlsCert"`       // 客户端证书文件
+	TLSKey        string `json:"tlsKey"`        // 客户端私钥文件
+	TLSServerName string `json:"tlsServerName"` // 校验vnc服务端证书使用的名称
+	TLSInsecure   bool   `json:"tlsInsecure"`   // 不校验vnc服务端证书
+	RA2ServerKey  string `json:"ra2ServerKey"`  // 固定vnc服务端RA2认证的rsa公钥指纹
+	RA2KnownHosts string `json:"ra2KnownHosts"` // 记录vnc服务端RA2认证rsa公钥的文件
+}
+
+// toTargetConfig 转换成rfb的目标配置
+func (that *targetEntry) toTargetConfig() (*rfb.TargetConfig, error) {
+	if len(that.Host) == 0 || that.Port <= 0 {
+		return nil, fmt.Errorf("目标配置不正确,host:%s,port:%d", that.Host, that.Port)
+	}
+	targetCfg := &rfb.TargetConfig{
+		Network:  that.Network,
+		Host:     that.Host,
+		Port:     that.Port,
+		Password: []byte(that.Password),
+		ViewOnly: that.ViewOnly,
+
+		Username:      []byte(that.Username),
+		TLS:           that.TLS,
+		TLSCAFile:     that.TLSCA,
+		TLSCertFile:   that.TLSCert,
+		TLSKeyFile:    that.TLSKey,
+		TLSServerName: that.TLSServerName,
+		TLSInsecure:   that.TLSInsecure,
+		RA2ServerKey:  that.RA2ServerKey,
+		RA2KnownHosts: that.RA2KnownHosts,
+	}
+	if len(that.Timeout) > 0 {
+		timeout, err := time.ParseDuration(that.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("目标配置的超时时间不正确:%v", err)
+		}
+		targetCfg.Timeout = timeout
+	}
+	return targetCfg, nil
+}
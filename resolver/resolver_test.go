@@ -0,0 +1,157 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// TestFileResolver 按令牌查找目标配置，令牌不存在，文件修改后重新加载
+func TestFileResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.toml")
+	content := `
+[vm1]
+host = "192.168.1.2"
+port = 5901
+password = "vprix"
+timeout = "3s"
+viewOnly = true
+
+[vm2]
+host = "192.168.1.3"
+port = 5902
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewFileResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	targetCfg, err := r.Resolve(ctx, "vm1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targetCfg.Host != "192.168.1.2" || targetCfg.Port != 5901 || string(targetCfg.Password) != "vprix" ||
+		targetCfg.Timeout != 3*time.Second || !targetCfg.ViewOnly {
+		t.Fatalf("目标配置为%+v", targetCfg)
+	}
+	// 返回的是副本，修改不影响之后的查询
+	targetCfg.Host = "changed"
+	if targetCfg, _ = r.Resolve(ctx, "vm1"); targetCfg.Host != "192.168.1.2" {
+		t.Fatalf("目标配置被修改为%s", targetCfg.Host)
+	}
+	if _, err = r.Resolve(ctx, "vm3"); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("令牌不存在返回%v", err)
+	}
+	tokens, err := r.Tokens(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tokens)
+	if len(tokens) != 2 || tokens[0] != "vm1" || tokens[1] != "vm2" {
+		t.Fatalf("令牌为%v", tokens)
+	}
+
+	// 文件修改后重新加载
+	if err = os.WriteFile(path, []byte("[vm3]\nhost = \"192.168.1.4\"\nport = 5903\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err = os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if targetCfg, err = r.Resolve(ctx, "vm3"); err != nil || targetCfg.Port != 5903 {
+		t.Fatalf("重新加载后返回%+v %v", targetCfg, err)
+	}
+	if _, err = r.Resolve(ctx, "vm1"); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("重新加载后删除的令牌返回%v", err)
+	}
+
+	// 文件被删除
+	if err = os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Resolve(ctx, "vm3"); err == nil {
+		t.Fatal("文件不存在没有返回错误")
+	}
+}
+
+// TestFileResolverMalformed 文件格式或者目标配置不正确
+func TestFileResolverMalformed(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"文件不存在", "missing.json", ""},
+		{"格式不正确", "tokens.json", `{"vm1": {"host": `},
+		{"缺少地址", "tokens.json", `{"vm1": {"port": 5901}}`},
+		{"端口不正确", "tokens.json", `{"vm1": {"host": "192.168.1.2", "port": 0}}`},
+		{"超时时间不正确", "tokens.json", `{"vm1": {"host": "192.168.1.2", "port": 5901, "timeout": "3 seconds"}}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), c.file)
+			if len(c.content) > 0 {
+				if err := os.WriteFile(path, []byte(c.content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := NewFileResolver(path); err == nil {
+				t.Fatal("没有返回错误")
+			}
+		})
+	}
+}
+
+// TestHttpResolver 回调接口返回目标配置、404、其他状态码及格式不正确的数据
+func TestHttpResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("token") {
+		case "vm1":
+			_, _ = w.Write([]byte(`{"host":"192.168.1.2","port":5901,"password":"vprix","username":"admin","tls":true}`))
+		case "broken":
+			_, _ = w.Write([]byte(`{"host":`))
+		case "invalid":
+			_, _ = w.Write([]byte(`{"host":"192.168.1.2"}`))
+		case "error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	r := NewHttpResolver(srv.URL, time.Second)
+
+	targetCfg, err := r.Resolve(ctx, "vm1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targetCfg.Host != "192.168.1.2" || targetCfg.Port != 5901 || string(targetCfg.Password) != "vprix" ||
+		string(targetCfg.Username) != "admin" || !targetCfg.TLS {
+		t.Fatalf("目标配置为%+v", targetCfg)
+	}
+	if _, err = r.Resolve(ctx, "vm2"); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("令牌不存在返回%v", err)
+	}
+	for _, token := range []string{"broken", "invalid", "error"} {
+		if _, err = r.Resolve(ctx, token); err == nil || errors.Is(err, ErrTokenNotFound) {
+			t.Fatalf("令牌%s返回%v", token, err)
+		}
+	}
+
+	// 接口无法访问
+	srv.Close()
+	if _, err = r.Resolve(ctx, "vm1"); err == nil || errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("接口无法访问返回%v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package rfb
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTooManyAttempts 认证失败次数过多被锁定，与TigerVNC一样把该原因发送给vnc客户端
+var ErrTooManyAttempts = errors.New("too many attempts")
+
+// AuthLimiter 认证限流器，按vnc客户端地址及用户名记录认证失败次数，防止暴力破解密码
+type AuthLimiter interface {
+	// Allow 判断该地址及用户名是否允许认证，username为空则只判断地址
+	Allow(remoteAddr, username string) bool
+	// Fail 记录一次认证失败，返回发送认证结果前需要等待的时长
+	Fail(remoteAddr, username string) time.Duration
+	// Reset 认证成功或者管理员解除锁定，清除该地址及用户名的失败记录
+	Reset(remoteAddr, username string)
+}
@@ -0,0 +1,31 @@
+package rfb
+
+import "context"
+
+// Identity 通过认证的vnc客户端身份，认证器可以同时给出该用户连接vnc服务端使用的凭证
+type Identity struct {
+	Username       string            // 用户名
+	Token          string            // 该用户连接的目标令牌，不为空时优先于连接携带的令牌
+	TargetUsername []byte            // 连接vnc服务端使用的用户名，为空则使用目标配置中的用户名
+	TargetPassword []byte            // 连接vnc服务端使用的密码，为空则使用目标配置中的密码
+	ViewOnly       bool              // 是否只读
+	Attributes     map[string]string // 其他属性，供日志，策略及录屏使用
+}
+
+// ApplyTo 把身份中的凭证应用到目标配置
+func (that *Identity) ApplyTo(targetCfg *TargetConfig) {
+	if len(that.TargetUsername) > 0 {
+		targetCfg.Username = that.TargetUsername
+	}
+	if len(that.TargetPassword) > 0 {
+		targetCfg.Password = that.TargetPassword
+	}
+	if that.ViewOnly {
+		targetCfg.ViewOnly = true
+	}
+}
+
+// Authenticator 认证器，校验vnc客户端的用户名和密码，认证成功返回该用户的身份
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*Identity, error)
+}
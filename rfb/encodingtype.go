@@ -75,3 +75,13 @@ const (
 	EncVMWFrameStamp                 EncodingType = 124 + 0x574d5600
 	EncOffscreenCopyRect             EncodingType = 126 + 0x574d5600
 )
+
+// Stateful 编码是否使用了整个连接上持续的zlib流，解码时依赖同一连接上之前的矩形，
+// 中途开始转发或者从关键帧开始播放时无法解码
+func (that EncodingType) Stateful() bool {
+	switch that {
+	case EncZlib, EncZlibHex, EncTight, EncTightPng, EncZRLE:
+		return true
+	}
+	return false
+}
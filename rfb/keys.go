@@ -1,6 +1,9 @@
 package rfb
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type Key uint32
 
@@ -30,6 +33,19 @@ func IntToKeys(v int) Keys {
 	return k
 }
 
+// ParseKey 根据名称获取按键，名称不区分大小写，例如 Space、Left、KeypadAdd，单个字符表示该字符对应的按键
+func ParseKey(name string) (Key, error) {
+	if r := []rune(name); len(r) == 1 && r[0] >= 0x20 && r[0] <= 0xff {
+		return Key(r[0]), nil
+	}
+	for k, v := range keyMap {
+		if strings.EqualFold(v, name) {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("未知的按键:%s", name)
+}
+
 // Latin 1 (byte 3 = 0)
 // ISO/IEC 8859-1 = Unicode U+0020..U+00FF
 const (
@@ -22,9 +22,10 @@ type Options struct {
 	ErrorCh                  chan error          // 错误通道
 
 	// 服务端配置
-	DesktopName []byte // 桌面名称，作为服务端配置的时候，需要设置
-	Height      uint16 // 缓冲帧高度，作为服务端配置的时候，需要设置
-	Width       uint16 // 缓冲帧宽度，作为服务端配置的时候，需要设置
+	DesktopName []byte      // 桌面名称，作为服务端配置的时候，需要设置
+	Height      uint16      // 缓冲帧高度，作为服务端配置的时候，需要设置
+	Width       uint16      // 缓冲帧宽度，作为服务端配置的时候，需要设置
+	AuthLimiter AuthLimiter // 认证限流器，为nil则不限制认证失败次数
 
 	// 客户端配置
 	DrawCursor bool // 是否绘制鼠标指针
@@ -69,6 +70,13 @@ func OptPixelFormat(opt PixelFormat) Option {
 	}
 }
 
+// OptAuthLimiter 设置认证限流器，防止暴力破解密码
+func OptAuthLimiter(opt AuthLimiter) Option {
+	return func(options *Options) {
+		options.AuthLimiter = opt
+	}
+}
+
 // OptGetConn 设置生成连接方法
 func OptGetConn(opt GetConn) Option {
 	return func(options *Options) {
@@ -9,8 +9,11 @@ const (
 	SecTypeUnknown  SecurityType = SecurityType(0)  // 未知认证类型
 	SecTypeNone     SecurityType = SecurityType(1)  // 不需要认证
 	SecTypeVNC      SecurityType = SecurityType(2)  // vnc密码认证
+	SecTypeRA2      SecurityType = SecurityType(5)  // RSA-AES认证，认证后的数据全部加密
+	SecTypeRA2ne    SecurityType = SecurityType(6)  // RSA-AES认证，只加密认证过程
 	SecTypeTight    SecurityType = SecurityType(16) // tight vnc主导的认证模式
 	SecTypeVeNCrypt SecurityType = SecurityType(19) // VeNCrypt 通用认证类型
+	SecTypeARD      SecurityType = SecurityType(30) // Apple Remote Desktop的Diffie-Hellman认证
 )
 
 // SecuritySubType 认证子类型
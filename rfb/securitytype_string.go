@@ -11,28 +11,39 @@ func _() {
 	_ = x[SecTypeUnknown-0]
 	_ = x[SecTypeNone-1]
 	_ = x[SecTypeVNC-2]
+	_ = x[SecTypeRA2-5]
+	_ = x[SecTypeRA2ne-6]
 	_ = x[SecTypeTight-16]
 	_ = x[SecTypeVeNCrypt-19]
+	_ = x[SecTypeARD-30]
 }
 
 const (
 	_SecurityType_name_0 = "SecTypeUnknownSecTypeNoneSecTypeVNC"
-	_SecurityType_name_1 = "SecTypeTight"
-	_SecurityType_name_2 = "SecTypeVeNCrypt"
+	_SecurityType_name_1 = "SecTypeRA2SecTypeRA2ne"
+	_SecurityType_name_2 = "SecTypeTight"
+	_SecurityType_name_3 = "SecTypeVeNCrypt"
+	_SecurityType_name_4 = "SecTypeARD"
 )
 
 var (
 	_SecurityType_index_0 = [...]uint8{0, 14, 25, 35}
+	_SecurityType_index_1 = [...]uint8{0, 10, 22}
 )
 
 func (i SecurityType) String() string {
 	switch {
 	case i <= 2:
 		return _SecurityType_name_0[_SecurityType_index_0[i]:_SecurityType_index_0[i+1]]
+	case 5 <= i && i <= 6:
+		i -= 5
+		return _SecurityType_name_1[_SecurityType_index_1[i]:_SecurityType_index_1[i+1]]
 	case i == 16:
-		return _SecurityType_name_1
-	case i == 19:
 		return _SecurityType_name_2
+	case i == 19:
+		return _SecurityType_name_3
+	case i == 30:
+		return _SecurityType_name_4
 	default:
 		return "SecurityType(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
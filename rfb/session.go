@@ -30,6 +30,14 @@ type ISession interface {
 	Type() SessionType
 }
 
+// IConnSetter 支持替换底层连接的会话，例如安全认证阶段把连接升级为tls连接
+type IConnSetter interface {
+	SetConn(io.ReadWriteCloser)
+	// TakeBuffered 取出读缓冲区中已经从底层连接读取但还未处理的数据，
+	// 替换连接时这些数据需要交给新连接先处理
+	TakeBuffered() []byte
+}
+
 type SessionType uint8
 
 //go:generate stringer -type=SessionType
@@ -0,0 +1,41 @@
+package rfb
+
+import (
+	"github.com/gogf/gf/v2/util/gconv"
+	"net"
+)
+
+// 会话交换区(Swap)中使用的key
+const (
+	SwapKeyToken    = "vprix.token"    // vnc客户端连接时携带的目标令牌
+	SwapKeyShared   = "vprix.shared"   // vnc客户端ClientInit阶段发送的共享屏幕标识
+	SwapKeyViewOnly = "vprix.viewOnly" // vnc客户端使用只读密码认证，只能查看不能操作
+	SwapKeyIdentity = "vprix.identity" // vnc客户端通过认证后的身份，类型为*Identity
+	SwapKeyUsername = "vprix.username" // vnc客户端认证时使用的用户名，认证失败也会保存，用于认证限流
+	// SwapKeyRemoteAddr vnc客户端的地址，websocket等无法从连接获取真实地址的场景由调用方设置
+	SwapKeyRemoteAddr = "vprix.remoteAddr"
+	// SwapKeyZlibStreams 画布会话解码ZRLE，Tight等编码时使用的持续的zlib流
+	SwapKeyZlibStreams = "vprix.zlibStreams"
+	// SwapKeyJPEGTables 画布会话解码JPEG编码时缓存的量化表及哈夫曼表
+	SwapKeyJPEGTables = "vprix.jpegTables"
+)
+
+// IdentityFromSwap 获取会话中vnc客户端的身份，未通过用户名认证则返回nil
+func IdentityFromSwap(session ISession) *Identity {
+	identity, _ := session.Swap().Get(SwapKeyIdentity).(*Identity)
+	return identity
+}
+
+// RemoteIP 获取vnc客户端的ip地址，优先使用会话交换区中的地址，否则使用连接的对端地址
+func RemoteIP(session ISession) string {
+	addr := gconv.String(session.Swap().Get(SwapKeyRemoteAddr))
+	if len(addr) == 0 {
+		if conn, ok := session.Conn().(interface{ RemoteAddr() net.Addr }); ok && conn.RemoteAddr() != nil {
+			addr = conn.RemoteAddr().String()
+		}
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -11,6 +11,18 @@ type TargetConfig struct {
 	Host     string        // vnc服务端地址
 	Port     int           // vnc服务端端口
 	Password []byte        // vnc服务端密码
+	ViewOnly bool          // 是否只读，只读模式下vnc客户端只能查看不能操作
+
+	Username      []byte // VeNCrypt Plain认证的用户名
+	TLS           bool   // 是否使用VeNCrypt加密连接，开启后不再使用明文认证
+	TLSCAFile     string // 校验vnc服务端证书的ca文件，为空则使用系统ca
+	TLSCertFile   string // 客户端证书文件，vnc服务端要求客户端证书时使用
+	TLSKeyFile    string // 客户端私钥文件
+	TLSServerName string // 校验vnc服务端证书使用的名称，为空则使用Host
+	TLSInsecure   bool   // 不校验vnc服务端证书
+
+	RA2ServerKey  string // 固定vnc服务端RA2认证的rsa公钥指纹(公钥sha256的十六进制)，不一致则拒绝连接
+	RA2KnownHosts string // 记录vnc服务端RA2认证rsa公钥的文件，首次连接时记录，之后公钥改变则拒绝连接，为空则使用用户配置目录下的vncproxy/ra2_known_hosts
 }
 
 func (that TargetConfig) Addr() string {
@@ -24,6 +36,14 @@ func (that TargetConfig) GetNetwork() string {
 	return that.Network
 }
 
+// GetTLSServerName 校验vnc服务端证书使用的名称
+func (that TargetConfig) GetTLSServerName() string {
+	if len(that.TLSServerName) == 0 {
+		return that.Host
+	}
+	return that.TLSServerName
+}
+
 func (that TargetConfig) GetTimeout() time.Duration {
 	if that.Timeout == 0 {
 		return 10 * time.Second
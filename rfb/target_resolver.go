@@ -0,0 +1,14 @@
+package rfb
+
+import "context"
+
+// TargetResolver 目标解析器，根据vnc客户端携带的令牌解析出要连接的vnc服务端配置
+type TargetResolver interface {
+	Resolve(ctx context.Context, token string) (*TargetConfig, error)
+}
+
+// TokenLister 能够列出全部令牌的目标解析器需要实现该接口，
+// tcp代理无法携带url参数，只能通过vnc密码逐个匹配令牌，所以需要获取全部令牌。
+type TokenLister interface {
+	Tokens(ctx context.Context) ([]string, error)
+}
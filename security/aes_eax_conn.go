@@ -0,0 +1,118 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// aesEAXMaxMessageLen 每条加密消息明文的最大长度
+const aesEAXMaxMessageLen = 8192
+
+// aesEAXConn RA2认证之后使用的加密连接，每条消息的格式为:
+// 2字节明文长度(同时作为附加认证数据) + 密文 + 16字节认证标签，
+// 读写两个方向各自使用独立的密钥和从0开始递增的小端序nonce。
+type aesEAXConn struct {
+	r io.Reader
+	w io.Writer
+	c io.Closer
+
+	reader     cipher.AEAD
+	writer     cipher.AEAD
+	readNonce  [eaxBlockSize]byte
+	writeNonce [eaxBlockSize]byte
+	plain      []byte // 已解密但还未被读取的数据
+}
+
+// newAESEAXConn 在r和w上建立AES-EAX加密连接，readKey用于解密读取的数据，writeKey用于加密写入的数据
+func newAESEAXConn(r io.Reader, w io.Writer, c io.Closer, readKey, writeKey []byte) (*aesEAXConn, error) {
+	newAEAD := func(key []byte) (cipher.AEAD, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return newEAX(block)
+	}
+	reader, err := newAEAD(readKey)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := newAEAD(writeKey)
+	if err != nil {
+		return nil, err
+	}
+	return &aesEAXConn{r: r, w: w, c: c, reader: reader, writer: writer}, nil
+}
+
+// increaseNonce nonce按小端序加1
+func increaseNonce(nonce *[eaxBlockSize]byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			break
+		}
+	}
+}
+
+func (that *aesEAXConn) Read(buf []byte) (int, error) {
+	if len(that.plain) == 0 {
+		if err := that.readMessage(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(buf, that.plain)
+	that.plain = that.plain[n:]
+	return n, nil
+}
+
+// readMessage 读取并解密一条消息
+func (that *aesEAXConn) readMessage() error {
+	var header [2]byte
+	if _, err := io.ReadFull(that.r, header[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint16(header[:])
+	msg := make([]byte, int(length)+that.reader.Overhead())
+	if _, err := io.ReadFull(that.r, msg); err != nil {
+		return err
+	}
+	plain, err := that.reader.Open(msg[:0], that.readNonce[:], msg, header[:])
+	if err != nil {
+		return fmt.Errorf("解密数据失败:%v", err)
+	}
+	increaseNonce(&that.readNonce)
+	that.plain = plain
+	return nil
+}
+
+func (that *aesEAXConn) Write(buf []byte) (int, error) {
+	written := 0
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > aesEAXMaxMessageLen {
+			n = aesEAXMaxMessageLen
+		}
+		if err := that.writeMessage(buf[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		buf = buf[n:]
+	}
+	return written, nil
+}
+
+// writeMessage 加密并发送一条消息
+func (that *aesEAXConn) writeMessage(plain []byte) error {
+	msg := make([]byte, 2, 2+len(plain)+that.writer.Overhead())
+	binary.BigEndian.PutUint16(msg, uint16(len(plain)))
+	msg = that.writer.Seal(msg, that.writeNonce[:], plain, msg[:2])
+	increaseNonce(&that.writeNonce)
+	_, err := that.w.Write(msg)
+	return err
+}
+
+func (that *aesEAXConn) Close() error {
+	return that.c.Close()
+}
@@ -0,0 +1,136 @@
+package security
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// eaxBlockSize EAX模式只支持分组长度为16字节的分组密码
+const eaxBlockSize = 16
+
+// eax EAX认证加密模式的实现，RA2认证使用AES-EAX加密数据
+// 参考 https://web.cs.ucdavis.edu/~rogaway/papers/eax.pdf
+type eax struct {
+	block  cipher.Block
+	k1, k2 [eaxBlockSize]byte // CMAC使用的子密钥
+}
+
+var _ cipher.AEAD = new(eax)
+
+// newEAX 使用分组密码创建EAX模式，nonce和tag的长度都是16字节
+func newEAX(block cipher.Block) (cipher.AEAD, error) {
+	if block.BlockSize() != eaxBlockSize {
+		return nil, errors.New("EAX模式只支持16字节的分组密码")
+	}
+	e := &eax{block: block}
+	var l [eaxBlockSize]byte
+	block.Encrypt(l[:], l[:])
+	e.k1 = eaxDouble(l)
+	e.k2 = eaxDouble(e.k1)
+	return e, nil
+}
+
+// eaxDouble GF(2^128)上乘以x
+func eaxDouble(in [eaxBlockSize]byte) [eaxBlockSize]byte {
+	var out [eaxBlockSize]byte
+	carry := in[0] >> 7
+	for i := 0; i < eaxBlockSize-1; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[eaxBlockSize-1] = in[eaxBlockSize-1]<<1 ^ carry*0x87
+	return out
+}
+
+func (that *eax) NonceSize() int {
+	return eaxBlockSize
+}
+
+func (that *eax) Overhead() int {
+	return eaxBlockSize
+}
+
+// omac 带tweak的CMAC，tweak作为第一个分组
+func (that *eax) omac(tweak byte, data []byte) [eaxBlockSize]byte {
+	var mac, block [eaxBlockSize]byte
+	block[eaxBlockSize-1] = tweak
+	// 第一个分组是tweak，后面跟着数据，数据为空时tweak就是最后一个分组
+	if len(data) == 0 {
+		for i := range block {
+			block[i] ^= that.k1[i]
+		}
+		that.block.Encrypt(mac[:], block[:])
+		return mac
+	}
+	that.block.Encrypt(mac[:], block[:])
+	for len(data) > eaxBlockSize {
+		subtle.XORBytes(mac[:], mac[:], data[:eaxBlockSize])
+		that.block.Encrypt(mac[:], mac[:])
+		data = data[eaxBlockSize:]
+	}
+	// 最后一个分组，完整分组使用k1，不完整的分组填充后使用k2
+	var last [eaxBlockSize]byte
+	copy(last[:], data)
+	key := that.k1
+	if len(data) < eaxBlockSize {
+		last[len(data)] = 0x80
+		key = that.k2
+	}
+	subtle.XORBytes(mac[:], mac[:], last[:])
+	subtle.XORBytes(mac[:], mac[:], key[:])
+	that.block.Encrypt(mac[:], mac[:])
+	return mac
+}
+
+// tag 计算认证标签
+func (that *eax) tag(n [eaxBlockSize]byte, ciphertext, additionalData []byte) [eaxBlockSize]byte {
+	h := that.omac(1, additionalData)
+	c := that.omac(2, ciphertext)
+	var t [eaxBlockSize]byte
+	for i := range t {
+		t[i] = n[i] ^ h[i] ^ c[i]
+	}
+	return t
+}
+
+func (that *eax) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != eaxBlockSize {
+		panic("EAX: nonce长度不正确")
+	}
+	n := that.omac(0, nonce)
+	ret, out := eaxSliceForAppend(dst, len(plaintext)+eaxBlockSize)
+	cipher.NewCTR(that.block, n[:]).XORKeyStream(out, plaintext)
+	t := that.tag(n, out[:len(plaintext)], additionalData)
+	copy(out[len(plaintext):], t[:])
+	return ret
+}
+
+func (that *eax) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != eaxBlockSize {
+		return nil, errors.New("EAX: nonce长度不正确")
+	}
+	if len(ciphertext) < eaxBlockSize {
+		return nil, errors.New("EAX: 密文长度不正确")
+	}
+	n := that.omac(0, nonce)
+	data := ciphertext[:len(ciphertext)-eaxBlockSize]
+	t := that.tag(n, data, additionalData)
+	if subtle.ConstantTimeCompare(t[:], ciphertext[len(data):]) != 1 {
+		return nil, errors.New("EAX: 消息认证失败")
+	}
+	ret, out := eaxSliceForAppend(dst, len(data))
+	cipher.NewCTR(that.block, n[:]).XORKeyStream(out, data)
+	return ret, nil
+}
+
+// eaxSliceForAppend 扩展切片，返回扩展后的切片及新增的部分
+func eaxSliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
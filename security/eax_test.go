@@ -0,0 +1,98 @@
+package security
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+// EAX论文附录中AES-128的测试向量，cipher为密文及16字节认证标签
+var eaxVectors = []struct {
+	msg, key, nonce, header, cipher string
+}{
+	{"", "233952DEE4D5ED5F9B9C6D6FF80FF478", "62EC67F9C3A4A407FCB2A8C49031A8B3", "6BFB914FD07EAE6B", "E037830E8389F27B025A2D6527E79D01"},
+	{"F7FB", "91945D3F4DCBEE0BF45EF52255F095A4", "BECAF043B0A23D843194BA972C66DEBD", "FA3BFD4806EB53FA", "19DD5C4C9331049D0BDAB0277408F67967E5"},
+	{"1A47CB4933", "01F74AD64077F2E704C0F60ADA3DD523", "70C3DB4F0D26368400A10ED05D2BFF5E", "234A3463C1264AC6", "D851D5BAE03A59F238A23E39199DC9266626C40F80"},
+	{"481C9E39B1", "D07CF6CBB7F313BDDE66B727AFD3C5E8", "8408DFFF3C1A2B1292DC199E46B7D617", "33CCE2EABFF5A79D", "632A9D131AD4C168A4225D8E1FF755939974A7BEDE"},
+	{"40D0C07DA5E4", "35B6D0580005BBC12B0587124557D2C2", "FDB6B06676EEDC5C61D74276E1F8E816", "AEB96EAEBE2970E9", "071DFE16C675CB0677E536F73AFE6A14B74EE49844DD"},
+	{"4DE3B35C3FC039245BD1FB7D", "BD8E6E11475E60B268784C38C62FEB22", "6EAC5C93072D8E8513F750935E46DA1B", "D4482D1CA78DCE0F", "835BB4F15D743E350E728414ABB8644FD6CCB86947C5E10590210A4F"},
+	{"8B0A79306C9CE7ED99DAE4F87F8DD61636", "7C77D6E813BED5AC98BAA417477A2E7D", "1A8C98DCD73D38393B2BF1569DEEFC19", "65D2017990D62528", "02083E3979DA014812F59F11D52630DA30137327D10649B0AA6E1C181DB617D7F2"},
+	{"1BDA122BCE8A8DBAF1877D962B8592DD2D56", "5FFF20CAFAB119CA2FC73549E20F5B0D", "DDE59B97D722156D4D9AFF2BC7559826", "54B9F04E6A09189A", "2EC47B2C4954A489AFC7BA4897EDCDAE8CC33B60450599BD02C96382902AEF7F832A"},
+	{"6CF36720872B8513F6EAB1A8A44438D5EF11", "A4A4782BCFFD3EC5E7EF6D8C34A56123", "B781FCF2F75FA5A8DE97A9CA48E522EC", "899A175897561D7E", "0DE18FD0FDD91E7AF19F1D8EE8733938B1E8E7F6D2231618102FDB7FE55FF1991700"},
+	{"CA40D7446E545FFAED3BD12A740A659FFBBB3CEAB7", "8395FCF1E95BEBD697BD010BC766AAC3", "22E7ADD93CFC6393C57EC0B3C17D6B44", "126735FCC320D25A", "CB8920F87A6C75CFF39627B56E3ED197C552D295A7CFC46AFC253B4652B1AF3795B124AB6E"},
+}
+
+func TestEAXVectors(t *testing.T) {
+	for i, v := range eaxVectors {
+		block, err := aes.NewCipher(mustHex(t, v.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead, err := newEAX(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, nonce, header, expected := mustHex(t, v.msg), mustHex(t, v.nonce), mustHex(t, v.header), mustHex(t, v.cipher)
+		if sealed := aead.Seal(nil, nonce, msg, header); !bytes.Equal(sealed, expected) {
+			t.Fatalf("第%d组加密结果为%X，期望%X", i, sealed, expected)
+		}
+		opened, err := aead.Open(nil, nonce, expected, header)
+		if err != nil {
+			t.Fatalf("第%d组解密失败:%v", i, err)
+		}
+		if !bytes.Equal(opened, msg) {
+			t.Fatalf("第%d组解密结果为%X，期望%X", i, opened, msg)
+		}
+		// 修改附加数据或者密文都不能通过认证
+		if _, err = aead.Open(nil, nonce, expected, append([]byte{0}, header...)); err == nil {
+			t.Fatalf("第%d组修改附加数据后解密成功", i)
+		}
+		tampered := append([]byte{}, expected...)
+		tampered[0] ^= 1
+		if _, err = aead.Open(nil, nonce, tampered, header); err == nil {
+			t.Fatalf("第%d组修改密文后解密成功", i)
+		}
+	}
+}
+
+func TestAESEAXConn(t *testing.T) {
+	// 超过单条消息最大长度的数据会被拆分成多条消息，nonce依次递增
+	var wire bytes.Buffer
+	key1, key2 := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 16)
+	writer, err := newAESEAXConn(nil, &wire, nil, key2, key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := newAESEAXConn(&wire, nil, nil, key1, key2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), aesEAXMaxMessageLen/5+1)
+	if _, err = writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if expected := len(data) + 3*(2+eaxBlockSize); wire.Len() != expected {
+		t.Fatalf("加密后长度为%d，期望%d", wire.Len(), expected)
+	}
+	got := make([]byte, len(data))
+	for n := 0; n < len(got); {
+		m, err := reader.Read(got[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("解密后的数据不一致")
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
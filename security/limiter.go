@@ -0,0 +1,212 @@
+package security
+
+import (
+	"github.com/vprix/vncproxy/rfb"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 失败记录的key前缀，地址和用户名分别统计
+const (
+	limiterKeyAddr = "addr:"
+	limiterKeyUser = "user:"
+)
+
+// BlockedClient 被锁定的vnc客户端，RemoteAddr和Username只有一个不为空
+type BlockedClient struct {
+	RemoteAddr string    // 被锁定的地址
+	Username   string    // 被锁定的用户名
+	Lockouts   int       // 连续被锁定的次数
+	Until      time.Time // 锁定的截止时间
+}
+
+// authRecord 一个地址或者用户名的认证失败记录
+type authRecord struct {
+	failures []time.Time // 时间窗口内认证失败的时间
+	lockouts int         // 连续被锁定的次数，每次锁定时长翻倍
+	until    time.Time   // 锁定的截止时间
+	lastFail time.Time   // 最后一次认证失败的时间
+}
+
+// AuthLimiter 认证限流器，与TigerVNC的黑名单类似，按vnc客户端地址及用户名分别记录认证失败次数:
+// 1. 每次认证失败后延迟发送认证结果，延迟时长从Backoff开始翻倍，最长MaxBackoff。
+// 2. Window时间内失败MaxAttempts次则锁定Lockout时长，连续锁定时锁定时长翻倍，最长MaxLockout。
+// 3. 锁定期间拒绝连接，即使密码正确也认证失败，失败原因为 rfb.ErrTooManyAttempts 。
+// 4. 认证成功后清除失败记录，锁定结束后Window时间内没有再失败也会清除失败记录。
+type AuthLimiter struct {
+	MaxAttempts int           // 时间窗口内允许认证失败的次数
+	Window      time.Duration // 统计认证失败次数的时间窗口
+	Backoff     time.Duration // 首次认证失败后的延迟
+	MaxBackoff  time.Duration // 认证失败后的最长延迟
+	Lockout     time.Duration // 首次锁定的时长
+	MaxLockout  time.Duration // 最长锁定时长
+
+	// OnBlocked 有地址或者用户名被锁定时调用，可以用来记录日志或者告警
+	OnBlocked func(client BlockedClient)
+
+	mu        sync.Mutex
+	records   map[string]*authRecord
+	lastPrune time.Time
+	now       func() time.Time // 获取当前时间，为nil则使用time.Now，测试时替换
+}
+
+var _ rfb.AuthLimiter = new(AuthLimiter)
+
+// NewAuthLimiter 创建认证限流器，window时间内认证失败maxAttempts次则锁定lockout时长
+func NewAuthLimiter(maxAttempts int, window, lockout time.Duration) *AuthLimiter {
+	return &AuthLimiter{
+		MaxAttempts: maxAttempts,
+		Window:      window,
+		Backoff:     100 * time.Millisecond,
+		MaxBackoff:  5 * time.Second,
+		Lockout:     lockout,
+		MaxLockout:  24 * time.Hour,
+		records:     make(map[string]*authRecord),
+	}
+}
+
+// clock 获取当前时间
+func (that *AuthLimiter) clock() time.Time {
+	if that.now != nil {
+		return that.now()
+	}
+	return time.Now()
+}
+
+// limiterKeys 地址和用户名对应的记录key
+func limiterKeys(remoteAddr, username string) []string {
+	var keys []string
+	if len(remoteAddr) > 0 {
+		keys = append(keys, limiterKeyAddr+remoteAddr)
+	}
+	if len(username) > 0 {
+		keys = append(keys, limiterKeyUser+username)
+	}
+	return keys
+}
+
+// Allow 判断该地址及用户名是否允许认证
+func (that *AuthLimiter) Allow(remoteAddr, username string) bool {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	now := that.clock()
+	for _, key := range limiterKeys(remoteAddr, username) {
+		if r, ok := that.records[key]; ok && now.Before(r.until) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fail 记录一次认证失败，返回发送认证结果前需要等待的时长
+func (that *AuthLimiter) Fail(remoteAddr, username string) time.Duration {
+	that.mu.Lock()
+	if that.records == nil {
+		that.records = make(map[string]*authRecord)
+	}
+	now := that.clock()
+	that.prune(now)
+	failures := 0
+	var blocked []BlockedClient
+	for _, key := range limiterKeys(remoteAddr, username) {
+		r, ok := that.records[key]
+		if !ok {
+			r = &authRecord{}
+			that.records[key] = r
+		}
+		r.failures = append(r.inWindow(now, that.Window), now)
+		r.lastFail = now
+		if len(r.failures) > failures {
+			failures = len(r.failures)
+		}
+		if that.MaxAttempts > 0 && len(r.failures) >= that.MaxAttempts {
+			r.until = now.Add(doubling(that.Lockout, r.lockouts, that.MaxLockout))
+			r.lockouts++
+			r.failures = nil
+			blocked = append(blocked, r.blockedClient(key))
+		}
+	}
+	that.mu.Unlock()
+	// 在锁外回调，回调中可以调用Blocked等方法
+	if that.OnBlocked != nil {
+		for _, client := range blocked {
+			that.OnBlocked(client)
+		}
+	}
+	if failures == 0 {
+		return 0
+	}
+	return doubling(that.Backoff, failures-1, that.MaxBackoff)
+}
+
+// Reset 清除该地址及用户名的失败记录
+func (that *AuthLimiter) Reset(remoteAddr, username string) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	for _, key := range limiterKeys(remoteAddr, username) {
+		delete(that.records, key)
+	}
+}
+
+// Blocked 当前被锁定的地址及用户名，按锁定截止时间排序
+func (that *AuthLimiter) Blocked() []BlockedClient {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	now := that.clock()
+	var clients []BlockedClient
+	for key, r := range that.records {
+		if now.Before(r.until) {
+			clients = append(clients, r.blockedClient(key))
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Until.Before(clients[j].Until)
+	})
+	return clients
+}
+
+// prune 清除过期的失败记录，每个时间窗口最多执行一次
+func (that *AuthLimiter) prune(now time.Time) {
+	if now.Sub(that.lastPrune) < that.Window {
+		return
+	}
+	that.lastPrune = now
+	for key, r := range that.records {
+		if now.Sub(r.lastFail) > that.Window && now.Sub(r.until) > that.Window {
+			delete(that.records, key)
+		}
+	}
+}
+
+// inWindow 时间窗口内的认证失败记录
+func (that *authRecord) inWindow(now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(that.failures) && now.Sub(that.failures[i]) > window {
+		i++
+	}
+	return that.failures[i:]
+}
+
+func (that *authRecord) blockedClient(key string) BlockedClient {
+	client := BlockedClient{Lockouts: that.lockouts, Until: that.until}
+	if strings.HasPrefix(key, limiterKeyAddr) {
+		client.RemoteAddr = strings.TrimPrefix(key, limiterKeyAddr)
+	} else {
+		client.Username = strings.TrimPrefix(key, limiterKeyUser)
+	}
+	return client
+}
+
+// doubling 从base开始每次翻倍，翻倍n次，不超过max
+func doubling(base time.Duration, n int, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
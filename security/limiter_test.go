@@ -0,0 +1,177 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+// testClock 测试使用的时钟，只有调用advance时才前进
+type testClock struct {
+	t time.Time
+}
+
+func (that *testClock) now() time.Time          { return that.t }
+func (that *testClock) advance(d time.Duration) { that.t = that.t.Add(d) }
+
+// newTestLimiter 使用测试时钟的认证限流器
+func newTestLimiter(maxAttempts int, window, lockout time.Duration) (*AuthLimiter, *testClock) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := NewAuthLimiter(maxAttempts, window, lockout)
+	limiter.now = clock.now
+	return limiter, clock
+}
+
+func TestAuthLimiterBackoff(t *testing.T) {
+	limiter, _ := newTestLimiter(0, time.Minute, time.Minute)
+	limiter.Backoff = 100 * time.Millisecond
+	limiter.MaxBackoff = time.Second
+	// 不限制失败次数时只延迟，不锁定
+	for i, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if d := limiter.Fail("10.0.0.1", ""); d != want*time.Millisecond {
+			t.Fatalf("第%d次失败延迟%v，应该为%v", i+1, d, want*time.Millisecond)
+		}
+	}
+	if !limiter.Allow("10.0.0.1", "") {
+		t.Fatal("不限制失败次数时被锁定")
+	}
+	// 没有地址及用户名时不记录
+	if d := limiter.Fail("", ""); d != 0 {
+		t.Fatalf("没有地址及用户名时延迟%v", d)
+	}
+}
+
+func TestAuthLimiterLockout(t *testing.T) {
+	limiter, clock := newTestLimiter(3, 10*time.Minute, time.Minute)
+	limiter.MaxLockout = 3 * time.Minute
+	var blocked []BlockedClient
+	limiter.OnBlocked = func(client BlockedClient) {
+		blocked = append(blocked, client)
+	}
+	// 每次连续锁定的时长翻倍，不超过MaxLockout
+	for i, lockout := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		for j := 0; j < 3; j++ {
+			if !limiter.Allow("10.0.0.1", "") {
+				t.Fatalf("第%d轮第%d次失败前被锁定", i+1, j+1)
+			}
+			limiter.Fail("10.0.0.1", "")
+		}
+		if limiter.Allow("10.0.0.1", "") {
+			t.Fatalf("第%d轮失败3次后没有被锁定", i+1)
+		}
+		if len(blocked) != i+1 || blocked[i].RemoteAddr != "10.0.0.1" || blocked[i].Lockouts != i+1 ||
+			!blocked[i].Until.Equal(clock.now().Add(lockout)) {
+			t.Fatalf("第%d轮锁定信息为%+v，应该锁定%v", i+1, blocked, lockout)
+		}
+		clock.advance(lockout - time.Second)
+		if limiter.Allow("10.0.0.1", "") {
+			t.Fatalf("第%d轮锁定结束前允许认证", i+1)
+		}
+		clock.advance(time.Second)
+		if !limiter.Allow("10.0.0.1", "") {
+			t.Fatalf("第%d轮锁定结束后不允许认证", i+1)
+		}
+	}
+	// 其他地址不受影响
+	if !limiter.Allow("10.0.0.2", "") {
+		t.Fatal("其他地址被锁定")
+	}
+}
+
+func TestAuthLimiterKeys(t *testing.T) {
+	cases := []struct {
+		name     string
+		fails    [][2]string // 失败的地址及用户名
+		check    [2]string
+		allowed  bool
+		blockedN int
+	}{
+		{"同一地址不同用户名", [][2]string{{"10.0.0.1", "alice"}, {"10.0.0.1", "bob"}}, [2]string{"10.0.0.1", "carol"}, false, 1},
+		{"同一用户名不同地址", [][2]string{{"10.0.0.1", "alice"}, {"10.0.0.2", "alice"}}, [2]string{"10.0.0.3", "alice"}, false, 1},
+		{"同一地址及用户名", [][2]string{{"10.0.0.1", "alice"}, {"10.0.0.1", "alice"}}, [2]string{"10.0.0.1", ""}, false, 2},
+		{"不同地址及用户名", [][2]string{{"10.0.0.1", "alice"}, {"10.0.0.2", "bob"}}, [2]string{"10.0.0.1", "alice"}, true, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			limiter, _ := newTestLimiter(2, time.Minute, time.Minute)
+			for _, f := range c.fails {
+				limiter.Fail(f[0], f[1])
+			}
+			if allowed := limiter.Allow(c.check[0], c.check[1]); allowed != c.allowed {
+				t.Fatalf("是否允许认证为%v", allowed)
+			}
+			if blocked := limiter.Blocked(); len(blocked) != c.blockedN {
+				t.Fatalf("被锁定的vnc客户端为%+v", blocked)
+			}
+		})
+	}
+}
+
+func TestAuthLimiterWindow(t *testing.T) {
+	limiter, clock := newTestLimiter(3, time.Minute, time.Minute)
+	limiter.Fail("10.0.0.1", "")
+	clock.advance(40 * time.Second)
+	limiter.Fail("10.0.0.1", "")
+	// 第一次失败已经超出时间窗口，时间窗口内只有两次失败
+	clock.advance(30 * time.Second)
+	if d := limiter.Fail("10.0.0.1", ""); d != 2*limiter.Backoff {
+		t.Fatalf("时间窗口内第二次失败延迟%v", d)
+	}
+	if !limiter.Allow("10.0.0.1", "") {
+		t.Fatal("超出时间窗口的失败被统计")
+	}
+	limiter.Fail("10.0.0.1", "")
+	if limiter.Allow("10.0.0.1", "") {
+		t.Fatal("时间窗口内失败3次没有被锁定")
+	}
+}
+
+func TestAuthLimiterReset(t *testing.T) {
+	limiter, _ := newTestLimiter(2, time.Minute, time.Minute)
+	limiter.Fail("10.0.0.1", "alice")
+	limiter.Fail("10.0.0.1", "alice")
+	limiter.Fail("10.0.0.2", "")
+	if limiter.Allow("10.0.0.1", "alice") {
+		t.Fatal("没有被锁定")
+	}
+	// 认证成功后清除地址及用户名的失败记录，其他地址的记录保留
+	limiter.Reset("10.0.0.1", "alice")
+	if !limiter.Allow("10.0.0.1", "alice") || len(limiter.Blocked()) != 0 {
+		t.Fatalf("清除后被锁定的vnc客户端为%+v", limiter.Blocked())
+	}
+	if d := limiter.Fail("10.0.0.1", "alice"); d != limiter.Backoff {
+		t.Fatalf("清除后第一次失败延迟%v", d)
+	}
+	if d := limiter.Fail("10.0.0.2", ""); d != 2*limiter.Backoff {
+		t.Fatalf("其他地址的失败记录被清除，延迟%v", d)
+	}
+}
+
+func TestAuthLimiterPrune(t *testing.T) {
+	limiter, clock := newTestLimiter(2, time.Minute, 5*time.Minute)
+	has := func(addr string) bool {
+		_, ok := limiter.records[limiterKeyAddr+addr]
+		return ok
+	}
+	limiter.Fail("10.0.0.1", "")
+	limiter.Fail("10.0.0.4", "")
+	limiter.Fail("10.0.0.4", "")
+	clock.advance(30 * time.Second)
+	limiter.Fail("10.0.0.2", "")
+	// 10.0.0.1的失败已经超出时间窗口被清除，10.0.0.4仍在锁定中保留
+	clock.advance(50 * time.Second)
+	limiter.Fail("10.0.0.3", "")
+	if has("10.0.0.1") || !has("10.0.0.2") || !has("10.0.0.4") {
+		t.Fatalf("清除后的失败记录为%v", limiter.records)
+	}
+	// 每个时间窗口最多清除一次，10.0.0.2已经过期但距离上次清除不到一个时间窗口
+	clock.advance(40 * time.Second)
+	limiter.Fail("10.0.0.3", "")
+	if !has("10.0.0.2") {
+		t.Fatal("一个时间窗口内清除了多次")
+	}
+	clock.advance(20 * time.Second)
+	limiter.Fail("10.0.0.3", "")
+	if has("10.0.0.2") || !has("10.0.0.3") || !has("10.0.0.4") || len(limiter.records) != 2 {
+		t.Fatalf("清除后的失败记录为%v", limiter.records)
+	}
+}
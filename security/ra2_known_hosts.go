@@ -0,0 +1,107 @@
+package security
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// knownHostsMu 串行化对RA2公钥记录文件的读写，多个连接同时首次连接同一个vnc服务端时避免重复记录
+var knownHostsMu sync.Mutex
+
+// RA2KeyFingerprint 计算RA2认证中rsa公钥的指纹，为公钥原始数据sha256的十六进制
+func RA2KeyFingerprint(key *rsa.PublicKey) string {
+	sum := sha256.Sum256(encodeRA2PublicKey(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint 统一指纹的格式，允许使用冒号分隔及大写
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
+
+// PinRA2ServerKey 固定vnc服务端的rsa公钥，公钥的指纹与传入的不一致则认证失败
+func PinRA2ServerKey(fingerprint string) func(key *rsa.PublicKey) error {
+	fingerprint = normalizeFingerprint(fingerprint)
+	return func(key *rsa.PublicKey) error {
+		if actual := RA2KeyFingerprint(key); actual != fingerprint {
+			return fmt.Errorf("vnc服务端公钥指纹%s与配置的%s不一致", actual, fingerprint)
+		}
+		return nil
+	}
+}
+
+// DefaultRA2KnownHostsFile 没有配置公钥指纹及记录文件时默认使用的记录文件，位于用户配置目录下的vncproxy/ra2_known_hosts
+func DefaultRA2KnownHostsFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vncproxy", "ra2_known_hosts"), nil
+}
+
+// KnownHostsRA2ServerKey 使用文件记录vnc服务端的rsa公钥(trust on first use)，
+// 首次连接addr时记录公钥指纹，之后公钥改变则认证失败，需要手动删除文件中对应的记录。
+// 文件每行为 "地址 指纹"，以#开头的行是注释。
+func KnownHostsRA2ServerKey(file string, addr string) func(key *rsa.PublicKey) error {
+	return func(key *rsa.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+		actual := RA2KeyFingerprint(key)
+		known, ok, err := lookupKnownHost(file, addr)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if known != actual {
+				return fmt.Errorf("vnc服务端%s的公钥指纹%s与%s中记录的%s不一致，公钥可能被篡改", addr, actual, file, known)
+			}
+			return nil
+		}
+		if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(f, "%s %s\n", addr, actual)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+}
+
+// lookupKnownHost 在记录文件中查找addr的公钥指纹，文件不存在时当作没有记录
+func lookupKnownHost(file string, addr string) (string, bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return "", false, fmt.Errorf("vnc服务端公钥记录文件%s的格式不正确:%s", file, line)
+		}
+		if fields[0] == addr {
+			return normalizeFingerprint(fields[1]), true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
@@ -0,0 +1,104 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+	"math/big"
+)
+
+// ardMaxKeyLen ARD认证中Diffie-Hellman密钥的最大长度
+const ardMaxKeyLen = 1024
+
+// ardCredentialLen ARD认证中用户名和密码各自占用的长度，包含结尾的0
+const ardCredentialLen = 64
+
+// ClientAuthARD vnc客户端使用Apple Remote Desktop认证方式(类型30)，macOS自带的屏幕共享使用该认证
+// 1. 读取vnc服务端发送的Diffie-Hellman生成元，素数及公钥
+// 2. 生成客户端公钥，计算共享密钥，使用共享密钥的md5作为AES-128密钥
+// 3. 使用AES-128-ECB加密用户名和密码，与客户端公钥一起发送给vnc服务端
+type ClientAuthARD struct {
+	Username []byte // macOS的用户名
+	Password []byte // macOS的密码
+}
+
+var _ rfb.ISecurityHandler = new(ClientAuthARD)
+
+func (*ClientAuthARD) Type() rfb.SecurityType {
+	return rfb.SecTypeARD
+}
+
+func (*ClientAuthARD) SubType() rfb.SecuritySubType {
+	return rfb.SecSubTypeUnknown
+}
+
+func (that *ClientAuthARD) Auth(session rfb.ISession) error {
+	if len(that.Username) == 0 || len(that.Password) == 0 {
+		return fmt.Errorf("安全认证失败，因为没有传入ARD认证方式所用的用户名和密码")
+	}
+	if len(that.Username) >= ardCredentialLen || len(that.Password) >= ardCredentialLen {
+		return fmt.Errorf("ARD认证的用户名和密码不能超过%d字节", ardCredentialLen-1)
+	}
+	var generator, keyLen uint16
+	if err := binary.Read(session, binary.BigEndian, &generator); err != nil {
+		return err
+	}
+	if err := binary.Read(session, binary.BigEndian, &keyLen); err != nil {
+		return err
+	}
+	if keyLen == 0 || keyLen > ardMaxKeyLen {
+		return fmt.Errorf("ARD认证的密钥长度不正确:%d", keyLen)
+	}
+	primeBytes := make([]byte, keyLen)
+	if err := binary.Read(session, binary.BigEndian, &primeBytes); err != nil {
+		return err
+	}
+	serverPubBytes := make([]byte, keyLen)
+	if err := binary.Read(session, binary.BigEndian, &serverPubBytes); err != nil {
+		return err
+	}
+	prime := new(big.Int).SetBytes(primeBytes)
+	serverPub := new(big.Int).SetBytes(serverPubBytes)
+	// 对端公钥必须在(1,p-1)之间，否则共享密钥可以被预测
+	pMinus1 := new(big.Int).Sub(prime, big.NewInt(1))
+	if serverPub.Cmp(big.NewInt(1)) <= 0 || serverPub.Cmp(pMinus1) >= 0 {
+		return fmt.Errorf("ARD认证的vnc服务端公钥不正确")
+	}
+	// 生成客户端私钥和公钥，计算共享密钥
+	priv, err := rand.Int(rand.Reader, new(big.Int).Sub(prime, big.NewInt(3)))
+	if err != nil {
+		return err
+	}
+	priv.Add(priv, big.NewInt(2))
+	pub := new(big.Int).Exp(big.NewInt(int64(generator)), priv, prime)
+	secret := new(big.Int).Exp(serverPub, priv, prime)
+	key := md5.Sum(secret.FillBytes(make([]byte, keyLen)))
+
+	// 用户名和密码各占64字节，以0结尾，剩余部分使用随机数填充
+	credentials := make([]byte, ardCredentialLen*2)
+	if _, err = rand.Read(credentials); err != nil {
+		return err
+	}
+	copy(credentials, that.Username)
+	credentials[len(that.Username)] = 0
+	copy(credentials[ardCredentialLen:], that.Password)
+	credentials[ardCredentialLen+len(that.Password)] = 0
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(credentials); i += block.BlockSize() {
+		block.Encrypt(credentials[i:i+block.BlockSize()], credentials[i:i+block.BlockSize()])
+	}
+	if err = binary.Write(session, binary.BigEndian, credentials); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, pub.FillBytes(make([]byte, keyLen))); err != nil {
+		return err
+	}
+	return session.Flush()
+}
@@ -0,0 +1,116 @@
+package security
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"math/big"
+	"testing"
+)
+
+// ardServer 模拟使用ARD认证的macOS屏幕共享
+type ardServer struct {
+	generator uint16
+	prime     *big.Int
+	serverPub *big.Int // 不为nil时发送该公钥，用于测试不合法的公钥
+
+	username, password string // 解密得到的用户名和密码
+}
+
+func (that *ardServer) Auth(session rfb.ISession) error {
+	keyLen := (that.prime.BitLen() + 7) / 8
+	priv, err := rand.Int(rand.Reader, that.prime)
+	if err != nil {
+		return err
+	}
+	pub := new(big.Int).Exp(big.NewInt(int64(that.generator)), priv, that.prime)
+	if that.serverPub != nil {
+		pub = that.serverPub
+	}
+	if err = binary.Write(session, binary.BigEndian, that.generator); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, uint16(keyLen)); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, that.prime.FillBytes(make([]byte, keyLen))); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, pub.FillBytes(make([]byte, keyLen))); err != nil {
+		return err
+	}
+	credentials := make([]byte, ardCredentialLen*2)
+	if _, err = io.ReadFull(session, credentials); err != nil {
+		return err
+	}
+	clientPub := make([]byte, keyLen)
+	if _, err = io.ReadFull(session, clientPub); err != nil {
+		return err
+	}
+	secret := new(big.Int).Exp(new(big.Int).SetBytes(clientPub), priv, that.prime)
+	key := md5.Sum(secret.FillBytes(make([]byte, keyLen)))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(credentials); i += block.BlockSize() {
+		block.Decrypt(credentials[i:i+block.BlockSize()], credentials[i:i+block.BlockSize()])
+	}
+	cString := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			return string(b[:i])
+		}
+		return string(b)
+	}
+	that.username = cString(credentials[:ardCredentialLen])
+	that.password = cString(credentials[ardCredentialLen:])
+	return nil
+}
+
+func (*ardServer) Type() rfb.SecurityType       { return rfb.SecTypeARD }
+func (*ardServer) SubType() rfb.SecuritySubType { return rfb.SecSubTypeUnknown }
+
+func TestClientAuthARD(t *testing.T) {
+	prime, err := rand.Prime(rand.Reader, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		server *ardServer
+		client *ClientAuthARD
+		ok     bool
+	}{
+		{"认证成功", &ardServer{generator: 2, prime: prime},
+			&ClientAuthARD{Username: []byte("user"), Password: []byte("secret")}, true},
+		{"缺少用户名", &ardServer{generator: 2, prime: prime},
+			&ClientAuthARD{Password: []byte("secret")}, false},
+		{"密码过长", &ardServer{generator: 2, prime: prime},
+			&ClientAuthARD{Username: []byte("user"), Password: bytes.Repeat([]byte("a"), ardCredentialLen)}, false},
+		{"服务端公钥为1", &ardServer{generator: 2, prime: prime, serverPub: big.NewInt(1)},
+			&ClientAuthARD{Username: []byte("user"), Password: []byte("secret")}, false},
+		{"服务端公钥为p-1", &ardServer{generator: 2, prime: prime, serverPub: new(big.Int).Sub(prime, big.NewInt(1))},
+			&ClientAuthARD{Username: []byte("user"), Password: []byte("secret")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srvErr, cliErr, _ := runAuth(t, tt.server, tt.client)
+			if !tt.ok {
+				if cliErr == nil {
+					t.Fatal("期望客户端认证失败")
+				}
+				return
+			}
+			if srvErr != nil || cliErr != nil {
+				t.Fatalf("认证失败:%v %v", srvErr, cliErr)
+			}
+			if tt.server.username != "user" || tt.server.password != "secret" {
+				t.Fatalf("服务端解密得到的凭证为%q %q", tt.server.username, tt.server.password)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package security
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+	"math/big"
+)
+
+const (
+	ra2MinKeyBits    = 1024 // vnc服务端rsa公钥的最小长度
+	ra2MaxKeyBits    = 8192 // vnc服务端rsa公钥的最大长度
+	ra2ClientKeyBits = 2048 // 客户端生成的rsa密钥长度
+	ra2RandomLen     = 16   // 双方随机数的长度，AES-128
+)
+
+// RA2认证的子类型，由vnc服务端决定需要的凭证
+const (
+	ra2SubTypeUserPass uint8 = 1 // 需要用户名和密码
+	ra2SubTypePass     uint8 = 2 // 只需要密码
+)
+
+// ClientAuthRA2 vnc客户端使用RSA-AES认证方式，RealVNC及新版TigerVNC使用该认证
+// 1. 交换双方的rsa公钥，使用对方的公钥加密各自的随机数并交换
+// 2. 使用双方随机数的sha1作为AES-EAX的密钥，之后的数据都使用AES-EAX加密
+// 3. 交换双方公钥的sha1，确认没有被篡改，然后发送用户名和密码
+// RA2(类型5)认证之后的所有数据都是加密的，RA2ne(类型6)只加密认证过程。
+type ClientAuthRA2 struct {
+	Username     []byte
+	Password     []byte
+	NoEncryption bool // 为true时使用RA2ne，认证之后的数据不加密
+
+	// VerifyServerKey 校验vnc服务端的rsa公钥，为nil则信任任何公钥，可以用来固定vnc服务端的公钥
+	VerifyServerKey func(key *rsa.PublicKey) error
+}
+
+var _ rfb.ISecurityHandler = new(ClientAuthRA2)
+
+func (that *ClientAuthRA2) Type() rfb.SecurityType {
+	if that.NoEncryption {
+		return rfb.SecTypeRA2ne
+	}
+	return rfb.SecTypeRA2
+}
+
+func (*ClientAuthRA2) SubType() rfb.SecuritySubType {
+	return rfb.SecSubTypeUnknown
+}
+
+func (that *ClientAuthRA2) Auth(session rfb.ISession) error {
+	setter, ok := session.(rfb.IConnSetter)
+	if !ok {
+		return fmt.Errorf("会话不支持替换连接，无法使用RA2认证")
+	}
+	// 读取vnc服务端的公钥
+	serverKey, serverKeyBytes, err := readRA2PublicKey(session)
+	if err != nil {
+		return err
+	}
+	if that.VerifyServerKey != nil {
+		if err = that.VerifyServerKey(serverKey); err != nil {
+			return fmt.Errorf("vnc服务端公钥校验失败:%v", err)
+		}
+	}
+	// 生成并发送客户端公钥
+	clientKey, err := rsa.GenerateKey(rand.Reader, ra2ClientKeyBits)
+	if err != nil {
+		return err
+	}
+	clientKeyBytes := encodeRA2PublicKey(&clientKey.PublicKey)
+	if err = binary.Write(session, binary.BigEndian, clientKeyBytes); err != nil {
+		return err
+	}
+	// 使用vnc服务端的公钥加密客户端随机数并发送
+	clientRandom := make([]byte, ra2RandomLen)
+	if _, err = rand.Read(clientRandom); err != nil {
+		return err
+	}
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, serverKey, clientRandom)
+	if err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, uint16(len(encrypted))); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, encrypted); err != nil {
+		return err
+	}
+	if err = session.Flush(); err != nil {
+		return err
+	}
+	// 读取vnc服务端使用客户端公钥加密的随机数
+	var length uint16
+	if err = binary.Read(session, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	if int(length) != clientKey.Size() {
+		return fmt.Errorf("RA2认证的随机数长度不正确:%d", length)
+	}
+	encrypted = make([]byte, length)
+	if err = binary.Read(session, binary.BigEndian, &encrypted); err != nil {
+		return err
+	}
+	serverRandom, err := rsa.DecryptPKCS1v15(rand.Reader, clientKey, encrypted)
+	if err != nil {
+		return fmt.Errorf("解密vnc服务端随机数失败:%v", err)
+	}
+	if len(serverRandom) != ra2RandomLen {
+		return fmt.Errorf("RA2认证的随机数长度不正确:%d", len(serverRandom))
+	}
+
+	// 之后的数据都使用AES-EAX加密
+	readKey := sha1.Sum(append(append([]byte{}, clientRandom...), serverRandom...))
+	writeKey := sha1.Sum(append(append([]byte{}, serverRandom...), clientRandom...))
+	raw := toNetConn(session.Conn(), setter.TakeBuffered())
+	conn, err := newAESEAXConn(raw, raw, raw, readKey[:ra2RandomLen], writeKey[:ra2RandomLen])
+	if err != nil {
+		return err
+	}
+	setter.SetConn(conn)
+
+	// 交换双方公钥的hash，确认公钥没有被篡改
+	hash := sha1.Sum(append(append([]byte{}, clientKeyBytes...), serverKeyBytes...))
+	if err = binary.Write(session, binary.BigEndian, hash[:]); err != nil {
+		return err
+	}
+	if err = session.Flush(); err != nil {
+		return err
+	}
+	var serverHash [sha1.Size]byte
+	if err = binary.Read(session, binary.BigEndian, &serverHash); err != nil {
+		return err
+	}
+	if expected := sha1.Sum(append(append([]byte{}, serverKeyBytes...), clientKeyBytes...)); !bytes.Equal(expected[:], serverHash[:]) {
+		return fmt.Errorf("RA2认证的公钥hash不一致，连接可能被篡改")
+	}
+
+	// 读取vnc服务端要求的凭证类型，发送用户名和密码
+	var subType uint8
+	if err = binary.Read(session, binary.BigEndian, &subType); err != nil {
+		return err
+	}
+	username := that.Username
+	switch subType {
+	case ra2SubTypeUserPass:
+		if len(username) == 0 {
+			return fmt.Errorf("安全认证失败，vnc服务端要求RA2认证提供用户名")
+		}
+	case ra2SubTypePass:
+		username = nil
+	default:
+		return fmt.Errorf("不支持的RA2认证子类型:%d", subType)
+	}
+	if len(username) > 255 || len(that.Password) > 255 {
+		return fmt.Errorf("RA2认证的用户名和密码不能超过255字节")
+	}
+	if err = binary.Write(session, binary.BigEndian, uint8(len(username))); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, username); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, uint8(len(that.Password))); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, that.Password); err != nil {
+		return err
+	}
+	if err = session.Flush(); err != nil {
+		return err
+	}
+	// RA2ne认证之后恢复为不加密的连接
+	if that.NoEncryption {
+		setter.SetConn(raw)
+	}
+	return nil
+}
+
+// readRA2PublicKey 读取rsa公钥，格式为4字节的密钥位数，模数和指数，模数和指数的长度都是密钥的字节数
+// 返回公钥及公钥的原始数据，原始数据用于计算hash
+func readRA2PublicKey(session rfb.ISession) (*rsa.PublicKey, []byte, error) {
+	var bits uint32
+	if err := binary.Read(session, binary.BigEndian, &bits); err != nil {
+		return nil, nil, err
+	}
+	if bits < ra2MinKeyBits || bits > ra2MaxKeyBits {
+		return nil, nil, fmt.Errorf("RA2认证的公钥长度不正确:%d", bits)
+	}
+	size := (bits + 7) / 8
+	buf := make([]byte, 4+2*size)
+	binary.BigEndian.PutUint32(buf, bits)
+	if err := binary.Read(session, binary.BigEndian, buf[4:]); err != nil {
+		return nil, nil, err
+	}
+	n := new(big.Int).SetBytes(buf[4 : 4+size])
+	e := new(big.Int).SetBytes(buf[4+size:])
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, nil, fmt.Errorf("RA2认证的公钥指数不正确")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, buf, nil
+}
+
+// encodeRA2PublicKey 按RA2认证的格式编码rsa公钥
+func encodeRA2PublicKey(key *rsa.PublicKey) []byte {
+	size := key.Size()
+	buf := make([]byte, 4+2*size)
+	binary.BigEndian.PutUint32(buf, uint32(key.N.BitLen()))
+	key.N.FillBytes(buf[4 : 4+size])
+	big.NewInt(int64(key.E)).FillBytes(buf[4+size:])
+	return buf
+}
@@ -0,0 +1,243 @@
+package security
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"github.com/gogf/gf/v2/util/grand"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ra2Server 模拟使用RA2认证的vnc服务端
+type ra2Server struct {
+	key        *rsa.PrivateKey
+	subType    uint8
+	encrypted  bool // 认证之后的数据是否加密
+	tamperHash bool // 发送错误的公钥hash，模拟中间人
+
+	username, password, after string // vnc客户端发送的凭证及认证之后发送的数据
+}
+
+func (that *ra2Server) Auth(session rfb.ISession) error {
+	serverKeyBytes := encodeRA2PublicKey(&that.key.PublicKey)
+	if err := binary.Write(session, binary.BigEndian, serverKeyBytes); err != nil {
+		return err
+	}
+	clientKey, clientKeyBytes, err := readRA2PublicKey(session)
+	if err != nil {
+		return err
+	}
+	var length uint16
+	if err = binary.Read(session, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	encrypted := make([]byte, length)
+	if _, err = io.ReadFull(session, encrypted); err != nil {
+		return err
+	}
+	clientRandom, err := rsa.DecryptPKCS1v15(rand.Reader, that.key, encrypted)
+	if err != nil {
+		return err
+	}
+	serverRandom := grand.B(ra2RandomLen)
+	if encrypted, err = rsa.EncryptPKCS1v15(rand.Reader, clientKey, serverRandom); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, uint16(len(encrypted))); err != nil {
+		return err
+	}
+	if err = binary.Write(session, binary.BigEndian, encrypted); err != nil {
+		return err
+	}
+
+	// 与客户端的读写密钥相反
+	readKey := sha1.Sum(append(append([]byte{}, serverRandom...), clientRandom...))
+	writeKey := sha1.Sum(append(append([]byte{}, clientRandom...), serverRandom...))
+	raw := session.Conn()
+	conn, err := newAESEAXConn(raw, raw, raw, readKey[:ra2RandomLen], writeKey[:ra2RandomLen])
+	if err != nil {
+		return err
+	}
+	hash := sha1.Sum(append(append([]byte{}, serverKeyBytes...), clientKeyBytes...))
+	if that.tamperHash {
+		hash[0] ^= 1
+	}
+	if _, err = conn.Write(hash[:]); err != nil {
+		return err
+	}
+	var clientHash [sha1.Size]byte
+	if _, err = io.ReadFull(conn, clientHash[:]); err != nil {
+		return err
+	}
+	if expected := sha1.Sum(append(append([]byte{}, clientKeyBytes...), serverKeyBytes...)); expected != clientHash {
+		return fmt.Errorf("公钥hash不一致")
+	}
+	if _, err = conn.Write([]byte{that.subType}); err != nil {
+		return err
+	}
+	readString := func() (string, error) {
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return "", err
+		}
+		buf := make([]byte, l[0])
+		_, err := io.ReadFull(conn, buf)
+		return string(buf), err
+	}
+	if that.username, err = readString(); err != nil {
+		return err
+	}
+	if that.password, err = readString(); err != nil {
+		return err
+	}
+	// 读取认证之后的数据，RA2继续使用加密连接，RA2ne使用原始连接
+	var r io.Reader = raw
+	if that.encrypted {
+		r = conn
+	}
+	after := make([]byte, 5)
+	if _, err = io.ReadFull(r, after); err != nil {
+		return err
+	}
+	that.after = string(after)
+	return nil
+}
+
+func (*ra2Server) Type() rfb.SecurityType       { return rfb.SecTypeRA2 }
+func (*ra2Server) SubType() rfb.SecuritySubType { return rfb.SecSubTypeUnknown }
+
+// ra2Client 执行RA2认证后发送hello
+func ra2Client(auth *ClientAuthRA2) authFunc {
+	return func(session rfb.ISession) error {
+		if err := auth.Auth(session); err != nil {
+			return err
+		}
+		_, err := session.Write([]byte("hello"))
+		return err
+	}
+}
+
+func TestClientAuthRA2(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, ra2MinKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, ra2MinKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 记录文件中的公钥与vnc服务端的公钥不一致
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err = os.WriteFile(knownHosts, []byte("10.0.0.1:5900 "+RA2KeyFingerprint(&other.PublicKey)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		server   *ra2Server
+		client   *ClientAuthRA2
+		ok       bool
+		username string
+	}{
+		{"RA2用户名密码", &ra2Server{key: key, subType: ra2SubTypeUserPass, encrypted: true},
+			&ClientAuthRA2{Username: []byte("user"), Password: []byte("secret")}, true, "user"},
+		{"RA2ne只需要密码", &ra2Server{key: key, subType: ra2SubTypePass},
+			&ClientAuthRA2{Username: []byte("user"), Password: []byte("secret"), NoEncryption: true}, true, ""},
+		{"缺少用户名", &ra2Server{key: key, subType: ra2SubTypeUserPass, encrypted: true},
+			&ClientAuthRA2{Password: []byte("secret")}, false, ""},
+		{"不支持的子类型", &ra2Server{key: key, subType: 3, encrypted: true},
+			&ClientAuthRA2{Password: []byte("secret")}, false, ""},
+		{"公钥hash被篡改", &ra2Server{key: key, subType: ra2SubTypePass, encrypted: true, tamperHash: true},
+			&ClientAuthRA2{Password: []byte("secret")}, false, ""},
+		{"固定公钥一致", &ra2Server{key: key, subType: ra2SubTypePass, encrypted: true},
+			&ClientAuthRA2{Password: []byte("secret"), VerifyServerKey: PinRA2ServerKey(RA2KeyFingerprint(&key.PublicKey))}, true, ""},
+		{"固定公钥不一致", &ra2Server{key: key, subType: ra2SubTypePass, encrypted: true},
+			&ClientAuthRA2{Password: []byte("secret"), VerifyServerKey: PinRA2ServerKey(RA2KeyFingerprint(&other.PublicKey))}, false, ""},
+		{"记录的公钥不一致", &ra2Server{key: key, subType: ra2SubTypePass, encrypted: true},
+			&ClientAuthRA2{Password: []byte("secret"), VerifyServerKey: KnownHostsRA2ServerKey(knownHosts, "10.0.0.1:5900")}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srvErr, cliErr, _ := runAuth(t, tt.server, ra2Client(tt.client))
+			if !tt.ok {
+				if cliErr == nil {
+					t.Fatal("期望客户端认证失败")
+				}
+				return
+			}
+			if srvErr != nil || cliErr != nil {
+				t.Fatalf("认证失败:%v %v", srvErr, cliErr)
+			}
+			if tt.server.username != tt.username || tt.server.password != "secret" {
+				t.Fatalf("服务端收到的凭证为%q %q", tt.server.username, tt.server.password)
+			}
+			if tt.server.after != "hello" {
+				t.Fatalf("服务端收到认证之后的数据为%q", tt.server.after)
+			}
+		})
+	}
+}
+
+func TestPinRA2ServerKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, ra2MinKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := RA2KeyFingerprint(&key.PublicKey)
+	// 允许大写及冒号分隔的指纹
+	var colon []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colon = append(colon, strings.ToUpper(fingerprint[i:i+2]))
+	}
+	if err = PinRA2ServerKey(strings.Join(colon, ":"))(&key.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKnownHostsRA2ServerKey(t *testing.T) {
+	key1, err := rsa.GenerateKey(rand.Reader, ra2MinKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := rsa.GenerateKey(rand.Reader, ra2MinKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	verify := KnownHostsRA2ServerKey(file, "10.0.0.1:5900")
+	// 首次连接记录公钥，之后同样的公钥可以通过，不同的公钥失败
+	if err = verify(&key1.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = verify(&key1.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = verify(&key2.PublicKey); err == nil {
+		t.Fatal("公钥改变后校验通过")
+	}
+	// 其他地址单独记录
+	if err = KnownHostsRA2ServerKey(file, "10.0.0.2:5900")(&key2.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("10.0.0.1:5900 %s\n10.0.0.2:5900 %s\n", RA2KeyFingerprint(&key1.PublicKey), RA2KeyFingerprint(&key2.PublicKey))
+	if !bytes.Equal(content, []byte(expected)) {
+		t.Fatalf("记录文件内容为%q，期望%q", content, expected)
+	}
+	if err = os.WriteFile(file, []byte("# comment\nbroken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = verify(&key1.PublicKey); err == nil {
+		t.Fatal("格式错误的记录文件校验通过")
+	}
+}
@@ -1 +1,191 @@
 package security
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+)
+
+// TightCapability tight认证中描述隧道类型及认证类型的结构
+type TightCapability struct {
+	Code   int32   // 类型编码
+	Vendor [4]byte // 厂商标识
+	Name   [8]byte // 类型名称
+}
+
+func newTightCapability(code int32, vendor, name string) TightCapability {
+	c := TightCapability{Code: code}
+	copy(c.Vendor[:], vendor)
+	copy(c.Name[:], name)
+	return c
+}
+
+func (that TightCapability) String() string {
+	return fmt.Sprintf("%d:%s%s", that.Code, that.Vendor[:], that.Name[:])
+}
+
+var (
+	TightNoTunnel = newTightCapability(0, "TGHT", "NOTUNNEL") // 不使用隧道
+	TightAuthNone = newTightCapability(1, "STDV", "NOAUTH__") // 不需要认证
+	TightAuthVNC  = newTightCapability(2, "STDV", "VNCAUTH_") // vnc密码认证
+)
+
+// tightMaxCapabilities 隧道类型及认证类型数量的上限，防止恶意的对端发送超大的数量
+const tightMaxCapabilities = 64
+
+// tightAuthCapability 根据内层认证方式获取对应的tight认证类型，目前只支持None和VNC
+func tightAuthCapability(handler rfb.ISecurityHandler) (TightCapability, bool) {
+	switch handler.Type() {
+	case rfb.SecTypeNone:
+		return TightAuthNone, true
+	case rfb.SecTypeVNC:
+		return TightAuthVNC, true
+	}
+	return TightCapability{}, false
+}
+
+// readTightCapabilities 读取对端发送的类型列表
+func readTightCapabilities(session rfb.ISession) ([]TightCapability, error) {
+	var num uint32
+	if err := binary.Read(session, binary.BigEndian, &num); err != nil {
+		return nil, err
+	}
+	if num > tightMaxCapabilities {
+		return nil, fmt.Errorf("tight认证类型数量太多:%d", num)
+	}
+	caps := make([]TightCapability, num)
+	if err := binary.Read(session, binary.BigEndian, &caps); err != nil {
+		return nil, err
+	}
+	return caps, nil
+}
+
+// writeTightCapabilities 发送类型列表
+func writeTightCapabilities(session rfb.ISession, caps []TightCapability) error {
+	if err := binary.Write(session, binary.BigEndian, uint32(len(caps))); err != nil {
+		return err
+	}
+	if err := binary.Write(session, binary.BigEndian, caps); err != nil {
+		return err
+	}
+	return session.Flush()
+}
+
+// ServerAuthTight vnc服务端使用tight认证方式
+// 不提供隧道，认证类型由Handlers决定，目前支持 ServerAuthNone 和 ServerAuthVNC 等None及VNC认证方式。
+// 使用tight认证后，ServerInit阶段需要额外发送交互能力列表，由 handler.ServerServerInitHandler 处理。
+type ServerAuthTight struct {
+	Handlers []rfb.ISecurityHandler // 内层认证方式，按优先级排列
+}
+
+var _ rfb.ISecurityHandler = new(ServerAuthTight)
+
+func (*ServerAuthTight) Type() rfb.SecurityType {
+	return rfb.SecTypeTight
+}
+
+func (*ServerAuthTight) SubType() rfb.SecuritySubType {
+	return rfb.SecSubTypeUnknown
+}
+
+func (that *ServerAuthTight) Auth(session rfb.ISession) error {
+	// 不提供隧道，隧道类型数量为0时vnc客户端不需要回复
+	if err := writeTightCapabilities(session, nil); err != nil {
+		return err
+	}
+	var caps []TightCapability
+	handlers := make(map[int32]rfb.ISecurityHandler)
+	for _, h := range that.Handlers {
+		c, ok := tightAuthCapability(h)
+		if !ok {
+			return fmt.Errorf("tight认证不支持内层认证方式:%v", h.Type())
+		}
+		caps = append(caps, c)
+		handlers[c.Code] = h
+	}
+	if len(caps) == 0 {
+		return fmt.Errorf("未设置tight认证的内层认证方式")
+	}
+	if err := writeTightCapabilities(session, caps); err != nil {
+		return err
+	}
+	var code int32
+	if err := binary.Read(session, binary.BigEndian, &code); err != nil {
+		return err
+	}
+	h, ok := handlers[code]
+	if !ok {
+		return fmt.Errorf("vnc客户端选择了不支持的tight认证类型:%d", code)
+	}
+	return h.Auth(session)
+}
+
+// ClientAuthTight vnc客户端使用tight认证方式
+// 只支持不使用隧道，认证类型由Handlers决定，目前支持 ClientAuthNone 和 ClientAuthVNC。
+// 使用tight认证后，ServerInit阶段需要额外读取交互能力列表，由 handler.ClientServerInitHandler 处理。
+type ClientAuthTight struct {
+	Handlers []rfb.ISecurityHandler // 内层认证方式，按优先级排列
+}
+
+var _ rfb.ISecurityHandler = new(ClientAuthTight)
+
+func (*ClientAuthTight) Type() rfb.SecurityType {
+	return rfb.SecTypeTight
+}
+
+func (*ClientAuthTight) SubType() rfb.SecuritySubType {
+	return rfb.SecSubTypeUnknown
+}
+
+func (that *ClientAuthTight) Auth(session rfb.ISession) error {
+	// 读取vnc服务端支持的隧道类型，提供了隧道则选择不使用隧道
+	tunnels, err := readTightCapabilities(session)
+	if err != nil {
+		return err
+	}
+	if len(tunnels) > 0 {
+		found := false
+		for _, c := range tunnels {
+			if c.Code == TightNoTunnel.Code {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("vnc服务端要求使用tight隧道%v，不支持", tunnels)
+		}
+		if err = binary.Write(session, binary.BigEndian, TightNoTunnel.Code); err != nil {
+			return err
+		}
+		if err = session.Flush(); err != nil {
+			return err
+		}
+	}
+	// 读取vnc服务端支持的认证类型，数量为0表示不需要认证
+	authCaps, err := readTightCapabilities(session)
+	if err != nil {
+		return err
+	}
+	if len(authCaps) == 0 {
+		return nil
+	}
+	for _, h := range that.Handlers {
+		c, ok := tightAuthCapability(h)
+		if !ok {
+			continue
+		}
+		for _, ac := range authCaps {
+			if ac.Code != c.Code {
+				continue
+			}
+			if err = binary.Write(session, binary.BigEndian, c.Code); err != nil {
+				return err
+			}
+			if err = session.Flush(); err != nil {
+				return err
+			}
+			return h.Auth(session)
+		}
+	}
+	return fmt.Errorf("vnc服务端支持的tight认证类型%v都不可用", authCaps)
+}
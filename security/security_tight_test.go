@@ -0,0 +1,261 @@
+package security
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTightAuth(t *testing.T) {
+	none := func() rfb.ISecurityHandler { return &ServerAuthNone{} }
+	vnc := func(password string) rfb.ISecurityHandler { return &ServerAuthVNC{Password: []byte(password)} }
+	tests := []struct {
+		name   string
+		server *ServerAuthTight
+		client *ClientAuthTight
+		srvOK  bool
+		cliOK  bool
+	}{
+		{"不需要认证", &ServerAuthTight{Handlers: []rfb.ISecurityHandler{none()}},
+			&ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthNone{}}}, true, true},
+		{"vnc密码正确", &ServerAuthTight{Handlers: []rfb.ISecurityHandler{vnc("secret")}},
+			&ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthVNC{Password: []byte("secret")}}}, true, true},
+		{"vnc密码错误", &ServerAuthTight{Handlers: []rfb.ISecurityHandler{vnc("secret")}},
+			&ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthVNC{Password: []byte("wrong")}}}, false, true},
+		// vnc客户端按自己的顺序选择，选择None时错误的密码不参与认证
+		{"按vnc客户端的顺序选择", &ServerAuthTight{Handlers: []rfb.ISecurityHandler{vnc("secret"), none()}},
+			&ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthNone{}, &ClientAuthVNC{Password: []byte("wrong")}}}, true, true},
+		{"没有共同的认证类型", &ServerAuthTight{Handlers: []rfb.ISecurityHandler{vnc("secret")}},
+			&ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthNone{}}}, false, false},
+		{"未设置内层认证方式", &ServerAuthTight{},
+			&ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthNone{}}}, false, false},
+		{"不支持的内层认证方式", &ServerAuthTight{Handlers: []rfb.ISecurityHandler{&ServerAuthTight{}}},
+			&ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthNone{}}}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cli := newPipeSessions()
+			srvErr, cliErr, _ := runAuthOn(t, srv, cli, tt.server, tt.client)
+			if (srvErr == nil) != tt.srvOK {
+				t.Fatalf("服务端认证结果为%v，期望认证成功:%v", srvErr, tt.srvOK)
+			}
+			if (cliErr == nil) != tt.cliOK {
+				t.Fatalf("客户端认证结果为%v，期望认证成功:%v", cliErr, tt.cliOK)
+			}
+		})
+	}
+}
+
+// TestClientAuthTightNegotiation 模拟vnc服务端发送的隧道类型及认证类型列表
+func TestClientAuthTightNegotiation(t *testing.T) {
+	other := newTightCapability(1, "SICR", "SCHANNEL")
+	// negotiate 发送隧道类型，如果vnc客户端需要回复则读取选择的隧道类型，再发送认证类型列表
+	negotiate := func(tunnels []TightCapability, authNum uint32, auths []TightCapability) authFunc {
+		return func(session rfb.ISession) error {
+			if err := writeTightCapabilities(session, tunnels); err != nil {
+				return err
+			}
+			if len(tunnels) > 0 {
+				var code int32
+				if err := binary.Read(session, binary.BigEndian, &code); err != nil {
+					return err
+				}
+				if code != TightNoTunnel.Code {
+					return fmt.Errorf("vnc客户端选择的隧道类型为%d", code)
+				}
+			}
+			if err := binary.Write(session, binary.BigEndian, authNum); err != nil {
+				return err
+			}
+			// net.Pipe写入空数据时也会等待对端读取
+			if len(auths) == 0 {
+				return nil
+			}
+			return binary.Write(session, binary.BigEndian, auths)
+		}
+	}
+	tests := []struct {
+		name   string
+		server authFunc
+		srvOK  bool
+		cliOK  bool
+	}{
+		{"选择不使用隧道", negotiate([]TightCapability{other, TightNoTunnel}, 0, nil), true, true},
+		{"不支持的隧道", negotiate([]TightCapability{other}, 0, nil), false, false},
+		{"认证类型为空", negotiate(nil, 0, nil), true, true},
+		{"认证类型数量太多", negotiate(nil, tightMaxCapabilities+1, nil), true, false},
+		{"认证类型不可用", negotiate(nil, 1, []TightCapability{TightAuthVNC}), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cli := newPipeSessions()
+			client := &ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthNone{}}}
+			srvErr, cliErr, _ := runAuthOn(t, srv, cli, tt.server, client)
+			if (srvErr == nil) != tt.srvOK {
+				t.Fatalf("服务端认证结果为%v，期望认证成功:%v", srvErr, tt.srvOK)
+			}
+			if (cliErr == nil) != tt.cliOK {
+				t.Fatalf("客户端认证结果为%v，期望认证成功:%v", cliErr, tt.cliOK)
+			}
+		})
+	}
+}
+
+// TestServerAuthTightUnknownCode vnc客户端选择了vnc服务端没有提供的认证类型
+func TestServerAuthTightUnknownCode(t *testing.T) {
+	client := authFunc(func(session rfb.ISession) error {
+		for i := 0; i < 2; i++ {
+			if _, err := readTightCapabilities(session); err != nil {
+				return err
+			}
+		}
+		return binary.Write(session, binary.BigEndian, int32(99))
+	})
+	srv, cli := newPipeSessions()
+	server := &ServerAuthTight{Handlers: []rfb.ISecurityHandler{&ServerAuthNone{}}}
+	srvErr, cliErr, _ := runAuthOn(t, srv, cli, server, client)
+	if cliErr != nil {
+		t.Fatalf("客户端认证出错:%v", cliErr)
+	}
+	if srvErr == nil {
+		t.Fatal("选择不支持的认证类型没有返回错误")
+	}
+}
+
+// TestTightServerInit proxy服务端与proxy客户端都使用tight认证完成握手，
+// ServerInit之后的交互能力列表被正确发送及跳过，之后的消息可以正常读取
+func TestTightServerInit(t *testing.T) {
+	c1, c2 := net.Pipe()
+	srv := session.NewServerSession(
+		rfb.OptSecurityHandlers(&ServerAuthTight{Handlers: []rfb.ISecurityHandler{&ServerAuthVNC{Password: []byte("secret")}}}),
+		rfb.OptWidth(64),
+		rfb.OptHeight(48),
+		rfb.OptDesktopName([]byte("tight")),
+		rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) { return c2, nil }),
+	)
+	defer func() { _ = srv.Close() }()
+	// 丢弃vnc客户端发送的消息
+	output, quit := srv.Options().Output, srv.Wait()
+	go func() {
+		for {
+			select {
+			case <-quit:
+				return
+			case <-output:
+			}
+		}
+	}()
+	go srv.Start()
+
+	cli := session.NewClient(
+		rfb.OptSecurityHandlers(&ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthVNC{Password: []byte("secret")}}}),
+		rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) { return c1, nil }),
+	)
+	defer func() { _ = cli.Close() }()
+	cli.Start()
+	select {
+	case err := <-cli.Options().ErrorCh:
+		t.Fatal(err)
+	case err := <-srv.Options().ErrorCh:
+		t.Fatal(err)
+	default:
+	}
+	if cli.SecurityHandler().Type() != rfb.SecTypeTight {
+		t.Fatalf("使用的认证方式为%v", cli.SecurityHandler().Type())
+	}
+	if opts := cli.Options(); opts.Width != 64 || opts.Height != 48 || string(opts.DesktopName) != "tight" {
+		t.Fatalf("ServerInit为%dx%d %s", opts.Width, opts.Height, opts.DesktopName)
+	}
+	expectBell(t, cli, func() { srv.Options().Input <- &messages.Bell{} })
+}
+
+// TestClientServerInitTightCaps 模拟的vnc服务端在ServerInit之后发送非空的交互能力列表，proxy客户端跳过这些能力描述
+func TestClientServerInitTightCaps(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer func() { _ = c2.Close() }()
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- func() error {
+			var version [rfb.ProtoVersionLength]byte
+			if _, err := c2.Write([]byte(rfb.ProtoVersion38)); err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(c2, version[:]); err != nil {
+				return err
+			}
+			if _, err := c2.Write([]byte{1, uint8(rfb.SecTypeTight)}); err != nil {
+				return err
+			}
+			var secType [1]byte
+			if _, err := io.ReadFull(c2, secType[:]); err != nil {
+				return err
+			}
+			sess := &testSession{conn: c2}
+			if err := (&ServerAuthTight{Handlers: []rfb.ISecurityHandler{&ServerAuthNone{}}}).Auth(sess); err != nil {
+				return err
+			}
+			// 认证结果及ClientInit
+			if err := binary.Write(c2, binary.BigEndian, uint32(0)); err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(c2, secType[:]); err != nil {
+				return err
+			}
+			// 之后vnc客户端发送的消息直接丢弃
+			go func() { _, _ = io.Copy(io.Discard, c2) }()
+			buf := &bytes.Buffer{}
+			_ = binary.Write(buf, binary.BigEndian, uint16(32))
+			_ = binary.Write(buf, binary.BigEndian, uint16(24))
+			_ = binary.Write(buf, binary.BigEndian, rfb.PixelFormat32bit)
+			_ = binary.Write(buf, binary.BigEndian, uint32(4))
+			buf.WriteString("caps")
+			// 1个服务端消息，1个客户端消息，2个编码，每个能力描述16字节
+			_ = binary.Write(buf, binary.BigEndian, [4]uint16{1, 1, 2, 0})
+			buf.Write(bytes.Repeat([]byte{0xAB}, 4*16))
+			_, err := c2.Write(buf.Bytes())
+			return err
+		}()
+	}()
+
+	cli := session.NewClient(
+		rfb.OptSecurityHandlers(&ClientAuthTight{Handlers: []rfb.ISecurityHandler{&ClientAuthNone{}}}),
+		rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) { return c1, nil }),
+	)
+	defer func() { _ = cli.Close() }()
+	cli.Start()
+	if err := <-srvErr; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-cli.Options().ErrorCh:
+		t.Fatal(err)
+	default:
+	}
+	if opts := cli.Options(); opts.Width != 32 || opts.Height != 24 || string(opts.DesktopName) != "caps" {
+		t.Fatalf("ServerInit为%dx%d %s", opts.Width, opts.Height, opts.DesktopName)
+	}
+	expectBell(t, cli, func() { _, _ = c2.Write([]byte{byte(rfb.Bell)}) })
+}
+
+// expectBell 执行send后，proxy客户端应该收到Bell消息
+func expectBell(t *testing.T, cli *session.ClientSession, send func()) {
+	t.Helper()
+	go send()
+	select {
+	case msg := <-cli.Options().Output:
+		if msg.Type() != rfb.MessageType(rfb.Bell) {
+			t.Fatalf("proxy客户端收到的消息为%v", msg)
+		}
+	case err := <-cli.Options().ErrorCh:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy客户端没有收到Bell消息")
+	}
+}
@@ -0,0 +1,391 @@
+package security
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+)
+
+// ServerAuthVeNCrypt vnc服务端使用VeNCrypt认证方式，只支持0.2版本。
+// TLS*子类型和X509*子类型都使用TLSConfig建立tls连接，区别是X509*子类型会在TLSConfig设置了ClientCAs时校验vnc客户端证书，
+// 由于crypto/tls不支持匿名加密套件，TLS*子类型也需要配置证书，vnc客户端在这些子类型下一般不会校验证书。
+// tls连接建立后再进行内层认证：None不认证，VNC使用vnc密码认证，Plain使用用户名密码认证。
+// 设置了Authenticator时Plain认证交给认证器校验，认证成功后把身份保存到会话的 rfb.SwapKeyIdentity 中。
+type ServerAuthVeNCrypt struct {
+	SubTypes         []rfb.SecuritySubType // 支持的认证子类型，按优先级排列，为空则根据配置自动生成
+	TLSConfig        *tls.Config           // tls配置
+	Username         []byte                // Plain认证的用户名
+	Password         []byte                // Plain认证的密码
+	VNCPassword      []byte                // VNC认证的密码
+	ViewOnlyPassword []byte                // VNC认证的只读密码
+	Authenticator    rfb.Authenticator     // Plain认证使用的认证器，设置后忽略Username和Password
+}
+
+var _ rfb.ISecurityHandler = new(ServerAuthVeNCrypt)
+
+// NewServerAuthVeNCrypt 使用证书和私钥文件创建VeNCrypt认证，clientCAFile不为空时X509*子类型要求vnc客户端提供证书
+func NewServerAuthVeNCrypt(certFile, keyFile, clientCAFile string) (*ServerAuthVeNCrypt, error) {
+	config, err := NewServerTLSConfig(certFile, keyFile, clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &ServerAuthVeNCrypt{TLSConfig: config}, nil
+}
+
+func (*ServerAuthVeNCrypt) Type() rfb.SecurityType {
+	return rfb.SecTypeVeNCrypt
+}
+
+func (*ServerAuthVeNCrypt) SubType() rfb.SecuritySubType {
+	return rfb.SecSubTypeUnknown
+}
+
+// subTypes 支持的认证子类型，未设置则根据是否配置了密码生成，优先使用X509*子类型
+// 设置了认证器时只提供Plain类的子类型，没有tls配置则只能使用明文的Plain子类型
+func (that *ServerAuthVeNCrypt) subTypes() []rfb.SecuritySubType {
+	if len(that.SubTypes) > 0 {
+		return that.SubTypes
+	}
+	if that.Authenticator != nil {
+		if that.TLSConfig == nil {
+			return []rfb.SecuritySubType{rfb.SecSubTypeVeNCrypt02Plain}
+		}
+		return []rfb.SecuritySubType{rfb.SecSubTypeVeNCrypt02X509Plain, rfb.SecSubTypeVeNCrypt02TLSPlain}
+	}
+	var subTypes []rfb.SecuritySubType
+	if len(that.Username) > 0 {
+		subTypes = append(subTypes, rfb.SecSubTypeVeNCrypt02X509Plain, rfb.SecSubTypeVeNCrypt02TLSPlain)
+	}
+	if len(that.VNCPassword) > 0 || len(that.ViewOnlyPassword) > 0 {
+		subTypes = append(subTypes, rfb.SecSubTypeVeNCrypt02X509VNC, rfb.SecSubTypeVeNCrypt02TLSVNC)
+	}
+	if len(subTypes) == 0 {
+		subTypes = append(subTypes, rfb.SecSubTypeVeNCrypt02X509None, rfb.SecSubTypeVeNCrypt02TLSNone)
+	}
+	return subTypes
+}
+
+func (that *ServerAuthVeNCrypt) Auth(session rfb.ISession) error {
+	// 发送服务端支持的版本号0.2
+	if err := binary.Write(session, binary.BigEndian, []uint8{0, 2}); err != nil {
+		return err
+	}
+	if err := session.Flush(); err != nil {
+		return err
+	}
+	var major, minor uint8
+	if err := binary.Read(session, binary.BigEndian, &major); err != nil {
+		return err
+	}
+	if err := binary.Read(session, binary.BigEndian, &minor); err != nil {
+		return err
+	}
+	if major != 0 || minor != 2 {
+		_ = binary.Write(session, binary.BigEndian, uint8(0xFF))
+		_ = session.Flush()
+		return fmt.Errorf("不支持的VeNCrypt版本:%d.%d", major, minor)
+	}
+	if err := binary.Write(session, binary.BigEndian, uint8(0)); err != nil {
+		return err
+	}
+	// 发送支持的认证子类型
+	subTypes := that.subTypes()
+	if err := binary.Write(session, binary.BigEndian, uint8(len(subTypes))); err != nil {
+		return err
+	}
+	if err := binary.Write(session, binary.BigEndian, subTypes); err != nil {
+		return err
+	}
+	if err := session.Flush(); err != nil {
+		return err
+	}
+	// 读取vnc客户端选择的认证子类型
+	var subType rfb.SecuritySubType
+	if err := binary.Read(session, binary.BigEndian, &subType); err != nil {
+		return err
+	}
+	supported := false
+	for _, st := range subTypes {
+		if st == subType {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("vnc客户端选择了不支持的VeNCrypt认证子类型:%d", subType)
+	}
+	switch subType {
+	case rfb.SecSubTypeVeNCrypt02Plain:
+		return that.authPlain(session)
+	case rfb.SecSubTypeVeNCrypt02TLSNone, rfb.SecSubTypeVeNCrypt02X509None:
+		return that.startTLS(session, subType)
+	case rfb.SecSubTypeVeNCrypt02TLSVNC, rfb.SecSubTypeVeNCrypt02X509VNC:
+		if err := that.startTLS(session, subType); err != nil {
+			return err
+		}
+		auth := &ServerAuthVNC{Password: that.VNCPassword, ViewOnlyPassword: that.ViewOnlyPassword}
+		return auth.Auth(session)
+	case rfb.SecSubTypeVeNCrypt02TLSPlain, rfb.SecSubTypeVeNCrypt02X509Plain:
+		if err := that.startTLS(session, subType); err != nil {
+			return err
+		}
+		return that.authPlain(session)
+	}
+	return fmt.Errorf("不支持的VeNCrypt认证子类型:%d", subType)
+}
+
+// startTLS 通知vnc客户端开始tls握手，并把会话的连接升级为tls连接
+func (that *ServerAuthVeNCrypt) startTLS(session rfb.ISession, subType rfb.SecuritySubType) error {
+	if that.TLSConfig == nil {
+		_ = binary.Write(session, binary.BigEndian, uint8(0))
+		_ = session.Flush()
+		return fmt.Errorf("未设置tls配置")
+	}
+	if err := binary.Write(session, binary.BigEndian, uint8(1)); err != nil {
+		return err
+	}
+	config := that.TLSConfig
+	if subType == rfb.SecSubTypeVeNCrypt02TLSNone || subType == rfb.SecSubTypeVeNCrypt02TLSVNC || subType == rfb.SecSubTypeVeNCrypt02TLSPlain {
+		// TLS*子类型是匿名tls，不校验vnc客户端证书
+		config = config.Clone()
+		config.ClientAuth = tls.NoClientCert
+		config.ClientCAs = nil
+	}
+	return upgradeTLS(session, config, true)
+}
+
+// authPlain 读取vnc客户端发送的用户名和密码并校验
+func (that *ServerAuthVeNCrypt) authPlain(session rfb.ISession) error {
+	username, password, err := readPlain(session)
+	if err != nil {
+		return err
+	}
+	// 认证失败也需要记录用户名，供认证限流器按用户名统计失败次数
+	session.Swap().Set(rfb.SwapKeyUsername, string(username))
+	if that.Authenticator != nil {
+		identity, err := that.Authenticator.Authenticate(context.Background(), string(username), string(password))
+		if err != nil {
+			return fmt.Errorf("用户 %s 认证失败:%v", username, err)
+		}
+		if identity == nil {
+			identity = &rfb.Identity{Username: string(username)}
+		}
+		session.Swap().Set(rfb.SwapKeyIdentity, identity)
+		if identity.ViewOnly {
+			session.Swap().Set(rfb.SwapKeyViewOnly, true)
+		}
+		return nil
+	}
+	if len(that.Username) == 0 {
+		return fmt.Errorf("未设置Plain认证的用户名")
+	}
+	userOk := subtle.ConstantTimeCompare(that.Username, username) == 1
+	passOk := subtle.ConstantTimeCompare(that.Password, password) == 1
+	if !userOk || !passOk {
+		return fmt.Errorf("用户名或密码错误")
+	}
+	session.Swap().Set(rfb.SwapKeyIdentity, &rfb.Identity{Username: string(username)})
+	return nil
+}
+
+// plainMaxLen Plain认证用户名和密码的最大长度，防止恶意的vnc客户端发送超长的数据
+const plainMaxLen = 1024
+
+// readPlain 读取Plain认证的用户名和密码
+func readPlain(session rfb.ISession) ([]byte, []byte, error) {
+	var uLength, pLength uint32
+	if err := binary.Read(session, binary.BigEndian, &uLength); err != nil {
+		return nil, nil, err
+	}
+	if err := binary.Read(session, binary.BigEndian, &pLength); err != nil {
+		return nil, nil, err
+	}
+	if uLength > plainMaxLen || pLength > plainMaxLen {
+		return nil, nil, fmt.Errorf("用户名或密码太长")
+	}
+	username := make([]byte, uLength)
+	password := make([]byte, pLength)
+	if err := binary.Read(session, binary.BigEndian, &username); err != nil {
+		return nil, nil, err
+	}
+	if err := binary.Read(session, binary.BigEndian, &password); err != nil {
+		return nil, nil, err
+	}
+	return username, password, nil
+}
+
+// veNCrypt01Offset 0.1版本的认证子类型加上该偏移量就是0.2版本对应的认证子类型
+const veNCrypt01Offset = rfb.SecSubTypeVeNCrypt02Plain - rfb.SecSubTypeVeNCrypt01Plain
+
+// ClientAuthVeNCrypt vnc客户端使用VeNCrypt认证方式，支持0.2和0.1版本。
+// X509*子类型使用TLSConfig校验vnc服务端证书，TLS*子类型是匿名tls，不校验证书，
+// 注意crypto/tls不支持匿名加密套件，只配置了匿名证书的vnc服务端无法使用TLS*子类型。
+type ClientAuthVeNCrypt struct {
+	SubTypes  []rfb.SecuritySubType // 可以接受的认证子类型(0.2版本的值)，按优先级排列，为空则根据配置自动生成
+	TLSConfig *tls.Config           // tls配置
+	Username  []byte                // Plain认证的用户名
+	Password  []byte                // Plain认证或者VNC认证的密码
+}
+
+var _ rfb.ISecurityHandler = new(ClientAuthVeNCrypt)
+
+func (*ClientAuthVeNCrypt) Type() rfb.SecurityType {
+	return rfb.SecTypeVeNCrypt
+}
+
+func (*ClientAuthVeNCrypt) SubType() rfb.SecuritySubType {
+	return rfb.SecSubTypeUnknown
+}
+
+// subTypes 可以接受的认证子类型，未设置则根据是否配置了用户名密码生成，优先使用X509*子类型，不会自动选择明文的Plain子类型
+func (that *ClientAuthVeNCrypt) subTypes() []rfb.SecuritySubType {
+	if len(that.SubTypes) > 0 {
+		return that.SubTypes
+	}
+	if len(that.Username) > 0 {
+		return []rfb.SecuritySubType{rfb.SecSubTypeVeNCrypt02X509Plain, rfb.SecSubTypeVeNCrypt02TLSPlain}
+	}
+	if len(that.Password) > 0 {
+		return []rfb.SecuritySubType{rfb.SecSubTypeVeNCrypt02X509VNC, rfb.SecSubTypeVeNCrypt02TLSVNC}
+	}
+	return []rfb.SecuritySubType{rfb.SecSubTypeVeNCrypt02X509None, rfb.SecSubTypeVeNCrypt02TLSNone}
+}
+
+func (that *ClientAuthVeNCrypt) Auth(session rfb.ISession) error {
+	// 读取vnc服务端支持的最高版本，协商使用0.2或者0.1版本
+	var major, minor uint8
+	if err := binary.Read(session, binary.BigEndian, &major); err != nil {
+		return err
+	}
+	if err := binary.Read(session, binary.BigEndian, &minor); err != nil {
+		return err
+	}
+	if major != 0 || minor < 1 {
+		return fmt.Errorf("不支持的VeNCrypt版本:%d.%d", major, minor)
+	}
+	if minor > 2 {
+		minor = 2
+	}
+	if err := binary.Write(session, binary.BigEndian, []uint8{0, minor}); err != nil {
+		return err
+	}
+	if err := session.Flush(); err != nil {
+		return err
+	}
+	var status uint8
+	if err := binary.Read(session, binary.BigEndian, &status); err != nil {
+		return err
+	}
+	if status != 0 {
+		return fmt.Errorf("vnc服务端不支持VeNCrypt版本:0.%d", minor)
+	}
+	// 读取vnc服务端支持的认证子类型，0.1版本使用1字节表示，0.2版本使用4字节表示
+	var num uint8
+	if err := binary.Read(session, binary.BigEndian, &num); err != nil {
+		return err
+	}
+	serverSubTypes := make([]rfb.SecuritySubType, num)
+	if minor == 1 {
+		subTypes := make([]uint8, num)
+		if err := binary.Read(session, binary.BigEndian, &subTypes); err != nil {
+			return err
+		}
+		for i, st := range subTypes {
+			serverSubTypes[i] = rfb.SecuritySubType(st) + veNCrypt01Offset
+		}
+	} else {
+		if err := binary.Read(session, binary.BigEndian, &serverSubTypes); err != nil {
+			return err
+		}
+	}
+	// 按客户端的优先级选择认证子类型
+	subType := rfb.SecSubTypeUnknown
+	for _, st := range that.subTypes() {
+		for _, sst := range serverSubTypes {
+			if st == sst {
+				subType = st
+				break
+			}
+		}
+		if subType != rfb.SecSubTypeUnknown {
+			break
+		}
+	}
+	if subType == rfb.SecSubTypeUnknown {
+		return fmt.Errorf("vnc服务端支持的VeNCrypt认证子类型%v都不可用", serverSubTypes)
+	}
+	var err error
+	if minor == 1 {
+		err = binary.Write(session, binary.BigEndian, uint8(subType-veNCrypt01Offset))
+	} else {
+		err = binary.Write(session, binary.BigEndian, subType)
+	}
+	if err != nil {
+		return err
+	}
+	if err = session.Flush(); err != nil {
+		return err
+	}
+	switch subType {
+	case rfb.SecSubTypeVeNCrypt02Plain:
+		return that.authPlain(session)
+	case rfb.SecSubTypeVeNCrypt02TLSNone, rfb.SecSubTypeVeNCrypt02X509None:
+		return that.startTLS(session, subType)
+	case rfb.SecSubTypeVeNCrypt02TLSVNC, rfb.SecSubTypeVeNCrypt02X509VNC:
+		if err = that.startTLS(session, subType); err != nil {
+			return err
+		}
+		auth := &ClientAuthVNC{Password: that.Password}
+		return auth.Auth(session)
+	case rfb.SecSubTypeVeNCrypt02TLSPlain, rfb.SecSubTypeVeNCrypt02X509Plain:
+		if err = that.startTLS(session, subType); err != nil {
+			return err
+		}
+		return that.authPlain(session)
+	}
+	return fmt.Errorf("不支持的VeNCrypt认证子类型:%d", subType)
+}
+
+// startTLS 等待vnc服务端确认后，把会话的连接升级为tls连接
+func (that *ClientAuthVeNCrypt) startTLS(session rfb.ISession, subType rfb.SecuritySubType) error {
+	var ack uint8
+	if err := binary.Read(session, binary.BigEndian, &ack); err != nil {
+		return err
+	}
+	if ack == 0 {
+		return fmt.Errorf("vnc服务端初始化tls失败")
+	}
+	config := that.TLSConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if subType == rfb.SecSubTypeVeNCrypt02TLSNone || subType == rfb.SecSubTypeVeNCrypt02TLSVNC || subType == rfb.SecSubTypeVeNCrypt02TLSPlain {
+		// TLS*子类型是匿名tls，不校验vnc服务端证书
+		config = config.Clone()
+		config.InsecureSkipVerify = true
+	}
+	return upgradeTLS(session, config, false)
+}
+
+// authPlain 发送Plain认证的用户名和密码
+func (that *ClientAuthVeNCrypt) authPlain(session rfb.ISession) error {
+	if len(that.Username) == 0 {
+		return fmt.Errorf("安全认证失败，因为没有传入VeNCrypt Plain认证方式所用的用户名")
+	}
+	if err := binary.Write(session, binary.BigEndian, uint32(len(that.Username))); err != nil {
+		return err
+	}
+	if err := binary.Write(session, binary.BigEndian, uint32(len(that.Password))); err != nil {
+		return err
+	}
+	if err := binary.Write(session, binary.BigEndian, that.Username); err != nil {
+		return err
+	}
+	if err := binary.Write(session, binary.BigEndian, that.Password); err != nil {
+		return err
+	}
+	return session.Flush()
+}
@@ -0,0 +1,229 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/vprix/vncproxy/rfb"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 测试时生成的自签名ca
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA 生成自签名的ca证书，证书文件保存在临时目录中
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{dir: t.TempDir(), cert: cert, key: key}
+	writePEM(t, ca.file("ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+func (that *testCA) file(name string) string {
+	return filepath.Join(that.dir, name)
+}
+
+// issue 使用ca签发证书，返回证书和私钥文件
+func (that *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, that.cert, &key.PublicKey, that.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := that.file(name+".pem"), that.file(name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVeNCrypt(t *testing.T) {
+	ca := newTestCA(t, "vncproxy test ca")
+	serverCert, serverKey := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "viewer", x509.ExtKeyUsageClientAuth)
+	otherCA := newTestCA(t, "other ca")
+	otherCert, otherKey := otherCA.issue(t, "viewer", x509.ExtKeyUsageClientAuth)
+
+	newServer := func(t *testing.T, subTypes ...rfb.SecuritySubType) *ServerAuthVeNCrypt {
+		server, err := NewServerAuthVeNCrypt(serverCert, serverKey, ca.file("ca.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.SubTypes = subTypes
+		return server
+	}
+	newClient := func(t *testing.T, caFile, certFile, keyFile string, subTypes ...rfb.SecuritySubType) *ClientAuthVeNCrypt {
+		config, err := NewClientTLSConfig(caFile, certFile, keyFile, "localhost", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &ClientAuthVeNCrypt{TLSConfig: config, SubTypes: subTypes}
+	}
+
+	tests := []struct {
+		name     string
+		server   func(t *testing.T) *ServerAuthVeNCrypt
+		client   func(t *testing.T) *ClientAuthVeNCrypt
+		ok       bool
+		viewOnly bool
+	}{
+		{
+			name:   "X509None双向校验证书",
+			server: func(t *testing.T) *ServerAuthVeNCrypt { return newServer(t, rfb.SecSubTypeVeNCrypt02X509None) },
+			client: func(t *testing.T) *ClientAuthVeNCrypt {
+				return newClient(t, ca.file("ca.pem"), clientCert, clientKey)
+			},
+			ok: true,
+		},
+		{
+			name:   "X509None客户端没有证书",
+			server: func(t *testing.T) *ServerAuthVeNCrypt { return newServer(t, rfb.SecSubTypeVeNCrypt02X509None) },
+			client: func(t *testing.T) *ClientAuthVeNCrypt { return newClient(t, ca.file("ca.pem"), "", "") },
+		},
+		{
+			name:   "X509None客户端证书不是ca签发的",
+			server: func(t *testing.T) *ServerAuthVeNCrypt { return newServer(t, rfb.SecSubTypeVeNCrypt02X509None) },
+			client: func(t *testing.T) *ClientAuthVeNCrypt {
+				return newClient(t, ca.file("ca.pem"), otherCert, otherKey)
+			},
+		},
+		{
+			name:   "X509None客户端不信任服务端证书",
+			server: func(t *testing.T) *ServerAuthVeNCrypt { return newServer(t, rfb.SecSubTypeVeNCrypt02X509None) },
+			client: func(t *testing.T) *ClientAuthVeNCrypt {
+				return newClient(t, otherCA.file("ca.pem"), clientCert, clientKey)
+			},
+		},
+		{
+			name:   "TLSNone不校验证书",
+			server: func(t *testing.T) *ServerAuthVeNCrypt { return newServer(t, rfb.SecSubTypeVeNCrypt02TLSNone) },
+			client: func(t *testing.T) *ClientAuthVeNCrypt { return newClient(t, otherCA.file("ca.pem"), "", "") },
+			ok:     true,
+		},
+		{
+			name: "X509Plain",
+			server: func(t *testing.T) *ServerAuthVeNCrypt {
+				server := newServer(t)
+				server.Username, server.Password = []byte("user"), []byte("pass")
+				return server
+			},
+			client: func(t *testing.T) *ClientAuthVeNCrypt {
+				client := newClient(t, ca.file("ca.pem"), clientCert, clientKey)
+				client.Username, client.Password = []byte("user"), []byte("pass")
+				return client
+			},
+			ok: true,
+		},
+		{
+			name: "TLSPlain密码错误",
+			server: func(t *testing.T) *ServerAuthVeNCrypt {
+				server := newServer(t, rfb.SecSubTypeVeNCrypt02TLSPlain)
+				server.Username, server.Password = []byte("user"), []byte("pass")
+				return server
+			},
+			client: func(t *testing.T) *ClientAuthVeNCrypt {
+				client := newClient(t, "", "", "")
+				client.Username, client.Password = []byte("user"), []byte("wrong")
+				return client
+			},
+		},
+		{
+			name: "X509VNC只读密码",
+			server: func(t *testing.T) *ServerAuthVeNCrypt {
+				server := newServer(t)
+				server.VNCPassword, server.ViewOnlyPassword = []byte("secret"), []byte("view")
+				return server
+			},
+			client: func(t *testing.T) *ClientAuthVeNCrypt {
+				client := newClient(t, ca.file("ca.pem"), clientCert, clientKey)
+				client.Password = []byte("view")
+				return client
+			},
+			ok:       true,
+			viewOnly: true,
+		},
+		{
+			name: "TLSVNC只设置只读密码时空密码",
+			server: func(t *testing.T) *ServerAuthVeNCrypt {
+				server := newServer(t)
+				server.ViewOnlyPassword = []byte("view")
+				return server
+			},
+			client: func(t *testing.T) *ClientAuthVeNCrypt {
+				client := newClient(t, "", "", "", rfb.SecSubTypeVeNCrypt02TLSVNC)
+				// vnc认证的密钥不足八位时补0，所以该密码与空密码加密的认证串相同
+				client.Password = []byte{0}
+				return client
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srvErr, cliErr, srv := runAuth(t, tt.server(t), tt.client(t))
+			if tt.ok && (srvErr != nil || cliErr != nil) {
+				t.Fatalf("认证失败:%v %v", srvErr, cliErr)
+			}
+			if !tt.ok && srvErr == nil {
+				t.Fatal("服务端认证应该失败")
+			}
+			if viewOnly := srv.Swap().GetVar(rfb.SwapKeyViewOnly).Bool(); viewOnly != tt.viewOnly {
+				t.Fatalf("只读标识为%v，期望%v", viewOnly, tt.viewOnly)
+			}
+		})
+	}
+}
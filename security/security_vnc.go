@@ -2,6 +2,7 @@ package security
 
 import (
 	"bytes"
+	"context"
 	"crypto/des"
 	"encoding/binary"
 	"fmt"
@@ -13,10 +14,13 @@ import (
 const ChallengeLen = 16
 
 // ServerAuthVNC vnc服务端使用vnc auth认证方式
+// 设置了ViewOnlyPassword后，使用只读密码认证成功的vnc客户端只能查看不能操作
+// 每次认证都会生成新的随机认证串，认证过程不会修改自身的字段，可以被多个连接共用
 type ServerAuthVNC struct {
-	Challenge []byte
-	Password  []byte
-	Crypted   []byte
+	Challenge        []byte
+	Password         []byte
+	ViewOnlyPassword []byte
+	Crypted          []byte
 }
 
 var _ rfb.ISecurityHandler = new(ServerAuthVNC)
@@ -47,26 +51,90 @@ func (that *ServerAuthVNC) ReadChallenge(session rfb.ISession) error {
 }
 
 func (that *ServerAuthVNC) Auth(session rfb.ISession) error {
+	// 同一个认证方式会被多个连接共用，每次认证都使用新的随机认证串，
+	// 复用认证串会让截获的加密串可以被重放
+	auth := &ServerAuthVNC{Challenge: grand.B(ChallengeLen)}
+	if err := auth.writeChallenge(session); err != nil {
+		return err
+	}
+	if err := auth.ReadChallenge(session); err != nil {
+		return err
+	}
+	// 加密随机认证串，并把加密后的串与客户端穿过来的串进行对比，如果对比一致，则说明密码一致
+	// 密码为空时不能匹配，空密码加密的认证串是固定可算的，只设置了只读密码时会被用来获取完整的控制权限
+	if len(that.Password) > 0 {
+		ok, err := auth.match(that.Password)
+		if err != nil || ok {
+			return err
+		}
+	}
+	if len(that.ViewOnlyPassword) > 0 {
+		ok, err := auth.match(that.ViewOnlyPassword)
+		if err != nil {
+			return err
+		}
+		if ok {
+			session.Swap().Set(rfb.SwapKeyViewOnly, true)
+			return nil
+		}
+	}
+	return fmt.Errorf("密码错误")
+}
 
-	if len(that.Challenge) != ChallengeLen {
-		that.Challenge = grand.B(ChallengeLen)
+// match 判断vnc客户端加密后的认证串是否与使用该密码加密的认证串一致
+func (that *ServerAuthVNC) match(password []byte) (bool, error) {
+	// 加密会修改传入的认证串，所以复制一份
+	challenge := make([]byte, ChallengeLen)
+	copy(challenge, that.Challenge)
+	encrypted, err := AuthVNCEncode(password, challenge)
+	if err != nil {
+		return false, err
 	}
+	return bytes.Equal(encrypted, that.Crypted), nil
+}
+
+// ServerAuthVNCToken vnc服务端使用vnc auth认证方式，vnc客户端输入的密码就是目标令牌。
+// 认证时逐个使用令牌加密随机认证串，匹配成功则把令牌存入会话的交换区，供后续解析要连接的vnc服务端。
+// 注意：vnc auth只使用密码的前八位，所以令牌的前八位需要保证唯一。
+type ServerAuthVNCToken struct {
+	Lister rfb.TokenLister
+}
 
-	if err := that.writeChallenge(session); err != nil {
+var _ rfb.ISecurityHandler = new(ServerAuthVNCToken)
+
+func (*ServerAuthVNCToken) Type() rfb.SecurityType {
+	return rfb.SecTypeVNC
+}
+func (*ServerAuthVNCToken) SubType() rfb.SecuritySubType {
+	return rfb.SecSubTypeUnknown
+}
+
+func (that *ServerAuthVNCToken) Auth(session rfb.ISession) error {
+	auth := &ServerAuthVNC{Challenge: grand.B(ChallengeLen)}
+	if err := auth.writeChallenge(session); err != nil {
 		return err
 	}
-	if err := that.ReadChallenge(session); err != nil {
+	if err := auth.ReadChallenge(session); err != nil {
 		return err
 	}
-	// 加密随机认证串，并把加密后的串与客户端穿过来的串进行对比，如果对比一致，则说明密码一致
-	encrypted, err := AuthVNCEncode(that.Password, that.Challenge)
+	tokens, err := that.Lister.Tokens(context.TODO())
 	if err != nil {
 		return err
 	}
-	if !bytes.Equal(encrypted, that.Crypted) {
-		return fmt.Errorf("密码错误")
+	for _, token := range tokens {
+		// 加密会修改传入的认证串，所以每次都要复制一份
+		challenge := make([]byte, ChallengeLen)
+		copy(challenge, auth.Challenge)
+		encrypted, err := AuthVNCEncode([]byte(token), challenge)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(encrypted, auth.Crypted) {
+			session.Swap().Set(rfb.SwapKeyToken, token)
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("密码错误")
 }
 
 // ClientAuthVNC vnc 客户端使用vnc auth认证方式
@@ -0,0 +1,97 @@
+package security
+
+import (
+	"encoding/binary"
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+)
+
+// vncResponse 模拟vnc客户端使用密码加密认证串，密码可以为空
+func vncResponse(password string) authFunc {
+	return func(session rfb.ISession) error {
+		challenge := make([]byte, ChallengeLen)
+		if err := binary.Read(session, binary.BigEndian, challenge); err != nil {
+			return err
+		}
+		return vncResponseTo(password, challenge, session)
+	}
+}
+
+func TestServerAuthVNC(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   *ServerAuthVNC
+		password string
+		ok       bool
+		viewOnly bool
+	}{
+		{"密码正确", &ServerAuthVNC{Password: []byte("secret")}, "secret", true, false},
+		{"密码错误", &ServerAuthVNC{Password: []byte("secret")}, "wrong", false, false},
+		{"只使用前八位", &ServerAuthVNC{Password: []byte("12345678")}, "123456789", true, false},
+		{"完整密码优先", &ServerAuthVNC{Password: []byte("secret"), ViewOnlyPassword: []byte("view")}, "secret", true, false},
+		{"只读密码", &ServerAuthVNC{Password: []byte("secret"), ViewOnlyPassword: []byte("view")}, "view", true, true},
+		{"只设置只读密码", &ServerAuthVNC{ViewOnlyPassword: []byte("view")}, "view", true, true},
+		{"只设置只读密码时空密码", &ServerAuthVNC{ViewOnlyPassword: []byte("view")}, "", false, false},
+		{"只设置只读密码时错误密码", &ServerAuthVNC{ViewOnlyPassword: []byte("view")}, "secret", false, false},
+		{"没有设置密码", &ServerAuthVNC{}, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srvErr, cliErr, srv := runAuth(t, tt.server, vncResponse(tt.password))
+			if cliErr != nil {
+				t.Fatalf("客户端认证出错:%v", cliErr)
+			}
+			if (srvErr == nil) != tt.ok {
+				t.Fatalf("认证结果为%v，期望认证成功:%v", srvErr, tt.ok)
+			}
+			if viewOnly := srv.Swap().GetVar(rfb.SwapKeyViewOnly).Bool(); viewOnly != tt.viewOnly {
+				t.Fatalf("只读标识为%v，期望%v", viewOnly, tt.viewOnly)
+			}
+		})
+	}
+}
+
+func TestServerAuthVNCChallenge(t *testing.T) {
+	// 同一个认证方式被多个连接共用，每次认证的随机认证串都不同
+	server := &ServerAuthVNC{Password: []byte("secret")}
+	var challenges [2][]byte
+	for i := range challenges {
+		client := authFunc(func(session rfb.ISession) error {
+			challenges[i] = make([]byte, ChallengeLen)
+			if err := binary.Read(session, binary.BigEndian, challenges[i]); err != nil {
+				return err
+			}
+			return vncResponseTo("secret", challenges[i], session)
+		})
+		if srvErr, cliErr, _ := runAuth(t, server, client); srvErr != nil || cliErr != nil {
+			t.Fatalf("认证失败:%v %v", srvErr, cliErr)
+		}
+	}
+	if string(challenges[0]) == string(challenges[1]) {
+		t.Fatal("两次认证使用了相同的随机认证串")
+	}
+	if len(server.Challenge) != 0 || len(server.Crypted) != 0 {
+		t.Fatal("认证修改了共用的认证方式")
+	}
+}
+
+// vncResponseTo 使用密码加密已经读取的认证串并发送
+func vncResponseTo(password string, challenge []byte, session rfb.ISession) error {
+	c := make([]byte, len(challenge))
+	copy(c, challenge)
+	encrypted, err := AuthVNCEncode([]byte(password), c)
+	if err != nil {
+		return err
+	}
+	return binary.Write(session, binary.BigEndian, encrypted)
+}
+
+func TestClientAuthVNC(t *testing.T) {
+	server := &ServerAuthVNC{Password: []byte("secret")}
+	if srvErr, cliErr, _ := runAuth(t, server, &ClientAuthVNC{Password: []byte("secret")}); srvErr != nil || cliErr != nil {
+		t.Fatalf("认证失败:%v %v", srvErr, cliErr)
+	}
+	if err := (&ClientAuthVNC{}).Auth(nil); err == nil {
+		t.Fatal("没有密码时应该认证失败")
+	}
+}
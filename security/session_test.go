@@ -0,0 +1,94 @@
+package security
+
+import (
+	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// testSession 测试使用的会话，直接读写底层连接，支持升级为tls连接
+type testSession struct {
+	rfb.ISession
+	conn io.ReadWriteCloser
+	swap *gmap.Map
+}
+
+// newTestSessions 创建一对通过本地tcp连接的会话，net.Pipe没有缓冲区，tls发送告警时会互相阻塞
+func newTestSessions(t *testing.T) (*testSession, *testSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSession{conn: c2, swap: gmap.New(true)}, &testSession{conn: c1, swap: gmap.New(true)}
+}
+
+// newPipeSessions 创建一对通过net.Pipe连接的会话，不需要升级为tls连接的认证方式使用
+func newPipeSessions() (*testSession, *testSession) {
+	c1, c2 := net.Pipe()
+	return &testSession{conn: c2, swap: gmap.New(true)}, &testSession{conn: c1, swap: gmap.New(true)}
+}
+
+func (that *testSession) Read(buf []byte) (int, error)  { return that.conn.Read(buf) }
+func (that *testSession) Write(buf []byte) (int, error) { return that.conn.Write(buf) }
+func (that *testSession) Close() error                  { return that.conn.Close() }
+func (that *testSession) Flush() error                  { return nil }
+func (that *testSession) Conn() io.ReadWriteCloser      { return that.conn }
+func (that *testSession) Swap() *gmap.Map               { return that.swap }
+func (that *testSession) Options() rfb.Options          { return rfb.Options{} }
+func (that *testSession) SetConn(conn io.ReadWriteCloser) {
+	that.conn = conn
+}
+func (that *testSession) TakeBuffered() []byte { return nil }
+
+// authFunc 把函数包装成认证方式，用于模拟vnc客户端或者vnc服务端
+type authFunc func(session rfb.ISession) error
+
+func (authFunc) Type() rfb.SecurityType               { return rfb.SecTypeUnknown }
+func (authFunc) SubType() rfb.SecuritySubType         { return rfb.SecSubTypeUnknown }
+func (that authFunc) Auth(session rfb.ISession) error { return that(session) }
+
+// runAuth 在一对会话上同时执行服务端和客户端的认证，返回两端的错误及服务端的会话
+func runAuth(t *testing.T, server, client rfb.ISecurityHandler) (error, error, *testSession) {
+	t.Helper()
+	srv, cli := newTestSessions(t)
+	return runAuthOn(t, srv, cli, server, client)
+}
+
+// runAuthOn 在指定的一对会话上同时执行服务端和客户端的认证
+func runAuthOn(t *testing.T, srv, cli *testSession, server, client rfb.ISecurityHandler) (error, error, *testSession) {
+	t.Helper()
+	errCh := make(chan error, 1)
+	go func() {
+		err := server.Auth(srv)
+		// 认证失败时关闭连接，避免另一端一直阻塞
+		if err != nil {
+			_ = srv.Close()
+		}
+		errCh <- err
+	}()
+	cliErr := client.Auth(cli)
+	if cliErr != nil {
+		_ = cli.Close()
+	}
+	select {
+	case srvErr := <-errCh:
+		_ = cli.Close()
+		return srvErr, cliErr, srv
+	case <-time.After(10 * time.Second):
+		t.Fatal("认证超时")
+	}
+	return nil, nil, nil
+}
@@ -0,0 +1,136 @@
+package security
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// rwcConn 把io.ReadWriteCloser包装成net.Conn，crypto/tls只能在net.Conn上建立连接
+type rwcConn struct {
+	io.ReadWriteCloser
+}
+
+type rwcAddr struct{}
+
+func (rwcAddr) Network() string { return "rfb" }
+func (rwcAddr) String() string  { return "rfb" }
+
+func (that *rwcConn) LocalAddr() net.Addr              { return rwcAddr{} }
+func (that *rwcConn) RemoteAddr() net.Addr             { return rwcAddr{} }
+func (that *rwcConn) SetDeadline(time.Time) error      { return nil }
+func (that *rwcConn) SetReadDeadline(time.Time) error  { return nil }
+func (that *rwcConn) SetWriteDeadline(time.Time) error { return nil }
+
+// prefixConn 先读取prefix中的数据，再读取底层连接的数据
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (that *prefixConn) Read(buf []byte) (int, error) {
+	return that.r.Read(buf)
+}
+
+// toNetConn 获取会话的底层连接，prefix是会话读缓冲区中未处理的数据
+func toNetConn(c io.ReadWriteCloser, prefix []byte) net.Conn {
+	conn, ok := c.(net.Conn)
+	if !ok {
+		conn = &rwcConn{ReadWriteCloser: c}
+	}
+	if len(prefix) == 0 {
+		return conn
+	}
+	return &prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(prefix), conn)}
+}
+
+// upgradeTLS 把会话的底层连接升级为tls连接，server为true表示作为tls服务端
+func upgradeTLS(session rfb.ISession, config *tls.Config, server bool) error {
+	setter, ok := session.(rfb.IConnSetter)
+	if !ok {
+		return fmt.Errorf("会话不支持替换连接，无法升级为tls连接")
+	}
+	if config == nil {
+		return fmt.Errorf("未设置tls配置")
+	}
+	if err := session.Flush(); err != nil {
+		return err
+	}
+	raw := toNetConn(session.Conn(), setter.TakeBuffered())
+	var conn *tls.Conn
+	if server {
+		conn = tls.Server(raw, config)
+	} else {
+		conn = tls.Client(raw, config)
+	}
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("tls握手失败:%v", err)
+	}
+	setter.SetConn(conn)
+	return nil
+}
+
+// loadCertPool 从pem文件中加载证书池
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("解析ca证书失败:%s", caFile)
+	}
+	return pool, nil
+}
+
+// NewServerTLSConfig 根据证书和私钥文件生成tls服务端配置
+// clientCAFile不为空时要求vnc客户端提供该ca签发的证书
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(clientCAFile) > 0 {
+		config.ClientCAs, err = loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// NewClientTLSConfig 生成连接vnc服务端使用的tls客户端配置
+// caFile不为空时只信任该ca签发的证书，certFile和keyFile不为空时向vnc服务端提供客户端证书
+func NewClientTLSConfig(caFile, certFile, keyFile, serverName string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+		MinVersion:         tls.VersionTLS12,
+	}
+	var err error
+	if len(caFile) > 0 {
+		config.RootCAs, err = loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -1,11 +1,13 @@
 package session
 
 import (
+	"bytes"
 	"github.com/gogf/gf/v2/container/gmap"
 	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/encodings"
 	"github.com/vprix/vncproxy/messages"
 	"github.com/vprix/vncproxy/rfb"
+	"image"
 	"io"
 )
 
@@ -66,6 +68,36 @@ func (that *CanvasSession) configure(opts ...rfb.Option) {
 func (that *CanvasSession) Start() {
 	that.canvas = canvas.NewVncCanvas(int(that.options.Width), int(that.options.Height))
 	that.canvas.DrawCursor = that.options.DrawCursor
+	that.canvas.ColorMap = that.options.ColorMap
+}
+
+// RawUpdate 把画布的指定区域按照像素格式生成使用Raw编码的帧缓冲更新消息，未指定区域则生成整个画布的更新消息，
+// 用于给新加入的vnc客户端发送全量画面，或者给像素格式不同的vnc客户端转码。
+func (that *CanvasSession) RawUpdate(pf rfb.PixelFormat, rects ...image.Rectangle) (*messages.FramebufferUpdate, error) {
+	if len(rects) == 0 {
+		rects = []image.Rectangle{that.canvas.Bounds()}
+	}
+	msg := &messages.FramebufferUpdate{}
+	for _, r := range rects {
+		r = r.Intersect(that.canvas.Bounds())
+		if r.Empty() {
+			continue
+		}
+		buff := &bytes.Buffer{}
+		if err := that.canvas.EncodeRaw(buff, &pf, r); err != nil {
+			return nil, err
+		}
+		msg.Rects = append(msg.Rects, &rfb.Rectangle{
+			X:       uint16(r.Min.X),
+			Y:       uint16(r.Min.Y),
+			Width:   uint16(r.Dx()),
+			Height:  uint16(r.Dy()),
+			EncType: rfb.EncRaw,
+			Enc:     encodings.NewRawEncoding(buff.Bytes()),
+		})
+	}
+	msg.NumRect = uint16(len(msg.Rects))
+	return msg, nil
 }
 
 // Conn 获取会话底层的网络链接
@@ -144,6 +176,11 @@ func (that *CanvasSession) Write(buf []byte) (int, error) {
 	return that.canvas.Write(buf)
 }
 
+// Canvas 获取画布
+func (that *CanvasSession) Canvas() *canvas.VncCanvas {
+	return that.canvas
+}
+
 // Close 关闭会话
 func (that *CanvasSession) Close() error {
 	if that.options.QuitCh != nil {
@@ -171,6 +208,10 @@ func (that *CanvasSession) SetPixelFormat(pf rfb.PixelFormat) {
 // SetColorMap 设置颜色地图
 func (that *CanvasSession) SetColorMap(cm rfb.ColorMap) {
 	that.options.ColorMap = cm
+	// 画布使用颜色地图绘制非真彩色的像素
+	if that.canvas != nil {
+		that.canvas.ColorMap = cm
+	}
 }
 
 // SetWidth 设置桌面宽度
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/gogf/gf/v2/container/gtype"
 	"github.com/osgochina/dmicro/logger"
 	"github.com/vprix/vncproxy/encodings"
 	"github.com/vprix/vncproxy/handler"
@@ -39,14 +40,17 @@ type ClientSession struct {
 
 	//交换区
 	swap *gmap.Map
+	quit *gtype.Bool // 是否已经退出
 }
 
 var _ rfb.ISession = new(ClientSession)
+var _ rfb.IConnSetter = new(ClientSession)
 
 // NewClient 创建客户端会话
 func NewClient(opts ...rfb.Option) *ClientSession {
 	sess := &ClientSession{
 		swap: gmap.New(true),
+		quit: gtype.NewBool(false),
 	}
 	sess.configure(opts...)
 
@@ -119,6 +123,25 @@ func (that *ClientSession) Conn() io.ReadWriteCloser {
 	return that.c
 }
 
+// SetConn 替换会话的底层连接，安全认证阶段升级为加密连接时使用
+// 读缓冲区中未处理的数据会被丢弃，需要先通过TakeBuffered取出
+func (that *ClientSession) SetConn(c io.ReadWriteCloser) {
+	that.c = c
+	that.br = bufio.NewReader(c)
+	that.bw = bufio.NewWriter(c)
+}
+
+// TakeBuffered 取出读缓冲区中未处理的数据
+func (that *ClientSession) TakeBuffered() []byte {
+	n := that.br.Buffered()
+	if n == 0 {
+		return nil
+	}
+	buf := make([]byte, n)
+	_, _ = io.ReadFull(that.br, buf)
+	return buf
+}
+
 // Options 获取配置信息
 func (that *ClientSession) Options() rfb.Options {
 	return that.options
@@ -193,8 +216,12 @@ func (that *ClientSession) Write(buf []byte) (int, error) {
 
 // Close 关闭会话
 func (that *ClientSession) Close() error {
-	if that.options.QuitCh != nil {
-		that.options.QuitCh <- struct{}{}
+	// 关闭退出通道，通知所有等待该会话的协程退出，多次关闭只生效一次
+	if that.options.QuitCh != nil && that.quit.Cas(false, true) {
+		close(that.options.QuitCh)
+	}
+	if that.c == nil {
+		return nil
 	}
 	return that.c.Close()
 }
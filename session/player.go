@@ -2,29 +2,61 @@ package session
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/gogf/gf/v2/container/gtype"
 	"github.com/vprix/vncproxy/encodings"
 	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
 	"github.com/vprix/vncproxy/rfb"
 	"io"
+	"time"
 )
 
+// recordedClientMessages 录屏中可能出现的vnc客户端消息
+var recordedClientMessages = append([]rfb.Message{&messages.QEMUExtKeyEvent{}}, messages.DefaultClientMessage...)
+
+// RecordEntry 录屏中的一条记录
+type RecordEntry struct {
+	Timestamp time.Duration // 相对录屏开始的时间
+	Type      rbs.ChunkType // 记录的类型，v1格式只有 rbs.ChunkFramebufferUpdate，FBS及vncrec格式没有vnc客户端消息
+	Redacted  bool          // 消息内容是否已经脱敏
+	Message   rfb.Message
+}
+
+// PlayerSession 读取rbs录屏文件，支持v1和v2格式，v2格式可以通过关键帧跳转，
+// 链接实现了 rbs.Segmented 时(例如 rbs.OpenFile 打开的文件)会把所有分段作为一个连续的录屏播放。
+// 也支持FBS及vncrec格式的录屏，与v1格式一样只能从头顺序播放。
 type PlayerSession struct {
 	c  io.ReadWriteCloser
 	br *bufio.Reader
 	bw *bufio.Writer
 
+	header    *rbs.Header
+	reader    *rbs.SegmentReader // v2格式的读取器，v1格式为nil
+	timestamp time.Duration      // v1格式累计的消息时间，vncrec格式下一个消息的时间
+	seeked    bool               // 刚跳转到关键帧，下一个关键帧需要返回
+
+	fbs         *rbs.FBSReader // FBS格式的读取器
+	vncrec      bool           // 是否是vncrec格式
+	vncrecStart time.Time      // vncrec格式第一个时间，作为录屏开始的时间
+
 	options         rfb.Options          // 配置信息
 	protocol        string               //协议版本
 	securityHandler rfb.ISecurityHandler // 安全认证方式
 
 	swap *gmap.Map
+	quit *gtype.Bool // 是否已经退出
 }
 
+var _ rfb.ISession = new(PlayerSession)
+
 func NewPlayerSession(opts ...rfb.Option) *PlayerSession {
 	sess := &PlayerSession{
 		swap: gmap.New(true),
+		quit: gtype.NewBool(false),
 	}
 	sess.configure(opts...)
 	return sess
@@ -73,63 +105,243 @@ func (that *PlayerSession) Start() {
 
 	that.br = bufio.NewReader(that.c)
 	that.bw = bufio.NewWriter(that.c)
-	version := make([]byte, len(RBSVersion))
-	_, err = that.br.Read(version)
+	version, err := that.br.Peek(len(rbs.Version2))
 	if err != nil {
 		that.options.ErrorCh <- err
 		return
 	}
-	// 读取rfb协议
-	version = make([]byte, len(rfb.ProtoVersion38))
-	_, err = that.br.Read(version)
-	if err != nil {
-		that.options.ErrorCh <- err
-		return
+	if string(version) == rbs.Version2 {
+		// v2格式需要跳转读取文件末尾的索引
+		if segmented, ok := that.c.(rbs.Segmented); ok {
+			that.reader, err = rbs.NewSegmentReader(segmented.Segments()...)
+		} else if rs, ok := that.c.(io.ReadSeeker); ok {
+			that.reader, err = rbs.NewSegmentReader(rs)
+		} else {
+			err = fmt.Errorf("v2格式的rbs文件需要支持Seek")
+		}
+		if err != nil {
+			that.options.ErrorCh <- err
+			return
+		}
+		that.header = that.reader.Header()
+		// 以关键帧开始的录屏(例如截取的录屏)需要返回开头的关键帧
+		that.seeked = true
+	} else {
+		if err = that.readHeader(); err != nil {
+			that.options.ErrorCh <- err
+			return
+		}
 	}
-	that.protocol = string(version)
-	var secTypeNone int32
-	err = binary.Read(that.br, binary.BigEndian, &secTypeNone)
-	if err != nil {
-		that.options.ErrorCh <- err
-		return
+	that.protocol = that.header.ProtocolVersion
+	that.SetWidth(that.header.Width)
+	that.SetHeight(that.header.Height)
+	that.SetPixelFormat(that.header.PixelFormat)
+	that.SetDesktopName(that.header.DesktopName)
+	return
+}
+
+// readHeader 读取v1、FBS或者vncrec格式的文件头，FBS格式之后从拼接的数据块中读取消息
+func (that *PlayerSession) readHeader() error {
+	magic, _ := that.br.Peek(len(rbs.FBSVersion))
+	var err error
+	switch {
+	case rbs.IsFBS(magic):
+		if that.fbs, err = rbs.NewFBSReader(that.br); err != nil {
+			return err
+		}
+		that.header = that.fbs.Header()
+		that.br = bufio.NewReader(that.fbs)
+	case rbs.IsVncrec(magic):
+		if that.header, err = rbs.ReadVncrecHeader(that.br); err != nil {
+			return err
+		}
+		that.vncrec = true
+	default:
+		if that.header, err = rbs.ReadHeader(that.br); err != nil {
+			return err
+		}
 	}
-	var fbWeight uint16
-	err = binary.Read(that.br, binary.BigEndian, &fbWeight)
-	if err != nil {
-		that.options.ErrorCh <- err
-		return
+	return nil
+}
+
+// Header 录屏文件头
+func (that *PlayerSession) Header() *rbs.Header {
+	return that.header
+}
+
+// Duration 录屏时长，v1、FBS及vncrec格式无法获取，返回0
+func (that *PlayerSession) Duration() time.Duration {
+	if that.reader == nil {
+		return 0
 	}
-	that.SetWidth(fbWeight)
+	return that.reader.Duration()
+}
 
-	var fbHeight uint16
-	err = binary.Read(that.br, binary.BigEndian, &fbHeight)
-	if err != nil {
-		that.options.ErrorCh <- err
-		return
+// Index 关键帧索引，分段录屏包括所有分段的关键帧，v1、FBS及vncrec格式没有关键帧，返回nil
+func (that *PlayerSession) Index() []rbs.IndexEntry {
+	if that.reader == nil {
+		return nil
+	}
+	return that.reader.Index()
+}
+
+// ReadMessage 读取下一个需要发送给vnc客户端的消息及其相对录屏开始的时间，会跳过vnc客户端发送的消息，读取到文件末尾返回io.EOF
+func (that *PlayerSession) ReadMessage() (rfb.Message, time.Duration, error) {
+	for {
+		entry, err := that.ReadEntry()
+		if err != nil {
+			return nil, 0, err
+		}
+		if entry.Type == rbs.ChunkClientMessage {
+			continue
+		}
+		return entry.Message, entry.Timestamp, nil
 	}
-	that.SetHeight(fbHeight)
+}
 
-	var pixelFormat rfb.PixelFormat
-	err = binary.Read(that.br, binary.BigEndian, &pixelFormat)
+// ReadEntry 读取下一条记录，包括vnc客户端发送的消息，顺序读取时会跳过关键帧，读取到文件末尾返回io.EOF
+func (that *PlayerSession) ReadEntry() (*RecordEntry, error) {
+	if that.fbs != nil || that.vncrec {
+		return that.readStreamEntry()
+	}
+	if that.reader == nil {
+		msg, ts, err := that.readV1Message()
+		if err != nil {
+			return nil, err
+		}
+		return &RecordEntry{Timestamp: ts, Type: rbs.ChunkFramebufferUpdate, Message: msg}, nil
+	}
+	for {
+		chunk, err := that.reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		seeked := that.seeked
+		that.seeked = false
+		msgs := messages.DefaultServerMessages
+		switch chunk.Type {
+		case rbs.ChunkKeyframe:
+			// 顺序播放时画面已经是最新的，只有跳转之后才需要关键帧
+			if !seeked {
+				continue
+			}
+		case rbs.ChunkFramebufferUpdate, rbs.ChunkServerMessage:
+		case rbs.ChunkClientMessage:
+			msgs = recordedClientMessages
+		default:
+			// 忽略不认识的数据块，兼容以后新增的数据块类型
+			continue
+		}
+		that.br.Reset(bytes.NewReader(chunk.Data))
+		msg, err := that.readMessage(msgs)
+		if err != nil {
+			return nil, err
+		}
+		return &RecordEntry{
+			Timestamp: chunk.Timestamp,
+			Type:      chunk.Type,
+			Redacted:  chunk.Flags&rbs.FlagRedacted != 0,
+			Message:   msg,
+		}, nil
+	}
+}
+
+// readV1Message 读取v1格式的消息，消息之后是播放该消息后需要等待的时长，
+// 所以返回的是加上该时长之前的时间，该时长累加到下一个消息的时间上
+func (that *PlayerSession) readV1Message() (rfb.Message, time.Duration, error) {
+	msg, err := that.readMessage(messages.DefaultServerMessages)
 	if err != nil {
-		that.options.ErrorCh <- err
-		return
+		return nil, 0, err
+	}
+	ts := that.timestamp
+	var delta int64
+	// 录屏结尾的消息之后可能没有时长
+	if err = binary.Read(that.br, binary.BigEndian, &delta); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	if delta > 0 {
+		that.timestamp += time.Duration(delta)
 	}
-	that.SetPixelFormat(pixelFormat)
-	var desktopNameSize uint32
-	err = binary.Read(that.br, binary.BigEndian, &desktopNameSize)
+	return msg, ts, nil
+}
+
+// readStreamEntry 读取FBS或者vncrec格式的下一个vnc服务端消息
+func (that *PlayerSession) readStreamEntry() (*RecordEntry, error) {
+	msg, err := that.readMessage(messages.DefaultServerMessages)
 	if err != nil {
-		that.options.ErrorCh <- err
-		return
+		return nil, err
+	}
+	entry := &RecordEntry{Timestamp: that.timestamp, Type: rbs.ChunkServerMessage, Message: msg}
+	if that.fbs != nil {
+		entry.Timestamp = that.fbs.Timestamp()
 	}
-	desktopName := make([]byte, desktopNameSize)
-	_, err = that.Read(desktopName)
+	if _, ok := msg.(*messages.FramebufferUpdate); !ok {
+		return entry, nil
+	}
+	entry.Type = rbs.ChunkFramebufferUpdate
+	if that.vncrec {
+		// 帧缓冲更新之后是下一个消息的时间，录屏结尾的帧缓冲更新之后可能没有时间
+		t, err := rbs.ReadVncrecTime(that.br)
+		if err == io.EOF {
+			return entry, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if that.vncrecStart.IsZero() {
+			that.vncrecStart = t
+		}
+		if ts := t.Sub(that.vncrecStart); ts > that.timestamp {
+			that.timestamp = ts
+		}
+	}
+	return entry, nil
+}
+
+// readMessage 读取消息类型及消息内容
+func (that *PlayerSession) readMessage(msgs []rfb.Message) (rfb.Message, error) {
+	var messageType rfb.MessageType
+	if err := binary.Read(that.br, binary.BigEndian, &messageType); err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		if msg.Type() == messageType {
+			return msg.Read(that)
+		}
+	}
+	return nil, fmt.Errorf("不支持的消息类型:%d", messageType)
+}
+
+// Seek 跳转到ts之前最近的关键帧，返回关键帧的时间，之后ReadMessage会先返回关键帧
+// v1、FBS及vncrec格式没有关键帧，只能跳转到第一个消息，返回0
+func (that *PlayerSession) Seek(ts time.Duration) (time.Duration, error) {
+	if that.reader == nil {
+		rs, ok := that.c.(io.Seeker)
+		if !ok {
+			return 0, fmt.Errorf("rbs文件不支持跳转")
+		}
+		that.timestamp = 0
+		if that.fbs != nil || that.vncrec {
+			// 重新读取文件头及握手数据
+			if _, err := rs.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+			that.br = bufio.NewReader(that.c)
+			that.fbs, that.vncrec, that.vncrecStart = nil, false, time.Time{}
+			return 0, that.readHeader()
+		}
+		if _, err := rs.Seek(that.header.Size(), io.SeekStart); err != nil {
+			return 0, err
+		}
+		that.br.Reset(that.c)
+		return 0, nil
+	}
+	kf, err := that.reader.Seek(ts)
 	if err != nil {
-		that.options.ErrorCh <- err
-		return
+		return 0, err
 	}
-	that.SetDesktopName(desktopName)
-	return
+	that.seeked = true
+	return kf, nil
 }
 
 // Conn 获取会话底层的网络链接
@@ -203,8 +415,12 @@ func (that *PlayerSession) Write(buf []byte) (int, error) {
 
 // Close 关闭会话
 func (that *PlayerSession) Close() error {
-	if that.options.QuitCh != nil {
-		that.options.QuitCh <- struct{}{}
+	// 关闭退出通道，多次关闭只生效一次
+	if that.options.QuitCh != nil && that.quit.Cas(false, true) {
+		close(that.options.QuitCh)
+	}
+	if that.c == nil {
+		return nil
 	}
 	return that.c.Close()
 }
@@ -0,0 +1,83 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"testing"
+	"time"
+)
+
+// nopConn 把只读的数据包装成连接
+type nopConn struct {
+	io.Reader
+}
+
+func (nopConn) Write(buf []byte) (int, error) { return len(buf), nil }
+func (nopConn) Close() error                  { return nil }
+
+// newV1Recording 生成v1格式的录屏，每个消息是一个响铃，之后是播放该消息后等待的时长，deltas比消息少一个时最后一个消息之后没有时长
+func newV1Recording(messageCount int, deltas ...time.Duration) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(rbs.Version1)
+	buf.WriteString(rfb.ProtoVersion38)
+	_ = binary.Write(&buf, binary.BigEndian, int32(rfb.SecTypeNone))
+	_ = binary.Write(&buf, binary.BigEndian, uint16(64))
+	_ = binary.Write(&buf, binary.BigEndian, uint16(48))
+	_ = binary.Write(&buf, binary.BigEndian, rfb.PixelFormat32bit)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(4))
+	buf.WriteString("test")
+	for i := 0; i < messageCount; i++ {
+		buf.WriteByte(byte(rfb.Bell))
+		if i < len(deltas) {
+			_ = binary.Write(&buf, binary.BigEndian, int64(deltas[i]))
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestPlayerSessionV1Timestamp(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []time.Duration
+	}{
+		{"每个消息之后都有时长", newV1Recording(3, 100*time.Millisecond, 200*time.Millisecond, 300*time.Millisecond),
+			[]time.Duration{0, 100 * time.Millisecond, 300 * time.Millisecond}},
+		{"最后一个消息之后没有时长", newV1Recording(3, 100*time.Millisecond, 200*time.Millisecond),
+			[]time.Duration{0, 100 * time.Millisecond, 300 * time.Millisecond}},
+		{"忽略负数时长", newV1Recording(2, -time.Second, 0),
+			[]time.Duration{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := NewPlayerSession(rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) {
+				return nopConn{bytes.NewReader(tt.data)}, nil
+			}))
+			sess.Start()
+			select {
+			case err := <-sess.Options().ErrorCh:
+				t.Fatal(err)
+			default:
+			}
+			for i, expected := range tt.expected {
+				entry, err := sess.ReadEntry()
+				if err != nil {
+					t.Fatalf("读取第%d个消息失败:%v", i, err)
+				}
+				if _, ok := entry.Message.(*messages.Bell); !ok {
+					t.Fatalf("第%d个消息为%v", i, entry.Message)
+				}
+				if entry.Timestamp != expected {
+					t.Fatalf("第%d个消息的时间为%s，期望%s", i, entry.Timestamp, expected)
+				}
+			}
+			if _, err := sess.ReadEntry(); err != io.EOF {
+				t.Fatalf("读取结束后返回%v，期望io.EOF", err)
+			}
+		})
+	}
+}
@@ -2,24 +2,61 @@ package session
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/gogf/gf/v2/container/gtype"
+	"github.com/osgochina/dmicro/logger"
+	"github.com/vprix/vncproxy/canvas"
 	"github.com/vprix/vncproxy/encodings"
 	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
 	"github.com/vprix/vncproxy/rfb"
+	"image"
 	"io"
+	"sync"
+	"time"
 )
 
-const RBSVersion = "RBS 001.001\n"
+// RBSVersion v1格式的rbs文件版本，新录制的文件使用v2格式 rbs.Version2
+const RBSVersion = rbs.Version1
 
+// DefaultKeyframeInterval 默认写入关键帧的间隔
+const DefaultKeyframeInterval = 30 * time.Second
+
+// RecorderSession 把vnc服务端及vnc客户端的消息录制成v2格式的rbs文件，
+// 同时把帧缓冲更新绘制到画布上，每隔一段时间把整个画布作为关键帧写入，播放时可以从关键帧开始跳转。
 type RecorderSession struct {
 	c  io.ReadWriteCloser
+	cw io.WriteCloser     // 压缩写入器，写入链接
+	ew *rbs.EncryptWriter // 加密写入器，为nil表示不加密
+	cc *countWriter       // 统计写入链接的字节数
 	bw *bufio.Writer
 
+	mu               sync.Mutex // 保护文件的写入，录制和关闭可能在不同的协程
+	writer           *rbs.Writer
+	buff             *bytes.Buffer  // 正在编码的消息
+	canvasSession    *CanvasSession // 用于生成关键帧的画布
+	cursor           *rfb.Rectangle // 最后一次收到的鼠标指针形状，写入关键帧
+	start            time.Time      // 开始录屏的时间
+	keyframeInterval time.Duration  // 写入关键帧的间隔
+	lastKeyframe     time.Duration  // 最后一次写入关键帧的时间，为负数表示还没有写入关键帧
+	redactKeys       bool           // 是否清空按键内容
+	redactPointer    bool           // 是否清空鼠标位置
+	redactClipboard  bool           // 是否清空剪贴板内容
+	compression      rbs.Compression
+	encryptKey       []byte        // 加密录屏的密钥，为空表示不加密
+	segmentSize      int64         // 分段的最大字节数(压缩后)，0表示不限制
+	segmentDuration  time.Duration // 分段的最大时长，0表示不限制
+	segment          int           // 当前分段的序号，从0开始
+	segmentStart     time.Duration // 当前分段开始的时间
+
 	options  rfb.Options // 客户端配置信息
 	protocol string      //协议版本
 
 	swap *gmap.Map
+	quit *gtype.Bool // 是否已经退出
 }
 
 var _ rfb.ISession = new(RecorderSession)
@@ -27,7 +64,11 @@ var _ rfb.ISession = new(RecorderSession)
 // NewRecorder 创建客户端会话
 func NewRecorder(opts ...rfb.Option) *RecorderSession {
 	recorder := &RecorderSession{
-		swap: gmap.New(true),
+		buff:             &bytes.Buffer{},
+		keyframeInterval: DefaultKeyframeInterval,
+		lastKeyframe:     -1,
+		swap:             gmap.New(true),
+		quit:             gtype.NewBool(false),
 	}
 	recorder.configure(opts...)
 	return recorder
@@ -68,62 +109,360 @@ func (that *RecorderSession) configure(opts ...rfb.Option) {
 		that.options.Encodings = encodings.DefaultEncodings
 	}
 }
+
+// SetKeyframeInterval 设置写入关键帧的间隔，小于等于0则只在开始录屏时写入一个关键帧
+func (that *RecorderSession) SetKeyframeInterval(interval time.Duration) {
+	that.keyframeInterval = interval
+}
+
+// SetRedactKeys 设置是否清空按键内容，只保留按下及弹起的时间，用于防止录屏泄露密码
+func (that *RecorderSession) SetRedactKeys(redact bool) {
+	that.redactKeys = redact
+}
+
+// SetRedactPointer 设置是否清空鼠标位置，只保留按键状态及时间，用于防止通过屏幕键盘的点击位置泄露密码
+func (that *RecorderSession) SetRedactPointer(redact bool) {
+	that.redactPointer = redact
+}
+
+// SetRedactClipboard 设置是否清空剪贴板内容
+func (that *RecorderSession) SetRedactClipboard(redact bool) {
+	that.redactClipboard = redact
+}
+
+// SetCompression 设置录屏文件整体使用的压缩算法，需要在Start之前设置
+func (that *RecorderSession) SetCompression(c rbs.Compression) {
+	that.compression = c
+}
+
+// SetEncryptKey 设置加密录屏的密钥，录屏数据(压缩后)使用AES-GCM加密并链式校验，需要在Start之前设置，参考 rbs.NewEncryptWriter
+func (that *RecorderSession) SetEncryptKey(key []byte) {
+	that.encryptKey = key
+}
+
+// SetRotation 设置分段录屏，当前分段的大小(压缩后)超过size或者时长超过duration时，关闭当前分段并通过GetConn打开下一个分段，
+// GetConn中通过 Segment 获取分段序号，使用 rbs.SegmentPath 生成分段的文件路径。为0表示不限制
+func (that *RecorderSession) SetRotation(size int64, duration time.Duration) {
+	that.segmentSize = size
+	that.segmentDuration = duration
+}
+
+// SetStartTime 设置开始录屏的时间，写入文件头，默认为调用Start的时间，需要在Start之前设置。
+// 编辑录屏时使用原录屏的时间
+func (that *RecorderSession) SetStartTime(start time.Time) {
+	that.start = start
+}
+
+// Segment 当前分段的序号，从0开始
+func (that *RecorderSession) Segment() int {
+	return that.segment
+}
+
 func (that *RecorderSession) Start() {
-	var err error
-	that.c, err = that.options.GetConn(that)
-	if err != nil {
-		that.options.ErrorCh <- err
-		return
+	if that.start.IsZero() {
+		that.start = time.Now()
 	}
-
-	that.bw = bufio.NewWriter(that.c)
-	_, err = that.Write([]byte(RBSVersion))
-	if err != nil {
+	if err := that.openSegment(); err != nil {
 		that.options.ErrorCh <- err
 		return
 	}
-	_, err = that.Write([]byte(that.ProtocolVersion()))
-	if err != nil {
+	that.canvasSession = NewCanvasSession(
+		rfb.OptPixelFormat(that.options.PixelFormat),
+		rfb.OptWidth(int(that.options.Width)),
+		rfb.OptHeight(int(that.options.Height)),
+	)
+	that.canvasSession.Start()
+	if err := that.Flush(); err != nil {
 		that.options.ErrorCh <- err
 		return
 	}
-	err = binary.Write(that.bw, binary.BigEndian, int32(rfb.SecTypeNone))
+	return
+}
+
+// openSegment 通过GetConn打开一个分段并写入文件头
+func (that *RecorderSession) openSegment() error {
+	var err error
+	that.c, err = that.options.GetConn(that)
 	if err != nil {
-		that.options.ErrorCh <- err
-		return
+		return err
 	}
-	err = binary.Write(that.bw, binary.BigEndian, int16(that.options.Width))
-	if err != nil {
-		that.options.ErrorCh <- err
-		return
+	that.cc = &countWriter{w: that.c}
+	var w io.Writer = that.cc
+	that.ew = nil
+	if len(that.encryptKey) > 0 {
+		if that.ew, err = rbs.NewEncryptWriter(that.cc, that.encryptKey); err != nil {
+			return err
+		}
+		w = that.ew
 	}
-	err = binary.Write(that.bw, binary.BigEndian, int16(that.options.Height))
+	// 关闭压缩写入器时会同时关闭加密写入器，写入结束记录
+	that.cw, err = rbs.NewCompressWriter(w, that.compression)
 	if err != nil {
-		that.options.ErrorCh <- err
-		return
+		return err
 	}
-	err = binary.Write(that.bw, binary.BigEndian, that.options.PixelFormat)
-	if err != nil {
-		that.options.ErrorCh <- err
-		return
+	that.bw = bufio.NewWriter(that.cw)
+	that.writer, err = rbs.NewWriter(that.bw, rbs.Header{
+		ProtocolVersion: that.ProtocolVersion(),
+		Width:           that.options.Width,
+		Height:          that.options.Height,
+		PixelFormat:     that.options.PixelFormat,
+		DesktopName:     that.options.DesktopName,
+		StartTime:       that.start,
+	})
+	return err
+}
+
+// closeSegment 写入当前分段的索引并关闭
+func (that *RecorderSession) closeSegment() error {
+	var err error
+	if that.writer != nil {
+		err = that.writer.Close()
 	}
-	nameSize := len(that.options.DesktopName)
-	err = binary.Write(that.bw, binary.BigEndian, uint32(nameSize))
-	if err != nil {
-		that.options.ErrorCh <- err
-		return
+	if e := that.Flush(); err == nil {
+		err = e
 	}
-	_, err = that.Write(that.options.DesktopName)
-	if err != nil {
-		that.options.ErrorCh <- err
-		return
+	if that.cw != nil {
+		if e := that.cw.Close(); err == nil {
+			err = e
+		}
+	}
+	if e := that.c.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// rotate 当前分段超过限制时打开下一个分段，新的分段以关键帧开始
+func (that *RecorderSession) rotate(ts time.Duration) error {
+	size := that.cc.n + int64(that.bw.Buffered())
+	if (that.segmentSize <= 0 || size < that.segmentSize) &&
+		(that.segmentDuration <= 0 || ts-that.segmentStart < that.segmentDuration) {
+		return nil
+	}
+	if err := that.closeSegment(); err != nil {
+		return err
+	}
+	that.segment++
+	that.segmentStart = ts
+	if err := that.openSegment(); err != nil {
+		return err
+	}
+	return that.writeKeyframe(ts)
+}
+
+// WriteMessage 录制一个消息，到了关键帧间隔则同时写入关键帧
+// 录制的vnc服务端消息有帧缓冲更新(包括桌面大小变化)、响铃、剪贴板及颜色地图，
+// vnc客户端消息有按键、鼠标及剪贴板，其他消息会被忽略。
+// vnc客户端修改像素格式的消息不会录制，之后的帧缓冲更新会转码成文件头中的像素格式。
+func (that *RecorderSession) WriteMessage(msg rfb.Message) error {
+	return that.WriteMessageAt(msg, time.Now())
+}
+
+// WriteMessageAt 录制一个在at时刻收到的消息，用于消息排队之后再写入文件的场景，
+// at需要按顺序递增，早于开始录屏的时间按开始录屏的时间记录
+func (that *RecorderSession) WriteMessageAt(msg rfb.Message, at time.Time) error {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	ts := at.Sub(that.start)
+	if ts < 0 {
+		ts = 0
+	}
+	return that.writeMessage(msg, ts, false)
+}
+
+// WriteEntry 按照记录中相对录屏开始的时间写入一条记录，用于编辑录屏，时间需要按顺序递增。
+// 关键帧会绘制到画布上并作为关键帧写入，已经脱敏的记录保留脱敏标识。
+func (that *RecorderSession) WriteEntry(entry *RecordEntry) error {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if entry.Type != rbs.ChunkKeyframe {
+		return that.writeMessage(entry.Message, entry.Timestamp, entry.Redacted)
 	}
-	err = that.Flush()
+	fbu, ok := entry.Message.(*messages.FramebufferUpdate)
+	if !ok {
+		return fmt.Errorf("关键帧的消息类型不正确:%T", entry.Message)
+	}
+	if that.quit.Val() {
+		return io.ErrClosedPipe
+	}
+	if err := fbu.Clone().Write(that.canvasSession); err != nil {
+		return err
+	}
+	for _, rect := range fbu.Rects {
+		if rect.EncType == rfb.EncCursorPseudo {
+			that.cursor = rect.Clone()
+		}
+	}
+	if err := that.writeKeyframe(entry.Timestamp); err != nil {
+		return err
+	}
+	if err := that.rotate(entry.Timestamp); err != nil {
+		return err
+	}
+	return that.Flush()
+}
+
+// writeMessage 录制一个消息，redacted表示消息已经脱敏，调用时需要持有锁
+func (that *RecorderSession) writeMessage(msg rfb.Message, ts time.Duration, redacted bool) error {
+	if spf, ok := msg.(*messages.SetPixelFormat); ok {
+		// vnc客户端修改了像素格式，之后的帧缓冲更新都使用新的像素格式
+		if that.canvasSession != nil {
+			that.canvasSession.SetPixelFormat(spf.PF)
+		}
+		return nil
+	}
+	if cm, ok := msg.(*messages.SetColorMapEntries); ok && that.canvasSession != nil {
+		// 关键帧需要按照颜色地图编码
+		_ = cm.Write(that.canvasSession)
+	}
+	typ, ok := recordChunkType(msg)
+	if !ok {
+		return nil
+	}
+	if that.quit.Val() {
+		return io.ErrClosedPipe
+	}
+	if fbu, ok := msg.(*messages.FramebufferUpdate); ok {
+		if err := that.writeFramebufferUpdate(ts, fbu); err != nil {
+			return err
+		}
+		if err := that.rotate(ts); err != nil {
+			return err
+		}
+		return that.Flush()
+	}
+	var flags uint8
+	if r := that.redact(msg); r != nil {
+		msg = r
+		redacted = true
+	}
+	if redacted {
+		flags |= rbs.FlagRedacted
+	}
+	that.buff.Reset()
+	if err := msg.Write(that); err != nil {
+		return err
+	}
+	if err := that.writer.WriteChunkFlags(typ, flags, ts, that.buff.Bytes()); err != nil {
+		return err
+	}
+	if err := that.rotate(ts); err != nil {
+		return err
+	}
+	return that.Flush()
+}
+
+// writeFramebufferUpdate 写入帧缓冲更新，到了关键帧间隔则同时写入关键帧
+func (that *RecorderSession) writeFramebufferUpdate(ts time.Duration, fbu *messages.FramebufferUpdate) error {
+	// 先绘制到画布上，编码写入文件会清空消息中的数据
+	if err := fbu.Clone().Write(that.canvasSession); err != nil {
+		logger.Warningf(context.TODO(), "录屏绘制画布失败，关键帧可能不完整:%v", err)
+	}
+	if that.canvasSession.Options().PixelFormat != that.options.PixelFormat {
+		// 像素格式与文件头不一致，从画布转码成Raw编码，鼠标指针无法转码直接丢弃
+		var rects []image.Rectangle
+		for _, rect := range fbu.Rects {
+			if rect.EncType >= 0 {
+				rects = append(rects, canvas.MakeRectFromVncRect(rect))
+			}
+		}
+		if len(rects) == 0 {
+			return nil
+		}
+		var err error
+		if fbu, err = that.canvasSession.RawUpdate(that.options.PixelFormat, rects...); err != nil {
+			return err
+		}
+	}
+	for _, rect := range fbu.Rects {
+		if rect.EncType == rfb.EncCursorPseudo {
+			that.cursor = rect.Clone()
+		}
+	}
+	that.buff.Reset()
+	if err := fbu.Write(that); err != nil {
+		return err
+	}
+	if err := that.writer.WriteChunk(rbs.ChunkFramebufferUpdate, ts, that.buff.Bytes()); err != nil {
+		return err
+	}
+	if that.lastKeyframe < 0 || (that.keyframeInterval > 0 && ts-that.lastKeyframe >= that.keyframeInterval) {
+		return that.writeKeyframe(ts)
+	}
+	return nil
+}
+
+// redact 按照脱敏配置清空消息内容，不需要脱敏则返回nil
+func (that *RecorderSession) redact(msg rfb.Message) rfb.Message {
+	switch m := msg.(type) {
+	case *messages.KeyEvent:
+		if that.redactKeys {
+			return &messages.KeyEvent{Down: m.Down}
+		}
+	case *messages.QEMUExtKeyEvent:
+		if that.redactKeys {
+			return &messages.QEMUExtKeyEvent{SubMessageType: m.SubMessageType, DownFlag: m.DownFlag}
+		}
+	case *messages.PointerEvent:
+		if that.redactPointer {
+			return &messages.PointerEvent{Mask: m.Mask}
+		}
+	case *messages.ClientCutText:
+		if that.redactClipboard {
+			return &messages.ClientCutText{}
+		}
+	case *messages.ServerCutText:
+		if that.redactClipboard {
+			return &messages.ServerCutText{}
+		}
+	}
+	return nil
+}
+
+// recordChunkType 消息录制后的数据块类型，不需要录制的消息返回false
+func recordChunkType(msg rfb.Message) (rbs.ChunkType, bool) {
+	switch msg.(type) {
+	case *messages.FramebufferUpdate:
+		return rbs.ChunkFramebufferUpdate, true
+	case *messages.Bell, *messages.ServerCutText, *messages.SetColorMapEntries:
+		return rbs.ChunkServerMessage, true
+	case *messages.KeyEvent, *messages.PointerEvent, *messages.ClientCutText, *messages.QEMUExtKeyEvent:
+		return rbs.ChunkClientMessage, true
+	}
+	return 0, false
+}
+
+// writeKeyframe 把整个画布及鼠标指针作为关键帧写入
+func (that *RecorderSession) writeKeyframe(ts time.Duration) error {
+	keyframe, err := that.canvasSession.RawUpdate(that.options.PixelFormat)
 	if err != nil {
-		that.options.ErrorCh <- err
-		return
+		return err
 	}
-	return
+	if that.cursor != nil {
+		keyframe.Rects = append(keyframe.Rects, that.cursor.Clone())
+		keyframe.NumRect = uint16(len(keyframe.Rects))
+	}
+	that.buff.Reset()
+	if err = keyframe.Write(that); err != nil {
+		return err
+	}
+	if err = that.writer.WriteChunk(rbs.ChunkKeyframe, ts, that.buff.Bytes()); err != nil {
+		return err
+	}
+	that.lastKeyframe = ts
+	// 压缩或者加密的录屏在关键帧处把缓存的数据写入文件，异常中断时最多丢失一个关键帧间隔的数据
+	if f, ok := that.cw.(interface{ Flush() error }); ok {
+		if err = that.Flush(); err != nil {
+			return err
+		}
+		if err = f.Flush(); err != nil {
+			return err
+		}
+	}
+	if that.ew != nil {
+		return that.ew.Flush()
+	}
+	return nil
 }
 
 // Conn 获取会话底层的网络链接
@@ -157,6 +496,9 @@ func (that *RecorderSession) SetEncodings(_ []rfb.EncodingType) error {
 }
 
 func (that *RecorderSession) Flush() error {
+	if that.bw == nil {
+		return nil
+	}
 	return that.bw.Flush()
 }
 
@@ -189,17 +531,41 @@ func (that *RecorderSession) Read(_ []byte) (int, error) {
 	return 0, nil
 }
 
-// Write 写入数据到链接
+// Write 写入正在编码的消息，由WriteMessage写入文件
 func (that *RecorderSession) Write(buf []byte) (int, error) {
-	return that.bw.Write(buf)
+	return that.buff.Write(buf)
 }
 
-// Close 关闭会话
+// Close 关闭会话，写入关键帧索引
 func (that *RecorderSession) Close() error {
+	// 多次关闭只生效一次
+	if !that.quit.Cas(false, true) {
+		return nil
+	}
 	if that.options.QuitCh != nil {
-		that.options.QuitCh <- struct{}{}
+		close(that.options.QuitCh)
+	}
+	if that.c == nil {
+		return nil
+	}
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if that.canvasSession != nil {
+		_ = that.canvasSession.Close()
 	}
-	return that.c.Close()
+	return that.closeSegment()
+}
+
+// countWriter 统计写入的字节数
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (that *countWriter) Write(p []byte) (int, error) {
+	n, err := that.w.Write(p)
+	that.n += int64(n)
+	return n, err
 }
 
 // Swap session存储的临时变量
@@ -0,0 +1,169 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/vprix/vncproxy/encodings"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memConn 把录屏写入内存
+type memConn struct {
+	*bytes.Buffer
+}
+
+func (memConn) Close() error { return nil }
+
+// seekConn 读取内存中的录屏，v2格式需要支持Seek
+type seekConn struct {
+	*bytes.Reader
+}
+
+func (seekConn) Write(buf []byte) (int, error) { return len(buf), nil }
+func (seekConn) Close() error                  { return nil }
+
+// recordMessages 按照配置录制消息，返回录屏文件的内容
+func recordMessages(t *testing.T, setup func(*RecorderSession), msgs ...rfb.Message) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	recorder := NewRecorder(
+		rfb.OptWidth(2),
+		rfb.OptHeight(1),
+		rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) { return memConn{buf}, nil }),
+	)
+	recorder.SetProtocolVersion(rfb.ProtoVersion38)
+	setup(recorder)
+	start := time.Now()
+	recorder.SetStartTime(start)
+	recorder.Start()
+	for i, msg := range msgs {
+		if err := recorder.WriteMessageAt(msg, start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-recorder.Options().ErrorCh:
+		t.Fatal(err)
+	default:
+	}
+	return buf.Bytes()
+}
+
+// readEntries 读取录屏中除关键帧以外的所有记录
+func readEntries(t *testing.T, data []byte) []*RecordEntry {
+	t.Helper()
+	player := NewPlayerSession(rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) {
+		return seekConn{bytes.NewReader(data)}, nil
+	}))
+	player.Start()
+	select {
+	case err := <-player.Options().ErrorCh:
+		t.Fatal(err)
+	default:
+	}
+	var entries []*RecordEntry
+	for {
+		entry, err := player.ReadEntry()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Type != rbs.ChunkKeyframe {
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// messageFields 消息的字段，用于比较读取的消息与录制的消息
+func messageFields(msg rfb.Message) string {
+	return fmt.Sprintf("%T%+v", msg, reflect.ValueOf(msg).Elem().Interface())
+}
+
+// TestRecorderRedact 脱敏的按键、鼠标及剪贴板内容不会写入录屏，其他消息原样录制
+func TestRecorderRedact(t *testing.T) {
+	events := []struct {
+		msg      rfb.Message
+		by       string      // 清空内容的配置，为空表示不脱敏
+		redacted rfb.Message // 脱敏后的消息
+	}{
+		{&messages.KeyEvent{Down: 1, Key: rfb.SmallP}, "keys", &messages.KeyEvent{Down: 1}},
+		{&messages.KeyEvent{Down: 0, Key: rfb.SmallP}, "keys", &messages.KeyEvent{Down: 0}},
+		{&messages.QEMUExtKeyEvent{SubMessageType: 0, DownFlag: 1, KeySym: rfb.SmallW, KeyCode: 17}, "keys",
+			&messages.QEMUExtKeyEvent{SubMessageType: 0, DownFlag: 1}},
+		{&messages.PointerEvent{Mask: 1, X: 1234, Y: 567}, "pointer", &messages.PointerEvent{Mask: 1}},
+		{&messages.ClientCutText{Length: 7, Text: []byte("hunter2")}, "clipboard", &messages.ClientCutText{Text: []byte{}}},
+		{&messages.ServerCutText{Length: 7, Text: []byte("s3cret!")}, "clipboard", &messages.ServerCutText{Text: []byte{}}},
+		{&messages.Bell{}, "", nil},
+		{&messages.FramebufferUpdate{NumRect: 1, Rects: []*rfb.Rectangle{{
+			Width: 2, Height: 1, EncType: rfb.EncRaw, Enc: encodings.NewRawEncoding([]byte{1, 2, 3, 0, 4, 5, 6, 0}),
+		}}}, "", nil},
+	}
+	tests := []struct {
+		name    string
+		redact  map[string]bool
+		secrets []string // 录屏文件中不能出现的内容
+	}{
+		{"不脱敏", map[string]bool{}, nil},
+		{"清空按键", map[string]bool{"keys": true}, nil},
+		{"清空鼠标位置", map[string]bool{"pointer": true}, nil},
+		{"清空剪贴板", map[string]bool{"clipboard": true}, []string{"hunter2", "s3cret!"}},
+		{"全部脱敏", map[string]bool{"keys": true, "pointer": true, "clipboard": true}, []string{"hunter2", "s3cret!"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msgs []rfb.Message
+			for _, e := range events {
+				msgs = append(msgs, e.msg.Clone())
+			}
+			data := recordMessages(t, func(r *RecorderSession) {
+				r.SetRedactKeys(tt.redact["keys"])
+				r.SetRedactPointer(tt.redact["pointer"])
+				r.SetRedactClipboard(tt.redact["clipboard"])
+			}, msgs...)
+			for _, secret := range tt.secrets {
+				if bytes.Contains(data, []byte(secret)) {
+					t.Fatalf("录屏文件中包含%q", secret)
+				}
+			}
+
+			entries := readEntries(t, data)
+			if len(entries) != len(events) {
+				t.Fatalf("读取了%d条记录，应该为%d条", len(entries), len(events))
+			}
+			for i, e := range events {
+				entry := entries[i]
+				if entry.Timestamp != time.Duration(i)*time.Second {
+					t.Fatalf("第%d条记录的时间为%v", i, entry.Timestamp)
+				}
+				redacted := e.by != "" && tt.redact[e.by]
+				if entry.Redacted != redacted {
+					t.Fatalf("第%d条记录%v的脱敏标识为%v", i, entry.Message, entry.Redacted)
+				}
+				if _, ok := e.msg.(*messages.FramebufferUpdate); ok {
+					if entry.Type != rbs.ChunkFramebufferUpdate {
+						t.Fatalf("第%d条记录的类型为%v", i, entry.Type)
+					}
+					continue
+				}
+				want := e.msg
+				if redacted {
+					want = e.redacted
+				}
+				if got := messageFields(entry.Message); got != messageFields(want) {
+					t.Fatalf("第%d条记录为%s，应该为%s", i, got, messageFields(want))
+				}
+			}
+		})
+	}
+}
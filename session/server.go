@@ -3,6 +3,7 @@ package session
 import (
 	"bufio"
 	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/gogf/gf/v2/container/gtype"
 	"github.com/vprix/vncproxy/encodings"
 	"github.com/vprix/vncproxy/handler"
 	"github.com/vprix/vncproxy/messages"
@@ -31,13 +32,16 @@ type ServerSession struct {
 	securityHandler rfb.ISecurityHandler // 安全认证方式
 
 	swap *gmap.Map
+	quit *gtype.Bool // 是否已经退出
 }
 
 var _ rfb.ISession = new(ServerSession)
+var _ rfb.IConnSetter = new(ServerSession)
 
 func NewServerSession(opts ...rfb.Option) *ServerSession {
 	sess := &ServerSession{
 		swap: gmap.New(true),
+		quit: gtype.NewBool(false),
 	}
 	sess.configure(opts...)
 
@@ -105,6 +109,25 @@ func (that *ServerSession) Start() {
 func (that *ServerSession) Conn() io.ReadWriteCloser {
 	return that.c
 }
+
+// SetConn 替换会话的底层连接，安全认证阶段升级为加密连接时使用
+// 读缓冲区中未处理的数据会被丢弃，需要先通过TakeBuffered取出
+func (that *ServerSession) SetConn(c io.ReadWriteCloser) {
+	that.c = c
+	that.br = bufio.NewReader(c)
+	that.bw = bufio.NewWriter(c)
+}
+
+// TakeBuffered 取出读缓冲区中未处理的数据
+func (that *ServerSession) TakeBuffered() []byte {
+	n := that.br.Buffered()
+	if n == 0 {
+		return nil
+	}
+	buf := make([]byte, n)
+	_, _ = io.ReadFull(that.br, buf)
+	return buf
+}
 func (that *ServerSession) Options() rfb.Options {
 	return that.options
 }
@@ -179,8 +202,12 @@ func (that *ServerSession) Write(buf []byte) (int, error) {
 
 // Close 关闭会话
 func (that *ServerSession) Close() error {
-	if that.options.QuitCh != nil {
-		that.options.QuitCh <- struct{}{}
+	// 关闭退出通道，通知所有等待该会话的协程退出，多次关闭只生效一次
+	if that.options.QuitCh != nil && that.quit.Cas(false, true) {
+		close(that.options.QuitCh)
+	}
+	if that.c == nil {
+		return nil
 	}
 	return that.c.Close()
 }
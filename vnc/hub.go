@@ -0,0 +1,420 @@
+package vnc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/container/gtype"
+	"github.com/gogf/gf/v2/util/grand"
+	"github.com/osgochina/dmicro/logger"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"image"
+	"sync"
+)
+
+// sharedEncodings 共享会话向vnc服务端请求的编码格式
+// 转发给多个vnc客户端的数据必须是无状态的，ZRLE，Tight，Zlib等编码使用了持续的zlib流，
+// 中途加入的vnc客户端无法解码，同时画布也需要能够解码这些数据，所以只使用以下编码。
+var sharedEncodings = []rfb.EncodingType{
+	rfb.EncHexTile,
+	rfb.EncRaw,
+	rfb.EncCursorPseudo,
+}
+
+// sharedViewerQueueLen 每个vnc客户端待发送消息的队列长度，队列满了说明vnc客户端处理太慢，会被断开
+const sharedViewerQueueLen = 256
+
+// Hub 共享会话中心，连接到同一个vnc服务端的多个vnc客户端共享同一个连接
+type Hub struct {
+	mu       sync.Mutex
+	sessions map[string]*SharedSession
+	pending  map[string]*pendingSession // 正在连接vnc服务端的共享会话
+}
+
+// pendingSession 正在创建的共享会话，同一个key的其他vnc客户端等待创建完成后再加入
+type pendingSession struct {
+	done chan struct{}
+	err  error
+}
+
+// NewHub 创建共享会话中心
+func NewHub() *Hub {
+	return &Hub{
+		sessions: make(map[string]*SharedSession),
+		pending:  make(map[string]*pendingSession),
+	}
+}
+
+// Len 当前共享会话的数量
+func (that *Hub) Len() int {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return len(that.sessions)
+}
+
+// join vnc客户端加入key对应的共享会话，共享会话不存在则使用remoteSession连接vnc服务端创建共享会话
+// exclusive 为true表示该vnc客户端要求独占，会断开共享会话中的其他vnc客户端
+// 连接vnc服务端时不持有锁，避免一个vnc服务端连接缓慢阻塞其他共享会话，同一个key同时只会创建一个共享会话
+func (that *Hub) join(key string, remoteSession *session.ClientSession, exclusive bool) (*SharedSession, *sharedViewer, error) {
+	for {
+		that.mu.Lock()
+		if shared, ok := that.sessions[key]; ok && !shared.closed.Val() {
+			viewer := shared.addViewer(exclusive)
+			that.mu.Unlock()
+			if viewer != nil {
+				return shared, viewer, nil
+			}
+			// 共享会话刚好被关闭，重新创建
+			continue
+		}
+		if pending, ok := that.pending[key]; ok {
+			that.mu.Unlock()
+			<-pending.done
+			if pending.err != nil {
+				return nil, nil, pending.err
+			}
+			continue
+		}
+		pending := &pendingSession{done: make(chan struct{})}
+		that.pending[key] = pending
+		that.mu.Unlock()
+
+		shared, err := newSharedSession(that, key, remoteSession)
+		var viewer *sharedViewer
+		that.mu.Lock()
+		delete(that.pending, key)
+		if err == nil {
+			that.sessions[key] = shared
+			if viewer = shared.addViewer(exclusive); viewer == nil {
+				err = errors.New("共享会话已关闭")
+			}
+		}
+		that.mu.Unlock()
+		pending.err = err
+		close(pending.done)
+		if err != nil {
+			return nil, nil, err
+		}
+		return shared, viewer, nil
+	}
+}
+
+// sharedKeySalt 计算凭证摘要使用的随机密钥，共享会话的key会出现在日志中，不能直接包含凭证
+var sharedKeySalt = grand.B(32)
+
+// sharedKeyWithCredentials 在共享会话的key后面加上连接vnc服务端所用凭证的摘要，
+// 使用不同凭证的vnc客户端不会加入同一个共享会话，否则可以借用其他用户的凭证访问vnc服务端
+func sharedKeyWithCredentials(key string, targetCfg *rfb.TargetConfig) string {
+	if len(targetCfg.Username) == 0 && len(targetCfg.Password) == 0 {
+		return key
+	}
+	mac := hmac.New(sha256.New, sharedKeySalt)
+	_, _ = mac.Write(targetCfg.Username)
+	_, _ = mac.Write([]byte{0})
+	_, _ = mac.Write(targetCfg.Password)
+	return fmt.Sprintf("%s#%x", key, mac.Sum(nil)[:8])
+}
+
+// remove 删除共享会话
+func (that *Hub) remove(shared *SharedSession) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if s, ok := that.sessions[shared.key]; ok && s == shared {
+		delete(that.sessions, shared.key)
+	}
+}
+
+// SharedSession 共享会话，一个连接到vnc服务端的会话，把服务端消息分发给多个vnc客户端，
+// 同时把帧缓冲更新绘制到画布上，新加入的vnc客户端请求全量更新时直接从画布生成。
+type SharedSession struct {
+	hub           *Hub
+	key           string
+	remoteSession *session.ClientSession
+	canvasSession *session.CanvasSession
+
+	mu      sync.Mutex // 保护画布，鼠标指针及vnc客户端列表
+	viewers map[*sharedViewer]struct{}
+	cursor  *rfb.Rectangle // 最后一次收到的鼠标指针形状，发送给新加入的vnc客户端
+	closed  *gtype.Bool
+	quit    chan struct{}
+}
+
+// sharedViewer 共享会话中的一个vnc客户端
+type sharedViewer struct {
+	output  chan rfb.Message // 分发给该vnc客户端的消息
+	errorCh chan error       // 共享会话出错或者被踢出时的错误
+
+	mu        sync.Mutex
+	pf        rfb.PixelFormat           // vnc客户端使用的像素格式
+	encodings map[rfb.EncodingType]bool // vnc客户端支持的编码格式
+	transcode bool                      // 是否需要把帧缓冲更新从画布转码成Raw编码
+	closed    *gtype.Bool
+}
+
+// newSharedSession 连接vnc服务端，创建共享会话
+func newSharedSession(hub *Hub, key string, remoteSession *session.ClientSession) (*SharedSession, error) {
+	remoteSession.Start()
+	select {
+	case err := <-remoteSession.Options().ErrorCh:
+		return nil, err
+	default:
+	}
+	if err := remoteSession.SetEncodings(sharedEncodings); err != nil {
+		_ = remoteSession.Close()
+		return nil, err
+	}
+	cfg := remoteSession.Options()
+	canvasSession := session.NewCanvasSession(
+		rfb.OptPixelFormat(cfg.PixelFormat),
+		rfb.OptWidth(int(cfg.Width)),
+		rfb.OptHeight(int(cfg.Height)),
+		rfb.OptDesktopName(cfg.DesktopName),
+	)
+	canvasSession.Start()
+	shared := &SharedSession{
+		hub:           hub,
+		key:           key,
+		remoteSession: remoteSession,
+		canvasSession: canvasSession,
+		viewers:       make(map[*sharedViewer]struct{}),
+		closed:        gtype.NewBool(false),
+		quit:          make(chan struct{}),
+	}
+	go shared.handleIO()
+	// 请求一次全量更新，填充画布
+	cfg.Input <- &messages.FramebufferUpdateRequest{Inc: 0, Width: cfg.Width, Height: cfg.Height}
+	if logger.IsDebug() {
+		logger.Debugf(context.TODO(), "创建共享会话:%s", key)
+	}
+	return shared, nil
+}
+
+// Viewers 当前共享会话中vnc客户端的数量
+func (that *SharedSession) Viewers() int {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return len(that.viewers)
+}
+
+// addViewer 加入vnc客户端，共享会话已关闭时返回nil
+func (that *SharedSession) addViewer(exclusive bool) *sharedViewer {
+	viewer := &sharedViewer{
+		output:    make(chan rfb.Message, sharedViewerQueueLen),
+		errorCh:   make(chan error, 1),
+		pf:        that.remoteSession.Options().PixelFormat,
+		encodings: make(map[rfb.EncodingType]bool),
+		transcode: true,
+		closed:    gtype.NewBool(false),
+	}
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if that.closed.Val() {
+		return nil
+	}
+	if exclusive {
+		for v := range that.viewers {
+			v.fail(errors.New("其他vnc客户端以独占模式连接，断开连接"))
+			delete(that.viewers, v)
+		}
+	}
+	that.viewers[viewer] = struct{}{}
+	return viewer
+}
+
+// leave vnc客户端离开共享会话，最后一个vnc客户端离开后关闭到vnc服务端的连接
+func (that *SharedSession) leave(viewer *sharedViewer) {
+	viewer.closed.Set(true)
+	that.mu.Lock()
+	delete(that.viewers, viewer)
+	empty := len(that.viewers) == 0
+	that.mu.Unlock()
+	if empty {
+		that.close()
+	}
+}
+
+// close 关闭共享会话
+func (that *SharedSession) close() {
+	if !that.closed.Cas(false, true) {
+		return
+	}
+	that.hub.remove(that)
+	close(that.quit)
+	that.mu.Lock()
+	for v := range that.viewers {
+		v.fail(errors.New("共享会话已关闭"))
+	}
+	that.mu.Unlock()
+	_ = that.remoteSession.Close()
+	_ = that.canvasSession.Close()
+	if logger.IsDebug() {
+		logger.Debugf(context.TODO(), "关闭共享会话:%s", that.key)
+	}
+}
+
+// refresh 从画布生成vnc客户端请求区域的全量更新
+func (that *SharedSession) refresh(viewer *sharedViewer, req *messages.FramebufferUpdateRequest) (*messages.FramebufferUpdate, error) {
+	pf, _ := viewer.format()
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	msg, err := that.canvasSession.RawUpdate(pf, canvas.MakeRect(int(req.X), int(req.Y), int(req.Width), int(req.Height)))
+	if err != nil {
+		return nil, err
+	}
+	if that.cursor != nil && viewer.supports(rfb.EncCursorPseudo) && !viewer.needTranscode() {
+		msg.Rects = append(msg.Rects, that.cursor.Clone())
+		msg.NumRect = uint16(len(msg.Rects))
+	}
+	return msg, nil
+}
+
+// handleIO 处理vnc服务端发送过来的消息，分发给所有的vnc客户端
+func (that *SharedSession) handleIO() {
+	cfg := that.remoteSession.Options()
+	for {
+		select {
+		case <-that.quit:
+			return
+		case err := <-cfg.ErrorCh:
+			that.mu.Lock()
+			for v := range that.viewers {
+				v.fail(err)
+			}
+			that.mu.Unlock()
+			that.close()
+			return
+		case msg := <-cfg.Output:
+			that.dispatch(msg)
+		}
+	}
+}
+
+// dispatch 分发vnc服务端的消息
+func (that *SharedSession) dispatch(msg rfb.Message) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	fbu, ok := msg.(*messages.FramebufferUpdate)
+	if !ok {
+		for v := range that.viewers {
+			v.send(msg.Clone())
+		}
+		return
+	}
+	// 先绘制到画布上，保证新加入的vnc客户端能获取到最新的画面
+	if err := fbu.Clone().Write(that.canvasSession); err != nil {
+		logger.Warningf(context.TODO(), "共享会话 %s 绘制画布失败:%v", that.key, err)
+	}
+	var rects []image.Rectangle
+	for _, rect := range fbu.Rects {
+		if rect.EncType == rfb.EncCursorPseudo {
+			that.cursor = rect.Clone()
+			continue
+		}
+		if rect.EncType >= 0 {
+			rects = append(rects, canvas.MakeRectFromVncRect(rect))
+		}
+	}
+	for v := range that.viewers {
+		if !v.needTranscode() {
+			v.send(that.filterCursor(v, fbu))
+			continue
+		}
+		// vnc客户端的像素格式或者编码格式与共享会话不一致，从画布转码成Raw编码
+		if len(rects) == 0 {
+			continue
+		}
+		pf, _ := v.format()
+		update, err := that.canvasSession.RawUpdate(pf, rects...)
+		if err != nil {
+			v.fail(err)
+			continue
+		}
+		if update.NumRect > 0 {
+			v.send(update)
+		}
+	}
+}
+
+// filterCursor 复制帧缓冲更新，vnc客户端不支持鼠标指针伪编码时去掉鼠标指针
+func (that *SharedSession) filterCursor(viewer *sharedViewer, fbu *messages.FramebufferUpdate) rfb.Message {
+	if viewer.supports(rfb.EncCursorPseudo) {
+		return fbu.Clone()
+	}
+	msg := &messages.FramebufferUpdate{}
+	for _, rect := range fbu.Rects {
+		if rect.EncType != rfb.EncCursorPseudo {
+			msg.Rects = append(msg.Rects, rect.Clone())
+		}
+	}
+	msg.NumRect = uint16(len(msg.Rects))
+	return msg
+}
+
+// send 非阻塞发送消息给vnc客户端，队列满了则断开该vnc客户端
+func (that *sharedViewer) send(msg rfb.Message) {
+	if that.closed.Val() {
+		return
+	}
+	select {
+	case that.output <- msg:
+	default:
+		that.fail(fmt.Errorf("vnc客户端处理消息太慢，断开连接"))
+	}
+}
+
+// fail 通知vnc客户端共享会话出错
+func (that *sharedViewer) fail(err error) {
+	if !that.closed.Cas(false, true) {
+		return
+	}
+	select {
+	case that.errorCh <- err:
+	default:
+	}
+}
+
+// setPixelFormat 设置vnc客户端的像素格式
+func (that *sharedViewer) setPixelFormat(pf, remotePF rfb.PixelFormat) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.pf = pf
+	that.update(remotePF)
+}
+
+// setEncodings 设置vnc客户端支持的编码格式
+func (that *sharedViewer) setEncodings(encs []rfb.EncodingType, remotePF rfb.PixelFormat) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.encodings = make(map[rfb.EncodingType]bool)
+	for _, enc := range encs {
+		that.encodings[enc] = true
+	}
+	that.update(remotePF)
+}
+
+// update 判断是否需要转码，像素格式不一致或者vnc客户端不支持hextile编码都需要转码成Raw编码
+func (that *sharedViewer) update(remotePF rfb.PixelFormat) {
+	that.transcode = that.pf != remotePF || !that.encodings[rfb.EncHexTile]
+}
+
+func (that *sharedViewer) format() (rfb.PixelFormat, bool) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.pf, that.transcode
+}
+
+func (that *sharedViewer) needTranscode() bool {
+	_, transcode := that.format()
+	return transcode
+}
+
+func (that *sharedViewer) supports(enc rfb.EncodingType) bool {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.encodings[enc]
+}
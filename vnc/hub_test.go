@@ -0,0 +1,146 @@
+package vnc
+
+import (
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/security"
+	"github.com/vprix/vncproxy/session"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newUpstreamClient 创建连接到模拟vnc服务端的客户端会话，dial在建立连接之前调用
+func newUpstreamClient(t *testing.T, dial func()) *session.ClientSession {
+	return session.NewClient(
+		rfb.OptSecurityHandlers(&security.ClientAuthNone{}),
+		rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) {
+			if dial != nil {
+				dial()
+			}
+			c1, c2 := net.Pipe()
+			srv := session.NewServerSession(
+				rfb.OptSecurityHandlers(&security.ServerAuthNone{}),
+				rfb.OptWidth(64),
+				rfb.OptHeight(48),
+				rfb.OptDesktopName([]byte("upstream")),
+				rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) { return c2, nil }),
+			)
+			// 丢弃vnc客户端发送的消息
+			output, quit := srv.Options().Output, srv.Wait()
+			go func() {
+				for {
+					select {
+					case <-quit:
+						return
+					case <-output:
+					}
+				}
+			}()
+			go srv.Start()
+			t.Cleanup(func() { _ = srv.Close() })
+			return c1, nil
+		}),
+	)
+}
+
+func TestHubJoin(t *testing.T) {
+	hub := NewHub()
+	release := make(chan struct{})
+	type result struct {
+		shared *SharedSession
+		err    error
+	}
+	results := make(chan result, 2)
+	join := func(key string, remote *session.ClientSession) {
+		shared, _, err := hub.join(key, remote, false)
+		results <- result{shared, err}
+	}
+	go join("slow", newUpstreamClient(t, func() { <-release }))
+	// 等待第一个vnc客户端开始连接vnc服务端
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		hub.mu.Lock()
+		_, pending := hub.pending["slow"]
+		hub.mu.Unlock()
+		if pending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("没有开始连接vnc服务端")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 连接缓慢的vnc服务端不能阻塞其他共享会话的创建
+	done := make(chan error, 1)
+	go func() {
+		shared, _, err := hub.join("fast", newUpstreamClient(t, nil), false)
+		if err == nil {
+			defer shared.close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("创建其他共享会话被阻塞")
+	}
+
+	// 同一个key的vnc客户端等待共享会话创建完成后加入，不会重复连接vnc服务端
+	go join("slow", newUpstreamClient(t, func() { t.Error("重复连接vnc服务端") }))
+	close(release)
+	r1, r2 := <-results, <-results
+	if r1.err != nil || r2.err != nil {
+		t.Fatalf("加入共享会话失败:%v %v", r1.err, r2.err)
+	}
+	defer r1.shared.close()
+	if r1.shared != r2.shared {
+		t.Fatal("同一个key创建了两个共享会话")
+	}
+	if n := r1.shared.Viewers(); n != 2 {
+		t.Fatalf("共享会话中有%d个vnc客户端，期望2个", n)
+	}
+}
+
+func TestHubJoinError(t *testing.T) {
+	// 连接vnc服务端失败时返回错误，之后可以重新创建
+	hub := NewHub()
+	failed := session.NewClient(
+		rfb.OptSecurityHandlers(&security.ClientAuthNone{}),
+		rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) { return nil, io.ErrClosedPipe }),
+	)
+	if _, _, err := hub.join("key", failed, false); err == nil {
+		t.Fatal("期望连接vnc服务端失败")
+	}
+	shared, _, err := hub.join("key", newUpstreamClient(t, nil), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shared.close()
+	if hub.Len() != 1 {
+		t.Fatalf("共享会话数量为%d，期望1", hub.Len())
+	}
+}
+
+func TestSharedKeyWithCredentials(t *testing.T) {
+	anonymous := sharedKeyWithCredentials("host:5900", &rfb.TargetConfig{})
+	if anonymous != "host:5900" {
+		t.Fatalf("没有凭证时key为%s", anonymous)
+	}
+	alice := sharedKeyWithCredentials("host:5900", &rfb.TargetConfig{Username: []byte("alice"), Password: []byte("secret")})
+	bob := sharedKeyWithCredentials("host:5900", &rfb.TargetConfig{Username: []byte("bob"), Password: []byte("secret")})
+	other := sharedKeyWithCredentials("host:5900", &rfb.TargetConfig{Username: []byte("alice"), Password: []byte("other")})
+	if alice == anonymous || alice == bob || alice == other {
+		t.Fatal("不同的凭证使用了相同的key")
+	}
+	if again := sharedKeyWithCredentials("host:5900", &rfb.TargetConfig{Username: []byte("alice"), Password: []byte("secret")}); again != alice {
+		t.Fatal("相同的凭证使用了不同的key")
+	}
+	// 用户名和密码的分界不同时key也不同
+	if sharedKeyWithCredentials("host:5900", &rfb.TargetConfig{Username: []byte("ali"), Password: []byte("cesecret")}) == alice {
+		t.Fatal("用户名和密码拼接相同时使用了相同的key")
+	}
+}
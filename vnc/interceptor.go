@@ -0,0 +1,82 @@
+package vnc
+
+import (
+	"context"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+)
+
+// Direction 消息的转发方向
+type Direction int
+
+const (
+	DirectionClientToServer Direction = iota // vnc客户端发送给vnc服务端的消息
+	DirectionServerToClient                  // vnc服务端发送给vnc客户端的消息
+)
+
+func (that Direction) String() string {
+	switch that {
+	case DirectionClientToServer:
+		return "ClientToServer"
+	case DirectionServerToClient:
+		return "ServerToClient"
+	}
+	return fmt.Sprintf("Direction(%d)", int(that))
+}
+
+// Interceptor 消息拦截器，proxy转发每一条消息之前都会按注册顺序调用拦截器。
+// 返回的消息会替代原消息继续转发，返回nil则丢弃该消息，返回错误则断开连接。
+// 拦截器在proxy的消息转发协程中执行，不要长时间阻塞。
+// 通过 ProxyFromContext 可以获取当前的proxy，使用 Proxy.Inject 注入新的消息。
+type Interceptor func(ctx context.Context, dir Direction, msg rfb.Message) (rfb.Message, error)
+
+type proxyCtxKey struct{}
+
+// ProxyFromContext 从拦截器的上下文中获取当前的proxy
+func ProxyFromContext(ctx context.Context) *Proxy {
+	p, _ := ctx.Value(proxyCtxKey{}).(*Proxy)
+	return p
+}
+
+// OptInterceptors 注册消息拦截器
+func OptInterceptors(interceptors ...Interceptor) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.interceptors = append(proxy.interceptors, interceptors...)
+	}
+}
+
+// intercept 按顺序执行拦截器，返回nil表示消息被丢弃
+func (that *Proxy) intercept(dir Direction, msg rfb.Message) (rfb.Message, error) {
+	var err error
+	for _, interceptor := range that.interceptors {
+		msg, err = interceptor(that.ctx, dir, msg)
+		if err != nil || msg == nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// Inject 注入消息，DirectionClientToServer 发送给vnc服务端，DirectionServerToClient 发送给vnc客户端。
+// 注入的消息不会经过拦截器，只能在消息转发阶段调用，proxy关闭后返回错误。
+func (that *Proxy) Inject(dir Direction, msg rfb.Message) error {
+	if !that.running.Val() {
+		return fmt.Errorf("proxy未进入消息转发阶段")
+	}
+	var input chan<- rfb.Message
+	switch dir {
+	case DirectionClientToServer:
+		input = that.remoteInput
+	case DirectionServerToClient:
+		input = that.svrSession.Options().Input
+		that.record(msg)
+	default:
+		return fmt.Errorf("不支持的消息方向:%v", dir)
+	}
+	select {
+	case input <- msg:
+		return nil
+	case <-that.ctx.Done():
+		return fmt.Errorf("proxy已关闭")
+	}
+}
@@ -0,0 +1,215 @@
+package vnc
+
+import (
+	"context"
+	"errors"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"testing"
+	"time"
+)
+
+// testProxy 已进入消息转发阶段的proxy，vnc服务端一侧使用测试的通道代替
+type testProxy struct {
+	*Proxy
+	remoteInput   chan rfb.Message // proxy发送给vnc服务端的消息
+	remoteOutput  chan rfb.Message // vnc服务端发送给proxy的消息
+	remoteErrorCh chan error
+}
+
+func newTestProxy(t *testing.T, opts ...ProxyOption) *testProxy {
+	that := &testProxy{
+		remoteInput:   make(chan rfb.Message, 8),
+		remoteOutput:  make(chan rfb.Message),
+		remoteErrorCh: make(chan error, 1),
+	}
+	that.Proxy = NewVncProxy(session.NewClient(), session.NewServerSession(), opts...)
+	that.Proxy.remoteInput, that.Proxy.remoteOutput, that.Proxy.remoteErrorCh = that.remoteInput, that.remoteOutput, that.remoteErrorCh
+	that.running.Set(true)
+	go that.handleIO()
+	t.Cleanup(that.Close)
+	return that
+}
+
+// fromClient 模拟vnc客户端发送消息
+func (that *testProxy) fromClient(t *testing.T, msg rfb.Message) {
+	t.Helper()
+	select {
+	case that.svrSession.Options().Output <- msg:
+	case <-time.After(5 * time.Second):
+		t.Fatal("发送vnc客户端的消息超时")
+	}
+}
+
+// fromServer 模拟vnc服务端发送消息
+func (that *testProxy) fromServer(t *testing.T, msg rfb.Message) {
+	t.Helper()
+	select {
+	case that.remoteOutput <- msg:
+	case <-time.After(5 * time.Second):
+		t.Fatal("发送vnc服务端的消息超时")
+	}
+}
+
+// toServer 获取转发给vnc服务端的下一条消息
+func (that *testProxy) toServer(t *testing.T) rfb.Message {
+	t.Helper()
+	select {
+	case msg := <-that.remoteInput:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有转发消息给vnc服务端")
+	}
+	return nil
+}
+
+// toClient 获取转发给vnc客户端的下一条消息
+func (that *testProxy) toClient(t *testing.T) rfb.Message {
+	t.Helper()
+	select {
+	case msg := <-that.svrSession.Options().Input:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有转发消息给vnc客户端")
+	}
+	return nil
+}
+
+// TestInterceptorDrop 拦截器返回nil时丢弃消息，之后的消息正常转发
+func TestInterceptorDrop(t *testing.T) {
+	p := newTestProxy(t, OptInterceptors(func(ctx context.Context, dir Direction, msg rfb.Message) (rfb.Message, error) {
+		if ProxyFromContext(ctx) == nil {
+			return nil, errors.New("上下文中没有proxy")
+		}
+		switch msg.Type() {
+		case rfb.MessageType(rfb.KeyEvent), rfb.MessageType(rfb.Bell):
+			return nil, nil
+		}
+		return msg, nil
+	}))
+
+	p.fromClient(t, &messages.KeyEvent{Down: 1, Key: 'a'})
+	p.fromClient(t, &messages.PointerEvent{Mask: 1, X: 2, Y: 3})
+	if msg := p.toServer(t); msg.Type() != rfb.MessageType(rfb.PointerEvent) {
+		t.Fatalf("转发给vnc服务端的消息为%v", msg)
+	}
+
+	p.fromServer(t, &messages.Bell{})
+	p.fromServer(t, &messages.ServerCutText{Length: 2, Text: []byte("hi")})
+	if msg := p.toClient(t); msg.Type() != rfb.MessageType(rfb.ServerCutText) {
+		t.Fatalf("转发给vnc客户端的消息为%v", msg)
+	}
+}
+
+// TestInterceptorRewrite 多个拦截器按注册顺序执行，返回的消息替代原消息
+func TestInterceptorRewrite(t *testing.T) {
+	var order []string
+	p := newTestProxy(t, OptInterceptors(
+		func(ctx context.Context, dir Direction, msg rfb.Message) (rfb.Message, error) {
+			order = append(order, "first")
+			switch m := msg.(type) {
+			case *messages.ClientCutText:
+				return &messages.ClientCutText{Length: 6, Text: []byte("[" + string(m.Text) + "]")}, nil
+			case *messages.Bell:
+				return &messages.ServerCutText{Length: 4, Text: []byte("bell")}, nil
+			}
+			return msg, nil
+		},
+		func(ctx context.Context, dir Direction, msg rfb.Message) (rfb.Message, error) {
+			order = append(order, "second")
+			if m, ok := msg.(*messages.ServerCutText); ok && dir == DirectionServerToClient {
+				return &messages.ServerCutText{Length: m.Length + 1, Text: append(m.Text, '!')}, nil
+			}
+			return msg, nil
+		},
+	))
+
+	p.fromClient(t, &messages.ClientCutText{Length: 4, Text: []byte("copy")})
+	msg, ok := p.toServer(t).(*messages.ClientCutText)
+	if !ok || string(msg.Text) != "[copy]" {
+		t.Fatalf("转发给vnc服务端的消息为%v", msg)
+	}
+	p.fromServer(t, &messages.Bell{})
+	cut, ok := p.toClient(t).(*messages.ServerCutText)
+	if !ok || string(cut.Text) != "bell!" {
+		t.Fatalf("转发给vnc客户端的消息为%v", cut)
+	}
+	if len(order) != 4 || order[0] != "first" || order[1] != "second" {
+		t.Fatalf("拦截器的执行顺序为%v", order)
+	}
+}
+
+// TestInterceptorError 拦截器返回错误时断开连接，错误作为proxy的结束原因
+func TestInterceptorError(t *testing.T) {
+	for _, dir := range []Direction{DirectionClientToServer, DirectionServerToClient} {
+		t.Run(dir.String(), func(t *testing.T) {
+			errDenied := errors.New("denied")
+			p := newTestProxy(t, OptInterceptors(func(ctx context.Context, d Direction, msg rfb.Message) (rfb.Message, error) {
+				if d == dir {
+					return nil, errDenied
+				}
+				return msg, nil
+			}))
+			if dir == DirectionClientToServer {
+				p.fromClient(t, &messages.KeyEvent{Key: 'a'})
+			} else {
+				p.fromServer(t, &messages.Bell{})
+			}
+			select {
+			case err := <-p.errorCh:
+				if !errors.Is(err, errDenied) {
+					t.Fatalf("proxy的结束原因为%v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("拦截器返回错误后proxy没有结束")
+			}
+			if !p.closed.Val() {
+				t.Fatal("拦截器返回错误后proxy没有关闭")
+			}
+		})
+	}
+}
+
+// TestInject 注入的消息发送到对应的一方，不经过拦截器
+func TestInject(t *testing.T) {
+	var intercepted int
+	p := newTestProxy(t, OptInterceptors(func(ctx context.Context, dir Direction, msg rfb.Message) (rfb.Message, error) {
+		intercepted++
+		return msg, nil
+	}))
+
+	if err := p.Inject(DirectionClientToServer, &messages.KeyEvent{Down: 1, Key: 'x'}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := p.toServer(t); msg.Type() != rfb.MessageType(rfb.KeyEvent) {
+		t.Fatalf("注入给vnc服务端的消息为%v", msg)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- p.Inject(DirectionServerToClient, &messages.Bell{}) }()
+	if msg := p.toClient(t); msg.Type() != rfb.MessageType(rfb.Bell) {
+		t.Fatalf("注入给vnc客户端的消息为%v", msg)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if intercepted != 0 {
+		t.Fatalf("注入的消息经过了%d次拦截器", intercepted)
+	}
+	if err := p.Inject(Direction(5), &messages.Bell{}); err == nil {
+		t.Fatal("不支持的方向没有返回错误")
+	}
+
+	// proxy关闭后，没有人接收的注入返回错误
+	p.Close()
+	if err := p.Inject(DirectionServerToClient, &messages.Bell{}); err == nil {
+		t.Fatal("proxy关闭后注入没有返回错误")
+	}
+
+	// 未进入消息转发阶段
+	idle := NewVncProxy(session.NewClient(), session.NewServerSession())
+	if err := idle.Inject(DirectionClientToServer, &messages.Bell{}); err == nil {
+		t.Fatal("未进入消息转发阶段注入没有返回错误")
+	}
+}
@@ -0,0 +1,567 @@
+package vnc
+
+import (
+	"context"
+	"fmt"
+	"github.com/osgochina/dmicro/logger"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"image"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// 播放速度的范围
+const (
+	MinPlaybackSpeed = 0.5
+	MaxPlaybackSpeed = 16
+)
+
+// DefaultPlaybackSeekStep 快捷键前进及后退的默认时长
+const DefaultPlaybackSeekStep = 10 * time.Second
+
+// PlayerOption 播放器的配置
+type PlayerOption func(*Player)
+
+// OptPlaybackSpeed 设置播放速度，范围为 MinPlaybackSpeed 到 MaxPlaybackSpeed
+func OptPlaybackSpeed(speed float64) PlayerOption {
+	return func(player *Player) {
+		_ = player.controller.SetSpeed(speed)
+	}
+}
+
+// OptPlaybackIdleLimit 设置最长的空闲时间，录屏中超过该时长没有画面变化则跳过，为0表示不跳过
+func OptPlaybackIdleLimit(limit time.Duration) PlayerOption {
+	return func(player *Player) {
+		player.controller.SetIdleLimit(limit)
+	}
+}
+
+// OptPlaybackLoop 设置是否循环播放
+func OptPlaybackLoop(loop bool) PlayerOption {
+	return func(player *Player) {
+		player.controller.SetLoop(loop)
+	}
+}
+
+// OptPlaybackHotkeys 设置播放控制的快捷键，为nil则不响应快捷键
+func OptPlaybackHotkeys(hotkeys PlaybackHotkeys) PlayerOption {
+	return func(player *Player) {
+		player.controller.hotkeys = hotkeys
+	}
+}
+
+// OptPlaybackSeekStep 设置快捷键前进及后退的时长
+func OptPlaybackSeekStep(step time.Duration) PlayerOption {
+	return func(player *Player) {
+		player.controller.seekStep = step
+	}
+}
+
+// OptPlaybackOverlay 设置是否在画面左下角显示播放信息
+func OptPlaybackOverlay(visible bool) PlayerOption {
+	return func(player *Player) {
+		player.controller.SetOverlayVisible(visible)
+	}
+}
+
+// OptPlaybackKey 设置解密录屏文件的密钥，播放加密的录屏时需要，参考 rbs.OptKey
+func OptPlaybackKey(key []byte) PlayerOption {
+	return func(player *Player) {
+		player.fileOptions = append(player.fileOptions, rbs.OptKey(key))
+	}
+}
+
+// PlaybackController 控制录屏的播放，支持暂停、跳转、倍速、跳过空闲时间及循环播放
+// 播放进度由一个可以暂停和变速的时钟驱动，消息的时间到了才发送给vnc客户端。
+// 跳转时从最近的关键帧开始把消息绘制到画布上，再把整个画布作为一个帧缓冲更新发送给vnc客户端。
+// 跳转之后vnc客户端的zlib流与录屏不再一致，之后使用ZRLE、Tight、Zlib等编码的帧缓冲更新从画布重新编码为Raw发送。
+type PlaybackController struct {
+	player *Player
+
+	mu         sync.Mutex
+	paused     bool
+	speed      float64
+	idleLimit  time.Duration
+	loop       bool
+	anchorPos  time.Duration // 时钟最后一次调整时的录屏时间
+	anchorWall time.Time     // 时钟最后一次调整时的实际时间
+	seekTo     time.Duration // 等待处理的跳转时间
+	seeking    bool          // 是否有等待处理的跳转
+	overlay    bool          // 是否显示播放信息
+
+	hotkeys  PlaybackHotkeys  // 播放控制的快捷键
+	seekStep time.Duration    // 快捷键前进及后退的时长
+	wake     chan struct{}    // 播放状态变化时唤醒播放协程
+	now      func() time.Time // 获取当前时间，为nil则使用time.Now，测试时替换
+
+	// 以下字段只在播放协程中使用
+	canvasSession *session.CanvasSession // vnc客户端当前画面，用于绘制播放信息
+	overlayLast   string                 // 已经发送给vnc客户端的播放信息
+	overlayBox    image.Rectangle        // 已经发送给vnc客户端的播放信息区域
+	transcode     bool                   // 是否已经跳转过，vnc客户端无法继续解码使用zlib流的编码
+}
+
+func newPlaybackController(player *Player) *PlaybackController {
+	return &PlaybackController{
+		player:     player,
+		speed:      1,
+		anchorWall: time.Now(),
+		hotkeys:    DefaultPlaybackHotkeys,
+		seekStep:   DefaultPlaybackSeekStep,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Pause 暂停播放
+func (that *PlaybackController) Pause() {
+	that.mu.Lock()
+	if !that.paused {
+		that.setClock(that.position())
+		that.paused = true
+	}
+	that.mu.Unlock()
+	that.notify()
+}
+
+// Resume 继续播放
+func (that *PlaybackController) Resume() {
+	that.mu.Lock()
+	if that.paused {
+		that.paused = false
+		that.setClock(that.anchorPos)
+	}
+	that.mu.Unlock()
+	that.notify()
+}
+
+// Paused 是否已经暂停
+func (that *PlaybackController) Paused() bool {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.paused
+}
+
+// Seek 跳转到录屏的指定时间，超出录屏时长则跳转到结尾
+func (that *PlaybackController) Seek(ts time.Duration) {
+	if ts < 0 {
+		ts = 0
+	}
+	if duration := that.Duration(); duration > 0 && ts > duration {
+		ts = duration
+	}
+	that.mu.Lock()
+	that.seekTo = ts
+	that.seeking = true
+	that.mu.Unlock()
+	that.notify()
+}
+
+// SetSpeed 设置播放速度，范围为 MinPlaybackSpeed 到 MaxPlaybackSpeed
+func (that *PlaybackController) SetSpeed(speed float64) error {
+	if speed < MinPlaybackSpeed || speed > MaxPlaybackSpeed {
+		return fmt.Errorf("播放速度必须在%v到%v之间", MinPlaybackSpeed, MaxPlaybackSpeed)
+	}
+	that.mu.Lock()
+	that.setClock(that.position())
+	that.speed = speed
+	that.mu.Unlock()
+	that.notify()
+	return nil
+}
+
+// Speed 当前的播放速度
+func (that *PlaybackController) Speed() float64 {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.speed
+}
+
+// SetIdleLimit 设置最长的空闲时间，录屏中超过该时长没有画面变化则跳过，为0表示不跳过
+func (that *PlaybackController) SetIdleLimit(limit time.Duration) {
+	that.mu.Lock()
+	that.idleLimit = limit
+	that.mu.Unlock()
+	that.notify()
+}
+
+// IdleLimit 最长的空闲时间
+func (that *PlaybackController) IdleLimit() time.Duration {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.idleLimit
+}
+
+// SetLoop 设置是否循环播放
+func (that *PlaybackController) SetLoop(loop bool) {
+	that.mu.Lock()
+	that.loop = loop
+	that.mu.Unlock()
+}
+
+// Loop 是否循环播放
+func (that *PlaybackController) Loop() bool {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.loop
+}
+
+// SetOverlayVisible 设置是否在画面左下角显示播放信息
+func (that *PlaybackController) SetOverlayVisible(visible bool) {
+	that.mu.Lock()
+	that.overlay = visible
+	that.mu.Unlock()
+	that.notify()
+}
+
+// OverlayVisible 是否显示播放信息
+func (that *PlaybackController) OverlayVisible() bool {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.overlay
+}
+
+// Skip 从当前位置前进d时长，d为负数则后退
+func (that *PlaybackController) Skip(d time.Duration) {
+	that.Seek(that.Position() + d)
+}
+
+// Restart 从头开始播放
+func (that *PlaybackController) Restart() {
+	that.Seek(0)
+	that.Resume()
+}
+
+// HandleKey 处理vnc客户端按下的按键，是快捷键则执行对应的操作并返回true
+func (that *PlaybackController) HandleKey(key rfb.Key) bool {
+	action, ok := that.hotkeys[key]
+	if !ok {
+		return false
+	}
+	switch action {
+	case ActionTogglePause:
+		if that.Paused() {
+			that.Resume()
+		} else {
+			that.Pause()
+		}
+	case ActionSeekBackward:
+		that.Skip(-that.seekStep)
+	case ActionSeekForward:
+		that.Skip(that.seekStep)
+	case ActionSpeedUp:
+		_ = that.SetSpeed(math.Min(that.Speed()*2, MaxPlaybackSpeed))
+	case ActionSpeedDown:
+		_ = that.SetSpeed(math.Max(that.Speed()/2, MinPlaybackSpeed))
+	case ActionRestart:
+		that.Restart()
+	case ActionToggleOverlay:
+		that.SetOverlayVisible(!that.OverlayVisible())
+	}
+	return true
+}
+
+// Position 当前播放到的录屏时间
+func (that *PlaybackController) Position() time.Duration {
+	that.mu.Lock()
+	pos := that.position()
+	that.mu.Unlock()
+	if duration := that.Duration(); duration > 0 && pos > duration {
+		pos = duration
+	}
+	return pos
+}
+
+// Duration 录屏时长，v1格式的录屏无法获取，返回0
+func (that *PlaybackController) Duration() time.Duration {
+	return that.player.playerSession.Duration()
+}
+
+// position 根据时钟计算当前的录屏时间，调用时需要持有锁
+func (that *PlaybackController) position() time.Duration {
+	if that.paused {
+		return that.anchorPos
+	}
+	return that.anchorPos + time.Duration(float64(that.clock().Sub(that.anchorWall))*that.speed)
+}
+
+// setClock 把时钟调整到指定的录屏时间，调用时需要持有锁
+func (that *PlaybackController) setClock(pos time.Duration) {
+	that.anchorPos = pos
+	that.anchorWall = that.clock()
+}
+
+// clock 获取当前时间
+func (that *PlaybackController) clock() time.Time {
+	if that.now != nil {
+		return that.now()
+	}
+	return time.Now()
+}
+
+// notify 唤醒播放协程
+func (that *PlaybackController) notify() {
+	select {
+	case that.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takeSeek 取出等待处理的跳转
+func (that *PlaybackController) takeSeek() (time.Duration, bool) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	seeking := that.seeking
+	that.seeking = false
+	return that.seekTo, seeking
+}
+
+// wait 计算发送ts时间的消息还需要等待的时长，暂停时返回true
+func (that *PlaybackController) wait(ts time.Duration) (time.Duration, bool) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if that.paused {
+		return 0, true
+	}
+	pos := that.position()
+	if that.idleLimit > 0 && ts-pos > that.idleLimit {
+		// 跳过空闲时间，只保留idleLimit
+		that.setClock(ts - that.idleLimit)
+		pos = that.anchorPos
+	}
+	return time.Duration(float64(ts-pos) / that.speed), false
+}
+
+// run 按照播放时钟把消息发送给vnc客户端
+func (that *PlaybackController) run() {
+	svrSession := that.player.svrSession
+	playerSession := that.player.playerSession
+	that.mu.Lock()
+	that.setClock(0)
+	that.mu.Unlock()
+	that.resetCanvas()
+	defer func() {
+		_ = that.canvasSession.Close()
+	}()
+	var pending rfb.Message
+	var pendingTs time.Duration
+	sent := false // 本轮播放是否发送过消息，没有消息的录屏不能循环播放
+	for that.player.closed.Val() == false {
+		if target, ok := that.takeSeek(); ok {
+			msg, ts, err := that.seek(target)
+			if err != nil {
+				playerSession.Options().ErrorCh <- err
+				return
+			}
+			pending, pendingTs = msg, ts
+			continue
+		}
+		if pending == nil {
+			msg, ts, err := playerSession.ReadMessage()
+			if err == io.EOF && sent && that.Loop() {
+				sent = false
+				that.Seek(0)
+				continue
+			}
+			if err != nil {
+				playerSession.Options().ErrorCh <- err
+				return
+			}
+			pending, pendingTs = msg, ts
+		}
+		if !that.refreshOverlay(false) {
+			return
+		}
+		wait, paused := that.wait(pendingTs)
+		if paused || wait > 0 {
+			if that.OverlayVisible() && (paused || wait > overlayRefresh) {
+				// 显示播放信息时需要定时刷新播放时间
+				paused, wait = false, overlayRefresh
+			}
+			var timer *time.Timer
+			var timeout <-chan time.Time
+			if !paused {
+				timer = time.NewTimer(wait)
+				timeout = timer.C
+			}
+			select {
+			case <-that.wake:
+			case <-timeout:
+			case <-svrSession.Wait():
+				return
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		}
+		if !that.sendRecorded(pending) {
+			return
+		}
+		pending = nil
+		sent = true
+	}
+}
+
+// seek 从ts之前最近的关键帧开始把消息绘制到画布上，然后把整个画布发送给vnc客户端
+// 返回ts之后的第一个消息及其时间，已经读取到录屏结尾则返回nil
+func (that *PlaybackController) seek(ts time.Duration) (rfb.Message, time.Duration, error) {
+	playerSession := that.player.playerSession
+	svrSession := that.player.svrSession
+	if _, err := playerSession.Seek(ts); err != nil {
+		return nil, 0, err
+	}
+	that.resetCanvas()
+	var cursor *rfb.Rectangle
+	var next rfb.Message
+	var nextTs time.Duration
+	for {
+		msg, msgTs, err := playerSession.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if msgTs > ts {
+			next, nextTs = msg, msgTs
+			break
+		}
+		if cm, ok := msg.(*messages.SetColorMapEntries); ok {
+			_ = cm.Write(that.canvasSession)
+			continue
+		}
+		fbu, ok := msg.(*messages.FramebufferUpdate)
+		if !ok {
+			continue
+		}
+		for _, rect := range fbu.Rects {
+			if rect.EncType == rfb.EncCursorPseudo {
+				cursor = rect.Clone()
+			}
+		}
+		if err = fbu.Write(that.canvasSession); err != nil {
+			logger.Warningf(context.TODO(), "跳转时绘制画布失败:%v", err)
+		}
+	}
+	update, err := that.canvasSession.RawUpdate(svrSession.Options().PixelFormat)
+	if err != nil {
+		return nil, 0, err
+	}
+	if cursor != nil {
+		update.Rects = append(update.Rects, cursor)
+		update.NumRect = uint16(len(update.Rects))
+	}
+	// 先调整时钟，vnc客户端收到画面时播放位置已经是跳转后的时间
+	that.mu.Lock()
+	that.setClock(ts)
+	that.mu.Unlock()
+	if !that.send(update) {
+		return nil, 0, io.EOF
+	}
+	// 整个画面都更新了，需要重新绘制播放信息
+	that.overlayBox = image.Rectangle{}
+	that.overlayLast = ""
+	// 跳转时重新读取了录屏，vnc客户端的zlib流与之后的消息不一致
+	that.transcode = true
+	return next, nextTs, nil
+}
+
+// resetCanvas 创建空白的画布，用于绘制vnc客户端的当前画面
+func (that *PlaybackController) resetCanvas() {
+	if that.canvasSession != nil {
+		_ = that.canvasSession.Close()
+	}
+	cfg := that.player.playerSession.Options()
+	that.canvasSession = session.NewCanvasSession(
+		rfb.OptPixelFormat(cfg.PixelFormat),
+		rfb.OptWidth(int(cfg.Width)),
+		rfb.OptHeight(int(cfg.Height)),
+	)
+	that.canvasSession.Start()
+	// 跳转时不会重新读取之前的颜色地图，使用已经读取到的颜色地图
+	that.canvasSession.SetColorMap(cfg.ColorMap)
+}
+
+// sendRecorded 发送录屏中的消息给vnc客户端，同时绘制到画布上，覆盖了播放信息则重新绘制播放信息
+func (that *PlaybackController) sendRecorded(msg rfb.Message) bool {
+	fbu, ok := msg.(*messages.FramebufferUpdate)
+	if !ok {
+		if cm, ok := msg.(*messages.SetColorMapEntries); ok {
+			_ = cm.Write(that.canvasSession)
+		}
+		return that.send(msg)
+	}
+	// 发送之后消息中的数据会被清空，先绘制到画布上
+	if err := fbu.Clone().Write(that.canvasSession); err != nil {
+		logger.Warningf(context.TODO(), "播放时绘制画布失败:%v", err)
+	}
+	if that.transcode {
+		update, err := that.transcodeUpdate(fbu)
+		if err != nil {
+			logger.Warningf(context.TODO(), "播放时重新编码帧缓冲更新失败:%v", err)
+		} else {
+			fbu = update
+		}
+	}
+	if !that.send(fbu) {
+		return false
+	}
+	for _, rect := range fbu.Rects {
+		if isPixelRect(rect) && canvas.MakeRectFromVncRect(rect).Overlaps(that.overlayBox) {
+			return that.refreshOverlay(true)
+		}
+	}
+	return true
+}
+
+// transcodeUpdate 帧缓冲更新中有使用zlib流编码的矩形时，把所有绘制像素的矩形替换为画布中对应区域的Raw编码，
+// 帧缓冲更新已经绘制到画布上，所以画布中的是这些矩形最终的画面，伪编码的矩形保持不变
+func (that *PlaybackController) transcodeUpdate(fbu *messages.FramebufferUpdate) (*messages.FramebufferUpdate, error) {
+	stateful := false
+	for _, rect := range fbu.Rects {
+		if rect.EncType.Stateful() {
+			stateful = true
+			break
+		}
+	}
+	if !stateful {
+		return fbu, nil
+	}
+	pf := that.player.svrSession.Options().PixelFormat
+	update := &messages.FramebufferUpdate{}
+	for _, rect := range fbu.Rects {
+		if !isPixelRect(rect) {
+			update.Rects = append(update.Rects, rect)
+			continue
+		}
+		raw, err := that.canvasSession.RawUpdate(pf, canvas.MakeRectFromVncRect(rect))
+		if err != nil {
+			return nil, err
+		}
+		update.Rects = append(update.Rects, raw.Rects...)
+	}
+	update.NumRect = uint16(len(update.Rects))
+	return update, nil
+}
+
+// isPixelRect 矩形是否绘制像素，TightPng的编码值虽然是负数，但不是伪编码
+func isPixelRect(rect *rfb.Rectangle) bool {
+	return rect.EncType >= 0 || rect.EncType == rfb.EncTightPng
+}
+
+// send 发送消息给vnc客户端，会话已经结束返回false
+func (that *PlaybackController) send(msg rfb.Message) bool {
+	svrSession := that.player.svrSession
+	select {
+	case svrSession.Options().Input <- msg:
+		return true
+	case <-svrSession.Wait():
+		return false
+	}
+}
@@ -0,0 +1,93 @@
+package vnc
+
+import (
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+	"strings"
+)
+
+// PlaybackAction 播放控制快捷键对应的操作
+type PlaybackAction uint8
+
+const (
+	ActionTogglePause   PlaybackAction = iota + 1 // 暂停或者继续播放
+	ActionSeekBackward                            // 后退
+	ActionSeekForward                             // 前进
+	ActionSpeedUp                                 // 加速
+	ActionSpeedDown                               // 减速
+	ActionRestart                                 // 从头播放
+	ActionToggleOverlay                           // 显示或者隐藏播放信息
+)
+
+// playbackActionNames 操作的名称，用于解析配置
+var playbackActionNames = map[PlaybackAction]string{
+	ActionTogglePause:   "pause",
+	ActionSeekBackward:  "backward",
+	ActionSeekForward:   "forward",
+	ActionSpeedUp:       "faster",
+	ActionSpeedDown:     "slower",
+	ActionRestart:       "restart",
+	ActionToggleOverlay: "overlay",
+}
+
+func (that PlaybackAction) String() string {
+	if name, ok := playbackActionNames[that]; ok {
+		return name
+	}
+	return fmt.Sprintf("PlaybackAction(%d)", uint8(that))
+}
+
+// PlaybackHotkeys 播放控制的快捷键
+type PlaybackHotkeys map[rfb.Key]PlaybackAction
+
+// DefaultPlaybackHotkeys 默认的快捷键，空格暂停，左右方向键后退及前进，加减号调整速度，Home键从头播放，o键显示或者隐藏播放信息
+var DefaultPlaybackHotkeys = PlaybackHotkeys{
+	rfb.Space:          ActionTogglePause,
+	rfb.Left:           ActionSeekBackward,
+	rfb.KeypadLeft:     ActionSeekBackward,
+	rfb.Right:          ActionSeekForward,
+	rfb.KeypadRight:    ActionSeekForward,
+	rfb.Plus:           ActionSpeedUp,
+	rfb.Equal:          ActionSpeedUp,
+	rfb.KeypadAdd:      ActionSpeedUp,
+	rfb.Minus:          ActionSpeedDown,
+	rfb.KeypadSubtract: ActionSpeedDown,
+	rfb.Home:           ActionRestart,
+	rfb.KeypadHome:     ActionRestart,
+	rfb.SmallO:         ActionToggleOverlay,
+}
+
+// ParsePlaybackHotkeys 解析快捷键配置，格式为 按键=操作，多个用逗号分隔，例如 "space=pause,left=backward,right=forward"
+// 按键名称参考 rfb.ParseKey ，操作为 pause、backward、forward、faster、slower、restart、overlay
+func ParsePlaybackHotkeys(s string) (PlaybackHotkeys, error) {
+	hotkeys := PlaybackHotkeys{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("快捷键配置格式错误:%s", item)
+		}
+		key, err := rfb.ParseKey(strings.TrimSpace(kv[0]))
+		if err != nil {
+			return nil, err
+		}
+		action, err := parsePlaybackAction(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, err
+		}
+		hotkeys[key] = action
+	}
+	return hotkeys, nil
+}
+
+func parsePlaybackAction(name string) (PlaybackAction, error) {
+	for action, n := range playbackActionNames {
+		if strings.EqualFold(n, name) {
+			return action, nil
+		}
+	}
+	return 0, fmt.Errorf("未知的播放操作:%s", name)
+}
@@ -0,0 +1,188 @@
+package vnc
+
+import (
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rfb"
+	"testing"
+	"time"
+)
+
+func TestParsePlaybackHotkeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    PlaybackHotkeys
+		wantErr bool
+	}{
+		{"多个快捷键", "space=pause, Left = backward,right=FORWARD",
+			PlaybackHotkeys{rfb.Space: ActionTogglePause, rfb.Left: ActionSeekBackward, rfb.Right: ActionSeekForward}, false},
+		{"单个字符的按键", "p=pause,P=overlay,+=faster,-=slower",
+			PlaybackHotkeys{rfb.SmallP: ActionTogglePause, rfb.P: ActionToggleOverlay, rfb.Plus: ActionSpeedUp, rfb.Minus: ActionSpeedDown}, false},
+		{"功能键及小键盘", "F5=restart,KeypadAdd=faster",
+			PlaybackHotkeys{rfb.F5: ActionRestart, rfb.KeypadAdd: ActionSpeedUp}, false},
+		{"忽略空的配置项", ",space=pause,, ,", PlaybackHotkeys{rfb.Space: ActionTogglePause}, false},
+		{"空字符串", "", PlaybackHotkeys{}, false},
+		{"同一个按键以最后一个为准", "space=pause,space=overlay", PlaybackHotkeys{rfb.Space: ActionToggleOverlay}, false},
+		{"缺少操作", "space", nil, true},
+		{"操作为空", "space=", nil, true},
+		{"按键为空", "=pause", nil, true},
+		{"按键名称错误", "spacebar=pause", nil, true},
+		{"操作名称错误", "space=stop", nil, true},
+		{"错误的配置在正确的之后", "space=pause,left=back", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hotkeys, err := ParsePlaybackHotkeys(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("解析结果为%v，期望返回错误:%v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if hotkeys != nil {
+					t.Fatalf("返回错误时快捷键为%v", hotkeys)
+				}
+				return
+			}
+			if len(hotkeys) != len(tt.want) {
+				t.Fatalf("快捷键为%v，应该为%v", hotkeys, tt.want)
+			}
+			for key, action := range tt.want {
+				if hotkeys[key] != action {
+					t.Fatalf("按键%v的操作为%v，应该为%v", key, hotkeys[key], action)
+				}
+			}
+		})
+	}
+}
+
+func TestPlaybackActionString(t *testing.T) {
+	// 每个操作的名称都能解析回来
+	for action, name := range playbackActionNames {
+		if action.String() != name {
+			t.Fatalf("操作%d的名称为%s", uint8(action), action.String())
+		}
+		if parsed, err := parsePlaybackAction(name); err != nil || parsed != action {
+			t.Fatalf("解析操作%s为%v %v", name, parsed, err)
+		}
+	}
+	if s := PlaybackAction(99).String(); s != "PlaybackAction(99)" {
+		t.Fatalf("未知的操作名称为%s", s)
+	}
+}
+
+// TestPlaybackHandleKey 默认快捷键对应的操作
+func TestPlaybackHandleKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *PlaybackController)
+		keys  []rfb.Key
+		check func(c *PlaybackController) bool
+	}{
+		{"空格暂停", nil, []rfb.Key{rfb.Space},
+			func(c *PlaybackController) bool { return c.Paused() }},
+		{"再按空格继续播放", nil, []rfb.Key{rfb.Space, rfb.Space},
+			func(c *PlaybackController) bool { return !c.Paused() }},
+		{"右方向键前进", nil, []rfb.Key{rfb.Right},
+			func(c *PlaybackController) bool {
+				ts, ok := c.takeSeek()
+				return ok && ts == 10*time.Second
+			}},
+		{"小键盘右方向键前进", func(c *PlaybackController) { c.setClock(5 * time.Second) }, []rfb.Key{rfb.KeypadRight},
+			func(c *PlaybackController) bool {
+				ts, ok := c.takeSeek()
+				return ok && ts == 15*time.Second
+			}},
+		{"左方向键后退", func(c *PlaybackController) { c.setClock(time.Minute) }, []rfb.Key{rfb.Left},
+			func(c *PlaybackController) bool {
+				ts, ok := c.takeSeek()
+				return ok && ts == 50*time.Second
+			}},
+		{"后退不超过开头", nil, []rfb.Key{rfb.KeypadLeft},
+			func(c *PlaybackController) bool {
+				ts, ok := c.takeSeek()
+				return ok && ts == 0
+			}},
+		{"加速", nil, []rfb.Key{rfb.Plus, rfb.Equal},
+			func(c *PlaybackController) bool { return c.Speed() == 4 }},
+		{"加速不超过最大速度", func(c *PlaybackController) { _ = c.SetSpeed(MaxPlaybackSpeed) }, []rfb.Key{rfb.KeypadAdd},
+			func(c *PlaybackController) bool { return c.Speed() == MaxPlaybackSpeed }},
+		{"减速不低于最小速度", nil, []rfb.Key{rfb.Minus, rfb.KeypadSubtract},
+			func(c *PlaybackController) bool { return c.Speed() == MinPlaybackSpeed }},
+		{"从头播放并继续", func(c *PlaybackController) { c.setClock(time.Minute); c.Pause() }, []rfb.Key{rfb.Home},
+			func(c *PlaybackController) bool {
+				ts, ok := c.takeSeek()
+				return ok && ts == 0 && !c.Paused()
+			}},
+		{"显示播放信息", nil, []rfb.Key{rfb.SmallO},
+			func(c *PlaybackController) bool { return c.OverlayVisible() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestPlayer(t, newPlaybackRecording()).controller
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+			for _, key := range tt.keys {
+				if !c.HandleKey(key) {
+					t.Fatalf("按键%v不是快捷键", key)
+				}
+			}
+			if !tt.check(c) {
+				t.Fatalf("按键之后的状态不正确，暂停:%v 速度:%v 播放信息:%v", c.Paused(), c.Speed(), c.OverlayVisible())
+			}
+		})
+	}
+
+	c := newTestPlayer(t, newPlaybackRecording()).controller
+	if c.HandleKey(rfb.SmallX) {
+		t.Fatal("不是快捷键的按键被处理")
+	}
+	// 不响应快捷键
+	c.hotkeys = nil
+	if c.HandleKey(rfb.Space) || c.Paused() {
+		t.Fatal("没有快捷键时按键被处理")
+	}
+	// 自定义的快捷键
+	c.hotkeys = PlaybackHotkeys{rfb.SmallP: ActionTogglePause}
+	if !c.HandleKey(rfb.SmallP) || !c.Paused() {
+		t.Fatal("自定义的快捷键没有生效")
+	}
+}
+
+// TestPlayerKeyDispatch vnc客户端按下的按键转换为播放操作，松开按键及其他消息不处理
+func TestPlayerKeyDispatch(t *testing.T) {
+	p := newTestPlayer(t, newPlaybackRecording())
+	c := p.controller
+	go p.handleIO()
+	// send 发送vnc客户端的消息，handleIO处理完上一个消息才会接收下一个
+	send := func(msg rfb.Message) {
+		t.Helper()
+		select {
+		case p.svrSession.Options().Output <- msg:
+		case <-time.After(5 * time.Second):
+			t.Fatal("发送vnc客户端的消息超时")
+		}
+	}
+	// barrier 发送一个不处理的消息，返回时之前的消息已经处理完
+	barrier := func() { send(&messages.ClientCutText{}) }
+
+	steps := []struct {
+		name  string
+		msg   rfb.Message
+		check func() bool
+	}{
+		{"松开按键不处理", &messages.KeyEvent{Down: 0, Key: rfb.Space}, func() bool { return !c.Paused() }},
+		{"按下空格暂停", &messages.KeyEvent{Down: 1, Key: rfb.Space}, func() bool { return c.Paused() }},
+		{"不是快捷键", &messages.KeyEvent{Down: 1, Key: rfb.SmallX}, func() bool { return c.Paused() }},
+		{"QEMU扩展按键事件", &messages.QEMUExtKeyEvent{DownFlag: 1, KeySym: rfb.Space}, func() bool { return !c.Paused() }},
+		{"QEMU扩展按键松开不处理", &messages.QEMUExtKeyEvent{DownFlag: 0, KeySym: rfb.Plus}, func() bool { return c.Speed() == 1 }},
+		{"加速", &messages.KeyEvent{Down: 1, Key: rfb.Plus}, func() bool { return c.Speed() == 2 }},
+		{"指针事件不处理", &messages.PointerEvent{Mask: 1}, func() bool { return c.Speed() == 2 && !c.Paused() }},
+	}
+	for _, s := range steps {
+		send(s.msg)
+		barrier()
+		if !s.check() {
+			t.Fatalf("%s之后暂停:%v 速度:%v", s.name, c.Paused(), c.Speed())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package vnc
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/encodings"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rfb"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+)
+
+const (
+	overlayMargin  = 8                      // 播放信息距离画面左下角的距离
+	overlayPadding = 4                      // 播放信息的内边距
+	overlayRefresh = 250 * time.Millisecond // 显示播放信息时的刷新间隔
+)
+
+var overlayBackground = image.NewUniform(color.RGBA{A: 0xb0})
+
+// overlayText 播放信息，包括播放状态、当前时间、录屏时长及播放速度
+func (that *PlaybackController) overlayText() string {
+	state := "PLAY"
+	if that.Paused() {
+		state = "PAUSE"
+	}
+	text := fmt.Sprintf("%s %s", state, formatPlaybackTime(that.Position()))
+	if duration := that.Duration(); duration > 0 {
+		text += " / " + formatPlaybackTime(duration)
+	}
+	text += fmt.Sprintf(" %gx", that.Speed())
+	if that.Loop() {
+		text += " LOOP"
+	}
+	return text
+}
+
+// overlayBox 播放信息在画面中的区域
+func overlayBox(bounds image.Rectangle, text string) image.Rectangle {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil() + 2*overlayPadding
+	height := face.Height + 2*overlayPadding
+	box := image.Rect(overlayMargin, bounds.Dy()-overlayMargin-height, overlayMargin+width, bounds.Dy()-overlayMargin)
+	return box.Intersect(bounds)
+}
+
+// refreshOverlay 播放信息有变化或者被帧缓冲更新覆盖时，重新绘制播放信息发送给vnc客户端
+// 隐藏播放信息时使用画布上的画面恢复原来的区域
+func (that *PlaybackController) refreshOverlay(force bool) bool {
+	if that.canvasSession == nil {
+		return true
+	}
+	var text string
+	var box image.Rectangle
+	if that.OverlayVisible() {
+		text = that.overlayText()
+		box = overlayBox(that.canvasSession.Canvas().Bounds(), text)
+	}
+	if !force && text == that.overlayLast && box == that.overlayBox {
+		return true
+	}
+	region := box.Union(that.overlayBox)
+	that.overlayLast, that.overlayBox = text, box
+	if region.Empty() {
+		return true
+	}
+	update, err := that.renderOverlay(region, box, text)
+	if err != nil {
+		that.player.playerSession.Options().ErrorCh <- err
+		return false
+	}
+	return that.send(update)
+}
+
+// renderOverlay 把画布上的区域及播放信息编码成Raw编码的帧缓冲更新
+func (that *PlaybackController) renderOverlay(region, box image.Rectangle, text string) (*messages.FramebufferUpdate, error) {
+	cv := that.canvasSession.Canvas()
+	img := image.NewRGBA(region)
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			r, g, b, _ := cv.At(x, y).RGBA()
+			img.SetRGBA(x, y, color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: 0xff})
+		}
+	}
+	if !box.Empty() {
+		draw.Draw(img, box, overlayBackground, image.Point{}, draw.Over)
+		d := &font.Drawer{
+			Dst:  img,
+			Src:  image.White,
+			Face: basicfont.Face7x13,
+			Dot:  fixed.P(box.Min.X+overlayPadding, box.Min.Y+overlayPadding+basicfont.Face7x13.Ascent),
+		}
+		d.DrawString(text)
+	}
+	pf := that.player.svrSession.Options().PixelFormat
+	buff := &bytes.Buffer{}
+	if err := (&canvas.VncCanvas{Image: img, ColorMap: cv.ColorMap}).EncodeRaw(buff, &pf, region); err != nil {
+		return nil, err
+	}
+	return &messages.FramebufferUpdate{
+		NumRect: 1,
+		Rects: []*rfb.Rectangle{{
+			X:       uint16(region.Min.X),
+			Y:       uint16(region.Min.Y),
+			Width:   uint16(region.Dx()),
+			Height:  uint16(region.Dy()),
+			EncType: rfb.EncRaw,
+			Enc:     encodings.NewRawEncoding(buff.Bytes()),
+		}},
+	}, nil
+}
+
+// formatPlaybackTime 格式化播放时间，超过1小时显示小时
+func formatPlaybackTime(d time.Duration) string {
+	sec := int(d / time.Second)
+	if sec >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", sec/3600, sec/60%60, sec%60)
+	}
+	return fmt.Sprintf("%02d:%02d", sec/60, sec%60)
+}
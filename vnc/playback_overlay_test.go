@@ -0,0 +1,152 @@
+package vnc
+
+import (
+	"bytes"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rfb"
+	"image"
+	"testing"
+	"time"
+)
+
+func TestFormatPlaybackTime(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "00:00"},
+		{1500 * time.Millisecond, "00:01"},
+		{75 * time.Second, "01:15"},
+		{59*time.Minute + 59*time.Second, "59:59"},
+		{time.Hour, "1:00:00"},
+		{26*time.Hour + 3*time.Minute + 4*time.Second, "26:03:04"},
+	}
+	for _, tt := range tests {
+		if got := formatPlaybackTime(tt.d); got != tt.want {
+			t.Fatalf("%v格式化为%s，应该为%s", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestPlaybackOverlayText(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *PlaybackController)
+		want  string
+	}{
+		{"播放中", func(c *PlaybackController) { c.setClock(75 * time.Second) }, "PLAY 01:15 1x"},
+		{"暂停", func(c *PlaybackController) { c.setClock(3 * time.Second); c.Pause() }, "PAUSE 00:03 1x"},
+		{"倍速", func(c *PlaybackController) { _ = c.SetSpeed(0.5) }, "PLAY 00:00 0.5x"},
+		{"循环播放", func(c *PlaybackController) { c.SetLoop(true) }, "PLAY 00:00 1x LOOP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestPlayer(t, newPlaybackRecording()).controller
+			tt.setup(c)
+			if got := c.overlayText(); got != tt.want {
+				t.Fatalf("播放信息为%q，应该为%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaybackOverlayBox(t *testing.T) {
+	box := overlayBox(image.Rect(0, 0, 640, 480), "PLAY 00:00 1x")
+	// 7x13的字体，13个字符，左下角距离边缘8个像素
+	want := image.Rect(overlayMargin, 480-overlayMargin-13-2*overlayPadding, overlayMargin+13*7+2*overlayPadding, 480-overlayMargin)
+	if box != want {
+		t.Fatalf("播放信息区域为%v，应该为%v", box, want)
+	}
+	// 画面太小时截取到画面内
+	if box = overlayBox(image.Rect(0, 0, 40, 20), "PLAY 00:00 1x"); box != image.Rect(overlayMargin, 0, 40, 12) {
+		t.Fatalf("小画面中播放信息区域为%v", box)
+	}
+}
+
+// TestPlaybackOverlayRefresh 显示播放信息时发送绘制了文字的区域，没有变化时不发送，隐藏时用画布恢复原来的画面
+func TestPlaybackOverlayRefresh(t *testing.T) {
+	var buf bytes.Buffer
+	writePlaybackHeader(&buf, 160, 40)
+	p := newTestPlayer(t, buf.Bytes())
+	c := p.controller
+	c.resetCanvas()
+	bounds := image.Rect(0, 0, 160, 40)
+
+	// refresh 在协程中刷新播放信息，返回发送给vnc客户端的帧缓冲更新，没有发送则返回nil
+	refresh := func(force bool) *messages.FramebufferUpdate {
+		t.Helper()
+		done := make(chan bool, 1)
+		go func() { done <- c.refreshOverlay(force) }()
+		select {
+		case msg := <-p.svrSession.Options().Input:
+			if ok := <-done; !ok {
+				t.Fatal("刷新播放信息失败")
+			}
+			fbu := msg.(*messages.FramebufferUpdate)
+			if err := fbu.Clone().Write(p.viewer); err != nil {
+				t.Fatal(err)
+			}
+			return fbu
+		case ok := <-done:
+			if !ok {
+				t.Fatal("刷新播放信息失败")
+			}
+			return nil
+		}
+	}
+	// whitePixels 区域内vnc客户端画面中白色像素的数量，画布是黑色的，白色的只有文字
+	whitePixels := func(r image.Rectangle) int {
+		n := 0
+		cv := p.viewer.Canvas()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if cr, cg, cb, _ := cv.At(x, y).RGBA(); cr>>8 == 0xff && cg>>8 == 0xff && cb>>8 == 0xff {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	if fbu := refresh(false); fbu != nil {
+		t.Fatal("隐藏播放信息时发送了帧缓冲更新")
+	}
+
+	c.SetOverlayVisible(true)
+	fbu := refresh(false)
+	box := overlayBox(bounds, "PLAY 00:00 1x")
+	if fbu == nil || len(fbu.Rects) != 1 || fbu.Rects[0].EncType != rfb.EncRaw {
+		t.Fatalf("显示播放信息时发送的帧缓冲更新为%v", fbu)
+	}
+	if r := fbu.Rects[0]; int(r.X) != box.Min.X || int(r.Y) != box.Min.Y || int(r.Width) != box.Dx() || int(r.Height) != box.Dy() {
+		t.Fatalf("播放信息的区域为%v，应该为%v", r, box)
+	}
+	if whitePixels(box) == 0 {
+		t.Fatal("播放信息区域没有文字")
+	}
+
+	// 没有变化时不发送，强制刷新时重新发送
+	if fbu = refresh(false); fbu != nil {
+		t.Fatal("播放信息没有变化时发送了帧缓冲更新")
+	}
+	if fbu = refresh(true); fbu == nil {
+		t.Fatal("强制刷新时没有发送帧缓冲更新")
+	}
+
+	// 文字变长时发送新旧区域的并集
+	c.SetLoop(true)
+	fbu = refresh(false)
+	longer := overlayBox(bounds, "PLAY 00:00 1x LOOP")
+	if fbu == nil || int(fbu.Rects[0].Width) != longer.Dx() || longer.Dx() <= box.Dx() {
+		t.Fatalf("播放信息变长时发送的帧缓冲更新为%v", fbu)
+	}
+
+	// 隐藏时恢复画布上的画面
+	c.SetOverlayVisible(false)
+	if fbu = refresh(false); fbu == nil || int(fbu.Rects[0].Width) != longer.Dx() {
+		t.Fatalf("隐藏播放信息时发送的帧缓冲更新为%v", fbu)
+	}
+	if n := whitePixels(bounds); n != 0 {
+		t.Fatalf("隐藏播放信息后还有%d个白色像素", n)
+	}
+}
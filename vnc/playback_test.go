@@ -0,0 +1,403 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"image/color"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// playbackClock 测试使用的播放时钟，只有调用advance时才前进
+type playbackClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (that *playbackClock) now() time.Time {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.t
+}
+
+func (that *playbackClock) advance(d time.Duration) {
+	that.mu.Lock()
+	that.t = that.t.Add(d)
+	that.mu.Unlock()
+}
+
+// seekConn 把内存中的录屏包装成支持跳转的连接
+type seekConn struct {
+	*bytes.Reader
+}
+
+func (seekConn) Write(buf []byte) (int, error) { return len(buf), nil }
+func (seekConn) Close() error                  { return nil }
+
+// 测试录屏的画面大小及颜色
+var (
+	testPlaybackRed   = color.RGBA{R: 255, A: 1}
+	testPlaybackGreen = color.RGBA{G: 255, A: 1}
+	testPlaybackBlue  = color.RGBA{B: 255, A: 1}
+)
+
+const testPlaybackWidth, testPlaybackHeight = 4, 2
+
+// newPlaybackRecording 生成v1格式的测试录屏，每秒一个消息：
+// 0s 整个画面为红色的Raw编码，1s 左上角2x1为绿色的Zlib编码，2s 右上角2x1为蓝色的Zlib编码，3s 响铃。
+// 两个Zlib编码的矩形使用同一个持续的zlib流，第二个矩形不能单独解码。
+func newPlaybackRecording() []byte {
+	var buf bytes.Buffer
+	writePlaybackHeader(&buf, testPlaybackWidth, testPlaybackHeight)
+
+	// pixels 32位像素格式下指定颜色的n个像素
+	pixels := func(c color.RGBA, n int) []byte {
+		return bytes.Repeat([]byte{c.B, c.G, c.R, 0}, n)
+	}
+	// update 只有一个矩形的帧缓冲更新，之后是等待1秒
+	update := func(x, y, w, h uint16, enc rfb.EncodingType, data []byte) {
+		_ = binary.Write(&buf, binary.BigEndian, [2]uint8{uint8(rfb.FramebufferUpdate), 0})
+		_ = binary.Write(&buf, binary.BigEndian, uint16(1))
+		_ = binary.Write(&buf, binary.BigEndian, [4]uint16{x, y, w, h})
+		_ = binary.Write(&buf, binary.BigEndian, enc)
+		buf.Write(data)
+		_ = binary.Write(&buf, binary.BigEndian, int64(time.Second))
+	}
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	// zlibRect 压缩的数据追加到同一个zlib流中，返回4字节长度及本次压缩的数据
+	zlibRect := func(data []byte) []byte {
+		zbuf.Reset()
+		_, _ = zw.Write(data)
+		_ = zw.Flush()
+		out := binary.BigEndian.AppendUint32(nil, uint32(zbuf.Len()))
+		return append(out, zbuf.Bytes()...)
+	}
+
+	update(0, 0, testPlaybackWidth, testPlaybackHeight, rfb.EncRaw, pixels(testPlaybackRed, testPlaybackWidth*testPlaybackHeight))
+	update(0, 0, 2, 1, rfb.EncZlib, zlibRect(pixels(testPlaybackGreen, 2)))
+	update(2, 0, 2, 1, rfb.EncZlib, zlibRect(pixels(testPlaybackBlue, 2)))
+	buf.WriteByte(byte(rfb.Bell))
+	return buf.Bytes()
+}
+
+// writePlaybackHeader 写入v1格式录屏的文件头，画面大小为width x height
+func writePlaybackHeader(buf *bytes.Buffer, width, height uint16) {
+	buf.WriteString(rbs.Version1)
+	buf.WriteString(rfb.ProtoVersion38)
+	_ = binary.Write(buf, binary.BigEndian, int32(rfb.SecTypeNone))
+	_ = binary.Write(buf, binary.BigEndian, [2]uint16{width, height})
+	_ = binary.Write(buf, binary.BigEndian, rfb.PixelFormat32bit)
+	_ = binary.Write(buf, binary.BigEndian, uint32(4))
+	buf.WriteString("play")
+}
+
+// testPlayer 使用测试时钟播放内存中录屏的播放器，发送给vnc客户端的帧缓冲更新绘制到viewer画布上
+type testPlayer struct {
+	*Player
+	clock  *playbackClock
+	viewer *session.CanvasSession
+}
+
+func newTestPlayer(t *testing.T, data []byte) *testPlayer {
+	svrSession := session.NewServerSession()
+	svrSession.SetPixelFormat(rfb.PixelFormat32bit)
+	that := &testPlayer{
+		Player: NewPlayer("", svrSession),
+		clock:  &playbackClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	that.playerSession = session.NewPlayerSession(rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) {
+		return seekConn{bytes.NewReader(data)}, nil
+	}))
+	that.playerSession.Start()
+	that.viewer = session.NewCanvasSession(
+		rfb.OptPixelFormat(rfb.PixelFormat32bit),
+		rfb.OptWidth(int(that.playerSession.Options().Width)),
+		rfb.OptHeight(int(that.playerSession.Options().Height)),
+	)
+	that.controller.now = that.clock.now
+	that.controller.setClock(0)
+	that.viewer.Start()
+	t.Cleanup(func() {
+		that.Close()
+		_ = that.viewer.Close()
+	})
+	return that
+}
+
+// next 获取发送给vnc客户端的下一条消息，帧缓冲更新会绘制到viewer画布上
+func (that *testPlayer) next(t *testing.T) rfb.Message {
+	t.Helper()
+	select {
+	case msg := <-that.svrSession.Options().Input:
+		if fbu, ok := msg.(*messages.FramebufferUpdate); ok {
+			if err := fbu.Clone().Write(that.viewer); err != nil {
+				t.Fatalf("vnc客户端解码帧缓冲更新失败:%v", err)
+			}
+		}
+		return msg
+	case err := <-that.playerSession.Options().ErrorCh:
+		t.Fatalf("播放结束:%v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有发送消息给vnc客户端")
+	}
+	return nil
+}
+
+// idle 播放时钟没有到下一个消息的时间，不应该发送消息
+func (that *testPlayer) idle(t *testing.T) {
+	t.Helper()
+	select {
+	case msg := <-that.svrSession.Options().Input:
+		t.Fatalf("提前发送了消息%v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// advance 播放时钟前进d并唤醒播放协程
+func (that *testPlayer) advance(d time.Duration) {
+	that.clock.advance(d)
+	that.controller.notify()
+}
+
+// expectUpdate 下一条消息是帧缓冲更新，矩形的编码依次为encs
+func (that *testPlayer) expectUpdate(t *testing.T, encs ...rfb.EncodingType) {
+	t.Helper()
+	fbu, ok := that.next(t).(*messages.FramebufferUpdate)
+	if !ok {
+		t.Fatal("发送的消息不是帧缓冲更新")
+	}
+	if len(fbu.Rects) != len(encs) {
+		t.Fatalf("帧缓冲更新有%d个矩形，应该为%d个", len(fbu.Rects), len(encs))
+	}
+	for i, enc := range encs {
+		if fbu.Rects[i].EncType != enc {
+			t.Fatalf("第%d个矩形的编码为%v，应该为%v", i, fbu.Rects[i].EncType, enc)
+		}
+	}
+}
+
+// expectBell 下一条消息是响铃
+func (that *testPlayer) expectBell(t *testing.T) {
+	t.Helper()
+	if msg := that.next(t); msg.Type() != rfb.MessageType(rfb.Bell) {
+		t.Fatalf("发送的消息为%v，应该为响铃", msg)
+	}
+}
+
+// expectScreen vnc客户端的画面第一行依次为colors，第二行为红色
+func (that *testPlayer) expectScreen(t *testing.T, colors ...color.RGBA) {
+	t.Helper()
+	cv := that.viewer.Conn().(*canvas.VncCanvas)
+	for x := 0; x < testPlaybackWidth; x++ {
+		for y, want := range []color.RGBA{colors[x], testPlaybackRed} {
+			if got := color.RGBAModel.Convert(cv.At(x, y)).(color.RGBA); got.R != want.R || got.G != want.G || got.B != want.B {
+				t.Fatalf("vnc客户端画面(%d,%d)的颜色为%v，应该为%v", x, y, got, want)
+			}
+		}
+	}
+}
+
+// TestPlaybackClock 暂停、倍速及跳过空闲时间对播放时钟的影响
+func TestPlaybackClock(t *testing.T) {
+	p := newTestPlayer(t, newPlaybackRecording())
+	c := p.controller
+	steps := []struct {
+		name    string
+		do      func()
+		advance time.Duration
+		pos     time.Duration // 之后的播放位置
+	}{
+		{"正常播放", func() {}, time.Second, time.Second},
+		{"2倍速", func() { _ = c.SetSpeed(2) }, time.Second, 3 * time.Second},
+		{"暂停后时钟不前进", c.Pause, 5 * time.Second, 3 * time.Second},
+		{"重复暂停", c.Pause, time.Second, 3 * time.Second},
+		{"继续播放", c.Resume, 500 * time.Millisecond, 4 * time.Second},
+		{"0.5倍速", func() { _ = c.SetSpeed(0.5) }, 2 * time.Second, 5 * time.Second},
+		{"跳转", func() { c.Seek(10 * time.Second) }, 0, 5 * time.Second},
+	}
+	for _, s := range steps {
+		s.do()
+		p.clock.advance(s.advance)
+		if pos := c.Position(); pos != s.pos {
+			t.Fatalf("%s之后播放位置为%v，应该为%v", s.name, pos, s.pos)
+		}
+	}
+	// 跳转由播放协程处理，只记录跳转的时间
+	if ts, ok := c.takeSeek(); !ok || ts != 10*time.Second {
+		t.Fatalf("等待处理的跳转为%v %v", ts, ok)
+	}
+	if _, ok := c.takeSeek(); ok {
+		t.Fatal("跳转被重复处理")
+	}
+	c.Seek(-time.Second)
+	if ts, _ := c.takeSeek(); ts != 0 {
+		t.Fatalf("跳转到负数时间变为%v", ts)
+	}
+
+	for _, speed := range []float64{0, MinPlaybackSpeed / 2, MaxPlaybackSpeed * 2} {
+		if err := c.SetSpeed(speed); err == nil {
+			t.Fatalf("播放速度%v没有返回错误", speed)
+		}
+	}
+	if c.Speed() != 0.5 {
+		t.Fatalf("设置失败后播放速度变为%v", c.Speed())
+	}
+
+	// 等待时长按播放速度换算，暂停时不等待
+	if wait, paused := c.wait(6 * time.Second); paused || wait != 2*time.Second {
+		t.Fatalf("等待时长为%v %v", wait, paused)
+	}
+	c.Pause()
+	if _, paused := c.wait(6 * time.Second); !paused {
+		t.Fatal("暂停时没有返回暂停")
+	}
+	c.Resume()
+
+	// 超过空闲时间的部分被跳过，播放位置直接前进
+	c.SetIdleLimit(time.Second)
+	if wait, _ := c.wait(6 * time.Second); wait != 2*time.Second {
+		t.Fatalf("没有超过空闲时间时等待%v", wait)
+	}
+	if wait, _ := c.wait(time.Minute); wait != 2*time.Second {
+		t.Fatalf("超过空闲时间时等待%v", wait)
+	}
+	if pos := c.Position(); pos != time.Minute-time.Second {
+		t.Fatalf("跳过空闲时间后播放位置为%v", pos)
+	}
+}
+
+// TestPlaybackRun 按播放时钟发送消息，暂停时不发送，倍速时提前发送，没有跳转时原样转发录屏中的编码
+func TestPlaybackRun(t *testing.T) {
+	p := newTestPlayer(t, newPlaybackRecording())
+	c := p.controller
+	go c.run()
+
+	p.expectUpdate(t, rfb.EncRaw)
+	p.idle(t)
+	p.advance(time.Second)
+	p.expectUpdate(t, rfb.EncZlib)
+	p.expectScreen(t, testPlaybackGreen, testPlaybackGreen, testPlaybackRed, testPlaybackRed)
+
+	c.Pause()
+	p.advance(5 * time.Second)
+	p.idle(t)
+	c.Resume()
+	p.idle(t)
+	p.advance(time.Second)
+	p.expectUpdate(t, rfb.EncZlib)
+	p.expectScreen(t, testPlaybackGreen, testPlaybackGreen, testPlaybackBlue, testPlaybackBlue)
+
+	if err := c.SetSpeed(4); err != nil {
+		t.Fatal(err)
+	}
+	p.advance(200 * time.Millisecond)
+	p.idle(t)
+	p.advance(50 * time.Millisecond)
+	p.expectBell(t)
+
+	// 不循环播放时读取到结尾结束播放
+	select {
+	case err := <-p.playerSession.Options().ErrorCh:
+		if !errors.Is(err, io.EOF) {
+			t.Fatalf("播放结束的原因为%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("读取到结尾后没有结束播放")
+	}
+}
+
+// TestPlaybackIdle 超过空闲时间没有消息时跳过空闲部分
+func TestPlaybackIdle(t *testing.T) {
+	p := newTestPlayer(t, newPlaybackRecording())
+	c := p.controller
+	c.SetIdleLimit(100 * time.Millisecond)
+	go c.run()
+
+	p.expectUpdate(t, rfb.EncRaw)
+	p.idle(t)
+	p.advance(100 * time.Millisecond)
+	p.expectUpdate(t, rfb.EncZlib)
+	p.advance(100 * time.Millisecond)
+	p.expectUpdate(t, rfb.EncZlib)
+	// 等待下一个消息时播放位置直接前进到响铃之前的100ms
+	p.idle(t)
+	if pos := c.Position(); pos != 2900*time.Millisecond {
+		t.Fatalf("跳过空闲时间后播放位置为%v", pos)
+	}
+}
+
+// TestPlaybackSeek 跳转后发送整个画布，之后使用zlib流的矩形从画布重新编码为Raw，vnc客户端的画面始终正确
+func TestPlaybackSeek(t *testing.T) {
+	p := newTestPlayer(t, newPlaybackRecording())
+	c := p.controller
+	go c.run()
+
+	p.expectUpdate(t, rfb.EncRaw)
+	p.advance(time.Second)
+	p.expectUpdate(t, rfb.EncZlib)
+	p.advance(time.Second)
+	p.expectUpdate(t, rfb.EncZlib)
+	p.expectScreen(t, testPlaybackGreen, testPlaybackGreen, testPlaybackBlue, testPlaybackBlue)
+
+	// 向后跳转，vnc客户端已经解码过第二个Zlib矩形，再次发送录屏中的数据无法解码
+	c.Seek(1500 * time.Millisecond)
+	p.expectUpdate(t, rfb.EncRaw)
+	p.expectScreen(t, testPlaybackGreen, testPlaybackGreen, testPlaybackRed, testPlaybackRed)
+	if pos := c.Position(); pos != 1500*time.Millisecond {
+		t.Fatalf("跳转后播放位置为%v", pos)
+	}
+	p.idle(t)
+	p.advance(500 * time.Millisecond)
+	p.expectUpdate(t, rfb.EncRaw)
+	p.expectScreen(t, testPlaybackGreen, testPlaybackGreen, testPlaybackBlue, testPlaybackBlue)
+
+	// 跳转到开头
+	c.Seek(0)
+	p.expectUpdate(t, rfb.EncRaw)
+	p.expectScreen(t, testPlaybackRed, testPlaybackRed, testPlaybackRed, testPlaybackRed)
+	p.advance(time.Second)
+	p.expectUpdate(t, rfb.EncRaw)
+	p.expectScreen(t, testPlaybackGreen, testPlaybackGreen, testPlaybackRed, testPlaybackRed)
+}
+
+// TestPlaybackLoop 循环播放时读取到结尾后从头开始，之后的zlib流矩形同样重新编码
+func TestPlaybackLoop(t *testing.T) {
+	p := newTestPlayer(t, newPlaybackRecording())
+	c := p.controller
+	c.SetLoop(true)
+	go c.run()
+
+	p.expectUpdate(t, rfb.EncRaw)
+	for i := 0; i < 2; i++ {
+		p.advance(time.Second)
+		p.expectUpdate(t, rfb.EncZlib)
+	}
+	p.advance(time.Second)
+	p.expectBell(t)
+
+	for i := 0; i < 2; i++ {
+		// 从头开始时先发送第一个消息绘制的画布
+		p.expectUpdate(t, rfb.EncRaw)
+		p.expectScreen(t, testPlaybackRed, testPlaybackRed, testPlaybackRed, testPlaybackRed)
+		if pos := c.Position(); pos != 0 {
+			t.Fatalf("第%d次循环的播放位置为%v", i+1, pos)
+		}
+		p.advance(time.Second)
+		p.expectUpdate(t, rfb.EncRaw)
+		p.advance(time.Second)
+		p.expectUpdate(t, rfb.EncRaw)
+		p.expectScreen(t, testPlaybackGreen, testPlaybackGreen, testPlaybackBlue, testPlaybackBlue)
+		p.advance(time.Second)
+		p.expectBell(t)
+	}
+}
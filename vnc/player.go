@@ -2,45 +2,49 @@ package vnc
 
 import (
 	"context"
-	"encoding/binary"
-	"fmt"
 	"github.com/gogf/gf/v2/container/gtype"
-	"github.com/gogf/gf/v2/os/gfile"
 	"github.com/osgochina/dmicro/logger"
 	"github.com/vprix/vncproxy/handler"
 	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
 	"github.com/vprix/vncproxy/rfb"
 	"github.com/vprix/vncproxy/session"
 	"io"
-	"os"
 	"sync"
-	"time"
 )
 
 type Player struct {
 	svrSession    *session.ServerSession // vnc客户端连接到proxy的会话
 	playerSession *session.PlayerSession
+	controller    *PlaybackController
 	errorCh       chan error
 	closed        *gtype.Bool
 	syncOnce      sync.Once
+	fileOptions   []rbs.OpenOption // 打开录屏文件的配置
 }
 
-func NewPlayer(filePath string, svrSession *session.ServerSession) *Player {
-	playerSession := session.NewPlayerSession(
+func NewPlayer(filePath string, svrSession *session.ServerSession, opts ...PlayerOption) *Player {
+	player := &Player{
+		errorCh:    make(chan error, 32),
+		svrSession: svrSession,
+		closed:     gtype.NewBool(false),
+	}
+	player.playerSession = session.NewPlayerSession(
 		rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
-			if !gfile.Exists(filePath) {
-				return nil, fmt.Errorf("要读取的文件[%s]不存在", filePath)
-			}
-			return gfile.OpenFile(filePath, os.O_RDONLY, 0644)
+			// 自动解密、解压录屏，并按顺序读取所有分段
+			return rbs.OpenFile(filePath, player.fileOptions...)
 		}),
 	)
-
-	return &Player{
-		errorCh:       make(chan error, 32),
-		svrSession:    svrSession,
-		playerSession: playerSession,
-		closed:        gtype.NewBool(false),
+	player.controller = newPlaybackController(player)
+	for _, o := range opts {
+		o(player)
 	}
+	return player
+}
+
+// Controller 播放控制器
+func (that *Player) Controller() *PlaybackController {
+	return that.controller
 }
 
 // Start 启动
@@ -93,39 +97,25 @@ func (that *Player) handleIO() {
 			if logger.IsDebug() {
 				logger.Debugf(context.TODO(), "收到vnc客户端发送过来的消息,%s", msg)
 			}
-			if msg.Type() == rfb.MessageType(rfb.FramebufferUpdateRequest) {
+			switch m := msg.(type) {
+			case *messages.FramebufferUpdateRequest:
 				that.syncOnce.Do(func() {
-					go that.readRbs()
+					go that.controller.run()
 				})
+			case *messages.KeyEvent:
+				// 录屏不能操作，按键只用于控制播放
+				if m.Down == 1 {
+					that.controller.HandleKey(m.Key)
+				}
+			case *messages.QEMUExtKeyEvent:
+				if m.DownFlag == 1 {
+					that.controller.HandleKey(m.KeySym)
+				}
 			}
 		}
 	}
 }
 
-func (that *Player) readRbs() {
-	for that.closed.Val() == false {
-		// 从会话中读取消息类型
-		var messageType rfb.ServerMessageType
-		if err := binary.Read(that.playerSession, binary.BigEndian, &messageType); err != nil {
-			that.playerSession.Options().ErrorCh <- err
-			return
-		}
-		msg := &messages.FramebufferUpdate{}
-		// 读取消息内容
-		parsedMsg, err := msg.Read(that.playerSession)
-		if err != nil {
-			that.playerSession.Options().ErrorCh <- err
-			return
-		}
-		that.svrSession.Options().Input <- parsedMsg
-		var sleep int64
-		_ = binary.Read(that.playerSession, binary.BigEndian, &sleep)
-		if sleep > 0 {
-			time.Sleep(time.Duration(sleep))
-		}
-	}
-}
-
 func (that *Player) Close() {
 	that.closed.Set(true)
 	_ = that.svrSession.Close()
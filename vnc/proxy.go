@@ -1,29 +1,124 @@
 package vnc
 
 import (
+	"context"
+	"fmt"
 	"github.com/gogf/gf/v2/container/gtype"
 	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/osgochina/dmicro/logger"
 	"github.com/vprix/vncproxy/handler"
 	"github.com/vprix/vncproxy/messages"
 	"github.com/vprix/vncproxy/rfb"
 	"github.com/vprix/vncproxy/session"
+	"net"
 )
 
+// viewOnlyClientMessageTypes 只读模式下丢弃的vnc客户端消息，这些消息会操作vnc服务端
+var viewOnlyClientMessageTypes = []rfb.ClientMessageType{
+	rfb.KeyEvent,
+	rfb.PointerEvent,
+	rfb.ClientCutText,
+	rfb.SetDesktopSize,
+	rfb.QEMUExtendedKeyEvent,
+}
+
 type Proxy struct {
 	remoteSession rfb.ISession // 链接到vnc远端服务的会话
 	svrSession    rfb.ISession // vnc客户端连接到proxy的会话
 	errorCh       chan error
 	closed        *gtype.Bool
+	running       *gtype.Bool // 是否已经进入消息转发阶段
+	viewOnly      *gtype.Bool // 是否只读，只读模式下丢弃vnc客户端的键盘鼠标等操作消息
+	ctx           context.Context
+	cancel        context.CancelFunc
+	interceptors  []Interceptor // 消息拦截器
+	targetAddr    string        // 连接的vnc服务端地址
+
+	recorderFactory RecorderFactory // 录屏会话工厂，为nil则不录屏
+	tap             *recordingTap   // 正在进行的录屏
+	recordStateless bool            // 录屏时是否只协商无状态的编码
+
+	target        *rfb.TargetConfig  // 固定的vnc服务端配置，在握手阶段结合vnc客户端的身份创建远端会话
+	resolver      rfb.TargetResolver // 目标解析器，未传入远端会话的时候，通过令牌动态解析要连接的vnc服务端
+	token         string             // 目标令牌，为空则使用安全认证阶段存入会话交换区的令牌
+	remoteOptions []rfb.Option       // 动态创建远端会话时附加的配置
+
+	hub       *Hub           // 共享会话中心，设置后连接同一个vnc服务端的vnc客户端共享同一个连接
+	sharedKey string         // 共享会话的key，为空则使用vnc服务端的地址
+	shared    *SharedSession // 加入的共享会话
+	viewer    *sharedViewer  // 在共享会话中的vnc客户端
+
+	remoteInput   chan<- rfb.Message // 发送给vnc服务端的消息
+	remoteOutput  <-chan rfb.Message // vnc服务端发送过来的消息
+	remoteErrorCh <-chan error       // 与vnc服务端连接的错误
+}
+
+type ProxyOption func(*Proxy)
+
+// OptViewOnly 设置只读模式，vnc客户端只能查看不能操作
+func OptViewOnly(viewOnly bool) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.viewOnly.Set(viewOnly)
+	}
+}
+
+// OptTarget 设置要连接的vnc服务端，远端会话在vnc客户端通过认证后创建，
+// 认证器给出的vnc服务端凭证会覆盖配置中的凭证。同时设置了目标解析器时，能获取到令牌则优先使用解析结果。
+func OptTarget(targetCfg rfb.TargetConfig) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.target = &targetCfg
+	}
+}
+
+// OptTargetResolver 设置目标解析器
+func OptTargetResolver(resolver rfb.TargetResolver) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.resolver = resolver
+	}
+}
+
+// OptTargetToken 设置目标令牌，例如websocket连接url中携带的令牌
+func OptTargetToken(token string) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.token = token
+	}
+}
+
+// OptRemoteOptions 设置动态创建远端会话时附加的配置
+func OptRemoteOptions(opts ...rfb.Option) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.remoteOptions = append(proxy.remoteOptions, opts...)
+	}
+}
+
+// OptSharedHub 设置共享会话中心，连接到同一个vnc服务端的vnc客户端共享同一个连接，
+// key为共享会话的标识，为空则使用vnc服务端的地址作为标识
+func OptSharedHub(hub *Hub, key ...string) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.hub = hub
+		if len(key) > 0 {
+			proxy.sharedKey = key[0]
+		}
+	}
 }
 
 // NewVncProxy 生成vnc proxy服务对象
-func NewVncProxy(remoteSession *session.ClientSession, serverSession *session.ServerSession) *Proxy {
+// 如果remoteSession为nil，则需要通过OptTarget或OptTargetResolver设置vnc服务端，在握手阶段动态连接vnc服务端
+func NewVncProxy(remoteSession *session.ClientSession, serverSession *session.ServerSession, opts ...ProxyOption) *Proxy {
 	vncProxy := &Proxy{
-		svrSession:    serverSession,
-		remoteSession: remoteSession,
+		svrSession: serverSession,
 		// 这里选择8是随便选的,后期应该会改
-		errorCh: make(chan error, 8),
-		closed:  gtype.NewBool(false),
+		errorCh:  make(chan error, 8),
+		closed:   gtype.NewBool(false),
+		running:  gtype.NewBool(false),
+		viewOnly: gtype.NewBool(false),
+	}
+	vncProxy.ctx, vncProxy.cancel = context.WithCancel(context.WithValue(context.Background(), proxyCtxKey{}, vncProxy))
+	if remoteSession != nil {
+		vncProxy.remoteSession = remoteSession
+	}
+	for _, opt := range opts {
+		opt(vncProxy)
 	}
 	return vncProxy
 }
@@ -34,8 +129,8 @@ func (that *Proxy) Start() error {
 	hds := []rfb.IHandler{
 		&handler.ServerVersionHandler{},
 		&handler.ServerSecurityHandler{},
-		that, // 把链接到vnc服务端的逻辑加入
 		&handler.ServerClientInitHandler{},
+		that, // 把链接到vnc服务端的逻辑加入
 		&handler.ServerServerInitHandler{},
 		&handler.ServerMessageHandler{},
 	}
@@ -46,6 +141,16 @@ func (that *Proxy) Start() error {
 		return err
 	}
 	that.svrSession.Start()
+	if !that.running.Val() {
+		// 握手阶段失败，消息转发协程未启动，直接从会话的错误通道中获取错误原因
+		select {
+		case err = <-that.svrSession.Options().ErrorCh:
+		default:
+			err = fmt.Errorf("vnc握手失败")
+		}
+		that.Close()
+		return err
+	}
 	err = <-that.errorCh
 	return err
 }
@@ -53,36 +158,48 @@ func (that *Proxy) Start() error {
 func (that *Proxy) handleIO() {
 	for that.closed.Val() == false {
 		select {
-		case msg := <-that.remoteSession.Options().ErrorCh:
+		case err := <-that.remoteErrorCh:
 			// 如果链接到vnc服务端的会话报错，则需要把链接到proxy的vnc客户端全部关闭
-			that.errorCh <- msg
-			_ = that.svrSession.Close()
-		case msg := <-that.svrSession.Options().ErrorCh:
+			that.errorCh <- err
+			that.Close()
+			return
+		case err := <-that.svrSession.Options().ErrorCh:
 			//  链接到proxy的vnc客户端链接报错，则把错误转发给vnc proxy
-			that.errorCh <- msg
-			_ = that.remoteSession.Close()
-		case msg := <-that.remoteSession.Options().Output:
+			that.errorCh <- err
+			that.Close()
+			return
+		case msg := <-that.remoteOutput:
 			// 收到vnc服务端发送给proxy客户端的消息，转发给proxy服务端, proxy服务端内部会把该消息转发给vnc客户端
-			sSessCfg := that.svrSession.Options()
-			disabled := false
-			// 如果该消息禁用，则跳过不转发该消息
-			for _, t := range sSessCfg.DisableServerMessageType {
-				if rfb.MessageType(t) == msg.Type() {
-					disabled = true
-					break
-				}
-			}
-			if !disabled {
-				sSessCfg.Input <- msg
+			if err := that.forwardServerMessage(msg); err != nil {
+				that.errorCh <- err
+				that.Close()
+				return
 			}
 		case msg := <-that.svrSession.Options().Output:
 			// vnc客户端发送消息到proxy服务端的时候,需要对消息进行检查
 			// 有些消息不支持转发给vnc服务端
+			if that.viewOnly.Val() && isViewOnlyDisabled(msg.Type()) {
+				continue
+			}
+			msg, err := that.intercept(DirectionClientToServer, msg)
+			if err != nil {
+				that.errorCh <- err
+				that.Close()
+				return
+			}
+			if msg == nil {
+				continue
+			}
+			that.record(msg)
+			if that.shared != nil {
+				that.handleSharedClientMessage(msg)
+				continue
+			}
 			switch rfb.ClientMessageType(msg.Type()) {
 			case rfb.SetPixelFormat:
 				// 发现是设置像素格式的消息，则忽略
 				that.remoteSession.SetPixelFormat(msg.(*messages.SetPixelFormat).PF)
-				that.remoteSession.Options().Input <- msg
+				that.remoteInput <- msg
 				continue
 			case rfb.SetEncodings:
 				// 设置编码格式的消息
@@ -90,39 +207,131 @@ func (that *Proxy) handleIO() {
 				// 判断编码是否再支持的列表
 				for _, s := range that.remoteSession.Encodings() {
 					for _, cEnc := range msg.(*messages.SetEncodings).Encodings {
-						if cEnc == s.Type() {
+						if cEnc == s.Type() && that.recordable(cEnc) {
 							encTypes = append(encTypes, s.Type())
 						}
 					}
 				}
 				// 发送编码消息给vnc服务端
-				that.remoteSession.Options().Input <- &messages.SetEncodings{EncNum: gconv.Uint16(len(encTypes)), Encodings: encTypes}
+				that.remoteInput <- &messages.SetEncodings{EncNum: gconv.Uint16(len(encTypes)), Encodings: encTypes}
 			default:
-				cliCfg := that.remoteSession.Options()
-				disabled := false
-				for _, t := range cliCfg.DisableClientMessageType {
-					if rfb.MessageType(t) == msg.Type() {
-						disabled = true
-						break
-					}
-				}
-				if !disabled {
-					that.remoteSession.Options().Input <- msg
-				}
+				that.forwardClientMessage(msg)
 			}
 		}
 	}
 	that.errorCh <- nil
 }
 
-// Handle 建立远程链接
-func (that *Proxy) Handle(sess rfb.ISession) (err error) {
+// isViewOnlyDisabled 判断该消息在只读模式下是否需要丢弃
+func isViewOnlyDisabled(t rfb.MessageType) bool {
+	for _, mt := range viewOnlyClientMessageTypes {
+		if rfb.MessageType(mt) == t {
+			return true
+		}
+	}
+	return false
+}
 
-	that.remoteSession.Start()
+// forwardServerMessage 经过拦截器把vnc服务端的消息转发给vnc客户端，跳过禁用的消息
+func (that *Proxy) forwardServerMessage(msg rfb.Message) error {
+	sSessCfg := that.svrSession.Options()
+	// 如果该消息禁用，则跳过不转发该消息
+	for _, t := range sSessCfg.DisableServerMessageType {
+		if rfb.MessageType(t) == msg.Type() {
+			return nil
+		}
+	}
+	msg, err := that.intercept(DirectionServerToClient, msg)
 	if err != nil {
 		return err
 	}
+	if msg != nil {
+		that.record(msg)
+		sSessCfg.Input <- msg
+	}
+	return nil
+}
+
+// forwardClientMessage 转发vnc客户端的消息给vnc服务端，跳过禁用的消息
+func (that *Proxy) forwardClientMessage(msg rfb.Message) {
+	for _, t := range that.remoteSession.Options().DisableClientMessageType {
+		if rfb.MessageType(t) == msg.Type() {
+			return
+		}
+	}
+	that.remoteInput <- msg
+}
+
+// handleSharedClientMessage 共享模式下处理vnc客户端发送的消息
+// 像素格式和编码格式由共享会话统一与vnc服务端协商，vnc客户端的设置只用于判断是否需要转码，
+// 全量更新请求直接使用画布生成，只向vnc服务端请求增量更新，避免影响其他vnc客户端。
+func (that *Proxy) handleSharedClientMessage(msg rfb.Message) {
+	remotePF := that.remoteSession.Options().PixelFormat
+	switch rfb.ClientMessageType(msg.Type()) {
+	case rfb.SetPixelFormat:
+		pf := msg.(*messages.SetPixelFormat).PF
+		that.svrSession.SetPixelFormat(pf)
+		that.viewer.setPixelFormat(pf, remotePF)
+	case rfb.SetEncodings:
+		that.viewer.setEncodings(msg.(*messages.SetEncodings).Encodings, remotePF)
+	case rfb.FramebufferUpdateRequest:
+		req := msg.(*messages.FramebufferUpdateRequest)
+		if req.Inc == 0 {
+			update, err := that.shared.refresh(that.viewer, req)
+			if err == nil {
+				// 从画布生成的全量更新与vnc服务端的消息一样经过拦截器
+				err = that.forwardServerMessage(update)
+			}
+			if err != nil {
+				that.viewer.fail(err)
+				return
+			}
+			req = &messages.FramebufferUpdateRequest{Inc: 1, X: req.X, Y: req.Y, Width: req.Width, Height: req.Height}
+		}
+		that.remoteInput <- req
+	default:
+		that.forwardClientMessage(msg)
+	}
+}
+
+// Handle 建立远程链接
+func (that *Proxy) Handle(sess rfb.ISession) (err error) {
 	that.svrSession = sess.(*session.ServerSession)
+	// 使用只读密码认证的vnc客户端只能查看
+	if gconv.Bool(that.svrSession.Swap().Get(rfb.SwapKeyViewOnly)) {
+		that.viewOnly.Set(true)
+	}
+	key := that.sharedKey
+	if that.remoteSession == nil {
+		identity := that.Identity()
+		targetCfg, err := that.resolveTarget(identity)
+		if err != nil {
+			return err
+		}
+		// 使用认证器给出的凭证连接vnc服务端
+		if identity != nil {
+			identity.ApplyTo(targetCfg)
+		}
+		that.targetAddr = targetCfg.Addr()
+		if len(key) == 0 {
+			key = that.targetAddr
+		}
+		// 不同身份使用的vnc服务端凭证可能不同，只有凭证一致的vnc客户端才能共享同一个连接
+		key = sharedKeyWithCredentials(key, targetCfg)
+		if targetCfg.ViewOnly {
+			that.viewOnly.Set(true)
+		}
+		that.remoteSession = NewTargetClient(*targetCfg, that.remoteOptions...)
+	}
+	if that.hub != nil {
+		if err = that.joinShared(key); err != nil {
+			return err
+		}
+	} else {
+		that.remoteSession.Start()
+		cfg := that.remoteSession.Options()
+		that.remoteInput, that.remoteOutput, that.remoteErrorCh = cfg.Input, cfg.Output, cfg.ErrorCh
+	}
 	that.svrSession.SetWidth(that.remoteSession.Options().Width)
 	that.svrSession.SetHeight(that.remoteSession.Options().Height)
 	desktopName := that.remoteSession.Options().DesktopName
@@ -131,13 +340,114 @@ func (that *Proxy) Handle(sess rfb.ISession) (err error) {
 	}
 	that.svrSession.SetDesktopName(desktopName)
 	that.svrSession.SetPixelFormat(that.remoteSession.Options().PixelFormat)
+	if that.recorderFactory != nil {
+		if err = that.startRecording(); err != nil {
+			return err
+		}
+	}
 
+	that.running.Set(true)
 	go that.handleIO()
 	return nil
 }
 
+// joinShared 加入共享会话，vnc客户端ClientInit阶段未要求共享屏幕则以独占模式加入
+func (that *Proxy) joinShared(key string) error {
+	if len(key) == 0 {
+		key = "default"
+	}
+	exclusive := !gconv.Bool(that.svrSession.Swap().GetOrSet(rfb.SwapKeyShared, true))
+	shared, viewer, err := that.hub.join(key, that.remoteSession.(*session.ClientSession), exclusive)
+	if err != nil {
+		return err
+	}
+	that.shared = shared
+	that.viewer = viewer
+	that.remoteSession = shared.remoteSession
+	that.remoteInput = shared.remoteSession.Options().Input
+	that.remoteOutput = viewer.output
+	that.remoteErrorCh = viewer.errorCh
+	return nil
+}
+
+// resolveTarget 获取要连接的vnc服务端配置，令牌的优先级为：身份中的令牌，连接携带的令牌，安全认证阶段存入的令牌。
+// 获取到令牌时通过目标解析器解析，否则使用固定的vnc服务端配置
+func (that *Proxy) resolveTarget(identity *rfb.Identity) (*rfb.TargetConfig, error) {
+	var token string
+	if identity != nil {
+		token = identity.Token
+	}
+	if len(token) == 0 {
+		token = that.token
+	}
+	if len(token) == 0 {
+		token = gconv.String(that.svrSession.Swap().Get(rfb.SwapKeyToken))
+	}
+	if that.target != nil && (that.resolver == nil || len(token) == 0) {
+		targetCfg := *that.target
+		return &targetCfg, nil
+	}
+	if that.resolver == nil {
+		return nil, fmt.Errorf("未设置要连接的vnc服务端")
+	}
+	if len(token) == 0 {
+		return nil, fmt.Errorf("未获取到目标令牌")
+	}
+	targetCfg, err := that.resolver.Resolve(context.TODO(), token)
+	if err != nil {
+		return nil, fmt.Errorf("解析目标令牌失败:%v", err)
+	}
+	if logger.IsDebug() {
+		logger.Debugf(context.TODO(), "目标令牌 %s 解析成功，vnc服务端地址:%s", token, targetCfg.Addr())
+	}
+	return targetCfg, nil
+}
+
+// Identity vnc客户端通过认证后的身份，未使用用户名认证则返回nil
+func (that *Proxy) Identity() *rfb.Identity {
+	if that.svrSession == nil {
+		return nil
+	}
+	return rfb.IdentityFromSwap(that.svrSession)
+}
+
+// TargetAddr 连接的vnc服务端地址，未连接时返回空
+func (that *Proxy) TargetAddr() string {
+	if len(that.targetAddr) > 0 {
+		return that.targetAddr
+	}
+	if that.remoteSession == nil {
+		return ""
+	}
+	if c, ok := that.remoteSession.Conn().(net.Conn); ok {
+		return c.RemoteAddr().String()
+	}
+	return ""
+}
+
+// ViewOnly 是否只读模式
+func (that *Proxy) ViewOnly() bool {
+	return that.viewOnly.Val()
+}
+
+// SetViewOnly 切换只读模式，连接过程中也可以切换
+func (that *Proxy) SetViewOnly(viewOnly bool) {
+	that.viewOnly.Set(viewOnly)
+}
+
 func (that *Proxy) Close() {
 	that.closed.Set(true)
+	that.cancel()
 	_ = that.svrSession.Close()
-	_ = that.remoteSession.Close()
+	if that.tap != nil {
+		that.tap.close()
+	}
+	if that.shared != nil {
+		// 共享模式下只离开共享会话，最后一个vnc客户端离开时才会关闭到vnc服务端的连接
+		that.shared.leave(that.viewer)
+		return
+	}
+	if that.remoteSession != nil {
+		_ = that.remoteSession.Close()
+	}
 }
@@ -0,0 +1,64 @@
+package vnc
+
+import (
+	"context"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"testing"
+	"time"
+)
+
+func TestSharedRefreshIntercept(t *testing.T) {
+	tests := []struct {
+		name    string
+		drop    bool
+		forward bool
+	}{
+		{"拦截器放行", false, true},
+		{"拦截器丢弃", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var intercepted []rfb.Message
+			svrSession := session.NewServerSession()
+			proxy := NewVncProxy(newUpstreamClient(t, nil), svrSession,
+				OptSharedHub(NewHub()),
+				OptInterceptors(func(ctx context.Context, dir Direction, msg rfb.Message) (rfb.Message, error) {
+					if dir == DirectionServerToClient {
+						intercepted = append(intercepted, msg)
+						if tt.drop {
+							return nil, nil
+						}
+					}
+					return msg, nil
+				}),
+			)
+			if err := proxy.joinShared("key"); err != nil {
+				t.Fatal(err)
+			}
+			defer proxy.shared.close()
+
+			// 请求全量更新，共享会话从画布生成更新发送给vnc客户端
+			done := make(chan struct{})
+			go func() {
+				proxy.handleSharedClientMessage(&messages.FramebufferUpdateRequest{Inc: 0, Width: 64, Height: 48})
+				close(done)
+			}()
+			var forwarded rfb.Message
+			select {
+			case forwarded = <-svrSession.Options().Input:
+				<-done
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("处理全量更新请求超时")
+			}
+			if len(intercepted) != 1 || intercepted[0].Type() != rfb.MessageType(rfb.FramebufferUpdate) {
+				t.Fatalf("拦截器收到的消息为%v，期望一条帧缓冲更新", intercepted)
+			}
+			if (forwarded != nil) != tt.forward {
+				t.Fatalf("转发给vnc客户端的消息为%v，期望转发:%v", forwarded, tt.forward)
+			}
+		})
+	}
+}
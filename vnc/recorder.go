@@ -2,15 +2,25 @@ package vnc
 
 import (
 	"context"
-	"encoding/binary"
 	"github.com/gogf/gf/v2/container/gtype"
-	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/osgochina/dmicro/logger"
 	"github.com/vprix/vncproxy/messages"
 	"github.com/vprix/vncproxy/rfb"
 	"github.com/vprix/vncproxy/session"
 )
 
+// recorderEncodings 录屏向vnc服务端请求的编码格式
+// 关键帧需要把帧缓冲更新绘制到画布上，从关键帧开始播放时vnc客户端也不能依赖之前的zlib流，
+// 所以只使用画布能够解码并且无状态的编码。
+var recorderEncodings = []rfb.EncodingType{
+	rfb.EncCursorPseudo,
+	rfb.EncDesktopSizePseudo,
+	rfb.EncPointerPosPseudo,
+	rfb.EncCopyRect,
+	rfb.EncHexTile,
+	rfb.EncRaw,
+}
+
 type Recorder struct {
 	errorCh         chan error
 	closed          *gtype.Bool
@@ -31,16 +41,8 @@ func NewRecorder(recorderSess *session.RecorderSession, cliSession *session.Clie
 func (that *Recorder) Start() error {
 	var err error
 	that.cliSession.Start()
-	encS := []rfb.EncodingType{
-		rfb.EncCursorPseudo,
-		rfb.EncPointerPosPseudo,
-		rfb.EncCopyRect,
-		rfb.EncZRLE,
-		rfb.EncHexTile,
-		rfb.EncZlib,
-		rfb.EncRRE,
-	}
-	err = that.cliSession.SetEncodings(encS)
+	defer that.Close()
+	err = that.cliSession.SetEncodings(recorderEncodings)
 	if err != nil {
 		return err
 	}
@@ -56,28 +58,16 @@ func (that *Recorder) Start() error {
 	if err != nil {
 		return err
 	}
-	var lastUpdate *gtime.Time
 	for {
 		select {
 		case msg := <-that.recorderSession.Options().Output:
 			logger.Debugf(context.TODO(), "client message received.messageType:%d,message:%s", msg.Type(), msg)
 		case msg := <-that.cliSession.Options().Output:
+			err = that.recorderSession.WriteMessage(msg)
+			if err != nil {
+				return err
+			}
 			if rfb.ServerMessageType(msg.Type()) == rfb.FramebufferUpdate {
-				err = msg.Write(that.recorderSession)
-				if err != nil {
-					return err
-				}
-				if lastUpdate == nil {
-					_ = binary.Write(that.recorderSession, binary.BigEndian, int64(0))
-				} else {
-					secsPassed := gtime.Now().UnixNano() - lastUpdate.UnixNano()
-					_ = binary.Write(that.recorderSession, binary.BigEndian, secsPassed)
-				}
-				err = that.recorderSession.Flush()
-				if err != nil {
-					return err
-				}
-				lastUpdate = gtime.Now()
 				reqMsg = messages.FramebufferUpdateRequest{Inc: 1, X: 0, Y: 0, Width: that.cliSession.Options().Width, Height: that.cliSession.Options().Height}
 				err = reqMsg.Write(that.cliSession)
 				if err != nil {
@@ -0,0 +1,445 @@
+package vnc
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/container/gset"
+	"github.com/gogf/gf/v2/container/gtype"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/osgochina/dmicro/logger"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/encodings"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordingQueueLen 录屏队列的长度，磁盘写入太慢导致队列满了会丢弃消息，之后重新请求全量更新
+const recordingQueueLen = 1024
+
+// RecorderFactory 为每个proxy会话创建录屏会话，在vnc客户端通过认证并连接上vnc服务端之后调用，
+// 返回nil表示该会话不需要录屏，返回错误则断开vnc客户端。
+// 录屏会话的文件头(桌面大小、像素格式等)由proxy设置，并由proxy负责启动及关闭。
+type RecorderFactory func(proxy *Proxy) (*session.RecorderSession, error)
+
+// OptRecorder 设置录屏，proxy转发消息的同时把vnc客户端看到的画面及操作录制下来。
+// 录屏在单独的协程中写入，不会阻塞消息转发。
+func OptRecorder(factory RecorderFactory) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.recorderFactory = factory
+	}
+}
+
+// OptRecordStatelessEncodings 录屏时只向vnc服务端协商 recorderEncodings 中的无状态编码。
+// 默认转发vnc客户端协商的编码，录屏时把ZRLE、Tight、Zlib等使用zlib流的矩形单独解码成Raw编码再录制，
+// 开启后不需要解码，减少录屏占用的CPU，但是转发给vnc客户端的带宽会大很多。
+func OptRecordStatelessEncodings(enable bool) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.recordStateless = enable
+	}
+}
+
+// WriterRecorder 把录屏写入newWriter返回的io.Writer，如果同时实现了io.Closer，录屏结束时会关闭。
+// newWriter返回nil表示该会话不需要录屏
+func WriterRecorder(newWriter func(proxy *Proxy) (io.Writer, error)) RecorderFactory {
+	return func(proxy *Proxy) (*session.RecorderSession, error) {
+		w, err := newWriter(proxy)
+		if err != nil || w == nil {
+			return nil, err
+		}
+		return session.NewRecorder(
+			rfb.OptEncodings(encodings.DefaultEncodings...),
+			rfb.OptMessages(messages.DefaultServerMessages...),
+			rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
+				return &writerConn{Writer: w}, nil
+			}),
+		), nil
+	}
+}
+
+// RecordFileOption 录屏文件的配置
+type RecordFileOption func(*recordFileOptions)
+
+type recordFileOptions struct {
+	compression     rbs.Compression
+	segmentSize     int64
+	segmentDuration time.Duration
+	retention       rbs.Retention
+	encryptKey      []byte
+}
+
+// OptRecordCompression 设置录屏文件的压缩算法，文件名会加上对应的扩展名
+func OptRecordCompression(c rbs.Compression) RecordFileOption {
+	return func(o *recordFileOptions) {
+		o.compression = c
+	}
+}
+
+// OptRecordRotation 设置分段录屏，参考 session.RecorderSession.SetRotation
+func OptRecordRotation(size int64, duration time.Duration) RecordFileOption {
+	return func(o *recordFileOptions) {
+		o.segmentSize = size
+		o.segmentDuration = duration
+	}
+}
+
+// OptRecordRetention 设置录屏目录的保留策略，每次创建录屏文件之前执行
+func OptRecordRetention(retention rbs.Retention) RecordFileOption {
+	return func(o *recordFileOptions) {
+		o.retention = retention
+	}
+}
+
+// OptRecordEncryptKey 设置加密录屏的密钥，参考 session.RecorderSession.SetEncryptKey
+func OptRecordEncryptKey(key []byte) RecordFileOption {
+	return func(o *recordFileOptions) {
+		o.encryptKey = key
+	}
+}
+
+// FileRecorder 把录屏保存到dir目录，文件名参考 RecordingName ，分段录屏的文件名参考 rbs.SegmentPath
+func FileRecorder(dir string, opts ...RecordFileOption) RecorderFactory {
+	o := &recordFileOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	// 正在录制的文件，包括正在录制的录屏之前的分段，保留策略不能删除
+	active := gset.NewStrSet(true)
+	return func(proxy *Proxy) (*session.RecorderSession, error) {
+		if err := gfile.Mkdir(dir); err != nil {
+			return nil, err
+		}
+		var path string
+		var paths []string // 该录屏创建的所有分段文件
+		recorder := session.NewRecorder(
+			rfb.OptEncodings(encodings.DefaultEncodings...),
+			rfb.OptMessages(messages.DefaultServerMessages...),
+			rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
+				if o.retention.Enabled() {
+					removed, err := o.retention.Apply(dir, active.Slice()...)
+					if err != nil {
+						logger.Warningf(context.TODO(), "清理录屏文件失败:%v", err)
+					}
+					for _, p := range removed {
+						logger.Infof(context.TODO(), "删除超出保留策略的录屏文件:%s", p)
+					}
+				}
+				var f *os.File
+				var err error
+				segment := sess.(*session.RecorderSession).Segment()
+				if segment == 0 {
+					f, err = createRecordingFile(dir, RecordingName(proxy), o.compression.Ext(), &path)
+				} else {
+					f, err = os.OpenFile(rbs.SegmentPath(path, segment), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+				}
+				if err != nil {
+					return nil, err
+				}
+				paths = append(paths, f.Name())
+				active.Add(f.Name())
+				return &recordingFile{File: f, done: sess.Wait(), release: func() {
+					for _, p := range paths {
+						active.Remove(p)
+					}
+				}}, nil
+			}),
+		)
+		recorder.SetCompression(o.compression)
+		recorder.SetRotation(o.segmentSize, o.segmentDuration)
+		recorder.SetEncryptKey(o.encryptKey)
+		return recorder, nil
+	}
+}
+
+// createRecordingFile 创建录屏文件，同一秒内同一个用户多次连接同一个vnc服务端时使用序号区分
+func createRecordingFile(dir, name, ext string, path *string) (*os.File, error) {
+	for i := 0; ; i++ {
+		*path = gfile.Join(dir, name+".rbs"+ext)
+		if i > 0 {
+			*path = gfile.Join(dir, fmt.Sprintf("%s_%d.rbs%s", name, i, ext))
+		}
+		f, err := os.OpenFile(*path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// recordingFile 正在录制的文件，录屏结束关闭文件时不再保护该录屏的所有分段，分段切换时关闭文件不影响
+type recordingFile struct {
+	*os.File
+	done    <-chan struct{} // 录屏会话结束
+	release func()
+}
+
+func (that *recordingFile) Close() error {
+	err := that.File.Close()
+	select {
+	case <-that.done:
+		that.release()
+	default:
+	}
+	return err
+}
+
+// RecordingName 录屏的名称，格式为 用户名_vnc服务端地址_开始时间，未使用用户名认证的用户名为anonymous，
+// 例如 alice_192.168.1.2_5901_20220102150405
+func RecordingName(proxy *Proxy) string {
+	username := "anonymous"
+	if identity := proxy.Identity(); identity != nil && len(identity.Username) > 0 {
+		username = identity.Username
+	}
+	target := proxy.TargetAddr()
+	if len(target) == 0 {
+		target = "unknown"
+	}
+	return fmt.Sprintf("%s_%s_%s", sanitizeRecordingName(username), sanitizeRecordingName(target), time.Now().Format("20060102150405"))
+}
+
+// sanitizeRecordingName 只保留字母、数字、点及中划线，其他字符替换为下划线，防止用户名中包含路径
+func sanitizeRecordingName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// writerConn 把io.Writer包装成录屏会话使用的链接
+type writerConn struct {
+	io.Writer
+}
+
+func (that *writerConn) Read(_ []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (that *writerConn) Close() error {
+	if c, ok := that.Writer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// recordingEntry 排队等待录制的消息
+type recordingEntry struct {
+	msg rfb.Message
+	at  time.Time // 收到消息的时间
+}
+
+// recordingTap 把proxy转发的消息复制一份放入队列，在单独的协程中写入录屏会话。
+// 使用zlib流的矩形依赖之前的所有数据，丢弃消息后无法再解码，所以在放入队列之前使用单独的画布解码成Raw编码，
+// 队列中的消息都是无状态的，丢弃之后重新请求全量更新即可恢复。
+type recordingTap struct {
+	proxy    *Proxy
+	recorder *session.RecorderSession
+	queue    chan recordingEntry
+	dropped  *gtype.Bool // 是否有消息因为队列满了被丢弃，需要重新请求全量更新
+	closed   *gtype.Bool
+	quit     chan struct{}
+	once     sync.Once
+
+	mu      sync.Mutex
+	decoder *session.CanvasSession // 解码使用zlib流的矩形，保存zlib流的状态
+}
+
+// startRecording 创建并启动录屏会话，需要在vnc服务端连接成功并设置好vnc客户端的桌面参数之后调用
+func (that *Proxy) startRecording() error {
+	recorder, err := that.recorderFactory(that)
+	if err != nil {
+		return fmt.Errorf("录屏失败:%v", err)
+	}
+	if recorder == nil {
+		return nil
+	}
+	cfg := that.svrSession.Options()
+	recorder.SetProtocolVersion(that.remoteSession.ProtocolVersion())
+	recorder.SetWidth(cfg.Width)
+	recorder.SetHeight(cfg.Height)
+	recorder.SetPixelFormat(cfg.PixelFormat)
+	recorder.SetDesktopName(cfg.DesktopName)
+	recorder.Start()
+	select {
+	case err = <-recorder.Options().ErrorCh:
+		_ = recorder.Close()
+		return fmt.Errorf("录屏失败:%v", err)
+	default:
+	}
+	that.tap = newRecordingTap(that, recorder, recordingQueueLen)
+	go that.tap.run()
+	return nil
+}
+
+func newRecordingTap(proxy *Proxy, recorder *session.RecorderSession, queueLen int) *recordingTap {
+	cfg := recorder.Options()
+	decoder := session.NewCanvasSession(
+		rfb.OptPixelFormat(cfg.PixelFormat),
+		rfb.OptWidth(int(cfg.Width)),
+		rfb.OptHeight(int(cfg.Height)),
+	)
+	decoder.Start()
+	return &recordingTap{
+		proxy:    proxy,
+		recorder: recorder,
+		queue:    make(chan recordingEntry, queueLen),
+		dropped:  gtype.NewBool(false),
+		closed:   gtype.NewBool(false),
+		quit:     make(chan struct{}),
+		decoder:  decoder,
+	}
+}
+
+// record 录制消息，未开启录屏时忽略
+func (that *Proxy) record(msg rfb.Message) {
+	if that.tap != nil {
+		that.tap.write(msg)
+	}
+}
+
+// recordable 开启了 OptRecordStatelessEncodings 的录屏只使用 recorderEncodings 中的编码，其他情况不限制
+func (that *Proxy) recordable(enc rfb.EncodingType) bool {
+	if that.tap == nil || !that.recordStateless {
+		return true
+	}
+	for _, e := range recorderEncodings {
+		if e == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// write 非阻塞写入队列，队列满了则丢弃消息
+func (that *recordingTap) write(msg rfb.Message) {
+	if that.closed.Val() {
+		return
+	}
+	msg = that.decode(msg)
+	select {
+	case that.queue <- recordingEntry{msg: msg, at: time.Now()}:
+	default:
+		if that.dropped.Cas(false, true) {
+			logger.Warningf(context.TODO(), "录屏写入太慢，丢弃消息")
+		}
+	}
+}
+
+// decode 复制一份需要录制的消息，帧缓冲更新中使用zlib流的矩形解码后替换成Raw编码，
+// 同时跟踪像素格式及颜色地图的变化，保证解码使用的像素格式与vnc服务端一致
+func (that *recordingTap) decode(msg rfb.Message) rfb.Message {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	switch m := msg.(type) {
+	case *messages.SetPixelFormat:
+		that.decoder.SetPixelFormat(m.PF)
+	case *messages.SetColorMapEntries:
+		_ = m.Clone().Write(that.decoder)
+	case *messages.FramebufferUpdate:
+		return that.decodeUpdate(m)
+	}
+	return msg.Clone()
+}
+
+// decodeUpdate 只把使用zlib流的矩形及桌面大小变化绘制到画布上，其他矩形原样录制，调用时需要持有锁
+func (that *recordingTap) decodeUpdate(fbu *messages.FramebufferUpdate) rfb.Message {
+	stateful := &messages.FramebufferUpdate{}
+	for _, rect := range fbu.Rects {
+		switch {
+		case rect.EncType.Stateful(), rect.EncType == rfb.EncDesktopSizePseudo, rect.EncType == rfb.EncExtendedDesktopSizePseudo:
+			stateful.Rects = append(stateful.Rects, rect.Clone())
+		}
+	}
+	stateful.NumRect = uint16(len(stateful.Rects))
+	if len(stateful.Rects) == 0 {
+		return fbu.Clone()
+	}
+	if err := stateful.Write(that.decoder); err != nil {
+		logger.Warningf(context.TODO(), "录屏解码帧缓冲更新失败:%v", err)
+	}
+	pf := that.decoder.Options().PixelFormat
+	update := &messages.FramebufferUpdate{}
+	for _, rect := range fbu.Rects {
+		if !rect.EncType.Stateful() {
+			update.Rects = append(update.Rects, rect.Clone())
+			continue
+		}
+		raw, err := that.decoder.RawUpdate(pf, canvas.MakeRectFromVncRect(rect))
+		if err != nil {
+			logger.Warningf(context.TODO(), "录屏转码帧缓冲更新失败:%v", err)
+			continue
+		}
+		update.Rects = append(update.Rects, raw.Rects...)
+	}
+	update.NumRect = uint16(len(update.Rects))
+	return update
+}
+
+// run 把队列中的消息写入录屏会话，写入失败则停止录屏，不影响proxy的消息转发
+func (that *recordingTap) run() {
+	defer func() {
+		_ = that.recorder.Close()
+		that.mu.Lock()
+		_ = that.decoder.Close()
+		that.mu.Unlock()
+	}()
+	for {
+		select {
+		case entry := <-that.queue:
+			if err := that.recorder.WriteMessageAt(entry.msg, entry.at); err != nil {
+				logger.Warningf(context.TODO(), "录屏写入失败，停止录屏:%v", err)
+				that.closed.Set(true)
+				return
+			}
+			if len(that.queue) == 0 && that.dropped.Cas(true, false) {
+				that.resync()
+			}
+		case <-that.quit:
+			// 写入队列中剩余的消息
+			for {
+				select {
+				case entry := <-that.queue:
+					if err := that.recorder.WriteMessageAt(entry.msg, entry.at); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// resync 丢弃消息之后录屏的画面不完整，重新获取全量更新
+// 共享模式下直接使用共享会话的画布生成，否则向vnc服务端请求全量更新
+func (that *recordingTap) resync() {
+	cfg := that.proxy.svrSession.Options()
+	req := &messages.FramebufferUpdateRequest{Inc: 0, Width: cfg.Width, Height: cfg.Height}
+	if that.proxy.shared != nil {
+		update, err := that.proxy.shared.refresh(that.proxy.viewer, req)
+		if err != nil {
+			logger.Warningf(context.TODO(), "录屏获取全量更新失败:%v", err)
+			return
+		}
+		that.write(update)
+		return
+	}
+	select {
+	case that.proxy.remoteInput <- req:
+	case <-that.proxy.ctx.Done():
+	}
+}
+
+// close 停止录屏，队列中剩余的消息写入完成后关闭录屏会话，不会阻塞调用方
+func (that *recordingTap) close() {
+	that.once.Do(func() {
+		that.closed.Set(true)
+		close(that.quit)
+	})
+}
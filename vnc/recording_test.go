@@ -0,0 +1,406 @@
+package vnc
+
+import (
+	"bytes"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/encodings"
+	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/session"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingFiles 列出目录中的录屏文件
+func recordingFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if rbs.IsRecordingFile(entry.Name()) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files
+}
+
+func TestFileRecorderRetention(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.rbs")
+	if err := os.WriteFile(old, []byte(rbs.Version2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+	// 只保留一个文件，每个消息之后都切换分段
+	factory := FileRecorder(dir, OptRecordRotation(1, 0), OptRecordRetention(rbs.Retention{MaxFiles: 1}))
+	newRecorder := func() *session.RecorderSession {
+		recorder, err := factory(NewVncProxy(nil, session.NewServerSession()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder.SetProtocolVersion(rfb.ProtoVersion38)
+		recorder.SetWidth(64)
+		recorder.SetHeight(48)
+		recorder.SetPixelFormat(rfb.PixelFormat32bit)
+		recorder.Start()
+		select {
+		case err = <-recorder.Options().ErrorCh:
+			t.Fatal(err)
+		default:
+		}
+		return recorder
+	}
+	r1, r2 := newRecorder(), newRecorder()
+	for i := 0; i < 3; i++ {
+		for _, r := range []*session.RecorderSession{r1, r2} {
+			if err := r.WriteMessage(&messages.Bell{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// 正在录制的两个录屏的所有分段都不能被删除，只删除了之前的文件
+	files := recordingFiles(t, dir)
+	if len(files) != 8 {
+		t.Fatalf("录屏文件为%v，期望两个录屏各4个分段", files)
+	}
+	for _, f := range files {
+		if f == old {
+			t.Fatal("之前的录屏文件没有被删除")
+		}
+	}
+	_ = r1.Close()
+	_ = r2.Close()
+
+	// 录屏结束后的文件可以被删除，保留策略在创建文件之前执行，所以还剩一个之前的文件及正在录制的文件
+	r3 := newRecorder()
+	defer func() { _ = r3.Close() }()
+	if files = recordingFiles(t, dir); len(files) != 2 {
+		t.Fatalf("录屏文件为%v，期望只剩一个之前的文件及正在录制的文件", files)
+	}
+}
+
+// TestFileRecorderRotation 按大小或者时长分段的压缩录屏，所有分段按顺序作为一个录屏读回
+func TestFileRecorderRotation(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression rbs.Compression
+		size        int64
+		duration    time.Duration
+		segments    int
+	}{
+		{"不分段", rbs.CompressionNone, 0, 0, 1},
+		{"gzip按大小分段", rbs.CompressionGzip, 1, 0, 5},
+		{"zstd按大小分段", rbs.CompressionZstd, 1, 0, 5},
+		{"gzip按时长分段", rbs.CompressionGzip, 0, 2 * time.Second, 2},
+		{"zstd按时长分段", rbs.CompressionZstd, 0, time.Second, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			factory := FileRecorder(dir, OptRecordCompression(tt.compression), OptRecordRotation(tt.size, tt.duration))
+			recorder, err := factory(NewVncProxy(nil, session.NewServerSession()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder.SetProtocolVersion(rfb.ProtoVersion38)
+			recorder.SetWidth(testPlaybackWidth)
+			recorder.SetHeight(testPlaybackHeight)
+			recorder.SetPixelFormat(rfb.PixelFormat32bit)
+			start := time.Now()
+			recorder.SetStartTime(start)
+			recorder.Start()
+			select {
+			case err = <-recorder.Options().ErrorCh:
+				t.Fatal(err)
+			default:
+			}
+			// 每秒一个消息，剪贴板内容为消息的序号
+			for i := 0; i < 4; i++ {
+				text := []byte{'0' + byte(i)}
+				if err = recorder.WriteMessageAt(&messages.ServerCutText{Length: 1, Text: text}, start.Add(time.Duration(i)*time.Second)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = recorder.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			files := recordingFiles(t, dir)
+			if len(files) != tt.segments {
+				t.Fatalf("录屏文件为%v，应该有%d个分段", files, tt.segments)
+			}
+			for _, f := range files {
+				data, err := os.ReadFile(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if filepath.Ext(f) != filepath.Ext("x.rbs"+tt.compression.Ext()) || rbs.DetectCompression(data) != tt.compression {
+					t.Fatalf("录屏文件%s的压缩算法不是%v", f, tt.compression)
+				}
+			}
+
+			// 从最后一个分段打开也能读取所有分段
+			file, err := rbs.OpenFile(files[len(files)-1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var texts []string
+			for _, entry := range readRecordingEntries(t, file) {
+				cut, ok := entry.Message.(*messages.ServerCutText)
+				if !ok {
+					t.Fatalf("读取的消息为%v", entry.Message)
+				}
+				if entry.Timestamp != time.Duration(len(texts))*time.Second {
+					t.Fatalf("第%d个消息的时间为%v", len(texts), entry.Timestamp)
+				}
+				texts = append(texts, string(cut.Text))
+			}
+			if got := strings.Join(texts, ""); got != "0123" {
+				t.Fatalf("读取的消息顺序为%s", got)
+			}
+		})
+	}
+}
+
+// newMemoryTap 创建把录屏写入内存的recordingTap，画面大小及像素格式与测试录屏一致，不启动写入协程
+func newMemoryTap(t *testing.T, proxy *Proxy, queueLen int) (*recordingTap, *bytes.Buffer) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	recorder, err := WriterRecorder(func(*Proxy) (io.Writer, error) { return buf, nil })(proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.SetProtocolVersion(rfb.ProtoVersion38)
+	recorder.SetWidth(testPlaybackWidth)
+	recorder.SetHeight(testPlaybackHeight)
+	recorder.SetPixelFormat(rfb.PixelFormat32bit)
+	recorder.Start()
+	select {
+	case err = <-recorder.Options().ErrorCh:
+		t.Fatal(err)
+	default:
+	}
+	return newRecordingTap(proxy, recorder, queueLen), buf
+}
+
+// readRecording 读取内存中录屏除关键帧以外的所有消息
+func readRecording(t *testing.T, data []byte) []rfb.Message {
+	t.Helper()
+	var msgs []rfb.Message
+	for _, entry := range readRecordingEntries(t, seekConn{bytes.NewReader(data)}) {
+		msgs = append(msgs, entry.Message)
+	}
+	return msgs
+}
+
+// readRecordingEntries 读取录屏除关键帧以外的所有记录，读取完成后关闭conn
+func readRecordingEntries(t *testing.T, conn io.ReadWriteCloser) []*session.RecordEntry {
+	t.Helper()
+	player := session.NewPlayerSession(rfb.OptGetConn(func(rfb.ISession) (io.ReadWriteCloser, error) {
+		return conn, nil
+	}))
+	defer func() { _ = conn.Close() }()
+	player.Start()
+	select {
+	case err := <-player.Options().ErrorCh:
+		t.Fatal(err)
+	default:
+	}
+	var entries []*session.RecordEntry
+	for {
+		entry, err := player.ReadEntry()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Type != rbs.ChunkKeyframe {
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// drawUpdates 把消息中的帧缓冲更新绘制到黑色的画布上，返回画布
+func drawUpdates(t *testing.T, msgs []rfb.Message) *canvas.VncCanvas {
+	t.Helper()
+	cs := session.NewCanvasSession(
+		rfb.OptPixelFormat(rfb.PixelFormat32bit),
+		rfb.OptWidth(testPlaybackWidth),
+		rfb.OptHeight(testPlaybackHeight),
+	)
+	cs.Start()
+	t.Cleanup(func() { _ = cs.Close() })
+	for _, msg := range msgs {
+		if fbu, ok := msg.(*messages.FramebufferUpdate); ok {
+			if err := fbu.Clone().Write(cs); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return cs.Canvas()
+}
+
+// expectPixels 检查画布第一行的颜色
+func expectPixels(t *testing.T, cv *canvas.VncCanvas, colors ...color.RGBA) {
+	t.Helper()
+	for x, want := range colors {
+		if got := color.RGBAModel.Convert(cv.At(x, 0)).(color.RGBA); got.R != want.R || got.G != want.G || got.B != want.B {
+			t.Fatalf("画面(%d,0)的颜色为%v，应该为%v", x, got, want)
+		}
+	}
+}
+
+// TestRecordingTapDecode 使用zlib流的矩形解码成Raw编码录制，转发给vnc客户端的消息不受影响，关闭时写入队列中剩余的消息
+func TestRecordingTapDecode(t *testing.T) {
+	tap, buf := newMemoryTap(t, NewVncProxy(nil, session.NewServerSession()), 8)
+	msgs := readRecording(t, newPlaybackRecording())
+	if len(msgs) != 4 {
+		t.Fatalf("测试录屏有%d个消息", len(msgs))
+	}
+	for _, msg := range msgs {
+		tap.write(msg)
+	}
+	tap.close()
+	tap.run()
+
+	// 转发给vnc客户端的消息仍然可以解码
+	expectPixels(t, drawUpdates(t, msgs), testPlaybackGreen, testPlaybackGreen, testPlaybackBlue, testPlaybackBlue)
+
+	recorded := readRecording(t, buf.Bytes())
+	if len(recorded) != len(msgs) {
+		t.Fatalf("录制了%d个消息，应该为%d个", len(recorded), len(msgs))
+	}
+	for i, msg := range recorded {
+		if reflect.TypeOf(msg) != reflect.TypeOf(msgs[i]) {
+			t.Fatalf("第%d个消息为%T，应该为%T", i, msg, msgs[i])
+		}
+		if fbu, ok := msg.(*messages.FramebufferUpdate); ok {
+			for _, rect := range fbu.Rects {
+				if rect.EncType != rfb.EncRaw {
+					t.Fatalf("第%d个消息录制的编码为%v", i, rect.EncType)
+				}
+			}
+		}
+	}
+	expectPixels(t, drawUpdates(t, recorded), testPlaybackGreen, testPlaybackGreen, testPlaybackBlue, testPlaybackBlue)
+}
+
+// TestRecordingTapDrop 队列满了丢弃的消息仍然会被解码，之后依赖同一个zlib流的矩形可以正确录制
+func TestRecordingTapDrop(t *testing.T) {
+	proxy := NewVncProxy(nil, session.NewServerSession())
+	// 没有连接vnc服务端，写入完成后请求全量更新时直接返回
+	proxy.cancel()
+	tap, buf := newMemoryTap(t, proxy, 1)
+	msgs := readRecording(t, newPlaybackRecording())
+	tap.write(msgs[0])
+	tap.write(msgs[1])
+	if !tap.dropped.Val() {
+		t.Fatal("队列满了没有丢弃消息")
+	}
+	// 取出红色的全屏更新，之后只录制蓝色的矩形
+	<-tap.queue
+	tap.write(msgs[2])
+	tap.close()
+	tap.run()
+
+	recorded := readRecording(t, buf.Bytes())
+	if len(recorded) != 1 {
+		t.Fatalf("录制了%d个消息，应该只有蓝色的矩形", len(recorded))
+	}
+	black := color.RGBA{}
+	expectPixels(t, drawUpdates(t, recorded), black, black, testPlaybackBlue, testPlaybackBlue)
+}
+
+// TestRecordingTapResync 丢弃消息后队列清空时向vnc服务端请求全量更新
+func TestRecordingTapResync(t *testing.T) {
+	svrSession := session.NewServerSession()
+	svrSession.SetWidth(testPlaybackWidth)
+	svrSession.SetHeight(testPlaybackHeight)
+	proxy := NewVncProxy(nil, svrSession)
+	remoteInput := make(chan rfb.Message, 1)
+	proxy.remoteInput = remoteInput
+	defer proxy.cancel()
+	tap, buf := newMemoryTap(t, proxy, 1)
+	tap.write(&messages.Bell{})
+	tap.write(&messages.Bell{})
+
+	done := make(chan struct{})
+	go func() {
+		tap.run()
+		close(done)
+	}()
+	select {
+	case msg := <-remoteInput:
+		want := &messages.FramebufferUpdateRequest{Inc: 0, Width: testPlaybackWidth, Height: testPlaybackHeight}
+		if req, ok := msg.(*messages.FramebufferUpdateRequest); !ok || *req != *want {
+			t.Fatalf("请求全量更新的消息为%v，应该为%v", msg, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("丢弃消息后没有请求全量更新")
+	}
+	tap.close()
+	<-done
+	if recorded := readRecording(t, buf.Bytes()); len(recorded) != 1 {
+		t.Fatalf("录制了%d个消息，应该为1个", len(recorded))
+	}
+	if tap.dropped.Val() {
+		t.Fatal("请求全量更新后没有清除丢弃标识")
+	}
+}
+
+// TestProxyRecordEncodings 默认转发vnc客户端协商的编码，开启 OptRecordStatelessEncodings 后录屏时只协商无状态的编码
+func TestProxyRecordEncodings(t *testing.T) {
+	requested := []rfb.EncodingType{rfb.EncZRLE, rfb.EncTight, rfb.EncHexTile, rfb.EncRaw, rfb.EncCursorPseudo}
+	tests := []struct {
+		name      string
+		record    bool
+		stateless bool
+		want      []rfb.EncodingType
+	}{
+		{"未录屏", false, false, requested},
+		{"录屏时保留vnc客户端的编码", true, false, requested},
+		{"未录屏时只协商无状态的编码不生效", false, true, requested},
+		{"录屏时只协商无状态的编码", true, true, []rfb.EncodingType{rfb.EncHexTile, rfb.EncRaw, rfb.EncCursorPseudo}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, OptRecordStatelessEncodings(tt.stateless), func(proxy *Proxy) {
+				proxy.remoteSession = session.NewClient(rfb.OptEncodings(encodings.DefaultEncodings...))
+				if tt.record {
+					proxy.tap, _ = newMemoryTap(t, proxy, 8)
+				}
+			})
+			p.fromClient(t, &messages.SetEncodings{EncNum: uint16(len(requested)), Encodings: requested})
+			msg, ok := p.toServer(t).(*messages.SetEncodings)
+			if !ok {
+				t.Fatalf("转发给vnc服务端的消息不是SetEncodings")
+			}
+			got := map[rfb.EncodingType]bool{}
+			for _, enc := range msg.Encodings {
+				got[enc] = true
+			}
+			if len(got) != len(tt.want) || int(msg.EncNum) != len(msg.Encodings) {
+				t.Fatalf("协商的编码为%v，应该为%v", msg.Encodings, tt.want)
+			}
+			for _, enc := range tt.want {
+				if !got[enc] {
+					t.Fatalf("协商的编码为%v，应该为%v", msg.Encodings, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -5,11 +5,9 @@ import (
 	"github.com/osgochina/dmicro/logger"
 	"github.com/vprix/vncproxy/messages"
 	"github.com/vprix/vncproxy/rfb"
-	"github.com/vprix/vncproxy/security"
 	"github.com/vprix/vncproxy/session"
 	"golang.org/x/net/context"
 	"io"
-	"net"
 	"time"
 )
 
@@ -20,21 +18,8 @@ type Screenshot struct {
 }
 
 func NewScreenshot(targetCfg rfb.TargetConfig) *Screenshot {
-	securityHandlers := []rfb.ISecurityHandler{
-		&security.ClientAuthNone{},
-	}
-	if len(targetCfg.Password) > 0 {
-		securityHandlers = []rfb.ISecurityHandler{
-			&security.ClientAuthVNC{Password: targetCfg.Password},
-		}
-	}
 	canvasSession := session.NewCanvasSession()
-	cliSession := session.NewClient(
-		rfb.OptSecurityHandlers(securityHandlers...),
-		rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
-			return net.DialTimeout(targetCfg.GetNetwork(), targetCfg.Addr(), targetCfg.GetTimeout())
-		}),
-	)
+	cliSession := NewTargetClient(targetCfg)
 	recorder := &Screenshot{
 		canvasSession: canvasSession,
 		cliSession:    cliSession,
@@ -82,6 +67,13 @@ func (that *Screenshot) GetImage() (io.ReadWriteCloser, error) {
 		case <-ctx.Done():
 			return nil, fmt.Errorf("获取截图超时")
 		case msg := <-that.cliSession.Options().Output:
+			if cm, ok := msg.(*messages.SetColorMapEntries); ok {
+				// 像素格式不是真彩色时使用颜色地图绘制画布
+				if err = cm.Write(that.canvasSession); err != nil {
+					return nil, err
+				}
+				continue
+			}
 			if rfb.ServerMessageType(msg.Type()) == rfb.FramebufferUpdate {
 				err = msg.Write(that.canvasSession)
 				if err != nil {
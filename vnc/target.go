@@ -0,0 +1,84 @@
+package vnc
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/security"
+	"github.com/vprix/vncproxy/session"
+	"io"
+	"net"
+)
+
+// NewTargetClient 根据目标配置生成连接到vnc服务端的客户端会话，
+// 目标开启了tls则只使用VeNCrypt认证，否则配置了密码优先使用RA2认证，其次vnc auth认证，未配置密码使用auth none，
+// vnc服务端只提供tight认证时在tight认证中使用同样的认证方式，配置了用户名和密码时还可以使用ARD认证。
+func NewTargetClient(targetCfg rfb.TargetConfig, opts ...rfb.Option) *session.ClientSession {
+	securityHandlers := []rfb.ISecurityHandler{
+		&security.ClientAuthNone{},
+	}
+	if len(targetCfg.Password) > 0 {
+		securityHandlers = []rfb.ISecurityHandler{
+			&security.ClientAuthVNC{Password: targetCfg.Password},
+		}
+	}
+	// tightvnc服务端可能只提供tight认证，使用相同的认证方式作为tight的内层认证
+	securityHandlers = append(securityHandlers, &security.ClientAuthTight{Handlers: securityHandlers})
+	if len(targetCfg.Password) > 0 {
+		// RealVNC及新版TigerVNC可能只提供RSA-AES认证，优先使用
+		verifyServerKey := newRA2ServerKeyVerifier(targetCfg)
+		securityHandlers = append([]rfb.ISecurityHandler{
+			&security.ClientAuthRA2{Username: targetCfg.Username, Password: targetCfg.Password, VerifyServerKey: verifyServerKey},
+			&security.ClientAuthRA2{Username: targetCfg.Username, Password: targetCfg.Password, NoEncryption: true, VerifyServerKey: verifyServerKey},
+		}, securityHandlers...)
+		// macOS的屏幕共享需要使用用户名和密码进行ARD认证
+		if len(targetCfg.Username) > 0 {
+			securityHandlers = append(securityHandlers, &security.ClientAuthARD{Username: targetCfg.Username, Password: targetCfg.Password})
+		}
+	}
+	var tlsErr error
+	if targetCfg.TLS {
+		auth := &security.ClientAuthVeNCrypt{
+			Username: targetCfg.Username,
+			Password: targetCfg.Password,
+		}
+		auth.TLSConfig, tlsErr = security.NewClientTLSConfig(
+			targetCfg.TLSCAFile,
+			targetCfg.TLSCertFile,
+			targetCfg.TLSKeyFile,
+			targetCfg.GetTLSServerName(),
+			targetCfg.TLSInsecure,
+		)
+		securityHandlers = []rfb.ISecurityHandler{auth}
+	}
+	opts = append([]rfb.Option{
+		rfb.OptSecurityHandlers(securityHandlers...),
+		rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
+			// tls配置错误，在连接的时候返回错误
+			if tlsErr != nil {
+				return nil, tlsErr
+			}
+			return net.DialTimeout(targetCfg.GetNetwork(), targetCfg.Addr(), targetCfg.GetTimeout())
+		}),
+	}, opts...)
+	return session.NewClient(opts...)
+}
+
+// newRA2ServerKeyVerifier 根据目标配置生成RA2认证校验vnc服务端公钥的方法，
+// 配置了公钥指纹则固定公钥，配置了记录文件则首次连接时信任并记录，
+// 都没有配置时使用默认的记录文件，无法确定默认的记录文件则拒绝RA2认证，不能信任任何公钥
+func newRA2ServerKeyVerifier(targetCfg rfb.TargetConfig) func(key *rsa.PublicKey) error {
+	if len(targetCfg.RA2ServerKey) > 0 {
+		return security.PinRA2ServerKey(targetCfg.RA2ServerKey)
+	}
+	file := targetCfg.RA2KnownHosts
+	if len(file) == 0 {
+		var err error
+		if file, err = security.DefaultRA2KnownHostsFile(); err != nil {
+			return func(*rsa.PublicKey) error {
+				return fmt.Errorf("无法确定RA2认证的公钥记录文件:%v，请配置vnc服务端的公钥指纹或者记录文件", err)
+			}
+		}
+	}
+	return security.KnownHostsRA2ServerKey(file, targetCfg.Addr())
+}
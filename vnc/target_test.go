@@ -0,0 +1,68 @@
+package vnc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/vprix/vncproxy/rfb"
+	"github.com/vprix/vncproxy/security"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRA2ServerKeyVerifier 没有配置公钥指纹及记录文件时使用默认的记录文件，公钥改变后拒绝连接
+func TestRA2ServerKeyVerifier(t *testing.T) {
+	key1, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	targetCfg := rfb.TargetConfig{Host: "10.0.0.1", Port: 5900, Password: []byte("secret")}
+
+	// 使用的RA2认证都会校验vnc服务端的公钥
+	for _, h := range NewTargetClient(targetCfg).Options().SecurityHandlers {
+		if ra2, ok := h.(*security.ClientAuthRA2); ok && ra2.VerifyServerKey == nil {
+			t.Fatal("RA2认证没有校验vnc服务端的公钥")
+		}
+	}
+
+	cases := []struct {
+		name      string
+		targetCfg rfb.TargetConfig
+	}{
+		{"默认记录文件", targetCfg},
+		{"配置的记录文件", rfb.TargetConfig{Host: "10.0.0.1", Port: 5900, RA2KnownHosts: filepath.Join(dir, "known_hosts")}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			verify := newRA2ServerKeyVerifier(c.targetCfg)
+			if err := verify(&key1.PublicKey); err != nil {
+				t.Fatal(err)
+			}
+			if err := verify(&key2.PublicKey); err == nil {
+				t.Fatal("公钥改变后校验通过")
+			}
+		})
+	}
+	if _, err = os.Stat(filepath.Join(dir, "vncproxy", "ra2_known_hosts")); err != nil {
+		t.Fatalf("没有创建默认的记录文件:%v", err)
+	}
+
+	pinned := rfb.TargetConfig{Host: "10.0.0.1", Port: 5900, RA2ServerKey: security.RA2KeyFingerprint(&key1.PublicKey)}
+	if err = newRA2ServerKeyVerifier(pinned)(&key2.PublicKey); err == nil {
+		t.Fatal("与固定的公钥不一致时校验通过")
+	}
+
+	// 无法确定默认的记录文件时拒绝任何公钥
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", "")
+	if err = newRA2ServerKeyVerifier(targetCfg)(&key1.PublicKey); err == nil {
+		t.Fatal("没有记录文件时校验通过")
+	}
+}
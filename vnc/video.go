@@ -2,131 +2,401 @@ package vnc
 
 import (
 	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/container/gtype"
 	"github.com/osgochina/dmicro/logger"
-	"github.com/vprix/vncproxy/encodings"
+	"github.com/vprix/vncproxy/canvas"
+	"github.com/vprix/vncproxy/media"
 	"github.com/vprix/vncproxy/messages"
+	"github.com/vprix/vncproxy/rbs"
 	"github.com/vprix/vncproxy/rfb"
-	"github.com/vprix/vncproxy/security"
 	"github.com/vprix/vncproxy/session"
+	"image"
+	"image/draw"
 	"io"
-	"net"
 	"time"
 )
 
-type Video struct {
-	cliCfg        *rfb.Options
-	targetCfg     rfb.TargetConfig
-	cliSession    *session.ClientSession // 链接到vnc服务端的会话
-	canvasSession *session.CanvasSession
-}
+// DefaultVideoFPS 视频默认每秒的帧数
+const DefaultVideoFPS = 10
 
-func NewVideo(cliCfg *rfb.Options, targetCfg rfb.TargetConfig) *Video {
-	if cliCfg == nil {
-		cliCfg = &rfb.Options{
-			PixelFormat: rfb.PixelFormat32bit,
-			Messages:    messages.DefaultServerMessages,
-			Encodings:   encodings.DefaultEncodings,
-			Output:      make(chan rfb.Message),
-			Input:       make(chan rfb.Message),
-			ErrorCh:     make(chan error),
+// VideoOption 视频的配置
+type VideoOption func(*Video)
+
+// OptVideoFPS 设置视频每秒的帧数
+func OptVideoFPS(fps int) VideoOption {
+	return func(video *Video) {
+		if fps > 0 {
+			video.fps = fps
 		}
 	}
-	if cliCfg.Output == nil {
-		cliCfg.Output = make(chan rfb.Message)
+}
+
+// OptVideoDrawCursor 设置是否在视频中绘制鼠标指针，只有vnc服务端通过伪编码发送鼠标指针时才能绘制，默认绘制
+func OptVideoDrawCursor(drawCursor bool) VideoOption {
+	return func(video *Video) {
+		video.drawCursor = drawCursor
 	}
-	if cliCfg.Input == nil {
-		cliCfg.Input = make(chan rfb.Message)
+}
+
+// OptVideoKey 设置解密录屏文件的密钥
+func OptVideoKey(key []byte) VideoOption {
+	return func(video *Video) {
+		video.key = key
 	}
-	if cliCfg.ErrorCh == nil {
-		cliCfg.ErrorCh = make(chan error)
+}
+
+// OptVideoRange 只转换录屏中from到to之间的部分，to为0表示到录屏结尾，连接vnc服务端时无效
+func OptVideoRange(from, to time.Duration) VideoOption {
+	return func(video *Video) {
+		video.from, video.to = from, to
 	}
-	if len(targetCfg.Password) > 0 {
-		cliCfg.SecurityHandlers = []rfb.ISecurityHandler{
-			&security.ClientAuthVNC{Password: targetCfg.Password},
-		}
-	} else {
-		cliCfg.SecurityHandlers = []rfb.ISecurityHandler{
-			&security.ClientAuthNone{},
+}
+
+// OptVideoCrop 只保留画面中的指定区域
+func OptVideoCrop(rect image.Rectangle) VideoOption {
+	return func(video *Video) {
+		video.crop = rect
+	}
+}
+
+// OptVideoScale 按比例缩放画面，例如0.5把画面缩小一半
+func OptVideoScale(scale float64) VideoOption {
+	return func(video *Video) {
+		if scale > 0 {
+			video.scale = scale
 		}
 	}
-	recorder := &Video{
-		//canvasSession: session.NewCanvasSession(*cliCfg),
-		targetCfg: targetCfg,
-		cliCfg:    cliCfg,
+}
+
+// Video 把vnc服务端的画面或者录屏文件转换成固定帧率的视频或者动画。
+// 帧缓冲更新绘制到画布上，每一帧把画布裁剪、缩放之后写入 media.FrameWriter，画面没有变化时重复上一帧，
+// 所以视频的时长与实际的时长一致。视频的大小由开始时的桌面大小决定。
+type Video struct {
+	output        media.Output
+	rbsFile       string                 // 输入的录屏文件，为空时连接vnc服务端
+	cliSession    *session.ClientSession // 链接到vnc服务端的会话
+	canvasSession *session.CanvasSession
+	out           media.FrameWriter
+	frames        int             // 已经写入的帧数
+	region        image.Rectangle // 画布中写入视频的区域
+	view          *image.RGBA     // 画布中region区域的画面
+	frame         *image.RGBA     // 缩放之后写入视频的画面，不缩放时就是view
+	dirty         bool            // 上一帧之后画面是否有变化
+	fps           int
+	drawCursor    bool
+	key           []byte
+	from          time.Duration
+	to            time.Duration
+	crop          image.Rectangle
+	scale         float64
+	started       *gtype.Bool
+	closed        *gtype.Bool
+	done          chan struct{}
+}
+
+// NewVideo 连接vnc服务端，把实时画面写入output
+func NewVideo(targetCfg rfb.TargetConfig, output media.Output, opts ...VideoOption) *Video {
+	video := newVideo(output, opts...)
+	video.cliSession = NewTargetClient(targetCfg)
+	return video
+}
+
+// NewFileVideo 把录屏文件按照录制时的时间写入output，支持rbs、FBS及vncrec格式的录屏
+func NewFileVideo(rbsFile string, output media.Output, opts ...VideoOption) *Video {
+	video := newVideo(output, opts...)
+	video.rbsFile = rbsFile
+	return video
+}
+
+func newVideo(output media.Output, opts ...VideoOption) *Video {
+	video := &Video{
+		output:     output,
+		fps:        DefaultVideoFPS,
+		drawCursor: true,
+		scale:      1,
+		started:    gtype.NewBool(false),
+		closed:     gtype.NewBool(false),
+		done:       make(chan struct{}),
 	}
-	return recorder
+	for _, opt := range opts {
+		opt(video)
+	}
+	return video
 }
 
+// Start 开始生成视频，录屏文件转换完成或者调用Close之后返回，返回前会写入视频剩余的数据
 func (that *Video) Start() error {
-	var err error
-	timeout := 10 * time.Second
-	if that.targetCfg.Timeout > 0 {
-		timeout = that.targetCfg.Timeout
+	that.started.Set(true)
+	defer close(that.done)
+	if that.closed.Val() {
+		return nil
 	}
-	network := "tcp"
-	if len(that.targetCfg.Network) > 0 {
-		network = that.targetCfg.Network
+	if len(that.rbsFile) > 0 {
+		return that.renderFile()
 	}
-	that.cliCfg.GetConn = func(sess rfb.ISession) (io.ReadWriteCloser, error) {
-		return net.DialTimeout(network, that.targetCfg.Addr(), timeout)
+	return that.renderLive()
+}
+
+// Close 停止生成视频，等待已经生成的视频写入完成
+func (that *Video) Close() {
+	if !that.closed.Cas(false, true) {
+		return
 	}
-	that.cliSession = session.NewClient()
+	if that.cliSession != nil {
+		_ = that.cliSession.Close()
+	}
+	if that.started.Val() {
+		<-that.done
+	}
+}
 
+// renderLive 把vnc服务端的画面按照实际经过的时间写入视频
+func (that *Video) renderLive() (err error) {
 	that.cliSession.Start()
-	encS := []rfb.EncodingType{
-		rfb.EncCursorPseudo,
-		rfb.EncPointerPosPseudo,
-		rfb.EncCopyRect,
-		rfb.EncTight,
-		rfb.EncZRLE,
-		rfb.EncHexTile,
-		rfb.EncZlib,
-		rfb.EncRRE,
-	}
-	err = that.cliSession.SetEncodings(encS)
-	if err != nil {
+	defer func() {
+		_ = that.cliSession.Close()
+	}()
+	select {
+	case err = <-that.cliSession.Options().ErrorCh:
 		return err
+	default:
 	}
-	// 设置参数信息
-	that.canvasSession.SetProtocolVersion(that.cliSession.ProtocolVersion())
-	that.canvasSession.SetWidth(that.cliSession.Options().Width)
-	that.canvasSession.SetHeight(that.cliSession.Options().Height)
-	that.canvasSession.SetPixelFormat(that.cliSession.Options().PixelFormat)
-	that.canvasSession.SetDesktopName(that.cliSession.Options().DesktopName)
-	that.canvasSession.Start()
-	reqMsg := messages.FramebufferUpdateRequest{Inc: 1, X: 0, Y: 0, Width: that.cliSession.Options().Width, Height: that.cliSession.Options().Height}
-	err = reqMsg.Write(that.cliSession)
-	if err != nil {
+	// 画布的解码器不保存zlib流的状态，与录屏一样只使用无状态的编码
+	if err = that.cliSession.SetEncodings(recorderEncodings); err != nil {
 		return err
 	}
+	cfg := that.cliSession.Options()
+	if err = that.open(cfg.PixelFormat, cfg.Width, cfg.Height); err != nil {
+		return err
+	}
+	defer func() {
+		if e := that.finish(); err == nil {
+			err = e
+		}
+	}()
+	reqMsg := messages.FramebufferUpdateRequest{Inc: 0, X: 0, Y: 0, Width: cfg.Width, Height: cfg.Height}
+	if err = reqMsg.Write(that.cliSession); err != nil {
+		return err
+	}
+	interval := time.Second / time.Duration(that.fps)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	start := time.Now()
 	for {
 		select {
-		case msg := <-that.cliCfg.Output:
-			logger.Debugf(context.TODO(), "client message received.messageType:%d,message:%s", msg.Type(), msg)
-		case msg := <-that.cliCfg.Input:
-			if rfb.ServerMessageType(msg.Type()) == rfb.FramebufferUpdate {
-				err = msg.Write(that.canvasSession)
-				if err != nil {
+		case <-ticker.C:
+			// 编码较慢错过了时间时补齐缺少的帧，保证视频的时长与实际的时长一致
+			due := int(time.Since(start)/interval) + 1
+			for that.frames < due {
+				if err = that.writeFrame(); err != nil {
 					return err
 				}
-				err = that.canvasSession.Flush()
-				if err != nil {
+			}
+		case msg := <-that.cliSession.Options().Output:
+			if cm, ok := msg.(*messages.SetColorMapEntries); ok {
+				// 像素格式不是真彩色时使用颜色地图绘制画布
+				if err = cm.Write(that.canvasSession); err != nil {
 					return err
 				}
-				reqMsg = messages.FramebufferUpdateRequest{Inc: 1, X: 0, Y: 0, Width: that.cliSession.Options().Width, Height: that.cliSession.Options().Height}
-				err = reqMsg.Write(that.cliSession)
-				if err != nil {
-					return err
+				continue
+			}
+			if rfb.ServerMessageType(msg.Type()) != rfb.FramebufferUpdate {
+				if logger.IsDebug() {
+					logger.Debugf(context.TODO(), "获取到来自vnc服务端的消息%v", msg)
 				}
+				continue
+			}
+			if err = that.draw(msg); err != nil {
+				return err
+			}
+			reqMsg = messages.FramebufferUpdateRequest{Inc: 1, X: 0, Y: 0, Width: cfg.Width, Height: cfg.Height}
+			if err = reqMsg.Write(that.cliSession); err != nil {
+				return err
 			}
-		case err = <-that.cliCfg.ErrorCh:
+		case <-that.cliSession.Wait():
+			return nil
+		case err = <-that.cliSession.Options().ErrorCh:
 			return err
 		}
 	}
 }
 
-func (that *Video) Close() {
-	_ = that.cliSession.Close()
+// renderFile 把录屏文件按照记录的时间写入视频
+func (that *Video) renderFile() (err error) {
+	playerSession := session.NewPlayerSession(
+		rfb.OptGetConn(func(sess rfb.ISession) (io.ReadWriteCloser, error) {
+			return rbs.OpenFile(that.rbsFile, rbs.OptKey(that.key))
+		}),
+	)
+	playerSession.Start()
+	defer func() {
+		_ = playerSession.Close()
+	}()
+	select {
+	case err = <-playerSession.Options().ErrorCh:
+		return fmt.Errorf("打开录屏文件[%s]失败:%v", that.rbsFile, err)
+	default:
+	}
+	// 跳转到开始时间之前最近的关键帧，之后到开始时间的记录只绘制到画布上
+	if that.from > 0 {
+		if _, err = playerSession.Seek(that.from); err != nil {
+			return err
+		}
+	}
+	cfg := playerSession.Options()
+	if err = that.open(cfg.PixelFormat, cfg.Width, cfg.Height); err != nil {
+		return err
+	}
+	defer func() {
+		if e := that.finish(); err == nil {
+			err = e
+		}
+	}()
+	interval := time.Second / time.Duration(that.fps)
+	var last time.Duration
+	for !that.closed.Val() {
+		entry, err := playerSession.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if that.to > 0 && entry.Timestamp > that.to {
+			last = that.to
+			break
+		}
+		// 第n帧是from+n*interval时的画面，先写入该记录之前的帧
+		for that.from+time.Duration(that.frames)*interval < entry.Timestamp {
+			if err = that.writeFrame(); err != nil {
+				return err
+			}
+		}
+		if entry.Timestamp > last {
+			last = entry.Timestamp
+		}
+		switch msg := entry.Message.(type) {
+		case *messages.FramebufferUpdate:
+			if err = that.draw(msg); err != nil {
+				return err
+			}
+		case *messages.SetColorMapEntries:
+			if err = msg.Write(that.canvasSession); err != nil {
+				return err
+			}
+		case *messages.PointerEvent:
+			// 录屏中vnc客户端的鼠标事件作为鼠标指针的位置
+			that.canvasSession.Canvas().CursorLocation = &image.Point{X: int(msg.X), Y: int(msg.Y)}
+			that.dirty = that.drawCursor
+		}
+	}
+	// 录屏中最后一个记录之后的空闲时间也在视频中，提前停止时只写入到已经绘制的记录
+	end := last
+	if d := playerSession.Duration(); d > end && !that.closed.Val() {
+		end = d
+	}
+	if that.to > 0 && end > that.to {
+		end = that.to
+	}
+	if that.from > end {
+		return fmt.Errorf("开始时间%s超过了录屏的时长%s", that.from, end)
+	}
+	// 写入到结束时间的帧
+	for that.from+time.Duration(that.frames)*interval <= end {
+		if err = that.writeFrame(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open 创建绘制画面的画布及视频的输出，视频的大小是裁剪及缩放之后的大小
+func (that *Video) open(pf rfb.PixelFormat, width, height uint16) error {
+	that.region = image.Rect(0, 0, int(width), int(height))
+	if !that.crop.Empty() {
+		if !that.crop.In(that.region) {
+			return fmt.Errorf("裁剪的区域%v超出了画面%v", that.crop, that.region)
+		}
+		that.region = that.crop
+	}
+	that.view = image.NewRGBA(image.Rect(0, 0, that.region.Dx(), that.region.Dy()))
+	that.frame = that.view
+	if that.scale != 1 {
+		w := max(int(float64(that.region.Dx())*that.scale+0.5), 1)
+		h := max(int(float64(that.region.Dy())*that.scale+0.5), 1)
+		that.frame = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	var err error
+	that.out, err = that.output(that.frame.Rect.Dx(), that.frame.Rect.Dy(), that.fps)
+	if err != nil {
+		return err
+	}
+	that.canvasSession = session.NewCanvasSession(
+		rfb.OptPixelFormat(pf),
+		rfb.OptWidth(int(width)),
+		rfb.OptHeight(int(height)),
+	)
+	that.canvasSession.Start()
+	that.dirty = true
+	return nil
+}
+
+// finish 写入视频剩余的数据并关闭画布
+func (that *Video) finish() error {
 	_ = that.canvasSession.Close()
+	return that.out.Close()
+}
+
+// draw 把帧缓冲更新绘制到画布上
+func (that *Video) draw(msg rfb.Message) error {
+	if err := msg.Write(that.canvasSession); err != nil {
+		return err
+	}
+	that.dirty = true
+	return nil
+}
 
+// writeFrame 写入当前画面作为下一帧，画面没有变化时重复上一帧
+func (that *Video) writeFrame() error {
+	var err error
+	if that.dirty {
+		that.render()
+		err = that.out.WriteFrame(that.frame)
+		that.dirty = false
+	} else {
+		err = that.out.RepeatFrame()
+	}
+	if err != nil {
+		return err
+	}
+	that.frames++
+	return nil
+}
+
+// render 把画布中的区域复制到需要写入的画面上，需要时绘制鼠标指针并缩放
+func (that *Video) render() {
+	cv := that.canvasSession.Canvas()
+	// 鼠标指针临时绘制到画布上，复制之后恢复
+	cv.DrawCursor = that.drawCursor
+	cv.PaintCursor()
+	rect := that.region.Intersect(cv.Bounds())
+	if img, ok := cv.Image.(*canvas.RGBImage); ok {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			src := img.Pix[img.PixOffset(rect.Min.X, y):]
+			dst := that.view.Pix[that.view.PixOffset(rect.Min.X-that.region.Min.X, y-that.region.Min.Y):]
+			for x := 0; x < rect.Dx(); x++ {
+				dst[x*4+0] = src[x*3+0]
+				dst[x*4+1] = src[x*3+1]
+				dst[x*4+2] = src[x*3+2]
+				dst[x*4+3] = 0xff
+			}
+		}
+	} else {
+		draw.Draw(that.view, rect.Sub(that.region.Min), cv.Image, rect.Min, draw.Src)
+	}
+	cv.RemoveCursor()
+	if that.frame != that.view {
+		media.Scale(that.frame, that.view)
+	}
 }